@@ -0,0 +1,76 @@
+// Package auth signs people in and issues the tokens that identify them
+// afterwards.
+//
+// A Provider verifies who is signing in and returns an Identity; the
+// handlers map the identity to an account through the stored logins and
+// issue a token for it with Tokens. Two kinds of provider exist: a
+// PasswordProvider checks credentials sent to the server (Local), and a
+// RedirectProvider sends the browser to sign in elsewhere and completes
+// the sign-in when it comes back (OIDC).
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+)
+
+// ErrInvalidCredentials is returned when a provider does not confirm who is
+// signing in: a wrong password, or an identity provider refusing the
+// sign-in or vouching for it with an invalid token
+var ErrInvalidCredentials = errors.New("invalid credentials")
+
+// Identity is who a provider says signed in
+type Identity struct {
+	// Provider is the Name of the provider that verified the identity
+	Provider string
+	// Subject identifies the person at the provider and never changes
+	Subject string
+	// DisplayName, Email and AvatarURL describe the person as far as the
+	// provider knows; they seed the account created on first sign-in
+	DisplayName string
+	Email       string
+	AvatarURL   string
+}
+
+// Provider is a way to sign in. Every provider also implements
+// PasswordProvider or RedirectProvider.
+type Provider interface {
+	// Name identifies the provider in URLs and stored logins, so it must
+	// not change once accounts sign in with it
+	Name() string
+}
+
+// PasswordProvider verifies an email address and password sent to the
+// server
+type PasswordProvider interface {
+	Provider
+	// Authenticate returns the identity the credentials belong to, or
+	// ErrInvalidCredentials
+	Authenticate(ctx context.Context, email, password string) (*Identity, error)
+}
+
+// RedirectProvider signs people in at an identity provider: the browser is
+// sent to AuthURL and comes back to the callback with a code, which
+// Exchange turns into an identity
+type RedirectProvider interface {
+	Provider
+	// AuthURL returns where to send the browser. The provider echoes state
+	// back to the callback and binds nonce to the identity it issues.
+	AuthURL(ctx context.Context, state, nonce string) (string, error)
+	// Exchange completes a sign-in with the code the callback received.
+	// It returns ErrInvalidCredentials if the provider refuses the code or
+	// vouches for it with an invalid token.
+	Exchange(ctx context.Context, code, nonce string) (*Identity, error)
+}
+
+// RandomString returns n random bytes, base64url encoded, for secrets,
+// states and nonces
+func RandomString(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
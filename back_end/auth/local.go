@@ -0,0 +1,69 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"poker-planning-api/models"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// LocalProvider is the name of the email and password provider
+const LocalProvider = "local"
+
+// bcryptCost is the work factor of stored password hashes
+const bcryptCost = bcrypt.DefaultCost
+
+// LoginStore finds stored logins; db.Store implements it
+type LoginStore interface {
+	// GetLogin returns sql.ErrNoRows if the provider does not know the
+	// subject
+	GetLogin(ctx context.Context, provider, subject string) (*models.Login, error)
+}
+
+// Local signs people in with an email address and a password, checked
+// against the bcrypt hash stored with their login. The email address,
+// as cleaned by validate.Email, is the login's subject.
+type Local struct {
+	logins LoginStore
+	// dummyHash is compared against when the address is unknown, so the
+	// response time does not reveal which addresses have accounts
+	dummyHash []byte
+}
+
+// NewLocal creates the email and password provider
+func NewLocal(logins LoginStore) *Local {
+	dummyHash, err := bcrypt.GenerateFromPassword([]byte("not a password"), bcryptCost)
+	if err != nil {
+		panic("bcrypt: " + err.Error())
+	}
+	return &Local{logins: logins, dummyHash: dummyHash}
+}
+
+// Name returns LocalProvider
+func (l *Local) Name() string {
+	return LocalProvider
+}
+
+// Authenticate checks the password of the login for email
+func (l *Local) Authenticate(ctx context.Context, email, password string) (*Identity, error) {
+	login, err := l.logins.GetLogin(ctx, LocalProvider, email)
+	if errors.Is(err, sql.ErrNoRows) {
+		bcrypt.CompareHashAndPassword(l.dummyHash, []byte(password))
+		return nil, ErrInvalidCredentials
+	}
+	if err != nil {
+		return nil, err
+	}
+	if bcrypt.CompareHashAndPassword([]byte(login.PasswordHash), []byte(password)) != nil {
+		return nil, ErrInvalidCredentials
+	}
+	return &Identity{Provider: LocalProvider, Subject: login.Subject, Email: login.Subject}, nil
+}
+
+// HashPassword returns the hash to store with a new local login
+func HashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcryptCost)
+	return string(hash), err
+}
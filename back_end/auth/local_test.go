@@ -0,0 +1,44 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"poker-planning-api/db/memstore"
+	"poker-planning-api/models"
+	"testing"
+	"time"
+)
+
+func TestLocalAuthenticate(t *testing.T) {
+	ctx := context.Background()
+	store := memstore.New()
+	if err := store.CreateAccount(ctx, &models.Account{ID: "a1", DisplayName: "Alice", Provider: LocalProvider, CreatedAt: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+	hash, err := HashPassword("correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	login := &models.Login{Provider: LocalProvider, Subject: "alice@example.com", AccountID: "a1", PasswordHash: hash, CreatedAt: time.Now()}
+	if err := store.CreateLogin(ctx, login); err != nil {
+		t.Fatal(err)
+	}
+
+	local := NewLocal(store)
+	identity, err := local.Authenticate(ctx, "alice@example.com", "correct horse")
+	if err != nil {
+		t.Fatalf("authenticate: %v", err)
+	}
+	if identity.Provider != LocalProvider || identity.Subject != "alice@example.com" {
+		t.Errorf("identity = %+v", identity)
+	}
+
+	for _, creds := range [][2]string{
+		{"alice@example.com", "battery staple"},
+		{"bob@example.com", "correct horse"},
+	} {
+		if _, err := local.Authenticate(ctx, creds[0], creds[1]); !errors.Is(err, ErrInvalidCredentials) {
+			t.Errorf("%v: err = %v, want ErrInvalidCredentials", creds, err)
+		}
+	}
+}
@@ -0,0 +1,406 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"poker-planning-api/config"
+	"strings"
+	"sync"
+	"time"
+)
+
+// clockSkew is how far the identity provider's clock may be ahead of ours
+// when checking an ID token's expiry
+const clockSkew = time.Minute
+
+// maxResponseSize bounds what is read from the identity provider
+const maxResponseSize = 1 << 20
+
+// keyRefetchInterval bounds how often tokens naming unknown keys make the
+// key set be fetched again, so forged tokens cannot flood the provider
+const keyRefetchInterval = time.Minute
+
+// OIDC signs people in with an OpenID Connect provider using the
+// authorization code flow. The provider's endpoints are discovered on
+// first use and its signing keys fetched again, at most once a minute,
+// when a token names a key not seen before, so the server starts while the
+// provider is down and follows key rotation.
+type OIDC struct {
+	cfg config.OIDCConfig
+
+	// HTTPClient calls the provider; replace it to customize timeouts or
+	// transport
+	HTTPClient *http.Client
+
+	mu        sync.Mutex
+	discovery *discovery
+	// discoveryFetch is closed once the discovery fetch in flight ends;
+	// nil when none is
+	discoveryFetch chan struct{}
+	keys           map[string]*rsa.PublicKey
+	// keysFetched is when the key set was last fetched successfully
+	keysFetched time.Time
+	// keysFetch is closed once the key set fetch in flight ends; nil when
+	// none is
+	keysFetch chan struct{}
+}
+
+// discovery is the part of the provider metadata the flow needs
+type discovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// NewOIDC creates the provider described by cfg
+func NewOIDC(cfg config.OIDCConfig) *OIDC {
+	return &OIDC{
+		cfg:        cfg,
+		HTTPClient: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// Name returns the configured name
+func (o *OIDC) Name() string {
+	return o.cfg.Name
+}
+
+// AuthURL returns the provider's authorization endpoint for a sign-in
+// asking for the openid, email and profile scopes
+func (o *OIDC) AuthURL(ctx context.Context, state, nonce string) (string, error) {
+	d, err := o.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	u, err := url.Parse(d.AuthorizationEndpoint)
+	if err != nil {
+		return "", fmt.Errorf("oidc: authorization endpoint: %w", err)
+	}
+	query := u.Query()
+	query.Set("response_type", "code")
+	query.Set("client_id", o.cfg.ClientID)
+	query.Set("redirect_uri", o.cfg.RedirectURL)
+	query.Set("scope", "openid email profile")
+	query.Set("state", state)
+	query.Set("nonce", nonce)
+	u.RawQuery = query.Encode()
+	return u.String(), nil
+}
+
+// Exchange redeems the code at the token endpoint and verifies the ID
+// token it returns
+func (o *OIDC) Exchange(ctx context.Context, code, nonce string) (*Identity, error) {
+	d, err := o.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	form := url.Values{
+		"grant_type":   {"authorization_code"},
+		"code":         {code},
+		"redirect_uri": {o.cfg.RedirectURL},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(url.QueryEscape(o.cfg.ClientID), url.QueryEscape(o.cfg.ClientSecret))
+
+	var token struct {
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	status, err := o.fetch(req, &token)
+	switch {
+	case err != nil:
+		return nil, err
+	case token.Error != "":
+		// RFC 6749 5.2: the code or the client was refused
+		return nil, fmt.Errorf("%w: %s %s", ErrInvalidCredentials, token.Error, token.ErrorDescription)
+	case status != http.StatusOK:
+		return nil, fmt.Errorf("oidc: token endpoint answered %d", status)
+	case token.IDToken == "":
+		return nil, fmt.Errorf("%w: no id_token in the token response", ErrInvalidCredentials)
+	}
+
+	return o.verify(ctx, d, token.IDToken, nonce)
+}
+
+// idClaims are the ID token claims the provider reads
+type idClaims struct {
+	Issuer    string   `json:"iss"`
+	Subject   string   `json:"sub"`
+	Audience  audience `json:"aud"`
+	ExpiresAt int64    `json:"exp"`
+	Nonce     string   `json:"nonce"`
+	Email     string   `json:"email"`
+	Name      string   `json:"name"`
+	Picture   string   `json:"picture"`
+}
+
+// audience is the "aud" claim, a string or an array of strings
+type audience []string
+
+func (a *audience) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*a = audience{single}
+		return nil
+	}
+	return json.Unmarshal(data, (*[]string)(a))
+}
+
+// verify checks an ID token's RS256 signature and its issuer, audience,
+// expiry and nonce
+func (o *OIDC) verify(ctx context.Context, d *discovery, idToken, nonce string) (*Identity, error) {
+	invalid := func(reason string) error {
+		return fmt.Errorf("%w: id_token %s", ErrInvalidCredentials, reason)
+	}
+
+	parts := strings.Split(idToken, ".")
+	if len(parts) != 3 {
+		return nil, invalid("is malformed")
+	}
+	var header struct {
+		Algorithm string `json:"alg"`
+		KeyID     string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, invalid("header is malformed")
+	}
+	if header.Algorithm != "RS256" {
+		return nil, invalid("is not signed with RS256")
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, invalid("signature is malformed")
+	}
+
+	key, err := o.key(ctx, d, header.KeyID)
+	if err != nil {
+		return nil, err
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature) != nil {
+		return nil, invalid("signature does not verify")
+	}
+
+	var claims idClaims
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, invalid("claims are malformed")
+	}
+	switch {
+	case claims.Issuer != d.Issuer:
+		return nil, invalid("was issued by " + claims.Issuer)
+	case !claims.Audience.contains(o.cfg.ClientID):
+		return nil, invalid("is meant for another client")
+	case time.Now().Add(-clockSkew).Unix() >= claims.ExpiresAt:
+		return nil, invalid("has expired")
+	case claims.Nonce != nonce:
+		return nil, invalid("answers another sign-in")
+	case claims.Subject == "":
+		return nil, invalid("has no subject")
+	}
+
+	return &Identity{
+		Provider:    o.cfg.Name,
+		Subject:     claims.Subject,
+		DisplayName: claims.Name,
+		Email:       claims.Email,
+		AvatarURL:   claims.Picture,
+	}, nil
+}
+
+func (a audience) contains(clientID string) bool {
+	for _, aud := range a {
+		if aud == clientID {
+			return true
+		}
+	}
+	return false
+}
+
+// discover fetches the provider metadata once. Like key, it fetches
+// outside the lock, and callers needing the metadata meanwhile wait for it;
+// after a failed fetch the next caller tries again.
+func (o *OIDC) discover(ctx context.Context) (*discovery, error) {
+	for {
+		o.mu.Lock()
+		if d := o.discovery; d != nil {
+			o.mu.Unlock()
+			return d, nil
+		}
+		if wait := o.discoveryFetch; wait != nil {
+			o.mu.Unlock()
+			select {
+			case <-wait:
+				continue
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+		}
+		done := make(chan struct{})
+		o.discoveryFetch = done
+		o.mu.Unlock()
+
+		d, err := o.fetchDiscovery(ctx)
+
+		o.mu.Lock()
+		o.discoveryFetch = nil
+		if err == nil {
+			o.discovery = d
+		}
+		o.mu.Unlock()
+		close(done)
+		return d, err
+	}
+}
+
+// fetchDiscovery fetches and checks the provider metadata
+func (o *OIDC) fetchDiscovery(ctx context.Context) (*discovery, error) {
+	issuer := strings.TrimSuffix(o.cfg.Issuer, "/")
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, issuer+"/.well-known/openid-configuration", nil)
+	if err != nil {
+		return nil, err
+	}
+	var d discovery
+	status, err := o.fetch(req, &d)
+	switch {
+	case err != nil:
+		return nil, err
+	case status != http.StatusOK:
+		return nil, fmt.Errorf("oidc: discovery answered %d", status)
+	case strings.TrimSuffix(d.Issuer, "/") != issuer:
+		return nil, fmt.Errorf("oidc: discovery names issuer %q, configured %q", d.Issuer, o.cfg.Issuer)
+	case d.AuthorizationEndpoint == "" || d.TokenEndpoint == "" || d.JWKSURI == "":
+		return nil, errors.New("oidc: discovery document lacks an endpoint")
+	}
+	return &d, nil
+}
+
+// key returns the signing key with the given ID, fetching the key set
+// when the ID is unknown. The fetch runs outside the lock, and callers
+// needing a key meanwhile wait for it rather than fetch again.
+func (o *OIDC) key(ctx context.Context, d *discovery, keyID string) (*rsa.PublicKey, error) {
+	for {
+		o.mu.Lock()
+		if key, ok := o.keys[keyID]; ok {
+			o.mu.Unlock()
+			return key, nil
+		}
+		if wait := o.keysFetch; wait != nil {
+			o.mu.Unlock()
+			select {
+			case <-wait:
+				continue
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+		}
+		if o.keys != nil && time.Since(o.keysFetched) < keyRefetchInterval {
+			o.mu.Unlock()
+			return nil, fmt.Errorf("%w: id_token signed with unknown key %q", ErrInvalidCredentials, keyID)
+		}
+		done := make(chan struct{})
+		o.keysFetch = done
+		o.mu.Unlock()
+
+		keys, err := o.fetchKeys(ctx, d)
+
+		o.mu.Lock()
+		o.keysFetch = nil
+		// A failed fetch does not hold off the next one, so an outage
+		// does not turn new keys away once the provider is back
+		if err == nil {
+			o.keys = keys
+			o.keysFetched = time.Now()
+		}
+		o.mu.Unlock()
+		close(done)
+		if err != nil {
+			return nil, err
+		}
+		// Look again: the key is in the new set, or unknown until the next
+		// fetch is allowed
+	}
+}
+
+// fetchKeys fetches the provider's RSA signing keys by key ID
+func (o *OIDC) fetchKeys(ctx context.Context, d *discovery) (map[string]*rsa.PublicKey, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, d.JWKSURI, nil)
+	if err != nil {
+		return nil, err
+	}
+	var set struct {
+		Keys []struct {
+			KeyType string `json:"kty"`
+			KeyID   string `json:"kid"`
+			Use     string `json:"use"`
+			N       string `json:"n"`
+			E       string `json:"e"`
+		} `json:"keys"`
+	}
+	status, err := o.fetch(req, &set)
+	if err != nil {
+		return nil, err
+	}
+	if status != http.StatusOK {
+		return nil, fmt.Errorf("oidc: key set answered %d", status)
+	}
+
+	keys := map[string]*rsa.PublicKey{}
+	for _, k := range set.Keys {
+		if k.KeyType != "RSA" || (k.Use != "" && k.Use != "sig") {
+			continue
+		}
+		n, errN := base64.RawURLEncoding.DecodeString(k.N)
+		e, errE := base64.RawURLEncoding.DecodeString(k.E)
+		if errN != nil || errE != nil || len(e) > 4 {
+			continue
+		}
+		keys[k.KeyID] = &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+	}
+	return keys, nil
+}
+
+// fetch sends req and decodes the JSON response into out, whatever the
+// status, which it returns
+func (o *OIDC) fetch(req *http.Request, out interface{}) (int, error) {
+	resp, err := o.HTTPClient.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("oidc: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxResponseSize)).Decode(out); err != nil {
+		return resp.StatusCode, fmt.Errorf("oidc: %s answered %d with an invalid body: %w", req.URL.Path, resp.StatusCode, err)
+	}
+	return resp.StatusCode, nil
+}
+
+// decodeSegment decodes a base64url JSON segment of a JWT
+func decodeSegment(segment string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
@@ -0,0 +1,176 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"poker-planning-api/auth/oidctest"
+	"testing"
+	"time"
+)
+
+// authorize follows the sign-in to the provider and returns the code it
+// redirects back with
+func authorize(t *testing.T, o *OIDC, state, nonce string) string {
+	t.Helper()
+
+	authURL, err := o.AuthURL(context.Background(), state, nonce)
+	if err != nil {
+		t.Fatalf("auth URL: %v", err)
+	}
+	noRedirect := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	resp, err := noRedirect.Get(authURL)
+	if err != nil {
+		t.Fatalf("authorize: %v", err)
+	}
+	resp.Body.Close()
+
+	back, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil || resp.StatusCode != http.StatusFound {
+		t.Fatalf("authorize answered %d, Location %q", resp.StatusCode, resp.Header.Get("Location"))
+	}
+	if back.Query().Get("state") != state {
+		t.Errorf("state %q came back as %q", state, back.Query().Get("state"))
+	}
+	return back.Query().Get("code")
+}
+
+func newTestOIDC(idp *oidctest.Server) *OIDC {
+	cfg := idp.Config("oidc")
+	cfg.RedirectURL = "http://poker.test/api/auth/oidc/callback"
+	return NewOIDC(cfg)
+}
+
+func TestOIDCSignIn(t *testing.T) {
+	idp := oidctest.New(t)
+	idp.SetUser(oidctest.User{Subject: "u-42", Email: "bob@example.com", Name: "Bob", Picture: "https://example.com/bob.png"})
+	o := newTestOIDC(idp)
+	ctx := context.Background()
+
+	code := authorize(t, o, "state-1", "nonce-1")
+	identity, err := o.Exchange(ctx, code, "nonce-1")
+	if err != nil {
+		t.Fatalf("exchange: %v", err)
+	}
+	want := Identity{Provider: "oidc", Subject: "u-42", DisplayName: "Bob", Email: "bob@example.com", AvatarURL: "https://example.com/bob.png"}
+	if *identity != want {
+		t.Errorf("identity = %+v, want %+v", *identity, want)
+	}
+
+	if _, err := o.Exchange(ctx, code, "nonce-1"); !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("redeeming a code twice: err = %v, want ErrInvalidCredentials", err)
+	}
+}
+
+func TestOIDCRejectsInvalidIDTokens(t *testing.T) {
+	idp := oidctest.New(t)
+	o := newTestOIDC(idp)
+	ctx := context.Background()
+
+	if _, err := o.Exchange(ctx, authorize(t, o, "s", "nonce-1"), "nonce-2"); !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("nonce of another sign-in: err = %v, want ErrInvalidCredentials", err)
+	}
+
+	idp.SetTokenExpiry(-time.Hour)
+	if _, err := o.Exchange(ctx, authorize(t, o, "s", "n"), "n"); !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("expired token: err = %v, want ErrInvalidCredentials", err)
+	}
+	idp.SetTokenExpiry(time.Hour)
+
+	d, err := o.discover(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims := map[string]interface{}{
+		"iss": idp.URL, "sub": "u-1", "aud": oidctest.ClientID, "nonce": "n",
+		"exp": time.Now().Add(time.Hour).Unix(),
+	}
+	// Another provider's key under the same key ID
+	impostor := oidctest.New(t)
+	if _, err := o.verify(ctx, d, impostor.Sign(claims), "n"); !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("token signed by another key: err = %v, want ErrInvalidCredentials", err)
+	}
+	claims["aud"] = []string{"another-client"}
+	if _, err := o.verify(ctx, d, idp.Sign(claims), "n"); !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("token for another client: err = %v, want ErrInvalidCredentials", err)
+	}
+	claims["aud"] = []string{"another-client", oidctest.ClientID}
+	if _, err := o.verify(ctx, d, idp.Sign(claims), "n"); err != nil {
+		t.Errorf("token for several audiences including ours: %v", err)
+	}
+}
+
+func TestOIDCLimitsKeyRefetches(t *testing.T) {
+	idp := oidctest.New(t)
+	o := newTestOIDC(idp)
+	ctx := context.Background()
+	d, err := o.discover(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 3; i++ {
+		if _, err := o.key(ctx, d, "rotated"); !errors.Is(err, ErrInvalidCredentials) {
+			t.Errorf("unknown key: err = %v, want ErrInvalidCredentials", err)
+		}
+	}
+	if fetches := idp.KeyFetches(); fetches != 1 {
+		t.Errorf("key set fetched %d times for unknown keys, want once a minute", fetches)
+	}
+
+	// Keys of the last fetch are still served
+	claims := map[string]interface{}{
+		"iss": idp.URL, "sub": "u-1", "aud": oidctest.ClientID, "nonce": "n",
+		"exp": time.Now().Add(time.Hour).Unix(),
+	}
+	if _, err := o.verify(ctx, d, idp.Sign(claims), "n"); err != nil {
+		t.Errorf("token signed with a known key: %v", err)
+	}
+	if fetches := idp.KeyFetches(); fetches != 1 {
+		t.Errorf("key set fetched %d times, want 1", fetches)
+	}
+}
+
+func TestOIDCRetriesFailedKeyFetches(t *testing.T) {
+	idp := oidctest.New(t)
+	o := newTestOIDC(idp)
+	ctx := context.Background()
+	d, err := o.discover(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims := map[string]interface{}{
+		"iss": idp.URL, "sub": "u-1", "aud": oidctest.ClientID, "nonce": "n",
+		"exp": time.Now().Add(time.Hour).Unix(),
+	}
+	if _, err := o.verify(ctx, d, idp.Sign(claims), "n"); err != nil {
+		t.Fatalf("token signed with a known key: %v", err)
+	}
+	o.keysFetched = time.Now().Add(-2 * keyRefetchInterval)
+
+	idp.SetKeysDown(true)
+	if _, err := o.key(ctx, d, "rotated"); err == nil || errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("key set down: err = %v, want a fetch error", err)
+	}
+
+	// A failed fetch does not hold off the next one for a minute
+	idp.SetKeysDown(false)
+	if _, err := o.key(ctx, d, "rotated"); !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("unknown key: err = %v, want ErrInvalidCredentials", err)
+	}
+	if fetches := idp.KeyFetches(); fetches != 3 {
+		t.Errorf("key set fetched %d times, want 3", fetches)
+	}
+}
+
+func TestOIDCProviderDown(t *testing.T) {
+	idp := oidctest.New(t)
+	o := newTestOIDC(idp)
+	idp.Close()
+
+	_, err := o.AuthURL(context.Background(), "s", "n")
+	if err == nil || errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("err = %v, want a connection error", err)
+	}
+}
@@ -0,0 +1,270 @@
+// Package oidctest runs a minimal OpenID Connect provider for tests. It
+// signs every browser in without asking, as the User set on the Server,
+// so a sign-in completes by following redirects.
+package oidctest
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"poker-planning-api/config"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Client credentials the provider accepts
+const (
+	ClientID     = "poker-planning"
+	ClientSecret = "test-client-secret"
+)
+
+// keyID names the provider's only signing key
+const keyID = "test-key"
+
+// User is the person the provider signs in
+type User struct {
+	Subject string
+	Email   string
+	Name    string
+	Picture string
+}
+
+// grant is an issued authorization code waiting to be redeemed
+type grant struct {
+	user        User
+	nonce       string
+	redirectURI string
+}
+
+// Server is a running provider
+type Server struct {
+	*httptest.Server
+
+	key *rsa.PrivateKey
+
+	mu     sync.Mutex
+	user   User
+	codes  map[string]grant
+	expiry time.Duration
+	// deny makes /authorize refuse the next sign-ins
+	deny bool
+	// keyFetches counts requests for the key set
+	keyFetches int
+	// keysDown makes the key set answer 503
+	keysDown bool
+}
+
+// New starts a provider signing in a default user for the duration of the
+// test
+func New(t testing.TB) *Server {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("oidctest: generating key: %v", err)
+	}
+	s := &Server{
+		key:    key,
+		user:   User{Subject: "user-1", Email: "alice@example.com", Name: "Alice"},
+		codes:  map[string]grant{},
+		expiry: time.Hour,
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", s.handleDiscovery)
+	mux.HandleFunc("/jwks", s.handleKeys)
+	mux.HandleFunc("/authorize", s.handleAuthorize)
+	mux.HandleFunc("/token", s.handleToken)
+	s.Server = httptest.NewServer(mux)
+	t.Cleanup(s.Close)
+	return s
+}
+
+// Config returns the provider configuration for this server. RedirectURL
+// is left for the caller to fill in.
+func (s *Server) Config(name string) config.OIDCConfig {
+	return config.OIDCConfig{
+		Name:         name,
+		Issuer:       s.URL,
+		ClientID:     ClientID,
+		ClientSecret: ClientSecret,
+	}
+}
+
+// SetUser sets who the following sign-ins sign in
+func (s *Server) SetUser(user User) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.user = user
+}
+
+// SetTokenExpiry sets how long issued ID tokens are valid; a negative
+// duration issues expired tokens
+func (s *Server) SetTokenExpiry(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.expiry = d
+}
+
+// SetDeny makes the provider refuse the following sign-ins, redirecting
+// with error=access_denied as when a user declines
+func (s *Server) SetDeny(deny bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.deny = deny
+}
+
+// SetKeysDown makes the key set endpoint fail with 503, or serve again
+func (s *Server) SetKeysDown(down bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.keysDown = down
+}
+
+// KeyFetches returns how many times the key set was fetched
+func (s *Server) KeyFetches() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.keyFetches
+}
+
+func (s *Server) handleDiscovery(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"issuer":                                s.URL,
+		"authorization_endpoint":                s.URL + "/authorize",
+		"token_endpoint":                        s.URL + "/token",
+		"jwks_uri":                              s.URL + "/jwks",
+		"response_types_supported":              []string{"code"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+	})
+}
+
+func (s *Server) handleKeys(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	s.keyFetches++
+	down := s.keysDown
+	s.mu.Unlock()
+	if down {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+		return
+	}
+
+	pub := s.key.PublicKey
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": keyID,
+			"use": "sig",
+			"alg": "RS256",
+			"n":   base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+		}},
+	})
+}
+
+// handleAuthorize signs the current user in and redirects back with a code
+func (s *Server) handleAuthorize(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	redirectURI, err := url.Parse(query.Get("redirect_uri"))
+	if err != nil || query.Get("redirect_uri") == "" {
+		http.Error(w, "redirect_uri is required", http.StatusBadRequest)
+		return
+	}
+	if query.Get("client_id") != ClientID || query.Get("response_type") != "code" {
+		http.Error(w, "unknown client or response type", http.StatusBadRequest)
+		return
+	}
+
+	back := url.Values{"state": {query.Get("state")}}
+	s.mu.Lock()
+	if s.deny {
+		back.Set("error", "access_denied")
+	} else {
+		code := randomString()
+		s.codes[code] = grant{user: s.user, nonce: query.Get("nonce"), redirectURI: redirectURI.String()}
+		back.Set("code", code)
+	}
+	s.mu.Unlock()
+
+	redirectURI.RawQuery = back.Encode()
+	http.Redirect(w, r, redirectURI.String(), http.StatusFound)
+}
+
+// handleToken redeems a code, once, for a signed ID token
+func (s *Server) handleToken(w http.ResponseWriter, r *http.Request) {
+	clientID, clientSecret, _ := r.BasicAuth()
+	clientID, _ = url.QueryUnescape(clientID)
+	clientSecret, _ = url.QueryUnescape(clientSecret)
+	if r.Method != http.MethodPost || clientID != ClientID || clientSecret != ClientSecret {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid_client"})
+		return
+	}
+
+	s.mu.Lock()
+	code := r.PostFormValue("code")
+	g, ok := s.codes[code]
+	delete(s.codes, code)
+	expiry := s.expiry
+	s.mu.Unlock()
+	if !ok || r.PostFormValue("grant_type") != "authorization_code" || r.PostFormValue("redirect_uri") != g.redirectURI {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant"})
+		return
+	}
+
+	now := time.Now()
+	claims := map[string]interface{}{
+		"iss":   s.URL,
+		"sub":   g.user.Subject,
+		"aud":   ClientID,
+		"iat":   now.Unix(),
+		"exp":   now.Add(expiry).Unix(),
+		"nonce": g.nonce,
+		"email": g.user.Email,
+		"name":  g.user.Name,
+	}
+	if g.user.Picture != "" {
+		claims["picture"] = g.user.Picture
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"access_token": randomString(),
+		"token_type":   "Bearer",
+		"expires_in":   int(expiry.Seconds()),
+		"id_token":     s.Sign(claims),
+	})
+}
+
+// Sign returns an RS256 JWT of claims signed with the provider's key, for
+// tests that forge ID tokens
+func (s *Server) Sign(claims map[string]interface{}) string {
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT", "kid": keyID})
+	payload, _ := json.Marshal(claims)
+	signingInput := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+
+	digest := sha256.Sum256([]byte(signingInput))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, s.key, crypto.SHA256, digest[:])
+	if err != nil {
+		panic("oidctest: signing: " + err.Error())
+	}
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func randomString() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,151 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+)
+
+// ErrInvalidToken is returned for a token that is malformed, was not
+// signed with the secret, is meant for something else or has expired
+var ErrInvalidToken = errors.New("invalid token")
+
+// Token audiences keep a token issued for one purpose from being accepted
+// for another
+const (
+	audienceSignIn     = "sign-in"
+	audienceLoginState = "login-state"
+)
+
+// hs256Header is the encoded header of every token Tokens issues
+var hs256Header = base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT"}`))
+
+// Tokens issues and verifies HS256 JSON Web Tokens. Tokens are not stored:
+// one stays valid until it expires, even after its holder signs out.
+type Tokens struct {
+	secret []byte
+	ttl    time.Duration
+	// now is replaced in tests
+	now func() time.Time
+}
+
+// NewTokens creates a token issuer. Sign-in tokens last ttl.
+func NewTokens(secret []byte, ttl time.Duration) *Tokens {
+	return &Tokens{secret: secret, ttl: ttl, now: time.Now}
+}
+
+// Claims identify a signed-in caller
+type Claims struct {
+	AccountID string `json:"sub"`
+	// Provider is the provider the caller signed in with
+	Provider  string `json:"provider"`
+	Audience  string `json:"aud"`
+	ExpiresAt int64  `json:"exp"`
+}
+
+// Issue returns a token identifying the account, signed in with provider,
+// and when it expires
+func (t *Tokens) Issue(accountID, provider string) (string, time.Time, error) {
+	expiresAt := t.now().Add(t.ttl)
+	token, err := t.seal(Claims{
+		AccountID: accountID,
+		Provider:  provider,
+		Audience:  audienceSignIn,
+		ExpiresAt: expiresAt.Unix(),
+	})
+	return token, expiresAt, err
+}
+
+// Verify returns the claims of a token issued by Issue, or ErrInvalidToken
+func (t *Tokens) Verify(token string) (*Claims, error) {
+	var claims Claims
+	if err := t.open(audienceSignIn, token, &claims); err != nil {
+		return nil, err
+	}
+	if claims.AccountID == "" {
+		return nil, ErrInvalidToken
+	}
+	return &claims, nil
+}
+
+// LoginState follows a redirect sign-in from its start to the callback, in
+// a cookie, so the callback can check that it answers a sign-in this
+// browser started
+type LoginState struct {
+	Provider string `json:"provider"`
+	State    string `json:"state"`
+	Nonce    string `json:"nonce"`
+	// ReturnTo is where to send the browser once signed in; empty answers
+	// the callback with JSON
+	ReturnTo  string `json:"returnTo,omitempty"`
+	Audience  string `json:"aud"`
+	ExpiresAt int64  `json:"exp"`
+}
+
+// SealLoginState signs a login state valid for ttl
+func (t *Tokens) SealLoginState(state LoginState, ttl time.Duration) (string, error) {
+	state.Audience = audienceLoginState
+	state.ExpiresAt = t.now().Add(ttl).Unix()
+	return t.seal(state)
+}
+
+// OpenLoginState verifies and decodes a token from SealLoginState
+func (t *Tokens) OpenLoginState(token string) (*LoginState, error) {
+	var state LoginState
+	if err := t.open(audienceLoginState, token, &state); err != nil {
+		return nil, err
+	}
+	return &state, nil
+}
+
+// seal signs claims, which carry "aud" and "exp" for open to check
+func (t *Tokens) seal(claims interface{}) (string, error) {
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+
+	signingInput := hs256Header + "." + base64.RawURLEncoding.EncodeToString(payload)
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(t.sign(signingInput)), nil
+}
+
+// open verifies a token from seal for audience and decodes it into claims
+func (t *Tokens) open(audience, token string, claims interface{}) error {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 || parts[0] != hs256Header {
+		return ErrInvalidToken
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil || !hmac.Equal(signature, t.sign(parts[0]+"."+parts[1])) {
+		return ErrInvalidToken
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return ErrInvalidToken
+	}
+
+	var registered struct {
+		Audience  string `json:"aud"`
+		ExpiresAt int64  `json:"exp"`
+	}
+	if err := json.Unmarshal(payload, &registered); err != nil {
+		return ErrInvalidToken
+	}
+	if registered.Audience != audience || t.now().Unix() >= registered.ExpiresAt {
+		return ErrInvalidToken
+	}
+	if err := json.Unmarshal(payload, claims); err != nil {
+		return ErrInvalidToken
+	}
+	return nil
+}
+
+func (t *Tokens) sign(signingInput string) []byte {
+	mac := hmac.New(sha256.New, t.secret)
+	mac.Write([]byte(signingInput))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,76 @@
+package auth
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTokensRoundTrip(t *testing.T) {
+	tokens := NewTokens([]byte("0123456789abcdef0123456789abcdef"), time.Hour)
+	token, expiresAt, err := tokens.Issue("account-1", LocalProvider)
+	if err != nil {
+		t.Fatalf("issue: %v", err)
+	}
+	if until := time.Until(expiresAt); until < 59*time.Minute || until > time.Hour {
+		t.Errorf("expires in %v, want an hour", until)
+	}
+
+	claims, err := tokens.Verify(token)
+	if err != nil {
+		t.Fatalf("verify: %v", err)
+	}
+	if claims.AccountID != "account-1" || claims.Provider != LocalProvider {
+		t.Errorf("claims = %+v", claims)
+	}
+}
+
+func TestTokensRejectForgeries(t *testing.T) {
+	tokens := NewTokens([]byte("0123456789abcdef0123456789abcdef"), time.Hour)
+	token, _, err := tokens.Issue("account-1", LocalProvider)
+	if err != nil {
+		t.Fatal(err)
+	}
+	state, err := tokens.SealLoginState(LoginState{Provider: "oidc", State: "s", Nonce: "n"}, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expired := NewTokens([]byte("0123456789abcdef0123456789abcdef"), time.Hour)
+	expired.now = func() time.Time { return time.Now().Add(-2 * time.Hour) }
+	expiredToken, _, _ := expired.Issue("account-1", LocalProvider)
+
+	otherSecret := NewTokens([]byte("fedcba9876543210fedcba9876543210"), time.Hour)
+	parts := strings.Split(token, ".")
+
+	for name, forged := range map[string]string{
+		"empty":        "",
+		"not a jwt":    "hello",
+		"other secret": mustIssue(t, otherSecret),
+		"expired":      expiredToken,
+		"login state":  state,
+		"alg none":     "eyJhbGciOiJub25lIiwidHlwIjoiSldUIn0." + parts[1] + ".",
+		"tampered":     parts[0] + "." + parts[1] + "x." + parts[2],
+	} {
+		if _, err := tokens.Verify(forged); err != ErrInvalidToken {
+			t.Errorf("%s: err = %v, want ErrInvalidToken", name, err)
+		}
+	}
+
+	if _, err := tokens.OpenLoginState(token); err != ErrInvalidToken {
+		t.Errorf("sign-in token accepted as a login state: %v", err)
+	}
+	opened, err := tokens.OpenLoginState(state)
+	if err != nil || opened.State != "s" || opened.Nonce != "n" || opened.Provider != "oidc" {
+		t.Errorf("login state = %+v, %v", opened, err)
+	}
+}
+
+func mustIssue(t *testing.T, tokens *Tokens) string {
+	t.Helper()
+	token, _, err := tokens.Issue("account-1", LocalProvider)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
@@ -0,0 +1,437 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"poker-planning-api/models"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Client calls the Poker Planning REST API. Each method corresponds to an
+// operation in openapi.Operations; client_test.go keeps the two in sync.
+type Client struct {
+	baseURL string
+
+	// HTTPClient is used for every request; replace it to customize
+	// timeouts or transport
+	HTTPClient *http.Client
+
+	// AccountID, when set, is sent with every request and join, acting as
+	// that account: sessions are created and joined as its participant and
+	// listings include them
+	AccountID string
+
+	// Token, when set, is a sign-in token from Register or Login, sent with
+	// every request and join to act as the signed-in account. It takes the
+	// place of AccountID, which accounts of a sign-in provider cannot use.
+	Token string
+}
+
+// New creates a client for the server at baseURL, e.g. "http://localhost:8080"
+func New(baseURL string) *Client {
+	return &Client{
+		baseURL:    strings.TrimRight(baseURL, "/"),
+		HTTPClient: &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// Error is returned when the server answers with a problem+json response
+type Error struct {
+	StatusCode int
+	Problem    models.Problem
+}
+
+func (e *Error) Error() string {
+	if e.Problem.Detail != "" {
+		return fmt.Sprintf("%d %s: %s", e.StatusCode, e.Problem.Code, e.Problem.Detail)
+	}
+	return fmt.Sprintf("%d %s", e.StatusCode, e.Problem.Code)
+}
+
+// Health calls GET /health and returns the response text
+func (c *Client) Health(ctx context.Context) (string, error) {
+	var body bytes.Buffer
+	if err := c.do(ctx, http.MethodGet, "/health", nil, &body); err != nil {
+		return "", err
+	}
+	return body.String(), nil
+}
+
+// CreateSession creates a session and its host user
+func (c *Client) CreateSession(ctx context.Context, req models.CreateSessionRequest) (*models.CreateSessionResponse, error) {
+	var resp models.CreateSessionResponse
+	if err := c.do(ctx, http.MethodPost, "/api/sessions", req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// ListOptions filters a session listing. Zero values are left out.
+type ListOptions struct {
+	// UserIDs are the caller's user IDs; only sessions they created or
+	// joined are listed
+	UserIDs     []string
+	Query       string
+	State       string
+	CreatedFrom time.Time
+	CreatedTo   time.Time
+	Limit       int
+	// Cursor is the NextCursor of the previous page
+	Cursor string
+}
+
+// ListSessions returns one page of the sessions the caller created or
+// joined, newest first
+func (c *Client) ListSessions(ctx context.Context, opts ListOptions) (*models.SessionPage, error) {
+	header := http.Header{}
+	if len(opts.UserIDs) > 0 {
+		header.Set("X-User-ID", strings.Join(opts.UserIDs, ","))
+	}
+
+	var resp models.SessionPage
+	if err := c.send(ctx, http.MethodGet, listPath("/api/sessions", opts), header, nil, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// listPath adds the filters of opts, other than UserIDs, to a listing's
+// path
+func listPath(path string, opts ListOptions) string {
+	query := url.Values{}
+	set := func(key, value string) {
+		if value != "" {
+			query.Set(key, value)
+		}
+	}
+	set("q", opts.Query)
+	set("state", opts.State)
+	if !opts.CreatedFrom.IsZero() {
+		set("createdFrom", opts.CreatedFrom.Format(time.RFC3339))
+	}
+	if !opts.CreatedTo.IsZero() {
+		set("createdTo", opts.CreatedTo.Format(time.RFC3339))
+	}
+	if opts.Limit > 0 {
+		set("limit", strconv.Itoa(opts.Limit))
+	}
+	set("cursor", opts.Cursor)
+
+	if len(query) > 0 {
+		path += "?" + query.Encode()
+	}
+	return path
+}
+
+// GetSession returns a session with its users, items and votes
+func (c *Client) GetSession(ctx context.Context, sessionID string) (*models.Session, error) {
+	var resp models.Session
+	if err := c.do(ctx, http.MethodGet, sessionPath(sessionID), nil, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// AddItem adds a planning item to a session
+func (c *Client) AddItem(ctx context.Context, sessionID string, req models.AddItemRequest) (*models.PlanningItem, error) {
+	var resp models.PlanningItem
+	if err := c.do(ctx, http.MethodPost, sessionPath(sessionID)+"/items", req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// SetCurrentItem sets the item being estimated in a session
+func (c *Client) SetCurrentItem(ctx context.Context, sessionID, itemID string) error {
+	req := models.SetCurrentItemRequest{ItemID: itemID}
+	var resp models.StatusResponse
+	return c.do(ctx, http.MethodPost, sessionPath(sessionID)+"/current-item", req, &resp)
+}
+
+// ArchiveSession archives a session. hostKey is the one returned by
+// CreateSession; it may be empty when the client acts as the host's
+// account.
+func (c *Client) ArchiveSession(ctx context.Context, sessionID, hostKey string) error {
+	req := models.ArchiveSessionRequest{HostKey: hostKey}
+	var resp models.StatusResponse
+	return c.do(ctx, http.MethodPost, sessionPath(sessionID)+"/archive", req, &resp)
+}
+
+// ResolveJoinCode finds the active session a join code belongs to
+func (c *Client) ResolveJoinCode(ctx context.Context, code string) (*models.JoinCodeResponse, error) {
+	var resp models.JoinCodeResponse
+	if err := c.do(ctx, http.MethodGet, "/api/join/"+url.PathEscape(code), nil, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// CreateAccount creates an account. Set AccountID to the returned ID to
+// act as the account.
+func (c *Client) CreateAccount(ctx context.Context, req models.AccountRequest) (*models.Account, error) {
+	var resp models.Account
+	if err := c.do(ctx, http.MethodPost, "/api/accounts", req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// GetAccount returns the account set in AccountID
+func (c *Client) GetAccount(ctx context.Context) (*models.Account, error) {
+	var resp models.Account
+	if err := c.do(ctx, http.MethodGet, "/api/accounts/me", nil, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// UpdateAccount replaces the display name and avatar of the account set in
+// AccountID
+func (c *Client) UpdateAccount(ctx context.Context, req models.AccountRequest) (*models.Account, error) {
+	var resp models.Account
+	if err := c.do(ctx, http.MethodPut, "/api/accounts/me", req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// DeleteAccount deletes the account set in AccountID
+func (c *Client) DeleteAccount(ctx context.Context) error {
+	var resp models.StatusResponse
+	return c.do(ctx, http.MethodDelete, "/api/accounts/me", nil, &resp)
+}
+
+// CreateTeam creates a team owned by the signed-in account
+func (c *Client) CreateTeam(ctx context.Context, req models.TeamRequest) (*models.Team, error) {
+	var resp models.Team
+	if err := c.do(ctx, http.MethodPost, "/api/teams", req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// ListTeams returns the signed-in account's teams
+func (c *Client) ListTeams(ctx context.Context) (*models.TeamList, error) {
+	var resp models.TeamList
+	if err := c.do(ctx, http.MethodGet, "/api/teams", nil, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// GetTeam returns a team with its members
+func (c *Client) GetTeam(ctx context.Context, teamID string) (*models.Team, error) {
+	var resp models.Team
+	if err := c.do(ctx, http.MethodGet, teamPath(teamID), nil, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// UpdateTeam replaces a team's name and default settings
+func (c *Client) UpdateTeam(ctx context.Context, teamID string, req models.TeamRequest) (*models.Team, error) {
+	var resp models.Team
+	if err := c.do(ctx, http.MethodPut, teamPath(teamID), req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// DeleteTeam deletes a team, leaving its sessions without one
+func (c *Client) DeleteTeam(ctx context.Context, teamID string) error {
+	var resp models.StatusResponse
+	return c.do(ctx, http.MethodDelete, teamPath(teamID), nil, &resp)
+}
+
+// SetTeamMember adds an account to a team with role, or changes its role,
+// and returns the team
+func (c *Client) SetTeamMember(ctx context.Context, teamID, accountID, role string) (*models.Team, error) {
+	req := models.TeamMemberRequest{Role: role}
+	var resp models.Team
+	if err := c.do(ctx, http.MethodPut, teamPath(teamID)+"/members/"+url.PathEscape(accountID), req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// RemoveTeamMember removes an account from a team
+func (c *Client) RemoveTeamMember(ctx context.Context, teamID, accountID string) error {
+	var resp models.StatusResponse
+	return c.do(ctx, http.MethodDelete, teamPath(teamID)+"/members/"+url.PathEscape(accountID), nil, &resp)
+}
+
+// ListTeamSessions returns one page of the sessions created under a team,
+// newest first. opts.UserIDs is ignored.
+func (c *Client) ListTeamSessions(ctx context.Context, teamID string, opts ListOptions) (*models.SessionPage, error) {
+	var resp models.SessionPage
+	if err := c.do(ctx, http.MethodGet, listPath(teamPath(teamID)+"/sessions", opts), nil, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// CreateTemplate creates a template owned by the account or, with
+// req.TeamID, shared with a team
+func (c *Client) CreateTemplate(ctx context.Context, req models.TemplateRequest) (*models.Template, error) {
+	var resp models.Template
+	if err := c.do(ctx, http.MethodPost, "/api/templates", req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// ListTemplates returns the templates the account may use, by name
+func (c *Client) ListTemplates(ctx context.Context) (*models.TemplateList, error) {
+	var resp models.TemplateList
+	if err := c.do(ctx, http.MethodGet, "/api/templates", nil, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// GetTemplate returns a template
+func (c *Client) GetTemplate(ctx context.Context, templateID string) (*models.Template, error) {
+	var resp models.Template
+	if err := c.do(ctx, http.MethodGet, templatePath(templateID), nil, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// UpdateTemplate replaces a template's name, settings and items
+func (c *Client) UpdateTemplate(ctx context.Context, templateID string, req models.TemplateRequest) (*models.Template, error) {
+	var resp models.Template
+	if err := c.do(ctx, http.MethodPut, templatePath(templateID), req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// DeleteTemplate deletes a template
+func (c *Client) DeleteTemplate(ctx context.Context, templateID string) error {
+	var resp models.StatusResponse
+	return c.do(ctx, http.MethodDelete, templatePath(templateID), nil, &resp)
+}
+
+// Register creates an account that signs in with an email address and
+// password, and signs it in. Set Token to the returned token to act as
+// the account.
+func (c *Client) Register(ctx context.Context, req models.RegisterRequest) (*models.AuthResponse, error) {
+	var resp models.AuthResponse
+	if err := c.do(ctx, http.MethodPost, "/api/auth/register", req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// Login signs in with the email address and password of a provider, such
+// as "local". Set Token to the returned token to act as the account.
+func (c *Client) Login(ctx context.Context, provider string, req models.LoginRequest) (*models.AuthResponse, error) {
+	var resp models.AuthResponse
+	if err := c.do(ctx, http.MethodPost, "/api/auth/"+url.PathEscape(provider)+"/login", req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// Logout clears the sign-in cookie. Tokens stay valid until they expire;
+// clear Token to stop using it.
+func (c *Client) Logout(ctx context.Context) error {
+	var resp models.StatusResponse
+	return c.do(ctx, http.MethodPost, "/api/auth/logout", nil, &resp)
+}
+
+func sessionPath(sessionID string) string {
+	return "/api/sessions/" + url.PathEscape(sessionID)
+}
+
+func teamPath(teamID string) string {
+	return "/api/teams/" + url.PathEscape(teamID)
+}
+
+func templatePath(templateID string) string {
+	return "/api/templates/" + url.PathEscape(templateID)
+}
+
+// authHeader identifies the caller's account, with Token or AccountID
+func (c *Client) authHeader() http.Header {
+	header := c.tokenHeader()
+	if c.Token == "" && c.AccountID != "" {
+		header.Set("X-Account-ID", c.AccountID)
+	}
+	return header
+}
+
+// tokenHeader carries Token; joins send AccountID in the join message
+// instead of a header
+func (c *Client) tokenHeader() http.Header {
+	header := http.Header{}
+	if c.Token != "" {
+		header.Set("Authorization", "Bearer "+c.Token)
+	}
+	return header
+}
+
+// do sends a request with an optional JSON body. The response is decoded as
+// JSON into out, or copied verbatim when out is a *bytes.Buffer.
+func (c *Client) do(ctx context.Context, method, path string, in, out interface{}) error {
+	return c.send(ctx, method, path, nil, in, out)
+}
+
+// send is do with extra request headers
+func (c *Client) send(ctx context.Context, method, path string, header http.Header, in, out interface{}) error {
+	var body io.Reader
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return fmt.Errorf("encoding request: %w", err)
+		}
+		body = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, body)
+	if err != nil {
+		return err
+	}
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	for key, values := range c.authHeader() {
+		req.Header[key] = values
+	}
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		apiErr := &Error{StatusCode: resp.StatusCode}
+		if err := json.NewDecoder(resp.Body).Decode(&apiErr.Problem); err != nil {
+			apiErr.Problem.Status = resp.StatusCode
+			apiErr.Problem.Title = http.StatusText(resp.StatusCode)
+		}
+		return apiErr
+	}
+
+	if buf, ok := out.(*bytes.Buffer); ok {
+		_, err := io.Copy(buf, resp.Body)
+		return err
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("decoding %s %s response: %w", method, path, err)
+	}
+	return nil
+}
@@ -0,0 +1,190 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"poker-planning-api/models"
+	"poker-planning-api/openapi"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// calls invokes each client method against a fake server, keyed by the
+// OpenAPI operationId it wraps
+var calls = map[string]func(c *Client) error{
+	"health": func(c *Client) error {
+		_, err := c.Health(context.Background())
+		return err
+	},
+	"createSession": func(c *Client) error {
+		_, err := c.CreateSession(context.Background(), models.CreateSessionRequest{Name: "n", HostName: "h"})
+		return err
+	},
+	"listSessions": func(c *Client) error {
+		_, err := c.ListSessions(context.Background(), ListOptions{UserIDs: []string{"u1"}, Query: "sprint"})
+		return err
+	},
+	"getSession": func(c *Client) error {
+		_, err := c.GetSession(context.Background(), "s1")
+		return err
+	},
+	"addItem": func(c *Client) error {
+		_, err := c.AddItem(context.Background(), "s1", models.AddItemRequest{Title: "t"})
+		return err
+	},
+	"setCurrentItem": func(c *Client) error {
+		return c.SetCurrentItem(context.Background(), "s1", "i1")
+	},
+	"archiveSession": func(c *Client) error {
+		return c.ArchiveSession(context.Background(), "s1", "h1")
+	},
+	"resolveJoinCode": func(c *Client) error {
+		_, err := c.ResolveJoinCode(context.Background(), "ABC234")
+		return err
+	},
+	"createAccount": func(c *Client) error {
+		_, err := c.CreateAccount(context.Background(), models.AccountRequest{DisplayName: "d"})
+		return err
+	},
+	"getAccount": func(c *Client) error {
+		_, err := c.GetAccount(context.Background())
+		return err
+	},
+	"updateAccount": func(c *Client) error {
+		_, err := c.UpdateAccount(context.Background(), models.AccountRequest{DisplayName: "d"})
+		return err
+	},
+	"deleteAccount": func(c *Client) error {
+		return c.DeleteAccount(context.Background())
+	},
+	"createTeam": func(c *Client) error {
+		_, err := c.CreateTeam(context.Background(), models.TeamRequest{Name: "n"})
+		return err
+	},
+	"listTeams": func(c *Client) error {
+		_, err := c.ListTeams(context.Background())
+		return err
+	},
+	"getTeam": func(c *Client) error {
+		_, err := c.GetTeam(context.Background(), "t1")
+		return err
+	},
+	"updateTeam": func(c *Client) error {
+		_, err := c.UpdateTeam(context.Background(), "t1", models.TeamRequest{Name: "n"})
+		return err
+	},
+	"deleteTeam": func(c *Client) error {
+		return c.DeleteTeam(context.Background(), "t1")
+	},
+	"setTeamMember": func(c *Client) error {
+		_, err := c.SetTeamMember(context.Background(), "t1", "a1", models.TeamRoleMember)
+		return err
+	},
+	"removeTeamMember": func(c *Client) error {
+		return c.RemoveTeamMember(context.Background(), "t1", "a1")
+	},
+	"listTeamSessions": func(c *Client) error {
+		_, err := c.ListTeamSessions(context.Background(), "t1", ListOptions{Query: "sprint"})
+		return err
+	},
+	"createTemplate": func(c *Client) error {
+		_, err := c.CreateTemplate(context.Background(), models.TemplateRequest{Name: "n"})
+		return err
+	},
+	"listTemplates": func(c *Client) error {
+		_, err := c.ListTemplates(context.Background())
+		return err
+	},
+	"getTemplate": func(c *Client) error {
+		_, err := c.GetTemplate(context.Background(), "tp1")
+		return err
+	},
+	"updateTemplate": func(c *Client) error {
+		_, err := c.UpdateTemplate(context.Background(), "tp1", models.TemplateRequest{Name: "n"})
+		return err
+	},
+	"deleteTemplate": func(c *Client) error {
+		return c.DeleteTemplate(context.Background(), "tp1")
+	},
+	"register": func(c *Client) error {
+		_, err := c.Register(context.Background(), models.RegisterRequest{Email: "e@example.com", Password: "p"})
+		return err
+	},
+	"login": func(c *Client) error {
+		_, err := c.Login(context.Background(), "local", models.LoginRequest{Email: "e@example.com", Password: "p"})
+		return err
+	},
+	"logout": func(c *Client) error {
+		return c.Logout(context.Background())
+	},
+	"joinSession": func(c *Client) error {
+		// The fake server cannot upgrade, so only the request is checked
+		c.JoinSession(context.Background(), "s1", JoinOptions{UserName: "u"})
+		return nil
+	},
+}
+
+// TestClientMatchesOpenAPISpec checks that every operation marked for the
+// client has a method with the matching name that calls the documented
+// method and path
+func TestClientMatchesOpenAPISpec(t *testing.T) {
+	clientType := reflect.TypeOf(&Client{})
+
+	for _, op := range openapi.Operations {
+		if !op.Client {
+			if _, exists := calls[op.OperationID]; exists {
+				t.Errorf("%s is wrapped by the client but not marked Client in the spec", op.OperationID)
+			}
+			continue
+		}
+
+		methodName := strings.ToUpper(op.OperationID[:1]) + op.OperationID[1:]
+		if _, exists := clientType.MethodByName(methodName); !exists {
+			t.Errorf("operation %s has no Client.%s method", op.OperationID, methodName)
+			continue
+		}
+
+		call, exists := calls[op.OperationID]
+		if !exists {
+			t.Errorf("operation %s has no entry in the calls table", op.OperationID)
+			continue
+		}
+
+		var gotMethod, gotPath string
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			gotMethod, gotPath = r.Method, r.URL.Path
+			w.Write([]byte("null"))
+		}))
+		if err := call(New(server.URL)); err != nil {
+			t.Errorf("%s: %v", op.OperationID, err)
+		}
+		server.Close()
+
+		wantPath := strings.NewReplacer("{sessionId}", "s1", "{code}", "ABC234", "{provider}", "local", "{teamId}", "t1", "{accountId}", "a1", "{templateId}", "tp1").Replace(op.Path)
+		if gotMethod != op.Method || gotPath != wantPath {
+			t.Errorf("%s: client sent %s %s, spec says %s %s", op.OperationID, gotMethod, gotPath, op.Method, wantPath)
+		}
+	}
+}
+
+func TestProblemResponsesBecomeErrors(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/problem+json")
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"status":404,"code":"session_not_found","requestId":"r1"}`))
+	}))
+	defer server.Close()
+
+	_, err := New(server.URL).GetSession(context.Background(), "missing")
+
+	var apiErr *Error
+	if !errors.As(err, &apiErr) {
+		t.Fatalf("expected *Error, got %v", err)
+	}
+	if apiErr.StatusCode != http.StatusNotFound || apiErr.Problem.Code != models.CodeSessionNotFound || apiErr.Problem.RequestID != "r1" {
+		t.Errorf("unexpected error %+v", apiErr)
+	}
+}
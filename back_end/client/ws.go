@@ -0,0 +1,562 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"poker-planning-api/models"
+	"poker-planning-api/protocol"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// ErrNotConnected is returned when sending while the connection is down,
+// e.g. during a reconnect
+var ErrNotConnected = errors.New("client: not connected")
+
+// JoinError is returned when the server rejects the join message, e.g.
+// because the username is already taken
+type JoinError struct {
+	Reason string
+	// Code is models.CodeInternal when the server failed to serve the
+	// join, which may succeed when retried; empty otherwise
+	Code string
+}
+
+func (e *JoinError) Error() string {
+	return "join rejected: " + e.Reason
+}
+
+// HandshakeError is returned when the server refuses the WebSocket
+// handshake, e.g. with 404 for an unknown session or 503 while it restarts
+type HandshakeError struct {
+	StatusCode int
+	Err        error
+}
+
+func (e *HandshakeError) Error() string {
+	return fmt.Sprintf("%v (status %d)", e.Err, e.StatusCode)
+}
+
+func (e *HandshakeError) Unwrap() error {
+	return e.Err
+}
+
+// errClosed ends a reconnect interrupted by Close
+var errClosed = errors.New("client: closed")
+
+// Event is a server message with its payload decoded into the matching
+// protocol or models type, e.g. models.PlanningItem for votes_revealed
+type Event struct {
+	Type       string
+	Payload    interface{}
+	ReceivedAt time.Time
+}
+
+// Handlers are called from the connection's read loop, one event at a time.
+// Any of them may be nil.
+type Handlers struct {
+	// OnEvent receives every event, before the typed handler below
+	OnEvent func(Event)
+
+	// OnWelcome is called after the initial join and after every reconnect
+	OnWelcome            func(protocol.WelcomePayload)
+	OnUserJoined         func(models.User)
+	OnUserLeft           func(protocol.UserLeftPayload)
+	OnItemAdded          func(models.PlanningItem)
+	OnCurrentItemChanged func(protocol.ItemPayload)
+	OnVoteSubmitted      func(protocol.VoteSubmittedPayload)
+	OnVotesRevealed      func(models.PlanningItem)
+	OnVotesReset         func(protocol.ItemPayload)
+	OnFinalEstimateSet   func(protocol.FinalEstimatePayload)
+	OnError              func(protocol.ErrorPayload)
+	OnNotice             func(protocol.NoticePayload)
+
+	// OnDisconnect is called when the connection drops. If reconnection is
+	// enabled a new attempt follows; otherwise the Conn is done.
+	OnDisconnect func(error)
+}
+
+// JoinOptions configures a WebSocket participant
+type JoinOptions struct {
+	// UserName may be left empty when the client has a Token or an
+	// AccountID, to use the account's display name
+	UserName string
+	// UserID rejoins as an existing user, e.g. the hostId returned by
+	// CreateSession. Leave empty to join as a new participant.
+	UserID string
+	// ResumeToken proves a rejoin as UserID: the one from Conn.ResumeToken
+	// of the participant's earlier connection. It is not needed for the
+	// participant of the client's account.
+	ResumeToken string
+	// HostKey proves a join as the host: the hostKey returned by
+	// CreateSession. It is not needed for the host's account.
+	HostKey string
+
+	Handlers Handlers
+
+	// Reconnect re-establishes dropped connections with exponential
+	// backoff, rejoining with the same user ID and resume token, until
+	// Close is called. Connections closed for a policy violation, such as
+	// exceeding the message rate, or because an operator closed the
+	// session stay closed, as do rejoins the server refuses for good: an
+	// unknown or archived session, or a resume it does not accept. Server
+	// failures and restarts are retried.
+	Reconnect bool
+	// MaxBackoff caps the delay between reconnect attempts (default 30s)
+	MaxBackoff time.Duration
+}
+
+// Conn is a participant connected to a session over WebSocket
+type Conn struct {
+	client    *Client
+	sessionID string
+	opts      JoinOptions
+
+	mu      sync.Mutex
+	ws      *websocket.Conn
+	welcome protocol.WelcomePayload
+	// resumeToken is the latest one received, kept across welcomes that
+	// carry none
+	resumeToken string
+	closed      bool
+	err         error
+	// closing is cancelled by Close, interrupting a reconnect's backoff
+	// and dial
+	closing context.Context
+	stop    context.CancelFunc
+
+	writeMu sync.Mutex
+	done    chan struct{}
+}
+
+const (
+	initialBackoff    = 500 * time.Millisecond
+	defaultMaxBackoff = 30 * time.Second
+	dialTimeout       = 10 * time.Second
+)
+
+// JoinSession connects to /ws/{sessionId}, performs the join handshake and
+// starts dispatching events to opts.Handlers. sessionID may also be the
+// session's join code.
+func (c *Client) JoinSession(ctx context.Context, sessionID string, opts JoinOptions) (*Conn, error) {
+	if opts.MaxBackoff <= 0 {
+		opts.MaxBackoff = defaultMaxBackoff
+	}
+
+	conn := &Conn{
+		client:      c,
+		sessionID:   sessionID,
+		opts:        opts,
+		resumeToken: opts.ResumeToken,
+		done:        make(chan struct{}),
+	}
+	conn.closing, conn.stop = context.WithCancel(context.Background())
+
+	ws, welcome, err := conn.dial(ctx, opts.UserID, opts.ResumeToken)
+	if err != nil {
+		conn.stop()
+		return nil, err
+	}
+	conn.ws = ws
+	conn.setWelcome(welcome)
+
+	if h := opts.Handlers.OnWelcome; h != nil {
+		h(welcome)
+	}
+	go conn.run(ws)
+
+	return conn, nil
+}
+
+// UserID returns the ID assigned to this participant by the server
+func (c *Conn) UserID() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.welcome.UserID
+}
+
+// ResumeToken returns the token to rejoin as this participant with, in
+// JoinOptions.ResumeToken, or "" if the server issued none, e.g. to the host
+func (c *Conn) ResumeToken() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.resumeToken
+}
+
+// setWelcome records the latest welcome and the resume token it carries
+func (c *Conn) setWelcome(welcome protocol.WelcomePayload) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.welcome = welcome
+	if welcome.ResumeToken != "" {
+		c.resumeToken = welcome.ResumeToken
+	}
+}
+
+// Session returns the session state received in the latest welcome message.
+// It is not updated by later events.
+func (c *Conn) Session() *models.Session {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.welcome.Session
+}
+
+// ProtocolVersion returns the protocol version negotiated with the server
+func (c *Conn) ProtocolVersion() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.welcome.ProtocolVersion
+}
+
+// Done is closed once the connection has ended for good
+func (c *Conn) Done() <-chan struct{} {
+	return c.done
+}
+
+// Err returns the error that ended the connection, or nil after Close
+func (c *Conn) Err() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.err
+}
+
+// Vote casts or changes this participant's vote for an item
+func (c *Conn) Vote(itemID, vote string) error {
+	return c.send(protocol.TypeVote, protocol.VotePayload{ItemID: itemID, Vote: vote})
+}
+
+// RevealVotes reveals all votes for an item (host only)
+func (c *Conn) RevealVotes(itemID string) error {
+	return c.send(protocol.TypeRevealVotes, protocol.ItemPayload{ItemID: itemID})
+}
+
+// ResetVotes clears all votes for an item (host only)
+func (c *Conn) ResetVotes(itemID string) error {
+	return c.send(protocol.TypeResetVotes, protocol.ItemPayload{ItemID: itemID})
+}
+
+// SetFinalEstimate records the agreed estimate for an item (host only)
+func (c *Conn) SetFinalEstimate(itemID, estimate string) error {
+	return c.send(protocol.TypeSetFinalEstimate, protocol.FinalEstimatePayload{ItemID: itemID, Estimate: estimate})
+}
+
+// Close disconnects from the session and stops reconnecting
+func (c *Conn) Close() error {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return nil
+	}
+	c.closed = true
+	ws := c.ws
+	c.mu.Unlock()
+	c.stop()
+
+	if ws == nil {
+		return nil
+	}
+	c.writeMu.Lock()
+	ws.WriteControl(websocket.CloseMessage,
+		websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""),
+		time.Now().Add(time.Second))
+	c.writeMu.Unlock()
+	return ws.Close()
+}
+
+func (c *Conn) send(msgType string, payload interface{}) error {
+	c.mu.Lock()
+	ws := c.ws
+	c.mu.Unlock()
+	if ws == nil {
+		return ErrNotConnected
+	}
+
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	return ws.WriteJSON(models.WSMessage{Type: msgType, Payload: payload})
+}
+
+// dial opens the socket and completes the join handshake
+func (c *Conn) dial(ctx context.Context, userID, resumeToken string) (*websocket.Conn, protocol.WelcomePayload, error) {
+	var welcome protocol.WelcomePayload
+
+	wsURL, err := c.client.webSocketURL(c.sessionID)
+	if err != nil {
+		return nil, welcome, err
+	}
+
+	ws, resp, err := websocket.DefaultDialer.DialContext(ctx, wsURL, c.client.tokenHeader())
+	if err != nil {
+		if resp != nil {
+			return nil, welcome, fmt.Errorf("dialing %s: %w", wsURL, &HandshakeError{StatusCode: resp.StatusCode, Err: err})
+		}
+		return nil, welcome, fmt.Errorf("dialing %s: %w", wsURL, err)
+	}
+
+	join := protocol.JoinMessage{
+		UserName:        c.opts.UserName,
+		UserID:          userID,
+		ResumeToken:     resumeToken,
+		HostKey:         c.opts.HostKey,
+		AccountID:       c.client.AccountID,
+		ProtocolVersion: protocol.CurrentVersion,
+	}
+	if err := ws.WriteJSON(join); err != nil {
+		ws.Close()
+		return nil, welcome, err
+	}
+
+	var frame protocol.Frame
+	if err := ws.ReadJSON(&frame); err != nil {
+		ws.Close()
+		return nil, welcome, fmt.Errorf("reading join response: %w", err)
+	}
+
+	switch frame.Type {
+	case protocol.TypeWelcome:
+		if err := frame.DecodePayload(&welcome); err != nil {
+			ws.Close()
+			return nil, welcome, err
+		}
+		return ws, welcome, nil
+	case protocol.TypeError:
+		var payload protocol.ErrorPayload
+		frame.DecodePayload(&payload)
+		ws.Close()
+		return nil, welcome, &JoinError{Reason: payload.Error, Code: payload.Code}
+	default:
+		ws.Close()
+		return nil, welcome, fmt.Errorf("unexpected %q message during join", frame.Type)
+	}
+}
+
+// run reads events until the connection ends, reconnecting if enabled
+func (c *Conn) run(ws *websocket.Conn) {
+	defer close(c.done)
+
+	for {
+		err := c.readLoop(ws)
+
+		c.mu.Lock()
+		c.ws = nil
+		closed := c.closed
+		c.mu.Unlock()
+		if closed {
+			return
+		}
+
+		if h := c.opts.Handlers.OnDisconnect; h != nil {
+			h(err)
+		}
+		// A client dropped for breaking the server's rules, e.g. for
+		// flooding, must not come straight back, and a closed session
+		// cannot be rejoined
+		if !c.opts.Reconnect || websocket.IsCloseError(err, websocket.ClosePolicyViolation, protocol.CloseSessionClosed) {
+			c.finish(err)
+			return
+		}
+
+		ws, err = c.reconnect()
+		if errors.Is(err, errClosed) {
+			return
+		}
+		if err != nil {
+			c.finish(err)
+			return
+		}
+	}
+}
+
+func (c *Conn) finish(err error) {
+	c.mu.Lock()
+	c.err = err
+	c.closed = true
+	c.mu.Unlock()
+}
+
+func (c *Conn) readLoop(ws *websocket.Conn) error {
+	defer ws.Close()
+
+	for {
+		var frame protocol.Frame
+		if err := ws.ReadJSON(&frame); err != nil {
+			return err
+		}
+		c.dispatch(frame)
+	}
+}
+
+// reconnect redials with exponential backoff until it succeeds, Close is
+// called or the server refuses the rejoin for good
+func (c *Conn) reconnect() (*websocket.Conn, error) {
+	backoff := initialBackoff
+	timer := time.NewTimer(backoff)
+	defer timer.Stop()
+	for {
+		select {
+		case <-timer.C:
+		case <-c.closing.Done():
+			return nil, errClosed
+		}
+
+		c.mu.Lock()
+		userID := c.welcome.UserID
+		resumeToken := c.resumeToken
+		c.mu.Unlock()
+
+		ctx, cancel := context.WithTimeout(c.closing, dialTimeout)
+		ws, welcome, err := c.dial(ctx, userID, resumeToken)
+		cancel()
+
+		if err == nil {
+			// Close may have run while dialing, and would not have seen
+			// the new socket
+			c.mu.Lock()
+			if c.closed {
+				c.mu.Unlock()
+				ws.Close()
+				return nil, errClosed
+			}
+			c.ws = ws
+			c.mu.Unlock()
+			c.setWelcome(welcome)
+
+			if h := c.opts.Handlers.OnWelcome; h != nil {
+				h(welcome)
+			}
+			return ws, nil
+		}
+		if permanent(err) {
+			return nil, err
+		}
+
+		backoff *= 2
+		if backoff > c.opts.MaxBackoff {
+			backoff = c.opts.MaxBackoff
+		}
+		timer.Reset(backoff)
+	}
+}
+
+// permanent reports whether a failed rejoin would fail the same way again:
+// the server refused the join itself, or refused the handshake with a
+// client error such as 404 for an unknown session or 410 for an archived
+// one. Server failures, restarts and rate limits are retried.
+func permanent(err error) bool {
+	var joinErr *JoinError
+	if errors.As(err, &joinErr) {
+		return joinErr.Code != models.CodeInternal
+	}
+	var handshakeErr *HandshakeError
+	if errors.As(err, &handshakeErr) {
+		status := handshakeErr.StatusCode
+		return status >= 400 && status < 500 && status != http.StatusTooManyRequests
+	}
+	return false
+}
+
+// payloadTypes maps each server message type to its payload type, taken
+// from the protocol specification
+var payloadTypes = func() map[string]reflect.Type {
+	types := map[string]reflect.Type{}
+	for _, m := range protocol.Messages {
+		if m.Direction == protocol.ServerToClient {
+			types[m.Type] = reflect.TypeOf(m.Payload)
+		}
+	}
+	return types
+}()
+
+func (c *Conn) dispatch(frame protocol.Frame) {
+	event := Event{Type: frame.Type, ReceivedAt: time.Now()}
+
+	if t, known := payloadTypes[frame.Type]; known {
+		ptr := reflect.New(t)
+		if err := frame.DecodePayload(ptr.Interface()); err != nil {
+			return
+		}
+		event.Payload = ptr.Elem().Interface()
+	} else {
+		event.Payload = frame.Payload
+	}
+
+	h := c.opts.Handlers
+	if h.OnEvent != nil {
+		h.OnEvent(event)
+	}
+
+	switch payload := event.Payload.(type) {
+	case protocol.WelcomePayload:
+		if h.OnWelcome != nil {
+			h.OnWelcome(payload)
+		}
+	case protocol.ErrorPayload:
+		if h.OnError != nil {
+			h.OnError(payload)
+		}
+	case protocol.UserLeftPayload:
+		if h.OnUserLeft != nil {
+			h.OnUserLeft(payload)
+		}
+	case protocol.VoteSubmittedPayload:
+		if h.OnVoteSubmitted != nil {
+			h.OnVoteSubmitted(payload)
+		}
+	case protocol.FinalEstimatePayload:
+		if h.OnFinalEstimateSet != nil {
+			h.OnFinalEstimateSet(payload)
+		}
+	case protocol.NoticePayload:
+		if h.OnNotice != nil {
+			h.OnNotice(payload)
+		}
+	case models.User:
+		if h.OnUserJoined != nil {
+			h.OnUserJoined(payload)
+		}
+	case models.PlanningItem:
+		switch frame.Type {
+		case protocol.TypeItemAdded:
+			if h.OnItemAdded != nil {
+				h.OnItemAdded(payload)
+			}
+		case protocol.TypeVotesRevealed:
+			if h.OnVotesRevealed != nil {
+				h.OnVotesRevealed(payload)
+			}
+		}
+	case protocol.ItemPayload:
+		switch frame.Type {
+		case protocol.TypeCurrentItemChanged:
+			if h.OnCurrentItemChanged != nil {
+				h.OnCurrentItemChanged(payload)
+			}
+		case protocol.TypeVotesReset:
+			if h.OnVotesReset != nil {
+				h.OnVotesReset(payload)
+			}
+		}
+	}
+}
+
+func (c *Client) webSocketURL(sessionID string) (string, error) {
+	u, err := url.Parse(c.baseURL)
+	if err != nil {
+		return "", err
+	}
+	switch u.Scheme {
+	case "https":
+		u.Scheme = "wss"
+	case "http":
+		u.Scheme = "ws"
+	}
+	u.Path = strings.TrimRight(u.Path, "/") + "/ws/" + url.PathEscape(sessionID)
+	return u.String(), nil
+}
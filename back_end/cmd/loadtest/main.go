@@ -0,0 +1,433 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"math/rand"
+	"poker-planning-api/client"
+	"poker-planning-api/models"
+	"poker-planning-api/protocol"
+	"sort"
+	"sync"
+	"time"
+)
+
+type config struct {
+	baseURL      string
+	sessions     int
+	participants int
+	rounds       int
+	ramp         time.Duration
+	think        time.Duration
+	timeout      time.Duration
+}
+
+func main() {
+	var cfg config
+	flag.StringVar(&cfg.baseURL, "url", "http://localhost:8080", "base URL of the server under test")
+	flag.IntVar(&cfg.sessions, "sessions", 10, "number of concurrent sessions")
+	flag.IntVar(&cfg.participants, "participants", 5, "voting participants per session, in addition to the host")
+	flag.IntVar(&cfg.rounds, "rounds", 10, "vote/reveal/reset cycles per session")
+	flag.DurationVar(&cfg.ramp, "ramp", 2*time.Second, "spread session start-up over this duration")
+	flag.DurationVar(&cfg.think, "think", 100*time.Millisecond, "maximum random delay before each participant votes")
+	flag.DurationVar(&cfg.timeout, "timeout", 10*time.Second, "how long to wait for each expected event")
+	flag.Parse()
+
+	api := client.New(cfg.baseURL)
+	if _, err := api.Health(context.Background()); err != nil {
+		log.Fatalf("Server at %s is not reachable: %v", cfg.baseURL, err)
+	}
+
+	log.Printf("Starting %d sessions with %d participants each, %d rounds against %s",
+		cfg.sessions, cfg.participants, cfg.rounds, cfg.baseURL)
+
+	stats := newStats()
+	start := time.Now()
+
+	var wg sync.WaitGroup
+	for i := 0; i < cfg.sessions; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if cfg.sessions > 1 {
+				time.Sleep(cfg.ramp * time.Duration(i) / time.Duration(cfg.sessions))
+			}
+			if err := runSession(api, cfg, i, stats); err != nil {
+				stats.sessionFailed(err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	stats.report(time.Since(start))
+}
+
+// runSession creates one session, connects its host and participants and
+// drives the configured number of rounds
+func runSession(api *client.Client, cfg config, index int, stats *stats) error {
+	ctx := context.Background()
+
+	created, err := api.CreateSession(ctx, models.CreateSessionRequest{
+		Name:     fmt.Sprintf("loadtest-%d-%d", time.Now().Unix(), index),
+		HostName: "host",
+	})
+	stats.request(err)
+	if err != nil {
+		return fmt.Errorf("create session: %w", err)
+	}
+
+	run := newSessionRun(cfg.participants)
+
+	host, err := join(api, created.SessionID, client.JoinOptions{
+		UserName: "host",
+		UserID:   created.HostID,
+		HostKey:  created.HostKey,
+		Handlers: run.handlers(stats, true),
+	}, stats)
+	if err != nil {
+		return err
+	}
+	defer host.Close()
+
+	voters := make([]*client.Conn, 0, cfg.participants)
+	defer func() {
+		for _, voter := range voters {
+			voter.Close()
+		}
+	}()
+	for p := 0; p < cfg.participants; p++ {
+		voter, err := join(api, created.SessionID, client.JoinOptions{
+			UserName: fmt.Sprintf("voter-%d", p),
+			Handlers: run.handlers(stats, false),
+		}, stats)
+		if err != nil {
+			continue
+		}
+		voters = append(voters, voter)
+	}
+	if len(voters) == 0 {
+		return fmt.Errorf("session %s: no participant could join", created.SessionID)
+	}
+
+	for round := 0; round < cfg.rounds; round++ {
+		item, err := api.AddItem(ctx, created.SessionID, models.AddItemRequest{Title: fmt.Sprintf("item %d", round)})
+		stats.request(err)
+		if err != nil {
+			return fmt.Errorf("add item: %w", err)
+		}
+		err = api.SetCurrentItem(ctx, created.SessionID, item.ID)
+		stats.request(err)
+		if err != nil {
+			return fmt.Errorf("set current item: %w", err)
+		}
+
+		// Every participant votes after a short random think time
+		var wg sync.WaitGroup
+		for _, voter := range voters {
+			wg.Add(1)
+			go func(voter *client.Conn) {
+				defer wg.Done()
+				if cfg.think > 0 {
+					time.Sleep(time.Duration(rand.Int63n(int64(cfg.think))))
+				}
+				run.markVoteSent(item.ID, voter.UserID())
+				stats.sent(voter.Vote(item.ID, models.CardValues[rand.Intn(len(models.CardValues))]))
+			}(voter)
+		}
+		wg.Wait()
+
+		if !run.waitFor(cfg.timeout, func() bool { return run.hostVotes[item.ID] >= len(voters) }) {
+			stats.timeout(protocol.TypeVoteSubmitted)
+			continue
+		}
+
+		stats.sent(host.RevealVotes(item.ID))
+		if !run.waitFor(cfg.timeout, func() bool { return run.revealed[item.ID] }) {
+			stats.timeout(protocol.TypeVotesRevealed)
+			continue
+		}
+
+		stats.sent(host.ResetVotes(item.ID))
+		if !run.waitFor(cfg.timeout, func() bool { return run.reset[item.ID] }) {
+			stats.timeout(protocol.TypeVotesReset)
+			continue
+		}
+		stats.roundCompleted()
+	}
+
+	return nil
+}
+
+func join(api *client.Client, sessionID string, opts client.JoinOptions, stats *stats) (*client.Conn, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	started := time.Now()
+	conn, err := api.JoinSession(ctx, sessionID, opts)
+	stats.connection(time.Since(started), err)
+	return conn, err
+}
+
+// sessionRun tracks what the host has observed so the driver can wait for
+// each step of a round to complete
+type sessionRun struct {
+	mu        sync.Mutex
+	changed   chan struct{}
+	hostVotes map[string]int
+	revealed  map[string]bool
+	reset     map[string]bool
+	voteSent  map[string]time.Time
+}
+
+func newSessionRun(participants int) *sessionRun {
+	return &sessionRun{
+		changed:   make(chan struct{}, 1),
+		hostVotes: map[string]int{},
+		revealed:  map[string]bool{},
+		reset:     map[string]bool{},
+		voteSent:  make(map[string]time.Time, participants),
+	}
+}
+
+func (r *sessionRun) markVoteSent(itemID, userID string) {
+	r.mu.Lock()
+	r.voteSent[itemID+"/"+userID] = time.Now()
+	r.mu.Unlock()
+}
+
+// handlers records vote delivery latency for every connection. Only the
+// host's events advance the round, so each step is counted once.
+func (r *sessionRun) handlers(stats *stats, isHost bool) client.Handlers {
+	return client.Handlers{
+		OnEvent: func(event client.Event) {
+			stats.received(event.Type)
+		},
+		OnVoteSubmitted: func(p protocol.VoteSubmittedPayload) {
+			r.mu.Lock()
+			defer r.mu.Unlock()
+			if sent, ok := r.voteSent[p.ItemID+"/"+p.UserID]; ok {
+				stats.voteLatency(time.Since(sent))
+			}
+			if isHost {
+				r.hostVotes[p.ItemID]++
+				r.notify()
+			}
+		},
+		OnVotesRevealed: func(item models.PlanningItem) {
+			if !isHost {
+				return
+			}
+			r.mu.Lock()
+			r.revealed[item.ID] = true
+			r.notify()
+			r.mu.Unlock()
+		},
+		OnVotesReset: func(p protocol.ItemPayload) {
+			if !isHost {
+				return
+			}
+			r.mu.Lock()
+			r.reset[p.ItemID] = true
+			r.notify()
+			r.mu.Unlock()
+		},
+		OnDisconnect: func(err error) {
+			stats.disconnected(err)
+		},
+	}
+}
+
+func (r *sessionRun) notify() {
+	select {
+	case r.changed <- struct{}{}:
+	default:
+	}
+}
+
+// waitFor blocks until cond holds or timeout elapses. cond runs with the
+// run's mutex held.
+func (r *sessionRun) waitFor(timeout time.Duration, cond func() bool) bool {
+	deadline := time.After(timeout)
+	for {
+		r.mu.Lock()
+		done := cond()
+		r.mu.Unlock()
+		if done {
+			return true
+		}
+
+		select {
+		case <-r.changed:
+		case <-deadline:
+			return false
+		}
+	}
+}
+
+// stats aggregates results across all sessions
+type stats struct {
+	mu sync.Mutex
+
+	connections       int
+	connectionsFailed int
+	connectLatency    []time.Duration
+	disconnects       int
+	sessionsFailed    int
+	requests          int
+	requestsFailed    int
+	messagesSent      int
+	sendsFailed       int
+	eventsReceived    map[string]int
+	timeouts          map[string]int
+	rounds            int
+	voteLatencies     []time.Duration
+	errors            map[string]int
+}
+
+func newStats() *stats {
+	return &stats{
+		eventsReceived: map[string]int{},
+		timeouts:       map[string]int{},
+		errors:         map[string]int{},
+	}
+}
+
+func (s *stats) connection(latency time.Duration, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.connections++
+	if err != nil {
+		s.connectionsFailed++
+		s.errors[err.Error()]++
+		return
+	}
+	s.connectLatency = append(s.connectLatency, latency)
+}
+
+func (s *stats) disconnected(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.disconnects++
+	if err != nil {
+		s.errors[err.Error()]++
+	}
+}
+
+func (s *stats) sessionFailed(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sessionsFailed++
+	s.errors[err.Error()]++
+}
+
+func (s *stats) request(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requests++
+	if err != nil {
+		s.requestsFailed++
+	}
+}
+
+func (s *stats) sent(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.messagesSent++
+	if err != nil {
+		s.sendsFailed++
+	}
+}
+
+func (s *stats) received(eventType string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.eventsReceived[eventType]++
+}
+
+func (s *stats) timeout(eventType string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.timeouts[eventType]++
+}
+
+func (s *stats) roundCompleted() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.rounds++
+}
+
+func (s *stats) voteLatency(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.voteLatencies = append(s.voteLatencies, d)
+}
+
+func (s *stats) report(elapsed time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	totalEvents := 0
+	for _, n := range s.eventsReceived {
+		totalEvents += n
+	}
+	seconds := elapsed.Seconds()
+
+	fmt.Println()
+	fmt.Printf("Duration:              %s\n", elapsed.Round(time.Millisecond))
+	fmt.Printf("Rounds completed:      %d (%.1f/s)\n", s.rounds, float64(s.rounds)/seconds)
+	fmt.Printf("Sessions failed:       %d\n", s.sessionsFailed)
+	fmt.Println()
+	fmt.Printf("WebSocket connections: %d attempted, %d failed, %d dropped\n", s.connections, s.connectionsFailed, s.disconnects)
+	printPercentiles("Connect latency", s.connectLatency)
+	fmt.Printf("REST requests:         %d (%d failed)\n", s.requests, s.requestsFailed)
+	fmt.Printf("WS messages sent:      %d (%.1f/s, %d failed)\n", s.messagesSent, float64(s.messagesSent)/seconds, s.sendsFailed)
+	fmt.Printf("WS events received:    %d (%.1f/s)\n", totalEvents, float64(totalEvents)/seconds)
+	for _, eventType := range sortedKeys(s.eventsReceived) {
+		fmt.Printf("  %-22s %d\n", eventType, s.eventsReceived[eventType])
+	}
+	fmt.Println()
+	printPercentiles("Vote -> vote_submitted", s.voteLatencies)
+
+	if len(s.timeouts) > 0 {
+		fmt.Println()
+		fmt.Println("Timed out waiting for:")
+		for _, eventType := range sortedKeys(s.timeouts) {
+			fmt.Printf("  %-22s %d\n", eventType, s.timeouts[eventType])
+		}
+	}
+	if len(s.errors) > 0 {
+		fmt.Println()
+		fmt.Println("Errors:")
+		for _, msg := range sortedKeys(s.errors) {
+			fmt.Printf("  %4d  %s\n", s.errors[msg], msg)
+		}
+	}
+}
+
+func printPercentiles(label string, samples []time.Duration) {
+	if len(samples) == 0 {
+		fmt.Printf("%s: no samples\n", label)
+		return
+	}
+	sorted := append([]time.Duration(nil), samples...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	pick := func(p float64) time.Duration {
+		return sorted[int(p*float64(len(sorted)-1))]
+	}
+	fmt.Printf("%s (%d samples): p50=%s p90=%s p95=%s p99=%s max=%s\n", label, len(sorted),
+		pick(0.50).Round(time.Microsecond), pick(0.90).Round(time.Microsecond),
+		pick(0.95).Round(time.Microsecond), pick(0.99).Round(time.Microsecond),
+		sorted[len(sorted)-1].Round(time.Microsecond))
+}
+
+func sortedKeys(m map[string]int) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -2,27 +2,26 @@ package main
 
 import (
 	"database/sql"
-	"fmt"
+	"flag"
 	"log"
+	"os"
+	"poker-planning-api/config"
 
 	_ "github.com/lib/pq"
 )
 
-const (
-	host     = "localhost"
-	port     = 5432
-	user     = "postgres"
-	password = "1234"
-	dbname   = "poker_planning"
-)
-
 func main() {
-	// Connect to the database
-	connStr := fmt.Sprintf("host=%s port=%d user=%s password=%s dbname=%s sslmode=disable",
-		host, port, user, password, dbname)
+	// Same configuration as the server: config file, env and flags
+	flags := config.BindFlags(flag.CommandLine)
+	flag.Parse()
+	cfg, err := config.Load(flags)
+	if err != nil {
+		log.Fatal("Invalid configuration:", err)
+	}
 
+	// Connect to the database
 	log.Println("Connecting to PostgreSQL...")
-	db, err := sql.Open("postgres", connStr)
+	db, err := sql.Open("postgres", cfg.Database.DSN())
 	if err != nil {
 		log.Fatal("Error connecting to PostgreSQL:", err)
 	}
@@ -33,22 +32,15 @@ func main() {
 	}
 	log.Println("✓ Connected to database")
 
-	// Drop all tables
+	// Drop all tables, with the same script as database/README.md, so the
+	// list cannot fall behind schema.sql
 	log.Println("Dropping all tables...")
-
-	dropStatements := []string{
-		"DROP TABLE IF EXISTS votes CASCADE",
-		"DROP TABLE IF EXISTS planning_items CASCADE",
-		"DROP TABLE IF EXISTS users CASCADE",
-		"DROP TABLE IF EXISTS sessions CASCADE",
-		"DROP FUNCTION IF EXISTS update_updated_at_column CASCADE",
+	drop, err := os.ReadFile("database/drop.sql")
+	if err != nil {
+		log.Fatal("Error reading drop.sql:", err)
 	}
-
-	for _, stmt := range dropStatements {
-		_, err := db.Exec(stmt)
-		if err != nil {
-			log.Printf("Warning: %v\n", err)
-		}
+	if _, err := db.Exec(string(drop)); err != nil {
+		log.Fatal("Error dropping tables:", err)
 	}
 
 	log.Println("✓ All tables dropped successfully!")
@@ -2,25 +2,28 @@ package main
 
 import (
 	"database/sql"
+	"flag"
 	"fmt"
 	"log"
 	"os"
+	"poker-planning-api/config"
+	"strings"
 
 	_ "github.com/lib/pq"
 )
 
-const (
-	host     = "localhost"
-	port     = 5432
-	user     = "postgres"
-	password = "1234"
-	dbname   = "poker_planning"
-)
-
 func main() {
+	// Same configuration as the server: config file, env and flags
+	flags := config.BindFlags(flag.CommandLine)
+	flag.Parse()
+	cfg, err := config.Load(flags)
+	if err != nil {
+		log.Fatal("Invalid configuration:", err)
+	}
+	dbname := cfg.Database.Name
+
 	// First, connect to postgres database to create our database
-	connStr := fmt.Sprintf("host=%s port=%d user=%s password=%s sslmode=disable",
-		host, port, user, password)
+	connStr := cfg.Database.DSNFor("postgres")
 
 	log.Println("Connecting to PostgreSQL...")
 	db, err := sql.Open("postgres", connStr)
@@ -37,7 +40,7 @@ func main() {
 
 	// Create database if it doesn't exist
 	log.Println("Creating database...")
-	_, err = db.Exec(fmt.Sprintf("CREATE DATABASE %s", dbname))
+	_, err = db.Exec(fmt.Sprintf("CREATE DATABASE %s", quoteIdentifier(dbname)))
 	if err != nil {
 		// Database might already exist
 		log.Printf("Database creation: %v (might already exist)\n", err)
@@ -49,20 +52,17 @@ func main() {
 	db.Close()
 
 	// Connect to our new database
-	connStr = fmt.Sprintf("host=%s port=%d user=%s password=%s dbname=%s sslmode=disable",
-		host, port, user, password, dbname)
-
-	log.Println("Connecting to poker_planning database...")
-	db, err = sql.Open("postgres", connStr)
+	log.Printf("Connecting to %s database...\n", dbname)
+	db, err = sql.Open("postgres", cfg.Database.DSN())
 	if err != nil {
-		log.Fatal("Error connecting to poker_planning database:", err)
+		log.Fatalf("Error connecting to %s database: %v", dbname, err)
 	}
 	defer db.Close()
 
 	if err = db.Ping(); err != nil {
-		log.Fatal("Error pinging poker_planning database:", err)
+		log.Fatalf("Error pinging %s database: %v", dbname, err)
 	}
-	log.Println("✓ Connected to poker_planning database")
+	log.Printf("✓ Connected to %s database\n", dbname)
 
 	// Read and execute schema
 	log.Println("Creating database schema...")
@@ -81,3 +81,9 @@ func main() {
 	log.Println("\n✅ Database setup complete!")
 	log.Println("\nYou can now run the application with: go run main.go")
 }
+
+// quoteIdentifier quotes a database name for CREATE DATABASE, which does
+// not accept bind parameters
+func quoteIdentifier(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
@@ -0,0 +1,682 @@
+// Package config loads the server configuration.
+//
+// Values are layered, each layer overriding the previous one:
+//
+//  1. built-in defaults, suitable for local development
+//  2. a YAML file given by -config or CONFIG_FILE
+//  3. environment variables
+//  4. command-line flags
+//
+// DATABASE_URL (or database.url), when set, takes precedence over the
+// individual database fields. Validate rejects invalid values everywhere
+// and insecure ones when env is "production".
+package config
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Environments
+const (
+	Development = "development"
+	Production  = "production"
+)
+
+// Config is the complete server configuration
+type Config struct {
+	// Env is "development" or "production"; production enables the
+	// security checks in Validate
+	Env       string          `yaml:"env"`
+	Server    ServerConfig    `yaml:"server"`
+	Database  DatabaseConfig  `yaml:"database"`
+	Log       LogConfig       `yaml:"log"`
+	RateLimit RateLimitConfig `yaml:"rateLimit"`
+	Auth      AuthConfig      `yaml:"auth"`
+}
+
+// ServerConfig configures the HTTP listener
+type ServerConfig struct {
+	Port           int      `yaml:"port"`
+	AllowedOrigins []string `yaml:"allowedOrigins"`
+	// AllowAllOrigins accepts requests and WebSocket connections from any
+	// origin; refused in production
+	AllowAllOrigins bool `yaml:"allowAllOrigins"`
+	// ShutdownTimeout bounds graceful shutdown after SIGTERM
+	ShutdownTimeout time.Duration `yaml:"shutdownTimeout"`
+	// AdminToken is the bearer token for the /admin API, which is
+	// disabled when it is empty
+	AdminToken string `yaml:"adminToken"`
+}
+
+// DatabaseConfig configures the PostgreSQL connection and pool
+type DatabaseConfig struct {
+	// URL is a postgres:// connection URL. When set it overrides Host,
+	// Port, User, Password, Name and SSLMode.
+	URL      string `yaml:"url"`
+	Host     string `yaml:"host"`
+	Port     int    `yaml:"port"`
+	User     string `yaml:"user"`
+	Password string `yaml:"password"`
+	Name     string `yaml:"name"`
+	SSLMode  string `yaml:"sslMode"`
+
+	MaxOpenConns int `yaml:"maxOpenConns"`
+	// MinConns connections are kept open while idle, so a quiet server
+	// does not pay for a new connection on its next query
+	MinConns        int           `yaml:"minConns"`
+	ConnMaxLifetime time.Duration `yaml:"connMaxLifetime"`
+	// ConnectTimeout bounds each attempt to open a connection
+	ConnectTimeout time.Duration `yaml:"connectTimeout"`
+	// Timeouts bound each database operation by its class
+	Timeouts QueryTimeouts `yaml:"timeouts"`
+	// VoteFlushInterval is how long votes are held to be written in one
+	// batch; zero writes each vote before acknowledging it
+	VoteFlushInterval time.Duration `yaml:"voteFlushInterval"`
+	// QueryExecMode is pgx's default_query_exec_mode. Empty keeps the
+	// URL's or pgx's default, which prepares statements; behind a pooler
+	// in transaction mode use "exec".
+	QueryExecMode string `yaml:"queryExecMode"`
+}
+
+// QueryTimeouts bound database operations, including waiting for a pooled
+// connection, by class. Zero leaves a class unbounded.
+type QueryTimeouts struct {
+	// Read covers loading sessions, listings and lookups
+	Read time.Duration `yaml:"read"`
+	// Write covers joins, votes, reveals and other changes made by a
+	// request or WebSocket message
+	Write time.Duration `yaml:"write"`
+	// Maintenance covers admin operations on many sessions, such as
+	// purging old ones
+	Maintenance time.Duration `yaml:"maintenance"`
+}
+
+// LogConfig configures the structured logger
+type LogConfig struct {
+	Format string `yaml:"format"`
+	Level  string `yaml:"level"`
+}
+
+// RateLimitConfig bounds how fast a single client may use the server. A
+// zero rate or count disables that limit.
+type RateLimitConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// TrustedProxies is the number of reverse proxies in front of the
+	// server that append to X-Forwarded-For. The client IP is taken that
+	// many entries from the right; 0 uses the connection's address.
+	TrustedProxies int `yaml:"trustedProxies"`
+	// DirectClients acknowledges that clients connect without a proxy,
+	// which production otherwise refuses with TrustedProxies 0: behind a
+	// proxy every client would share its address's limits
+	DirectClients bool `yaml:"directClients"`
+
+	// RequestsPerSecond and RequestBurst apply per client IP to /api and
+	// /ws requests
+	RequestsPerSecond float64 `yaml:"requestsPerSecond"`
+	RequestBurst      int     `yaml:"requestBurst"`
+	// SessionsPerHour caps session creation per client IP
+	SessionsPerHour int `yaml:"sessionsPerHour"`
+	// MessagesPerSecond and MessageBurst apply per WebSocket connection
+	MessagesPerSecond float64 `yaml:"messagesPerSecond"`
+	MessageBurst      int     `yaml:"messageBurst"`
+}
+
+// AuthConfig configures sign-in. A caller who signs in gets a token, signed
+// with Secret, that identifies their account until it expires.
+type AuthConfig struct {
+	// Secret signs the tokens. When it is empty in development a random
+	// secret is used, so sign-ins end when the server restarts.
+	Secret   string        `yaml:"secret"`
+	TokenTTL time.Duration `yaml:"tokenTTL"`
+	// SecureCookie sends the sign-in cookie over HTTPS only
+	SecureCookie bool            `yaml:"secureCookie"`
+	Local        LocalAuthConfig `yaml:"local"`
+	OIDC         OIDCConfig      `yaml:"oidc"`
+}
+
+// LocalAuthConfig configures sign-in with an email address and password
+type LocalAuthConfig struct {
+	Enabled bool `yaml:"enabled"`
+}
+
+// OIDCConfig configures sign-in with an OpenID Connect provider, which is
+// off while Issuer is empty
+type OIDCConfig struct {
+	// Name identifies the provider in URLs and stored logins; changing it
+	// orphans the accounts created through it
+	Name         string `yaml:"name"`
+	Issuer       string `yaml:"issuer"`
+	ClientID     string `yaml:"clientId"`
+	ClientSecret string `yaml:"clientSecret"`
+	// RedirectURL is the callback registered with the provider:
+	// https://<host>/api/auth/<name>/callback
+	RedirectURL string `yaml:"redirectUrl"`
+}
+
+// Enabled reports whether any sign-in provider is configured
+func (a AuthConfig) Enabled() bool {
+	return a.Local.Enabled || a.OIDC.Issuer != ""
+}
+
+// Default returns the built-in configuration. The database defaults match
+// the local setup in README.md and are refused in production.
+func Default() Config {
+	return Config{
+		Env: Development,
+		Server: ServerConfig{
+			Port:            8080,
+			AllowedOrigins:  []string{"http://localhost:3000"},
+			ShutdownTimeout: 8 * time.Second,
+		},
+		Database: DatabaseConfig{
+			Host:            "localhost",
+			Port:            5432,
+			User:            "postgres",
+			Password:        "1234",
+			Name:            "poker_planning",
+			SSLMode:         "disable",
+			MaxOpenConns:    25,
+			MinConns:        1,
+			ConnMaxLifetime: 30 * time.Minute,
+			ConnectTimeout:  5 * time.Second,
+			Timeouts: QueryTimeouts{
+				Read:        10 * time.Second,
+				Write:       10 * time.Second,
+				Maintenance: 2 * time.Minute,
+			},
+			VoteFlushInterval: 20 * time.Millisecond,
+		},
+		Log: LogConfig{
+			Format: "text",
+			Level:  "info",
+		},
+		RateLimit: RateLimitConfig{
+			Enabled:           true,
+			RequestsPerSecond: 10,
+			RequestBurst:      30,
+			SessionsPerHour:   20,
+			MessagesPerSecond: 5,
+			MessageBurst:      15,
+		},
+		Auth: AuthConfig{
+			TokenTTL: 24 * time.Hour,
+			Local:    LocalAuthConfig{Enabled: true},
+			OIDC:     OIDCConfig{Name: "oidc"},
+		},
+	}
+}
+
+// setting maps one configuration value to its environment variable and,
+// optionally, its command-line flag
+type setting struct {
+	env   string
+	flag  string
+	usage string
+	apply func(c *Config, value string) error
+}
+
+var settings = []setting{
+	{"APP_ENV", "env", "environment: development or production", func(c *Config, v string) error {
+		c.Env = v
+		return nil
+	}},
+	{"PORT", "port", "HTTP port", intSetter(func(c *Config) *int { return &c.Server.Port })},
+	{"ALLOWED_ORIGINS", "allowed-origins", "comma-separated CORS origins", func(c *Config, v string) error {
+		c.Server.AllowedOrigins = splitList(v)
+		return nil
+	}},
+	{"ALLOW_ALL_ORIGINS", "allow-all-origins", "accept any origin (development only)", boolSetter(func(c *Config) *bool { return &c.Server.AllowAllOrigins })},
+	{"SHUTDOWN_TIMEOUT", "shutdown-timeout", "graceful shutdown deadline", durationSetter(func(c *Config) *time.Duration { return &c.Server.ShutdownTimeout })},
+	{"ADMIN_TOKEN", "", "", stringSetter(func(c *Config) *string { return &c.Server.AdminToken })},
+	{"DATABASE_URL", "database-url", "postgres:// connection URL", stringSetter(func(c *Config) *string { return &c.Database.URL })},
+	{"DB_HOST", "", "", stringSetter(func(c *Config) *string { return &c.Database.Host })},
+	{"DB_PORT", "", "", intSetter(func(c *Config) *int { return &c.Database.Port })},
+	{"DB_USER", "", "", stringSetter(func(c *Config) *string { return &c.Database.User })},
+	{"DB_PASSWORD", "", "", stringSetter(func(c *Config) *string { return &c.Database.Password })},
+	{"DB_NAME", "", "", stringSetter(func(c *Config) *string { return &c.Database.Name })},
+	{"DB_SSLMODE", "", "", stringSetter(func(c *Config) *string { return &c.Database.SSLMode })},
+	{"DB_MAX_OPEN_CONNS", "", "", intSetter(func(c *Config) *int { return &c.Database.MaxOpenConns })},
+	{"DB_MIN_CONNS", "", "", intSetter(func(c *Config) *int { return &c.Database.MinConns })},
+	{"DB_CONN_MAX_LIFETIME", "", "", durationSetter(func(c *Config) *time.Duration { return &c.Database.ConnMaxLifetime })},
+	{"DB_CONNECT_TIMEOUT", "", "", durationSetter(func(c *Config) *time.Duration { return &c.Database.ConnectTimeout })},
+	{"DB_READ_TIMEOUT", "", "", durationSetter(func(c *Config) *time.Duration { return &c.Database.Timeouts.Read })},
+	{"DB_WRITE_TIMEOUT", "", "", durationSetter(func(c *Config) *time.Duration { return &c.Database.Timeouts.Write })},
+	{"DB_MAINTENANCE_TIMEOUT", "", "", durationSetter(func(c *Config) *time.Duration { return &c.Database.Timeouts.Maintenance })},
+	{"DB_VOTE_FLUSH_INTERVAL", "", "", durationSetter(func(c *Config) *time.Duration { return &c.Database.VoteFlushInterval })},
+	{"DB_QUERY_EXEC_MODE", "", "", stringSetter(func(c *Config) *string { return &c.Database.QueryExecMode })},
+	{"LOG_FORMAT", "log-format", "log format: json or text", stringSetter(func(c *Config) *string { return &c.Log.Format })},
+	{"LOG_LEVEL", "log-level", "log level: debug, info, warn or error", stringSetter(func(c *Config) *string { return &c.Log.Level })},
+	{"RATE_LIMIT_ENABLED", "rate-limit", "enable rate limiting: true or false", boolSetter(func(c *Config) *bool { return &c.RateLimit.Enabled })},
+	{"TRUSTED_PROXIES", "trusted-proxies", "reverse proxies appending to X-Forwarded-For", intSetter(func(c *Config) *int { return &c.RateLimit.TrustedProxies })},
+	{"RATE_LIMIT_DIRECT_CLIENTS", "", "", boolSetter(func(c *Config) *bool { return &c.RateLimit.DirectClients })},
+	{"RATE_LIMIT_REQUESTS_PER_SECOND", "", "", floatSetter(func(c *Config) *float64 { return &c.RateLimit.RequestsPerSecond })},
+	{"RATE_LIMIT_REQUEST_BURST", "", "", intSetter(func(c *Config) *int { return &c.RateLimit.RequestBurst })},
+	{"RATE_LIMIT_SESSIONS_PER_HOUR", "", "", intSetter(func(c *Config) *int { return &c.RateLimit.SessionsPerHour })},
+	{"RATE_LIMIT_MESSAGES_PER_SECOND", "", "", floatSetter(func(c *Config) *float64 { return &c.RateLimit.MessagesPerSecond })},
+	{"RATE_LIMIT_MESSAGE_BURST", "", "", intSetter(func(c *Config) *int { return &c.RateLimit.MessageBurst })},
+	{"AUTH_SECRET", "", "", stringSetter(func(c *Config) *string { return &c.Auth.Secret })},
+	{"AUTH_TOKEN_TTL", "", "", durationSetter(func(c *Config) *time.Duration { return &c.Auth.TokenTTL })},
+	{"AUTH_SECURE_COOKIE", "", "", boolSetter(func(c *Config) *bool { return &c.Auth.SecureCookie })},
+	{"AUTH_LOCAL_ENABLED", "", "", boolSetter(func(c *Config) *bool { return &c.Auth.Local.Enabled })},
+	{"OIDC_NAME", "", "", stringSetter(func(c *Config) *string { return &c.Auth.OIDC.Name })},
+	{"OIDC_ISSUER", "", "", stringSetter(func(c *Config) *string { return &c.Auth.OIDC.Issuer })},
+	{"OIDC_CLIENT_ID", "", "", stringSetter(func(c *Config) *string { return &c.Auth.OIDC.ClientID })},
+	{"OIDC_CLIENT_SECRET", "", "", stringSetter(func(c *Config) *string { return &c.Auth.OIDC.ClientSecret })},
+	{"OIDC_REDIRECT_URL", "", "", stringSetter(func(c *Config) *string { return &c.Auth.OIDC.RedirectURL })},
+}
+
+// Flags holds the command-line flags bound by BindFlags
+type Flags struct {
+	fs   *flag.FlagSet
+	file *string
+}
+
+// BindFlags registers the configuration flags on fs. Call fs.Parse before
+// Load.
+func BindFlags(fs *flag.FlagSet) *Flags {
+	f := &Flags{
+		fs:   fs,
+		file: fs.String("config", "", "path to a YAML configuration file (env CONFIG_FILE)"),
+	}
+	// Flags only override when given, which Load detects with fs.Visit
+	for _, s := range settings {
+		if s.flag != "" {
+			fs.String(s.flag, "", s.usage+" (env "+s.env+")")
+		}
+	}
+	return f
+}
+
+// Load builds the configuration from defaults, the config file, the
+// environment and the parsed flags, then validates it. flags may be nil.
+func Load(flags *Flags) (*Config, error) {
+	return load(flags, os.LookupEnv)
+}
+
+func load(flags *Flags, lookupEnv func(string) (string, bool)) (*Config, error) {
+	cfg := Default()
+
+	file, _ := lookupEnv("CONFIG_FILE")
+	if flags != nil && *flags.file != "" {
+		file = *flags.file
+	}
+	if file != "" {
+		if err := cfg.loadFile(file); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, s := range settings {
+		if value, ok := lookupEnv(s.env); ok && value != "" {
+			if err := s.apply(&cfg, value); err != nil {
+				return nil, fmt.Errorf("%s: %w", s.env, err)
+			}
+		}
+	}
+
+	if flags != nil {
+		var err error
+		flags.fs.Visit(func(f *flag.Flag) {
+			for _, s := range settings {
+				if s.flag == f.Name && err == nil {
+					if applyErr := s.apply(&cfg, f.Value.String()); applyErr != nil {
+						err = fmt.Errorf("-%s: %w", s.flag, applyErr)
+					}
+				}
+			}
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if cfg.Database.URL != "" {
+		if err := cfg.Database.applyURL(); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return &cfg, nil
+}
+
+func (c *Config) loadFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("config file: %w", err)
+	}
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(c); err != nil {
+		return fmt.Errorf("config file %s: %w", path, err)
+	}
+	return nil
+}
+
+// applyURL copies the parts of URL into the individual fields so that
+// validation and cmd/setup see the effective values
+func (d *DatabaseConfig) applyURL() error {
+	u, err := url.Parse(d.URL)
+	if err != nil || (u.Scheme != "postgres" && u.Scheme != "postgresql") {
+		return errors.New("DATABASE_URL must be a postgres:// URL")
+	}
+
+	d.Host = u.Hostname()
+	if port := u.Port(); port != "" {
+		if d.Port, err = strconv.Atoi(port); err != nil {
+			return fmt.Errorf("DATABASE_URL: invalid port %q", port)
+		}
+	} else {
+		d.Port = 5432
+	}
+	d.User = u.User.Username()
+	d.Password, _ = u.User.Password()
+	d.Name = strings.TrimPrefix(u.Path, "/")
+	if sslMode := u.Query().Get("sslmode"); sslMode != "" {
+		d.SSLMode = sslMode
+	}
+	return nil
+}
+
+// DSN returns the connection URL for the configured database
+func (d DatabaseConfig) DSN() string {
+	return d.DSNFor(d.Name)
+}
+
+// DSNFor returns a connection URL for another database on the same server.
+// cmd/setup uses it to connect to "postgres" and create the database.
+func (d DatabaseConfig) DSNFor(name string) string {
+	query := url.Values{}
+	if d.URL != "" {
+		if u, err := url.Parse(d.URL); err == nil {
+			query = u.Query()
+		}
+	}
+	query.Set("sslmode", d.SSLMode)
+	if query.Get("connect_timeout") == "" && d.ConnectTimeout > 0 {
+		query.Set("connect_timeout", strconv.Itoa(int(d.ConnectTimeout.Seconds())))
+	}
+
+	u := url.URL{
+		Scheme:   "postgresql",
+		User:     url.UserPassword(d.User, d.Password),
+		Host:     fmt.Sprintf("%s:%d", d.Host, d.Port),
+		Path:     "/" + name,
+		RawQuery: query.Encode(),
+	}
+	return u.String()
+}
+
+// maxVoteFlushInterval bounds how many acknowledged votes a crash can lose
+const maxVoteFlushInterval = time.Second
+
+// minAdminTokenLength keeps the admin token out of reach of guessing
+const minAdminTokenLength = 32
+
+// minAuthSecretLength keeps the token signing secret out of reach of
+// guessing
+const minAuthSecretLength = 32
+
+// providerName is the form of a provider name, which appears in URLs
+var providerName = regexp.MustCompile(`^[a-z0-9-]{1,64}$`)
+
+// insecurePasswords are refused in production
+var insecurePasswords = map[string]bool{"": true, "1234": true, "postgres": true, "password": true}
+
+// Validate reports every invalid setting, plus insecure ones in production
+func (c *Config) Validate() error {
+	var errs []error
+	add := func(format string, args ...interface{}) {
+		errs = append(errs, fmt.Errorf(format, args...))
+	}
+
+	if c.Env != Development && c.Env != Production {
+		add("env must be %q or %q, got %q", Development, Production, c.Env)
+	}
+	if c.Server.Port < 1 || c.Server.Port > 65535 {
+		add("server.port must be between 1 and 65535, got %d", c.Server.Port)
+	}
+	if len(c.Server.AllowedOrigins) == 0 {
+		add("server.allowedOrigins must list at least one origin")
+	}
+	if c.Server.ShutdownTimeout <= 0 {
+		add("server.shutdownTimeout must be positive")
+	}
+	if c.Server.AdminToken != "" && len(c.Server.AdminToken) < minAdminTokenLength {
+		add("server.adminToken must be at least %d characters", minAdminTokenLength)
+	}
+
+	d := c.Database
+	if d.Host == "" || d.Name == "" || d.User == "" {
+		add("database host, name and user are required")
+	}
+	if d.Port < 1 || d.Port > 65535 {
+		add("database.port must be between 1 and 65535, got %d", d.Port)
+	}
+	if d.MaxOpenConns < 1 {
+		add("database.maxOpenConns must be at least 1")
+	}
+	if d.MinConns < 0 || d.MinConns > d.MaxOpenConns {
+		add("database.minConns must be between 0 and maxOpenConns")
+	}
+	if d.ConnMaxLifetime < 0 {
+		add("database.connMaxLifetime must not be negative")
+	}
+	if d.ConnectTimeout < time.Second {
+		add("database.connectTimeout must be at least 1s")
+	}
+	if d.Timeouts.Read < 0 || d.Timeouts.Write < 0 || d.Timeouts.Maintenance < 0 {
+		add("database.timeouts must not be negative")
+	}
+	if d.VoteFlushInterval < 0 || d.VoteFlushInterval > maxVoteFlushInterval {
+		add("database.voteFlushInterval must be between 0 and %s", maxVoteFlushInterval)
+	}
+	switch d.QueryExecMode {
+	case "", "cache_statement", "cache_describe", "describe_exec", "exec", "simple_protocol":
+	default:
+		add("database.queryExecMode must be cache_statement, cache_describe, describe_exec, exec or simple_protocol, got %q", d.QueryExecMode)
+	}
+
+	rl := c.RateLimit
+	if rl.TrustedProxies < 0 {
+		add("rateLimit.trustedProxies must not be negative")
+	}
+	if rl.RequestsPerSecond < 0 || rl.RequestBurst < 0 || rl.SessionsPerHour < 0 ||
+		rl.MessagesPerSecond < 0 || rl.MessageBurst < 0 {
+		add("rateLimit values must not be negative")
+	}
+
+	a := c.Auth
+	if a.Secret != "" && len(a.Secret) < minAuthSecretLength {
+		add("auth.secret must be at least %d characters", minAuthSecretLength)
+	}
+	if a.TokenTTL <= 0 {
+		add("auth.tokenTTL must be positive")
+	}
+	if o := a.OIDC; o.Issuer != "" {
+		if !providerName.MatchString(o.Name) || o.Name == "local" {
+			add("auth.oidc.name must be lower-case letters, digits and dashes, and not \"local\", got %q", o.Name)
+		}
+		if !isHTTPURL(o.Issuer) || !isHTTPURL(o.RedirectURL) {
+			add("auth.oidc.issuer and redirectUrl must be http or https URLs")
+		}
+		if o.ClientID == "" {
+			add("auth.oidc.clientId is required")
+		}
+	}
+
+	if c.Log.Format != "json" && c.Log.Format != "text" {
+		add("log.format must be \"json\" or \"text\", got %q", c.Log.Format)
+	}
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(c.Log.Level)); err != nil {
+		add("log.level must be debug, info, warn or error, got %q", c.Log.Level)
+	}
+
+	if c.Env == Production {
+		if insecurePasswords[d.Password] {
+			add("production: database password is empty or a well-known default")
+		}
+		if d.SSLMode == "disable" || d.SSLMode == "allow" || d.SSLMode == "prefer" {
+			add("production: database sslMode %q does not enforce TLS; use require or verify-full", d.SSLMode)
+		}
+		if c.Server.AllowAllOrigins {
+			add("production: allowAllOrigins must be off")
+		}
+		for _, origin := range c.Server.AllowedOrigins {
+			if origin == "*" || !strings.HasPrefix(origin, "https://") {
+				add("production: allowed origin %q must be an explicit https:// origin", origin)
+			}
+		}
+		if a.Enabled() {
+			if a.Secret == "" {
+				add("production: auth.secret is required when sign-in is enabled")
+			}
+			if !a.SecureCookie {
+				add("production: auth.secureCookie must be on when sign-in is enabled")
+			}
+		}
+		if rl.Enabled && rl.TrustedProxies == 0 && !rl.DirectClients {
+			add("production: rateLimit.trustedProxies must count the proxies in front of the server, or rateLimit.directClients be set when there are none")
+		}
+		if a.OIDC.Issuer != "" && (!strings.HasPrefix(a.OIDC.Issuer, "https://") || !strings.HasPrefix(a.OIDC.RedirectURL, "https://")) {
+			add("production: auth.oidc.issuer and redirectUrl must be https:// URLs")
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// redacted replaces secrets in printed configuration
+const redacted = "REDACTED"
+
+// Redacted returns a copy with passwords and tokens removed, safe to print
+// or log
+func (c Config) Redacted() Config {
+	if c.Server.AdminToken != "" {
+		c.Server.AdminToken = redacted
+	}
+	if c.Auth.Secret != "" {
+		c.Auth.Secret = redacted
+	}
+	if c.Auth.OIDC.ClientSecret != "" {
+		c.Auth.OIDC.ClientSecret = redacted
+	}
+	if c.Database.Password != "" {
+		c.Database.Password = redacted
+	}
+	if c.Database.URL != "" {
+		c.Database.URL = redactURL(c.Database.URL)
+	}
+	c.Server.AllowedOrigins = append([]string(nil), c.Server.AllowedOrigins...)
+	return c
+}
+
+// redactURL removes the password from a database URL, where it may be in
+// the userinfo or, as pgx also accepts, in a password query parameter
+func redactURL(dsn string) string {
+	u, err := url.Parse(dsn)
+	if err != nil {
+		return redacted
+	}
+	if u.User != nil {
+		if _, hasPassword := u.User.Password(); hasPassword {
+			u.User = url.UserPassword(u.User.Username(), redacted)
+		}
+	}
+	if query := u.Query(); query.Has("password") {
+		query.Set("password", redacted)
+		u.RawQuery = query.Encode()
+	}
+	return u.String()
+}
+
+// String renders the redacted configuration as YAML
+func (c Config) String() string {
+	out, err := yaml.Marshal(c.Redacted())
+	if err != nil {
+		return err.Error()
+	}
+	return string(out)
+}
+
+func stringSetter(field func(*Config) *string) func(*Config, string) error {
+	return func(c *Config, v string) error {
+		*field(c) = v
+		return nil
+	}
+}
+
+func intSetter(field func(*Config) *int) func(*Config, string) error {
+	return func(c *Config, v string) error {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("invalid number %q", v)
+		}
+		*field(c) = n
+		return nil
+	}
+}
+
+func floatSetter(field func(*Config) *float64) func(*Config, string) error {
+	return func(c *Config, v string) error {
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return fmt.Errorf("invalid number %q", v)
+		}
+		*field(c) = f
+		return nil
+	}
+}
+
+func boolSetter(field func(*Config) *bool) func(*Config, string) error {
+	return func(c *Config, v string) error {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return fmt.Errorf("invalid boolean %q", v)
+		}
+		*field(c) = b
+		return nil
+	}
+}
+
+func durationSetter(field func(*Config) *time.Duration) func(*Config, string) error {
+	return func(c *Config, v string) error {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return fmt.Errorf("invalid duration %q", v)
+		}
+		*field(c) = d
+		return nil
+	}
+}
+
+// isHTTPURL reports whether v is an absolute http or https URL
+func isHTTPURL(v string) bool {
+	u, err := url.Parse(v)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+func splitList(v string) []string {
+	var items []string
+	for _, item := range strings.Split(v, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
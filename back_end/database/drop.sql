@@ -3,6 +3,12 @@ DROP TABLE IF EXISTS votes CASCADE;
 DROP TABLE IF EXISTS planning_items CASCADE;
 DROP TABLE IF EXISTS users CASCADE;
 DROP TABLE IF EXISTS sessions CASCADE;
+DROP TABLE IF EXISTS session_templates CASCADE;
+DROP TABLE IF EXISTS team_members CASCADE;
+DROP TABLE IF EXISTS teams CASCADE;
+DROP TABLE IF EXISTS logins CASCADE;
+DROP TABLE IF EXISTS accounts CASCADE;
+DROP TABLE IF EXISTS schema_version CASCADE;
 
 -- Drop trigger function
 DROP FUNCTION IF EXISTS update_updated_at_column CASCADE;
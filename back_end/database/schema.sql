@@ -4,10 +4,100 @@ CREATE TABLE IF NOT EXISTS sessions (
     name VARCHAR(255) NOT NULL,
     host_id UUID NOT NULL,
     current_item_id UUID,
+    join_code VARCHAR(6),
+    archived_at TIMESTAMP,
     created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
     updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
 );
 
+-- Added in schema version 2
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS join_code VARCHAR(6);
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS archived_at TIMESTAMP;
+
+-- Added in schema version 4: sessions only signed-in participants may join
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS auth_required BOOLEAN NOT NULL DEFAULT FALSE;
+
+-- Create accounts table: identities that span sessions
+CREATE TABLE IF NOT EXISTS accounts (
+    id UUID PRIMARY KEY,
+    display_name VARCHAR(255) NOT NULL,
+    avatar_url VARCHAR(2048),
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Added in schema version 4: the sign-in provider an account belongs to
+ALTER TABLE accounts ADD COLUMN IF NOT EXISTS provider VARCHAR(64);
+
+-- Create logins table: how each provider knows an account. Subject is the
+-- email address of a local login and the OpenID Connect subject otherwise.
+CREATE TABLE IF NOT EXISTS logins (
+    provider VARCHAR(64) NOT NULL,
+    subject VARCHAR(255) NOT NULL,
+    account_id UUID NOT NULL REFERENCES accounts(id) ON DELETE CASCADE,
+    password_hash VARCHAR(255),
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (provider, subject)
+);
+
+CREATE INDEX IF NOT EXISTS idx_logins_account_id ON logins(account_id);
+
+-- Create teams table: groups of accounts whose sessions share default
+-- settings. An empty deck accepts any vote.
+CREATE TABLE IF NOT EXISTS teams (
+    id UUID PRIMARY KEY,
+    name VARCHAR(255) NOT NULL,
+    deck TEXT[] NOT NULL DEFAULT '{}',
+    timer_seconds INTEGER NOT NULL DEFAULT 0,
+    auto_reveal BOOLEAN NOT NULL DEFAULT FALSE,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Create team_members table: role is 'owner' or 'member'
+CREATE TABLE IF NOT EXISTS team_members (
+    team_id UUID NOT NULL REFERENCES teams(id) ON DELETE CASCADE,
+    account_id UUID NOT NULL REFERENCES accounts(id) ON DELETE CASCADE,
+    role VARCHAR(16) NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (team_id, account_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_team_members_account_id ON team_members(account_id);
+
+-- Create session_templates table: reusable session setups, owned by an
+-- account or shared with a team. items holds the seed items as a JSON
+-- array of {"title", "description"}.
+CREATE TABLE IF NOT EXISTS session_templates (
+    id UUID PRIMARY KEY,
+    name VARCHAR(255) NOT NULL,
+    account_id UUID REFERENCES accounts(id) ON DELETE CASCADE,
+    team_id UUID REFERENCES teams(id) ON DELETE CASCADE,
+    deck TEXT[] NOT NULL DEFAULT '{}',
+    timer_seconds INTEGER NOT NULL DEFAULT 0,
+    auto_reveal BOOLEAN NOT NULL DEFAULT FALSE,
+    auth_required BOOLEAN NOT NULL DEFAULT FALSE,
+    items JSONB NOT NULL DEFAULT '[]',
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    CHECK ((account_id IS NULL) <> (team_id IS NULL))
+);
+
+CREATE INDEX IF NOT EXISTS idx_session_templates_account_id ON session_templates(account_id);
+CREATE INDEX IF NOT EXISTS idx_session_templates_team_id ON session_templates(team_id);
+
+-- Added in schema version 5: the team a session belongs to, kept when the
+-- team is deleted as a session without one, and the session's settings
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS team_id UUID REFERENCES teams(id) ON DELETE SET NULL;
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS deck TEXT[] NOT NULL DEFAULT '{}';
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS timer_seconds INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS auto_reveal BOOLEAN NOT NULL DEFAULT FALSE;
+CREATE INDEX IF NOT EXISTS idx_sessions_team_id ON sessions(team_id);
+
+-- Added in schema version 7: the SHA-256 of the key authorizing the host's
+-- REST requests; the key is only returned to the session's creator
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS host_key_hash BYTEA;
+
 -- Create users table
 CREATE TABLE IF NOT EXISTS users (
     id UUID PRIMARY KEY,
@@ -19,6 +109,16 @@ CREATE TABLE IF NOT EXISTS users (
     UNIQUE(session_id, name)
 );
 
+-- Added in schema version 3: a participant may belong to an account, once
+-- per session; deleting the account leaves its participants as guests
+ALTER TABLE users ADD COLUMN IF NOT EXISTS account_id UUID REFERENCES accounts(id) ON DELETE SET NULL;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_users_session_account ON users(session_id, account_id);
+CREATE INDEX IF NOT EXISTS idx_users_account_id ON users(account_id);
+
+-- Added in schema version 8: the SHA-256 of the token a participant rejoins
+-- with; the token is only sent to the participant in its welcome message
+ALTER TABLE users ADD COLUMN IF NOT EXISTS resume_token_hash BYTEA;
+
 -- Create planning_items table
 CREATE TABLE IF NOT EXISTS planning_items (
     id UUID PRIMARY KEY,
@@ -42,11 +142,16 @@ CREATE TABLE IF NOT EXISTS votes (
 );
 
 -- Create indexes for better query performance
-CREATE INDEX idx_users_session_id ON users(session_id);
-CREATE INDEX idx_planning_items_session_id ON planning_items(session_id);
-CREATE INDEX idx_votes_planning_item_id ON votes(planning_item_id);
-CREATE INDEX idx_votes_user_id ON votes(user_id);
-CREATE INDEX idx_sessions_created_at ON sessions(created_at);
+CREATE INDEX IF NOT EXISTS idx_users_session_id ON users(session_id);
+CREATE INDEX IF NOT EXISTS idx_planning_items_session_id ON planning_items(session_id);
+CREATE INDEX IF NOT EXISTS idx_votes_planning_item_id ON votes(planning_item_id);
+CREATE INDEX IF NOT EXISTS idx_votes_user_id ON votes(user_id);
+CREATE INDEX IF NOT EXISTS idx_sessions_created_at ON sessions(created_at);
+
+-- Join codes are unique among active sessions only, so archived sessions
+-- give their codes back
+CREATE UNIQUE INDEX IF NOT EXISTS idx_sessions_join_code ON sessions(join_code)
+    WHERE archived_at IS NULL;
 
 -- Create updated_at trigger function
 CREATE OR REPLACE FUNCTION update_updated_at_column()
@@ -58,5 +163,28 @@ END;
 $$ language 'plpgsql';
 
 -- Create trigger for sessions table
+DROP TRIGGER IF EXISTS update_sessions_updated_at ON sessions;
 CREATE TRIGGER update_sessions_updated_at BEFORE UPDATE ON sessions
     FOR EACH ROW EXECUTE FUNCTION update_updated_at_column();
+
+DROP TRIGGER IF EXISTS update_accounts_updated_at ON accounts;
+CREATE TRIGGER update_accounts_updated_at BEFORE UPDATE ON accounts
+    FOR EACH ROW EXECUTE FUNCTION update_updated_at_column();
+
+DROP TRIGGER IF EXISTS update_teams_updated_at ON teams;
+CREATE TRIGGER update_teams_updated_at BEFORE UPDATE ON teams
+    FOR EACH ROW EXECUTE FUNCTION update_updated_at_column();
+
+DROP TRIGGER IF EXISTS update_session_templates_updated_at ON session_templates;
+CREATE TRIGGER update_session_templates_updated_at BEFORE UPDATE ON session_templates
+    FOR EACH ROW EXECUTE FUNCTION update_updated_at_column();
+
+-- Record the schema version checked by the server's /ready endpoint.
+-- Keep in sync with db.SchemaVersion.
+CREATE TABLE IF NOT EXISTS schema_version (
+    id BOOLEAN PRIMARY KEY DEFAULT TRUE CHECK (id),
+    version INTEGER NOT NULL
+);
+
+INSERT INTO schema_version (id, version) VALUES (TRUE, 8)
+    ON CONFLICT (id) DO UPDATE SET version = EXCLUDED.version;
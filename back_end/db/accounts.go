@@ -0,0 +1,109 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"poker-planning-api/models"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// CreateAccount creates a new account
+func (p *Postgres) CreateAccount(ctx context.Context, account *models.Account) (err error) {
+	defer observe("create_account", &err)()
+
+	query := `
+		INSERT INTO accounts (id, display_name, avatar_url, provider, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $5)
+	`
+	_, err = p.q.Exec(ctx, query, account.ID, account.DisplayName, nullString(account.AvatarURL),
+		nullString(account.Provider), account.CreatedAt)
+	return err
+}
+
+// GetAccount retrieves an account by ID
+func (p *Postgres) GetAccount(ctx context.Context, accountID string) (_ *models.Account, err error) {
+	defer observe("get_account", &err)()
+
+	query := `SELECT id, display_name, avatar_url, provider, created_at FROM accounts WHERE id = $1`
+
+	account := &models.Account{}
+	var avatarURL, provider sql.NullString
+	err = p.q.QueryRow(ctx, query, accountID).Scan(&account.ID, &account.DisplayName, &avatarURL, &provider, &account.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	account.AvatarURL = avatarURL.String
+	account.Provider = provider.String
+	return account, nil
+}
+
+// UpdateAccount replaces an account's display name and avatar
+func (p *Postgres) UpdateAccount(ctx context.Context, account *models.Account) (err error) {
+	defer observe("update_account", &err)()
+
+	query := `UPDATE accounts SET display_name = $1, avatar_url = $2, updated_at = $3 WHERE id = $4`
+	tag, err := p.q.Exec(ctx, query, account.DisplayName, nullString(account.AvatarURL), time.Now(), account.ID)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// DeleteAccount deletes an account and its logins; its participants stay
+// in their sessions as guests (ON DELETE SET NULL)
+func (p *Postgres) DeleteAccount(ctx context.Context, accountID string) (err error) {
+	defer observe("delete_account", &err)()
+
+	tag, err := p.q.Exec(ctx, `DELETE FROM accounts WHERE id = $1`, accountID)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// CreateLogin links a provider's subject to an account
+func (p *Postgres) CreateLogin(ctx context.Context, login *models.Login) (err error) {
+	defer observe("create_login", &err)()
+
+	query := `
+		INSERT INTO logins (provider, subject, account_id, password_hash, created_at)
+		VALUES ($1, $2, $3, $4, $5)
+	`
+	_, err = p.q.Exec(ctx, query, login.Provider, login.Subject, login.AccountID,
+		nullString(login.PasswordHash), login.CreatedAt)
+
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == uniqueViolation {
+		return ErrLoginTaken
+	}
+	return err
+}
+
+// GetLogin retrieves the login a provider knows by subject
+func (p *Postgres) GetLogin(ctx context.Context, provider, subject string) (_ *models.Login, err error) {
+	defer observe("get_login", &err)()
+
+	query := `
+		SELECT provider, subject, account_id, password_hash, created_at
+		FROM logins WHERE provider = $1 AND subject = $2
+	`
+
+	login := &models.Login{}
+	var passwordHash sql.NullString
+	err = p.q.QueryRow(ctx, query, provider, subject).Scan(&login.Provider, &login.Subject, &login.AccountID,
+		&passwordHash, &login.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	login.PasswordHash = passwordHash.String
+	return login, nil
+}
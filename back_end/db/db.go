@@ -1,61 +1,202 @@
 package db
 
 import (
-	"database/sql"
+	"context"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
+	"net"
+	"poker-planning-api/config"
+	"poker-planning-api/logging"
+	"strings"
+	"time"
 
-	_ "github.com/jackc/pgx/v5/stdlib"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/jackc/puddle/v2"
 )
 
-var DB *sql.DB
-
-// Config holds database configuration
-type Config struct {
-	Host     string
-	Port     int
-	User     string
-	Password string
-	DBName   string
-	SSLMode  string // SSL mode for database connection
+// Postgres is the PostgreSQL implementation of Store
+type Postgres struct {
+	pool *pgxpool.Pool
+	// q runs the queries: pool itself, or the transaction this store is
+	// bound to inside WithTx
+	q querier
+	// votes holds votes waiting to be written in one batch; nil writes
+	// each vote as it is saved, as do stores bound to a transaction
+	votes *voteBatcher
+	// queue is the batcher votes are queued in, if any, also for stores
+	// bound to a transaction, so DeleteItemVotes can drop queued votes
+	queue *voteBatcher
+	// afterCommit runs once the transaction this store is bound to
+	// commits, and is dropped if it rolls back
+	afterCommit []func()
+	// began is when the transaction this store is bound to started,
+	// before it flushed the queued votes
+	began time.Time
+	// connectTimeout bounds each ping while Connect waits for the database
+	connectTimeout time.Duration
 }
 
-// InitDB initializes the database connection
-func InitDB(config Config) error {
-	// Default to 'require' for production databases (like Neon)
-	sslMode := config.SSLMode
-	if sslMode == "" {
-		sslMode = "disable" // Default for local development
+// Open prepares the connection pool without connecting. Connections are
+// opened on demand, so the pool recovers on its own once the database is
+// reachable again; until then queries fail with a connection error.
+func Open(cfg config.DatabaseConfig) (*Postgres, error) {
+	// The DSN carries connect_timeout, so requests fail fast with a 503
+	// while the database is unreachable
+	poolCfg, err := pgxpool.ParseConfig(cfg.DSN())
+	if err != nil {
+		return nil, fmt.Errorf("error opening database: %w", err)
 	}
+	return open(poolCfg, cfg)
+}
 
-	connStr := fmt.Sprintf(
-		"postgresql://%s:%s@%s:%d/%s?sslmode=%s",
-		config.User, config.Password, config.Host, config.Port, config.DBName, sslMode,
-	)
+// open creates the pool from poolCfg, which tests use to add a tracer
+func open(poolCfg *pgxpool.Config, cfg config.DatabaseConfig) (*Postgres, error) {
+	poolCfg.MaxConns = int32(cfg.MaxOpenConns)
+	poolCfg.MinConns = int32(cfg.MinConns)
+	poolCfg.MaxConnLifetime = cfg.ConnMaxLifetime
 
-	var err error
-	DB, err = sql.Open("pgx", connStr)
+	// ParseConfig leaves each connection preparing a statement the first
+	// time it runs a query and reusing it from then on. A pooler in
+	// transaction mode cannot keep prepared statements; QueryExecMode
+	// (or default_query_exec_mode in DATABASE_URL) selects exec instead.
+	if cfg.QueryExecMode != "" {
+		mode, ok := queryExecModes[cfg.QueryExecMode]
+		if !ok {
+			return nil, fmt.Errorf("error opening database: unknown query exec mode %q", cfg.QueryExecMode)
+		}
+		poolCfg.ConnConfig.DefaultQueryExecMode = mode
+	}
+
+	pool, err := pgxpool.NewWithConfig(context.Background(), poolCfg)
 	if err != nil {
-		return fmt.Errorf("error opening database: %w", err)
+		return nil, fmt.Errorf("error opening database: %w", err)
 	}
 
-	// Test the connection
-	if err = DB.Ping(); err != nil {
-		return fmt.Errorf("error connecting to database: %w", err)
+	p := &Postgres{pool: pool, q: pool, connectTimeout: cfg.ConnectTimeout}
+	if cfg.VoteFlushInterval > 0 {
+		p.votes = newVoteBatcher(pool, cfg.VoteFlushInterval, cfg.Timeouts.Write)
+		p.queue = p.votes
 	}
+	return p, nil
+}
 
-	// Set connection pool settings
-	DB.SetMaxOpenConns(25)
-	DB.SetMaxIdleConns(5)
+// queryExecModes maps config.DatabaseConfig.QueryExecMode to pgx's modes,
+// named as in default_query_exec_mode
+var queryExecModes = map[string]pgx.QueryExecMode{
+	"cache_statement": pgx.QueryExecModeCacheStatement,
+	"cache_describe":  pgx.QueryExecModeCacheDescribe,
+	"describe_exec":   pgx.QueryExecModeDescribeExec,
+	"exec":            pgx.QueryExecModeExec,
+	"simple_protocol": pgx.QueryExecModeSimpleProtocol,
+}
 
-	log.Println("Successfully connected to PostgreSQL database")
-	return nil
+// Backoff bounds the delay between connection attempts
+type Backoff struct {
+	Initial time.Duration
+	Max     time.Duration
 }
 
-// CloseDB closes the database connection
-func CloseDB() error {
-	if DB != nil {
-		return DB.Close()
+// DefaultBackoff starts at half a second and doubles up to 30 seconds
+var DefaultBackoff = Backoff{Initial: 500 * time.Millisecond, Max: 30 * time.Second}
+
+// Connect pings the database with exponential backoff until it answers or
+// ctx is cancelled, then checks the schema version. It is meant to run in
+// the background at startup so the server can serve /health and report
+// /ready as failing while the database comes up.
+func (p *Postgres) Connect(ctx context.Context, backoff Backoff) error {
+	delay := backoff.Initial
+	for attempt := 1; ; attempt++ {
+		pingCtx, cancel := context.WithTimeout(ctx, p.connectTimeout)
+		err := p.Ping(pingCtx)
+		cancel()
+		if err == nil {
+			break
+		}
+
+		slog.Warn("database not reachable, retrying",
+			"attempt", attempt, "retry_in", delay.String(), logging.Err(err))
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+		delay *= 2
+		if delay > backoff.Max {
+			delay = backoff.Max
+		}
+	}
+
+	version, err := p.SchemaVersion(ctx)
+	switch {
+	case err != nil:
+		slog.Error("connected to PostgreSQL but could not read the schema version", logging.Err(err))
+	case version != SchemaVersion:
+		slog.Error("database schema version mismatch; run cmd/setup",
+			"schema_version", version, "expected_schema_version", SchemaVersion)
+	default:
+		slog.Info("connected to PostgreSQL", "schema_version", version)
 	}
 	return nil
 }
+
+// Ping checks that the database is reachable
+func (p *Postgres) Ping(ctx context.Context) error {
+	return p.pool.Ping(ctx)
+}
+
+// SchemaVersion returns the version stored by schema.sql, or 0 if the
+// schema predates versioning
+func (p *Postgres) SchemaVersion(ctx context.Context) (version int, err error) {
+	defer observe("schema_version", &err)()
+
+	var exists bool
+	err = p.pool.QueryRow(ctx, `SELECT to_regclass('schema_version') IS NOT NULL`).Scan(&exists)
+	if err != nil || !exists {
+		return 0, err
+	}
+	err = p.pool.QueryRow(ctx, `SELECT version FROM schema_version`).Scan(&version)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return 0, nil
+	}
+	return version, err
+}
+
+// Close writes the votes still waiting for a batch, then closes the pool
+func (p *Postgres) Close() error {
+	var err error
+	if p.votes != nil {
+		err = p.votes.close()
+	}
+	p.pool.Close()
+	return err
+}
+
+// IsUnavailable reports whether err means the database could not be
+// reached, or did not answer within the query timeout, as opposed to the
+// database rejecting the query
+func IsUnavailable(err error) bool {
+	var netErr net.Error
+	var retryable interface{ SafeToRetry() bool }
+	var pgErr *pgconn.PgError
+	switch {
+	case errors.Is(err, ErrUnavailable),
+		errors.Is(err, context.DeadlineExceeded),
+		errors.Is(err, puddle.ErrClosedPool),
+		errors.As(err, &netErr),
+		pgconn.Timeout(err):
+		return true
+	case errors.As(err, &retryable) && retryable.SafeToRetry():
+		// The query never reached the server
+		return true
+	case errors.As(err, &pgErr):
+		// Refused connections (a server starting up or shutting down, or
+		// out of connection slots) are reported by the server itself
+		return pgErr.Code == "53300" || pgErr.Code == "57P01" ||
+			pgErr.Code == "57P02" || pgErr.Code == "57P03" ||
+			strings.HasPrefix(pgErr.Code, "08")
+	}
+	return false
+}
@@ -0,0 +1,1475 @@
+package memstore
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"poker-planning-api/db"
+	"poker-planning-api/models"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+type sessionRow struct {
+	id            string
+	name          string
+	hostID        string
+	currentItemID string
+	joinCode      string
+	authRequired  bool
+	teamID        string
+	settings      models.SessionSettings
+	hostKeyHash   []byte
+	createdAt     time.Time
+	archivedAt    *time.Time
+}
+
+type accountRow struct {
+	id          string
+	displayName string
+	avatarURL   string
+	provider    string
+	createdAt   time.Time
+}
+
+type teamRow struct {
+	id        string
+	name      string
+	settings  models.SessionSettings
+	createdAt time.Time
+}
+
+type teamMemberKey struct {
+	teamID    string
+	accountID string
+}
+
+type teamMemberRow struct {
+	role     string
+	joinedAt time.Time
+	seq      int64
+}
+
+type templateRow struct {
+	template models.Template
+}
+
+type loginKey struct {
+	provider string
+	subject  string
+}
+
+type userRow struct {
+	id        string
+	sessionID string
+	accountID string
+	name      string
+	isHost    bool
+	connected bool
+	// resumeTokenHash stands in for users.resume_token_hash
+	resumeTokenHash []byte
+	seq             int64
+}
+
+type itemRow struct {
+	id            string
+	sessionID     string
+	title         string
+	description   string
+	revealed      bool
+	finalEstimate string
+	order         int
+	seq           int64
+}
+
+type voteKey struct {
+	itemID string
+	userID string
+}
+
+// Store is an in-memory implementation of db.Store. It mirrors the
+// constraints of database/schema.sql (foreign keys, cascading deletes,
+// unique user names and accounts per session and unique join codes among
+// active sessions) so handlers behave as they do against PostgreSQL. It is meant for tests and local experiments; data is lost
+// when the process exits.
+type Store struct {
+	mu          sync.RWMutex
+	accounts    map[string]*accountRow
+	logins      map[loginKey]models.Login
+	teams       map[string]*teamRow
+	teamMembers map[teamMemberKey]*teamMemberRow
+	templates   map[string]*templateRow
+	sessions    map[string]*sessionRow
+	users       map[string]*userRow
+	items       map[string]*itemRow
+	votes       map[voteKey]string
+	// seq orders rows by insertion, standing in for created_at
+	seq int64
+	// unavailable simulates a database outage
+	unavailable bool
+	// latency simulates a slow database
+	latency time.Duration
+	// txMu serializes transactions, standing in for row locks
+	txMu sync.Mutex
+}
+
+var _ db.Store = (*Store)(nil)
+
+// New creates an empty store
+func New() *Store {
+	return &Store{
+		accounts:    map[string]*accountRow{},
+		logins:      map[loginKey]models.Login{},
+		teams:       map[string]*teamRow{},
+		teamMembers: map[teamMemberKey]*teamMemberRow{},
+		templates:   map[string]*templateRow{},
+		sessions:    map[string]*sessionRow{},
+		users:       map[string]*userRow{},
+		items:       map[string]*itemRow{},
+		votes:       map[voteKey]string{},
+	}
+}
+
+// SetUnavailable makes every call fail with db.ErrUnavailable until it is
+// called again with false, simulating a database outage
+func (s *Store) SetUnavailable(unavailable bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.unavailable = unavailable
+}
+
+// SetLatency delays every call by d, as a slow database would. A call
+// whose context ends first fails with the context's error.
+func (s *Store) SetLatency(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.latency = d
+}
+
+// Ping reports db.ErrUnavailable during a simulated outage
+func (s *Store) Ping(ctx context.Context) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if err := s.check(ctx); err != nil {
+		return err
+	}
+	return nil
+}
+
+// SchemaVersion always matches the version this build expects
+func (s *Store) SchemaVersion(ctx context.Context) (int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if err := s.check(ctx); err != nil {
+		return 0, err
+	}
+	return db.SchemaVersion, nil
+}
+
+// WithTx runs fn against the store, restoring the data as it was before if
+// fn fails. Transactions run one at a time; writes made outside a
+// transaction while one is rolled back are lost with it.
+func (s *Store) WithTx(ctx context.Context, fn func(tx db.Store) error) error {
+	s.txMu.Lock()
+	defer s.txMu.Unlock()
+
+	s.mu.RLock()
+	saved := s.snapshot()
+	err := s.check(ctx)
+	s.mu.RUnlock()
+	if err != nil {
+		return err
+	}
+
+	if err := fn(s); err != nil {
+		s.mu.Lock()
+		s.restore(saved)
+		s.mu.Unlock()
+		return err
+	}
+	return nil
+}
+
+// LockSession checks that the session exists; WithTx already serializes
+// transactions
+func (s *Store) LockSession(ctx context.Context, sessionID string) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if err := s.check(ctx); err != nil {
+		return err
+	}
+	if _, exists := s.sessions[sessionID]; !exists {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// CreateSession creates a new session
+func (s *Store) CreateSession(ctx context.Context, session *models.Session) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.check(ctx); err != nil {
+		return err
+	}
+
+	if _, exists := s.sessions[session.ID]; exists {
+		return fmt.Errorf("memstore: duplicate session id %s", session.ID)
+	}
+	if session.TeamID != "" {
+		if _, exists := s.teams[session.TeamID]; !exists {
+			return fmt.Errorf("memstore: team %s does not exist", session.TeamID)
+		}
+	}
+	if session.JoinCode != "" {
+		if _, taken := s.activeJoinCode(session.JoinCode); taken {
+			return db.ErrJoinCodeTaken
+		}
+	}
+	s.sessions[session.ID] = &sessionRow{
+		id:            session.ID,
+		name:          session.Name,
+		hostID:        session.HostID,
+		currentItemID: session.CurrentItemID,
+		joinCode:      session.JoinCode,
+		authRequired:  session.AuthRequired,
+		teamID:        session.TeamID,
+		settings:      session.Settings,
+		hostKeyHash:   session.HostKeyHash,
+		createdAt:     session.CreatedAt,
+	}
+	return nil
+}
+
+// GetSession retrieves a session with its users, items and votes
+func (s *Store) GetSession(ctx context.Context, sessionID string) (*models.Session, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if err := s.check(ctx); err != nil {
+		return nil, err
+	}
+
+	row, exists := s.sessions[sessionID]
+	if !exists {
+		return nil, sql.ErrNoRows
+	}
+
+	session := row.toModel()
+	for _, user := range s.sessionUsers(sessionID) {
+		session.Users[user.ID] = user
+	}
+	session.Items = s.sessionItems(sessionID)
+	return session, nil
+}
+
+// ListSessions retrieves the sessions matching filter with their user and
+// item counts
+func (s *Store) ListSessions(ctx context.Context, filter db.SessionFilter) ([]models.SessionSummary, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if err := s.check(ctx); err != nil {
+		return nil, err
+	}
+
+	callers := map[string]bool{}
+	joined := map[string]bool{}
+	for _, userID := range filter.UserIDs {
+		callers[userID] = true
+		if user, exists := s.users[userID]; exists {
+			joined[user.sessionID] = true
+		}
+	}
+	for _, user := range s.users {
+		if filter.AccountID != "" && user.accountID == filter.AccountID {
+			callers[user.id] = true
+			joined[user.sessionID] = true
+		}
+	}
+
+	listed := joined
+	if filter.TeamID != "" {
+		listed = map[string]bool{}
+		for id, row := range s.sessions {
+			if row.teamID == filter.TeamID {
+				listed[id] = true
+			}
+		}
+	}
+
+	query := strings.ToLower(filter.Query)
+	rows := []*sessionRow{}
+	for sessionID := range listed {
+		row := s.sessions[sessionID]
+		switch {
+		case query != "" && !strings.Contains(strings.ToLower(row.name), query),
+			filter.State == models.SessionStateActive && row.archivedAt != nil,
+			filter.State == models.SessionStateArchived && row.archivedAt == nil,
+			!filter.CreatedFrom.IsZero() && row.createdAt.Before(filter.CreatedFrom),
+			!filter.CreatedTo.IsZero() && !row.createdAt.Before(filter.CreatedTo),
+			filter.After != nil && !listedAfter(row, filter.After):
+			continue
+		}
+		rows = append(rows, row)
+	}
+	sort.Slice(rows, func(i, j int) bool {
+		return listedAfter(rows[j], &db.SessionCursor{CreatedAt: rows[i].createdAt, ID: rows[i].id})
+	})
+	if len(rows) > filter.Limit {
+		rows = rows[:filter.Limit]
+	}
+
+	sessions := make([]models.SessionSummary, 0, len(rows))
+	for _, row := range rows {
+		session := models.SessionSummary{
+			ID:         row.id,
+			Name:       row.name,
+			JoinCode:   row.joinCode,
+			State:      models.SessionStateActive,
+			TeamID:     row.teamID,
+			UserCount:  len(s.sessionUsers(row.id)),
+			ItemCount:  len(s.sessionItems(row.id)),
+			CreatedAt:  row.createdAt,
+			ArchivedAt: row.archivedAt,
+		}
+		if row.archivedAt != nil {
+			session.State = models.SessionStateArchived
+		}
+		switch {
+		case callers[row.hostID]:
+			session.Role = models.RoleHost
+		case joined[row.id]:
+			session.Role = models.RoleParticipant
+		}
+		sessions = append(sessions, session)
+	}
+	return sessions, nil
+}
+
+// listedAfter reports whether row comes after cursor in a listing, which
+// runs newest first
+func listedAfter(row *sessionRow, cursor *db.SessionCursor) bool {
+	if !row.createdAt.Equal(cursor.CreatedAt) {
+		return row.createdAt.Before(cursor.CreatedAt)
+	}
+	return row.id < cursor.ID
+}
+
+// UpdateSessionCurrentItem updates the current item being voted on
+func (s *Store) UpdateSessionCurrentItem(ctx context.Context, sessionID, itemID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.check(ctx); err != nil {
+		return err
+	}
+
+	if row, exists := s.sessions[sessionID]; exists {
+		row.currentItemID = itemID
+	}
+	return nil
+}
+
+// DeleteSession deletes a session and everything that belongs to it
+func (s *Store) DeleteSession(ctx context.Context, sessionID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.check(ctx); err != nil {
+		return err
+	}
+
+	s.deleteSession(sessionID)
+	return nil
+}
+
+// DeleteSessionsCreatedBefore deletes old sessions with everything that
+// belongs to them and returns their IDs
+func (s *Store) DeleteSessionsCreatedBefore(ctx context.Context, before time.Time) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.check(ctx); err != nil {
+		return nil, err
+	}
+
+	sessionIDs := []string{}
+	for id, row := range s.sessions {
+		if row.createdAt.Before(before) {
+			s.deleteSession(id)
+			sessionIDs = append(sessionIDs, id)
+		}
+	}
+	return sessionIDs, nil
+}
+
+// GetSessionIDByJoinCode resolves the join code of an active session
+func (s *Store) GetSessionIDByJoinCode(ctx context.Context, code string) (string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if err := s.check(ctx); err != nil {
+		return "", err
+	}
+
+	sessionID, exists := s.activeJoinCode(code)
+	if !exists {
+		return "", sql.ErrNoRows
+	}
+	return sessionID, nil
+}
+
+// ArchiveSession marks a session archived so its join code can be reused
+func (s *Store) ArchiveSession(ctx context.Context, sessionID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.check(ctx); err != nil {
+		return err
+	}
+
+	row, exists := s.sessions[sessionID]
+	if !exists {
+		return sql.ErrNoRows
+	}
+	if row.archivedAt == nil {
+		now := time.Now()
+		row.archivedAt = &now
+	}
+	return nil
+}
+
+// activeJoinCode finds the session that is not archived holding code
+func (s *Store) activeJoinCode(code string) (string, bool) {
+	for id, row := range s.sessions {
+		if row.joinCode == code && row.archivedAt == nil {
+			return id, true
+		}
+	}
+	return "", false
+}
+
+// CreateAccount creates a new account
+func (s *Store) CreateAccount(ctx context.Context, account *models.Account) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.check(ctx); err != nil {
+		return err
+	}
+
+	if _, exists := s.accounts[account.ID]; exists {
+		return fmt.Errorf("memstore: duplicate account id %s", account.ID)
+	}
+	s.accounts[account.ID] = &accountRow{
+		id:          account.ID,
+		displayName: account.DisplayName,
+		avatarURL:   account.AvatarURL,
+		provider:    account.Provider,
+		createdAt:   account.CreatedAt,
+	}
+	return nil
+}
+
+// GetAccount retrieves an account by ID
+func (s *Store) GetAccount(ctx context.Context, accountID string) (*models.Account, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if err := s.check(ctx); err != nil {
+		return nil, err
+	}
+
+	row, exists := s.accounts[accountID]
+	if !exists {
+		return nil, sql.ErrNoRows
+	}
+	return row.toModel(), nil
+}
+
+// UpdateAccount replaces an account's display name and avatar
+func (s *Store) UpdateAccount(ctx context.Context, account *models.Account) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.check(ctx); err != nil {
+		return err
+	}
+
+	row, exists := s.accounts[account.ID]
+	if !exists {
+		return sql.ErrNoRows
+	}
+	row.displayName = account.DisplayName
+	row.avatarURL = account.AvatarURL
+	return nil
+}
+
+// DeleteAccount deletes an account and its logins, leaving its
+// participants as guests
+func (s *Store) DeleteAccount(ctx context.Context, accountID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.check(ctx); err != nil {
+		return err
+	}
+
+	if _, exists := s.accounts[accountID]; !exists {
+		return sql.ErrNoRows
+	}
+	delete(s.accounts, accountID)
+	for key, login := range s.logins {
+		if login.AccountID == accountID {
+			delete(s.logins, key)
+		}
+	}
+	for _, user := range s.users {
+		if user.accountID == accountID {
+			user.accountID = ""
+		}
+	}
+	for key := range s.teamMembers {
+		if key.accountID == accountID {
+			delete(s.teamMembers, key)
+		}
+	}
+	for id, row := range s.templates {
+		if row.template.AccountID == accountID {
+			delete(s.templates, id)
+		}
+	}
+	return nil
+}
+
+// CreateLogin links a provider's subject to an account
+func (s *Store) CreateLogin(ctx context.Context, login *models.Login) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.check(ctx); err != nil {
+		return err
+	}
+
+	key := loginKey{provider: login.Provider, subject: login.Subject}
+	if _, exists := s.logins[key]; exists {
+		return db.ErrLoginTaken
+	}
+	if _, exists := s.accounts[login.AccountID]; !exists {
+		return fmt.Errorf("memstore: login for unknown account %s", login.AccountID)
+	}
+	s.logins[key] = *login
+	return nil
+}
+
+// GetLogin retrieves the login a provider knows by subject
+func (s *Store) GetLogin(ctx context.Context, provider, subject string) (*models.Login, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if err := s.check(ctx); err != nil {
+		return nil, err
+	}
+
+	login, exists := s.logins[loginKey{provider: provider, subject: subject}]
+	if !exists {
+		return nil, sql.ErrNoRows
+	}
+	return &login, nil
+}
+
+// CreateTeam creates a new team without members
+func (s *Store) CreateTeam(ctx context.Context, team *models.Team) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.check(ctx); err != nil {
+		return err
+	}
+
+	if _, exists := s.teams[team.ID]; exists {
+		return fmt.Errorf("memstore: duplicate team id %s", team.ID)
+	}
+	s.teams[team.ID] = &teamRow{
+		id:        team.ID,
+		name:      team.Name,
+		settings:  team.Settings,
+		createdAt: team.CreatedAt,
+	}
+	return nil
+}
+
+// GetTeam retrieves a team by ID
+func (s *Store) GetTeam(ctx context.Context, teamID string) (*models.Team, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if err := s.check(ctx); err != nil {
+		return nil, err
+	}
+
+	row, exists := s.teams[teamID]
+	if !exists {
+		return nil, sql.ErrNoRows
+	}
+	return row.toModel(), nil
+}
+
+// UpdateTeam replaces a team's name and settings
+func (s *Store) UpdateTeam(ctx context.Context, team *models.Team) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.check(ctx); err != nil {
+		return err
+	}
+
+	row, exists := s.teams[team.ID]
+	if !exists {
+		return sql.ErrNoRows
+	}
+	row.name = team.Name
+	row.settings = team.Settings
+	return nil
+}
+
+// DeleteTeam deletes a team and its memberships, leaving its sessions
+// without a team
+func (s *Store) DeleteTeam(ctx context.Context, teamID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.check(ctx); err != nil {
+		return err
+	}
+
+	if _, exists := s.teams[teamID]; !exists {
+		return sql.ErrNoRows
+	}
+	delete(s.teams, teamID)
+	for key := range s.teamMembers {
+		if key.teamID == teamID {
+			delete(s.teamMembers, key)
+		}
+	}
+	for _, row := range s.sessions {
+		if row.teamID == teamID {
+			row.teamID = ""
+		}
+	}
+	for id, row := range s.templates {
+		if row.template.TeamID == teamID {
+			delete(s.templates, id)
+		}
+	}
+	return nil
+}
+
+// LockTeam checks that the team exists; WithTx already serializes
+// transactions
+func (s *Store) LockTeam(ctx context.Context, teamID string) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if err := s.check(ctx); err != nil {
+		return err
+	}
+	if _, exists := s.teams[teamID]; !exists {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// ListAccountTeams retrieves the teams an account is a member of, by name
+func (s *Store) ListAccountTeams(ctx context.Context, accountID string) ([]models.Team, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if err := s.check(ctx); err != nil {
+		return nil, err
+	}
+
+	teams := []models.Team{}
+	for key, member := range s.teamMembers {
+		if key.accountID == accountID {
+			team := s.teams[key.teamID].toModel()
+			team.Role = member.role
+			teams = append(teams, *team)
+		}
+	}
+	sort.Slice(teams, func(i, j int) bool {
+		if teams[i].Name != teams[j].Name {
+			return teams[i].Name < teams[j].Name
+		}
+		return teams[i].ID < teams[j].ID
+	})
+	return teams, nil
+}
+
+// SetTeamMember adds an account to a team or changes its role
+func (s *Store) SetTeamMember(ctx context.Context, teamID, accountID, role string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.check(ctx); err != nil {
+		return err
+	}
+
+	if _, exists := s.teams[teamID]; !exists {
+		return fmt.Errorf("memstore: team %s does not exist", teamID)
+	}
+	if _, exists := s.accounts[accountID]; !exists {
+		return fmt.Errorf("memstore: account %s does not exist", accountID)
+	}
+	key := teamMemberKey{teamID: teamID, accountID: accountID}
+	if member, exists := s.teamMembers[key]; exists {
+		member.role = role
+		return nil
+	}
+	s.teamMembers[key] = &teamMemberRow{role: role, joinedAt: time.Now(), seq: s.nextSeq()}
+	return nil
+}
+
+// GetTeamMembers retrieves a team's members with their profiles, owners
+// first and then in the order they joined
+func (s *Store) GetTeamMembers(ctx context.Context, teamID string) ([]models.TeamMember, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if err := s.check(ctx); err != nil {
+		return nil, err
+	}
+
+	keys := []teamMemberKey{}
+	for key := range s.teamMembers {
+		if key.teamID == teamID {
+			keys = append(keys, key)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		a, b := s.teamMembers[keys[i]], s.teamMembers[keys[j]]
+		if (a.role == models.TeamRoleOwner) != (b.role == models.TeamRoleOwner) {
+			return a.role == models.TeamRoleOwner
+		}
+		return a.seq < b.seq
+	})
+
+	members := make([]models.TeamMember, 0, len(keys))
+	for _, key := range keys {
+		member := s.teamMembers[key]
+		account := s.accounts[key.accountID]
+		members = append(members, models.TeamMember{
+			AccountID:   key.accountID,
+			DisplayName: account.displayName,
+			AvatarURL:   account.avatarURL,
+			Role:        member.role,
+			JoinedAt:    member.joinedAt,
+		})
+	}
+	return members, nil
+}
+
+// GetTeamRole retrieves an account's role in a team
+func (s *Store) GetTeamRole(ctx context.Context, teamID, accountID string) (string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if err := s.check(ctx); err != nil {
+		return "", err
+	}
+
+	member, exists := s.teamMembers[teamMemberKey{teamID: teamID, accountID: accountID}]
+	if !exists {
+		return "", sql.ErrNoRows
+	}
+	return member.role, nil
+}
+
+// RemoveTeamMember removes an account from a team
+func (s *Store) RemoveTeamMember(ctx context.Context, teamID, accountID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.check(ctx); err != nil {
+		return err
+	}
+
+	key := teamMemberKey{teamID: teamID, accountID: accountID}
+	if _, exists := s.teamMembers[key]; !exists {
+		return sql.ErrNoRows
+	}
+	delete(s.teamMembers, key)
+	return nil
+}
+
+// CreateTemplate creates a new template
+func (s *Store) CreateTemplate(ctx context.Context, template *models.Template) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.check(ctx); err != nil {
+		return err
+	}
+
+	if _, exists := s.templates[template.ID]; exists {
+		return fmt.Errorf("memstore: duplicate template id %s", template.ID)
+	}
+	if (template.AccountID == "") == (template.TeamID == "") {
+		return fmt.Errorf("memstore: template %s needs one of an account and a team", template.ID)
+	}
+	if _, exists := s.accounts[template.AccountID]; template.AccountID != "" && !exists {
+		return fmt.Errorf("memstore: account %s does not exist", template.AccountID)
+	}
+	if _, exists := s.teams[template.TeamID]; template.TeamID != "" && !exists {
+		return fmt.Errorf("memstore: team %s does not exist", template.TeamID)
+	}
+	s.templates[template.ID] = &templateRow{template: copyTemplate(template)}
+	return nil
+}
+
+// GetTemplate retrieves a template by ID
+func (s *Store) GetTemplate(ctx context.Context, templateID string) (*models.Template, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if err := s.check(ctx); err != nil {
+		return nil, err
+	}
+
+	row, exists := s.templates[templateID]
+	if !exists {
+		return nil, sql.ErrNoRows
+	}
+	template := copyTemplate(&row.template)
+	return &template, nil
+}
+
+// UpdateTemplate replaces a template's name, settings and items
+func (s *Store) UpdateTemplate(ctx context.Context, template *models.Template) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.check(ctx); err != nil {
+		return err
+	}
+
+	row, exists := s.templates[template.ID]
+	if !exists {
+		return sql.ErrNoRows
+	}
+	updated := copyTemplate(template)
+	updated.AccountID = row.template.AccountID
+	updated.TeamID = row.template.TeamID
+	updated.CreatedAt = row.template.CreatedAt
+	row.template = updated
+	return nil
+}
+
+// DeleteTemplate deletes a template
+func (s *Store) DeleteTemplate(ctx context.Context, templateID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.check(ctx); err != nil {
+		return err
+	}
+
+	if _, exists := s.templates[templateID]; !exists {
+		return sql.ErrNoRows
+	}
+	delete(s.templates, templateID)
+	return nil
+}
+
+// ListTemplates retrieves the account's templates and its teams', by name
+func (s *Store) ListTemplates(ctx context.Context, accountID string) ([]models.Template, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if err := s.check(ctx); err != nil {
+		return nil, err
+	}
+
+	templates := []models.Template{}
+	for _, row := range s.templates {
+		_, member := s.teamMembers[teamMemberKey{teamID: row.template.TeamID, accountID: accountID}]
+		if row.template.AccountID == accountID || member {
+			templates = append(templates, copyTemplate(&row.template))
+		}
+	}
+	sort.Slice(templates, func(i, j int) bool {
+		if templates[i].Name != templates[j].Name {
+			return templates[i].Name < templates[j].Name
+		}
+		return templates[i].ID < templates[j].ID
+	})
+	return templates, nil
+}
+
+// CreateUser creates a new user in a session
+func (s *Store) CreateUser(ctx context.Context, user *models.User, sessionID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.check(ctx); err != nil {
+		return err
+	}
+
+	if _, exists := s.sessions[sessionID]; !exists {
+		return fmt.Errorf("memstore: session %s does not exist", sessionID)
+	}
+	if _, exists := s.users[user.ID]; exists {
+		return fmt.Errorf("memstore: duplicate user id %s", user.ID)
+	}
+	if user.AccountID != "" {
+		if _, exists := s.accounts[user.AccountID]; !exists {
+			return fmt.Errorf("memstore: account %s does not exist", user.AccountID)
+		}
+	}
+	for _, other := range s.users {
+		if other.sessionID == sessionID && other.name == user.Name {
+			return fmt.Errorf("memstore: user name %q already exists in session %s", user.Name, sessionID)
+		}
+		if other.sessionID == sessionID && user.AccountID != "" && other.accountID == user.AccountID {
+			return fmt.Errorf("memstore: account %s already joined session %s", user.AccountID, sessionID)
+		}
+	}
+
+	s.users[user.ID] = &userRow{
+		id:        user.ID,
+		sessionID: sessionID,
+		accountID: user.AccountID,
+		name:      user.Name,
+		isHost:    user.IsHost,
+		connected: user.Connected,
+		seq:       s.nextSeq(),
+
+		resumeTokenHash: user.ResumeTokenHash,
+	}
+	return nil
+}
+
+// GetSessionUsers retrieves all users for a session
+func (s *Store) GetSessionUsers(ctx context.Context, sessionID string) ([]*models.User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if err := s.check(ctx); err != nil {
+		return nil, err
+	}
+	return s.sessionUsers(sessionID), nil
+}
+
+// GetUserByID retrieves a user by ID
+func (s *Store) GetUserByID(ctx context.Context, userID string) (*models.User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if err := s.check(ctx); err != nil {
+		return nil, err
+	}
+
+	row, exists := s.users[userID]
+	if !exists {
+		return nil, sql.ErrNoRows
+	}
+	return s.userModel(row), nil
+}
+
+// GetSessionUserByAccount retrieves the account's participant in a session
+func (s *Store) GetSessionUserByAccount(ctx context.Context, sessionID, accountID string) (*models.User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if err := s.check(ctx); err != nil {
+		return nil, err
+	}
+
+	for _, row := range s.users {
+		if row.sessionID == sessionID && row.accountID == accountID {
+			return s.userModel(row), nil
+		}
+	}
+	return nil, sql.ErrNoRows
+}
+
+// UpdateUserConnection updates a user's connection status
+func (s *Store) UpdateUserConnection(ctx context.Context, userID string, connected bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.check(ctx); err != nil {
+		return err
+	}
+
+	if row, exists := s.users[userID]; exists {
+		row.connected = connected
+	}
+	return nil
+}
+
+// ClaimResumeToken stores the resume token hash of a user that has none
+func (s *Store) ClaimResumeToken(ctx context.Context, userID string, hash []byte) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.check(ctx); err != nil {
+		return false, err
+	}
+
+	row, exists := s.users[userID]
+	if !exists || len(row.resumeTokenHash) > 0 {
+		return false, nil
+	}
+	row.resumeTokenHash = append([]byte(nil), hash...)
+	return true, nil
+}
+
+// DeleteUser deletes a user and their votes
+func (s *Store) DeleteUser(ctx context.Context, userID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.check(ctx); err != nil {
+		return err
+	}
+	s.deleteUser(userID)
+	return nil
+}
+
+// IsUserNameTaken checks if a username is already taken in a session (case-insensitive)
+func (s *Store) IsUserNameTaken(ctx context.Context, sessionID, userName, excludeUserID string) (bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if err := s.check(ctx); err != nil {
+		return false, err
+	}
+
+	wanted := strings.ToLower(strings.TrimSpace(userName))
+	for _, user := range s.users {
+		if user.sessionID == sessionID && user.id != excludeUserID &&
+			strings.ToLower(strings.TrimSpace(user.name)) == wanted {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// CreatePlanningItem creates a new planning item at the end of the session
+func (s *Store) CreatePlanningItem(ctx context.Context, item *models.PlanningItem, sessionID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.check(ctx); err != nil {
+		return err
+	}
+
+	if _, exists := s.sessions[sessionID]; !exists {
+		return sql.ErrNoRows
+	}
+	if _, exists := s.items[item.ID]; exists {
+		return fmt.Errorf("memstore: duplicate item id %s", item.ID)
+	}
+
+	maxOrder := 0
+	for _, other := range s.items {
+		if other.sessionID == sessionID && other.order > maxOrder {
+			maxOrder = other.order
+		}
+	}
+
+	s.items[item.ID] = &itemRow{
+		id:            item.ID,
+		sessionID:     sessionID,
+		title:         item.Title,
+		description:   item.Description,
+		revealed:      item.Revealed,
+		finalEstimate: item.FinalEstimate,
+		order:         maxOrder + 1,
+		seq:           s.nextSeq(),
+	}
+	return nil
+}
+
+// GetSessionItems retrieves all planning items for a session in order
+func (s *Store) GetSessionItems(ctx context.Context, sessionID string) ([]models.PlanningItem, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if err := s.check(ctx); err != nil {
+		return nil, err
+	}
+	return s.sessionItems(sessionID), nil
+}
+
+// GetPlanningItemByID retrieves a planning item with its votes
+func (s *Store) GetPlanningItemByID(ctx context.Context, itemID string) (*models.PlanningItem, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if err := s.check(ctx); err != nil {
+		return nil, err
+	}
+
+	row, exists := s.items[itemID]
+	if !exists {
+		return nil, sql.ErrNoRows
+	}
+	item := s.itemModel(row)
+	return &item, nil
+}
+
+// GetItemSessionID retrieves the session an item belongs to
+func (s *Store) GetItemSessionID(ctx context.Context, itemID string) (string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if err := s.check(ctx); err != nil {
+		return "", err
+	}
+
+	row, exists := s.items[itemID]
+	if !exists {
+		return "", sql.ErrNoRows
+	}
+	return row.sessionID, nil
+}
+
+// UpdateItemRevealed updates the revealed status of an item
+func (s *Store) UpdateItemRevealed(ctx context.Context, itemID string, revealed bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.check(ctx); err != nil {
+		return err
+	}
+
+	if row, exists := s.items[itemID]; exists {
+		row.revealed = revealed
+	}
+	return nil
+}
+
+// UpdateItemFinalEstimate updates the final estimate of an item
+func (s *Store) UpdateItemFinalEstimate(ctx context.Context, itemID, estimate string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.check(ctx); err != nil {
+		return err
+	}
+
+	if row, exists := s.items[itemID]; exists {
+		row.finalEstimate = estimate
+	}
+	return nil
+}
+
+// SaveVote saves or updates a user's vote for an item
+func (s *Store) SaveVote(ctx context.Context, itemID, userID, vote string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.check(ctx); err != nil {
+		return err
+	}
+
+	if _, exists := s.items[itemID]; !exists {
+		return fmt.Errorf("memstore: item %s does not exist", itemID)
+	}
+	if _, exists := s.users[userID]; !exists {
+		return fmt.Errorf("memstore: user %s does not exist", userID)
+	}
+	s.votes[voteKey{itemID, userID}] = vote
+	return nil
+}
+
+// GetItemVotes retrieves all votes for a planning item
+func (s *Store) GetItemVotes(ctx context.Context, itemID string) (map[string]string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if err := s.check(ctx); err != nil {
+		return nil, err
+	}
+	return s.itemVotes(itemID), nil
+}
+
+// DeleteItemVotes deletes all votes for a planning item
+func (s *Store) DeleteItemVotes(ctx context.Context, itemID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.check(ctx); err != nil {
+		return err
+	}
+
+	for key := range s.votes {
+		if key.itemID == itemID {
+			delete(s.votes, key)
+		}
+	}
+	return nil
+}
+
+// The helpers below expect s.mu to be held by the caller
+
+// check fails a call during a simulated outage, or when its context ends
+// before the simulated latency has passed
+func (s *Store) check(ctx context.Context) error {
+	if s.unavailable {
+		return db.ErrUnavailable
+	}
+	if s.latency > 0 {
+		timer := time.NewTimer(s.latency)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+		}
+	}
+	return ctx.Err()
+}
+
+func (s *Store) nextSeq() int64 {
+	s.seq++
+	return s.seq
+}
+
+func (s *Store) sessionUsers(sessionID string) []*models.User {
+	rows := []*userRow{}
+	for _, row := range s.users {
+		if row.sessionID == sessionID {
+			rows = append(rows, row)
+		}
+	}
+	sort.Slice(rows, func(i, j int) bool { return rows[i].seq < rows[j].seq })
+
+	users := make([]*models.User, 0, len(rows))
+	for _, row := range rows {
+		users = append(users, s.userModel(row))
+	}
+	return users
+}
+
+func (s *Store) sessionItems(sessionID string) []models.PlanningItem {
+	rows := []*itemRow{}
+	for _, row := range s.items {
+		if row.sessionID == sessionID {
+			rows = append(rows, row)
+		}
+	}
+	sort.Slice(rows, func(i, j int) bool {
+		if rows[i].order != rows[j].order {
+			return rows[i].order < rows[j].order
+		}
+		return rows[i].seq < rows[j].seq
+	})
+
+	items := make([]models.PlanningItem, 0, len(rows))
+	for _, row := range rows {
+		items = append(items, s.itemModel(row))
+	}
+	return items
+}
+
+func (s *Store) itemModel(row *itemRow) models.PlanningItem {
+	return models.PlanningItem{
+		ID:            row.id,
+		Title:         row.title,
+		Description:   row.description,
+		Votes:         s.itemVotes(row.id),
+		Revealed:      row.revealed,
+		FinalEstimate: row.finalEstimate,
+	}
+}
+
+func (s *Store) itemVotes(itemID string) map[string]string {
+	votes := map[string]string{}
+	for key, vote := range s.votes {
+		if key.itemID == itemID {
+			votes[key.userID] = vote
+		}
+	}
+	return votes
+}
+
+func (s *Store) deleteSession(sessionID string) {
+	delete(s.sessions, sessionID)
+	for id, user := range s.users {
+		if user.sessionID == sessionID {
+			s.deleteUser(id)
+		}
+	}
+	for id, item := range s.items {
+		if item.sessionID == sessionID {
+			s.deleteItem(id)
+		}
+	}
+}
+
+func (s *Store) deleteUser(userID string) {
+	delete(s.users, userID)
+	for key := range s.votes {
+		if key.userID == userID {
+			delete(s.votes, key)
+		}
+	}
+}
+
+func (s *Store) deleteItem(itemID string) {
+	delete(s.items, itemID)
+	for key := range s.votes {
+		if key.itemID == itemID {
+			delete(s.votes, key)
+		}
+	}
+}
+
+// data is a copy of the store's rows, taken by WithTx to roll back
+type data struct {
+	accounts    map[string]*accountRow
+	logins      map[loginKey]models.Login
+	teams       map[string]*teamRow
+	teamMembers map[teamMemberKey]*teamMemberRow
+	templates   map[string]*templateRow
+	sessions    map[string]*sessionRow
+	users       map[string]*userRow
+	items       map[string]*itemRow
+	votes       map[voteKey]string
+	seq         int64
+}
+
+func (s *Store) snapshot() data {
+	saved := data{
+		accounts:    make(map[string]*accountRow, len(s.accounts)),
+		logins:      make(map[loginKey]models.Login, len(s.logins)),
+		teams:       make(map[string]*teamRow, len(s.teams)),
+		teamMembers: make(map[teamMemberKey]*teamMemberRow, len(s.teamMembers)),
+		templates:   make(map[string]*templateRow, len(s.templates)),
+		sessions:    make(map[string]*sessionRow, len(s.sessions)),
+		users:       make(map[string]*userRow, len(s.users)),
+		items:       make(map[string]*itemRow, len(s.items)),
+		votes:       make(map[voteKey]string, len(s.votes)),
+		seq:         s.seq,
+	}
+	for id, row := range s.accounts {
+		copied := *row
+		saved.accounts[id] = &copied
+	}
+	for key, login := range s.logins {
+		saved.logins[key] = login
+	}
+	for id, row := range s.teams {
+		copied := *row
+		saved.teams[id] = &copied
+	}
+	for key, member := range s.teamMembers {
+		copied := *member
+		saved.teamMembers[key] = &copied
+	}
+	for id, row := range s.templates {
+		saved.templates[id] = &templateRow{template: copyTemplate(&row.template)}
+	}
+	for id, row := range s.sessions {
+		copied := *row
+		saved.sessions[id] = &copied
+	}
+	for id, row := range s.users {
+		copied := *row
+		saved.users[id] = &copied
+	}
+	for id, row := range s.items {
+		copied := *row
+		saved.items[id] = &copied
+	}
+	for key, vote := range s.votes {
+		saved.votes[key] = vote
+	}
+	return saved
+}
+
+func (s *Store) restore(saved data) {
+	s.accounts = saved.accounts
+	s.logins = saved.logins
+	s.teams = saved.teams
+	s.teamMembers = saved.teamMembers
+	s.templates = saved.templates
+	s.sessions = saved.sessions
+	s.users = saved.users
+	s.items = saved.items
+	s.votes = saved.votes
+	s.seq = saved.seq
+}
+
+func (row *sessionRow) toModel() *models.Session {
+	return &models.Session{
+		ID:            row.id,
+		Name:          row.name,
+		HostID:        row.hostID,
+		Users:         make(map[string]*models.User),
+		Items:         []models.PlanningItem{},
+		CurrentItemID: row.currentItemID,
+		JoinCode:      row.joinCode,
+		AuthRequired:  row.authRequired,
+		TeamID:        row.teamID,
+		Settings:      row.settings,
+		HostKeyHash:   row.hostKeyHash,
+		CreatedAt:     row.createdAt,
+		ArchivedAt:    row.archivedAt,
+	}
+}
+
+func (s *Store) userModel(row *userRow) *models.User {
+	user := &models.User{
+		ID:        row.id,
+		SessionID: row.sessionID,
+		Name:      row.name,
+		IsHost:    row.isHost,
+		Connected: row.connected,
+		AccountID: row.accountID,
+
+		ResumeTokenHash: row.resumeTokenHash,
+	}
+	if account, exists := s.accounts[row.accountID]; exists {
+		user.AvatarURL = account.avatarURL
+	}
+	return user
+}
+
+func (row *accountRow) toModel() *models.Account {
+	return &models.Account{
+		ID:          row.id,
+		DisplayName: row.displayName,
+		AvatarURL:   row.avatarURL,
+		Provider:    row.provider,
+		CreatedAt:   row.createdAt,
+	}
+}
+
+func (row *teamRow) toModel() *models.Team {
+	return &models.Team{
+		ID:        row.id,
+		Name:      row.name,
+		Settings:  row.settings,
+		CreatedAt: row.createdAt,
+	}
+}
+
+// copyTemplate copies a template with its items and deck, so the stored
+// row shares nothing with callers
+func copyTemplate(template *models.Template) models.Template {
+	copied := *template
+	copied.Items = append([]models.TemplateItem{}, template.Items...)
+	if template.Settings.Deck != nil {
+		copied.Settings.Deck = append([]string{}, template.Settings.Deck...)
+	}
+	return copied
+}
@@ -0,0 +1,54 @@
+package memstore
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"poker-planning-api/db"
+	"poker-planning-api/models"
+	"testing"
+)
+
+// ctx is passed to every store call in these tests
+var ctx = context.Background()
+
+func TestWithTxRollsBackOnError(t *testing.T) {
+	s := New()
+	session := models.NewSession("00000000-0000-0000-0000-000000000001", "Sprint 1", "00000000-0000-0000-0000-000000000002")
+	host := &models.User{ID: session.HostID, Name: "Hana", IsHost: true}
+
+	// The second host insert fails on the duplicate ID, which must undo
+	// the session created before it
+	err := s.WithTx(ctx, func(tx db.Store) error {
+		if err := tx.CreateSession(ctx, session); err != nil {
+			return err
+		}
+		if err := tx.CreateUser(ctx, host, session.ID); err != nil {
+			return err
+		}
+		return tx.CreateUser(ctx, host, session.ID)
+	})
+	if err == nil {
+		t.Fatal("expected the duplicate user to fail the transaction")
+	}
+	if _, err := s.GetSession(ctx, session.ID); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("session survived the rollback: %v", err)
+	}
+	if _, err := s.GetUserByID(ctx, host.ID); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("host survived the rollback: %v", err)
+	}
+
+	err = s.WithTx(ctx, func(tx db.Store) error {
+		if err := tx.CreateSession(ctx, session); err != nil {
+			return err
+		}
+		return tx.CreateUser(ctx, host, session.ID)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := s.GetSession(ctx, session.ID)
+	if err != nil || len(loaded.Users) != 1 {
+		t.Errorf("committed session %+v, %v", loaded, err)
+	}
+}
@@ -1,46 +1,87 @@
 package db
 
 import (
+	"context"
 	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"poker-planning-api/logging"
+	"poker-planning-api/metrics"
 	"poker-planning-api/models"
+	"strconv"
+	"strings"
 	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
 )
 
+// uniqueViolation is the PostgreSQL error code for a violated unique
+// constraint or index
+const uniqueViolation = "23505"
+
+// observe records the latency of a query and logs its failure, other than
+// finding no rows or being cancelled by the caller. Call it with the
+// method's named error result:
+//
+//	defer observe("save_vote", &err)()
+//
+// It also turns pgx.ErrNoRows into sql.ErrNoRows, which Store promises and
+// memstore returns.
+func observe(query string, err *error) func() {
+	done := metrics.ObserveQuery(query)
+	return func() {
+		done()
+		if errors.Is(*err, pgx.ErrNoRows) {
+			*err = sql.ErrNoRows
+		}
+		if *err != nil && !errors.Is(*err, sql.ErrNoRows) && !errors.Is(*err, context.Canceled) {
+			slog.Error("database query failed", logging.KeyQuery, query, logging.Err(*err))
+		}
+	}
+}
+
 // CreateSession creates a new session in the database
-func CreateSession(session *models.Session) error {
+func (p *Postgres) CreateSession(ctx context.Context, session *models.Session) (err error) {
+	defer observe("create_session", &err)()
+
 	query := `
-		INSERT INTO sessions (id, name, host_id, current_item_id, created_at, updated_at)
-		VALUES ($1, $2, $3, $4, $5, $6)
+		INSERT INTO sessions (id, name, host_id, current_item_id, join_code, auth_required,
+			team_id, deck, timer_seconds, auto_reveal, host_key_hash, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)
 	`
-	_, err := DB.Exec(query, session.ID, session.Name, session.HostID,
+	_, err = p.q.Exec(ctx, query, session.ID, session.Name, session.HostID,
 		sql.NullString{String: session.CurrentItemID, Valid: session.CurrentItemID != ""},
-		session.CreatedAt, time.Now())
+		sql.NullString{String: session.JoinCode, Valid: session.JoinCode != ""},
+		session.AuthRequired, nullString(session.TeamID), deck(session.Settings),
+		session.Settings.TimerSeconds, session.Settings.AutoReveal, session.HostKeyHash, session.CreatedAt, time.Now())
+
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == uniqueViolation && pgErr.ConstraintName == "idx_sessions_join_code" {
+		return ErrJoinCodeTaken
+	}
 	return err
 }
 
-// GetSession retrieves a session by ID
-func GetSession(sessionID string) (*models.Session, error) {
-	query := `SELECT id, name, host_id, current_item_id, created_at FROM sessions WHERE id = $1`
+// GetSession retrieves a session by ID with its users, items and votes.
+// It takes three queries however large the session is: the session row,
+// its users, and its items joined with their votes.
+func (p *Postgres) GetSession(ctx context.Context, sessionID string) (_ *models.Session, err error) {
+	defer observe("get_session", &err)()
 
-	session := &models.Session{
-		Users: make(map[string]*models.User),
-		Items: []models.PlanningItem{},
-	}
+	query := `
+		SELECT id, name, host_id, current_item_id, join_code, auth_required,
+			team_id, deck, timer_seconds, auto_reveal, host_key_hash, created_at, archived_at
+		FROM sessions WHERE id = $1
+	`
 
-	var currentItemID sql.NullString
-	err := DB.QueryRow(query, sessionID).Scan(
-		&session.ID, &session.Name, &session.HostID, &currentItemID, &session.CreatedAt,
-	)
+	session, err := scanSession(p.q.QueryRow(ctx, query, sessionID))
 	if err != nil {
 		return nil, err
 	}
 
-	if currentItemID.Valid {
-		session.CurrentItemID = currentItemID.String
-	}
-
-	// Load users
-	users, err := GetSessionUsers(sessionID)
+	users, err := p.GetSessionUsers(ctx, sessionID)
 	if err != nil {
 		return nil, err
 	}
@@ -48,8 +89,7 @@ func GetSession(sessionID string) (*models.Session, error) {
 		session.Users[user.ID] = user
 	}
 
-	// Load items
-	items, err := GetSessionItems(sessionID)
+	items, err := p.GetSessionItems(ctx, sessionID)
 	if err != nil {
 		return nil, err
 	}
@@ -58,65 +98,235 @@ func GetSession(sessionID string) (*models.Session, error) {
 	return session, nil
 }
 
-// GetAllSessions retrieves all sessions
-func GetAllSessions() ([]*models.Session, error) {
-	query := `SELECT id, name, host_id, current_item_id, created_at FROM sessions ORDER BY created_at DESC`
+// ListSessions retrieves the sessions matching filter with their user and
+// item counts
+func (p *Postgres) ListSessions(ctx context.Context, filter SessionFilter) (_ []models.SessionSummary, err error) {
+	defer observe("list_sessions", &err)()
+
+	// An empty array rather than NULL keeps the role columns true or false
+	userIDs := filter.UserIDs
+	if userIDs == nil {
+		userIDs = []string{}
+	}
+	args := []interface{}{userIDs, nullString(filter.AccountID)}
+	arg := func(value interface{}) string {
+		args = append(args, value)
+		return "$" + strconv.Itoa(len(args))
+	}
 
-	rows, err := DB.Query(query)
+	joined := "s.id IN (SELECT session_id FROM users WHERE id = ANY($1::uuid[]) OR account_id = $2::uuid)"
+	conditions := []string{joined}
+	if filter.TeamID != "" {
+		conditions = []string{"s.team_id = " + arg(filter.TeamID)}
+	}
+	if filter.Query != "" {
+		conditions = append(conditions, "s.name ILIKE "+arg("%"+escapeLike(filter.Query)+"%"))
+	}
+	switch filter.State {
+	case models.SessionStateActive:
+		conditions = append(conditions, "s.archived_at IS NULL")
+	case models.SessionStateArchived:
+		conditions = append(conditions, "s.archived_at IS NOT NULL")
+	}
+	if !filter.CreatedFrom.IsZero() {
+		conditions = append(conditions, "s.created_at >= "+arg(filter.CreatedFrom))
+	}
+	if !filter.CreatedTo.IsZero() {
+		conditions = append(conditions, "s.created_at < "+arg(filter.CreatedTo))
+	}
+	if filter.After != nil {
+		conditions = append(conditions, fmt.Sprintf("(s.created_at, s.id) < (%s, %s)",
+			arg(filter.After.CreatedAt), arg(filter.After.ID)))
+	}
+
+	query := `
+		SELECT s.id, s.name, s.join_code, s.team_id, s.created_at, s.archived_at,
+			s.host_id = ANY($1::uuid[]) OR s.host_id IN (SELECT id FROM users WHERE account_id = $2::uuid),
+			` + joined + `,
+			(SELECT COUNT(*) FROM users u WHERE u.session_id = s.id),
+			(SELECT COUNT(*) FROM planning_items i WHERE i.session_id = s.id)
+		FROM sessions s
+		WHERE ` + strings.Join(conditions, " AND ") + `
+		ORDER BY s.created_at DESC, s.id DESC
+		LIMIT ` + arg(filter.Limit)
+
+	rows, err := p.q.Query(ctx, query, args...)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	sessions := []*models.Session{}
+	sessions := []models.SessionSummary{}
 	for rows.Next() {
-		session := &models.Session{
-			Users: make(map[string]*models.User),
-			Items: []models.PlanningItem{},
-		}
-		var currentItemID sql.NullString
-		err := rows.Scan(&session.ID, &session.Name, &session.HostID, &currentItemID, &session.CreatedAt)
+		var session models.SessionSummary
+		var joinCode, teamID sql.NullString
+		var archivedAt sql.NullTime
+		var isHost, isParticipant bool
+		err := rows.Scan(&session.ID, &session.Name, &joinCode, &teamID, &session.CreatedAt, &archivedAt,
+			&isHost, &isParticipant, &session.UserCount, &session.ItemCount)
 		if err != nil {
 			return nil, err
 		}
-		if currentItemID.Valid {
-			session.CurrentItemID = currentItemID.String
+
+		session.JoinCode = joinCode.String
+		session.TeamID = teamID.String
+		session.State = models.SessionStateActive
+		if archivedAt.Valid {
+			session.State = models.SessionStateArchived
+			session.ArchivedAt = &archivedAt.Time
+		}
+		switch {
+		case isHost:
+			session.Role = models.RoleHost
+		case isParticipant:
+			session.Role = models.RoleParticipant
 		}
 		sessions = append(sessions, session)
 	}
 
-	return sessions, nil
+	return sessions, rows.Err()
+}
+
+// nullString stores an empty string as NULL
+func nullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
+
+// deck is the settings' deck as stored: an empty array rather than NULL
+func deck(settings models.SessionSettings) []string {
+	if settings.Deck == nil {
+		return []string{}
+	}
+	return settings.Deck
+}
+
+// escapeLike quotes the wildcards of a LIKE pattern
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(s)
+}
+
+// scanSession reads the session columns selected by GetSession, leaving
+// users and items empty
+func scanSession(row interface{ Scan(...interface{}) error }) (*models.Session, error) {
+	session := &models.Session{
+		Users: make(map[string]*models.User),
+		Items: []models.PlanningItem{},
+	}
+
+	var currentItemID, joinCode, teamID sql.NullString
+	var archivedAt sql.NullTime
+	err := row.Scan(&session.ID, &session.Name, &session.HostID, &currentItemID, &joinCode,
+		&session.AuthRequired, &teamID, &session.Settings.Deck, &session.Settings.TimerSeconds,
+		&session.Settings.AutoReveal, &session.HostKeyHash, &session.CreatedAt, &archivedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	session.CurrentItemID = currentItemID.String
+	session.JoinCode = joinCode.String
+	session.TeamID = teamID.String
+	if len(session.Settings.Deck) == 0 {
+		session.Settings.Deck = nil
+	}
+	if archivedAt.Valid {
+		session.ArchivedAt = &archivedAt.Time
+	}
+	return session, nil
 }
 
 // UpdateSessionCurrentItem updates the current item being voted on
-func UpdateSessionCurrentItem(sessionID, itemID string) error {
+func (p *Postgres) UpdateSessionCurrentItem(ctx context.Context, sessionID, itemID string) (err error) {
+	defer observe("update_session_current_item", &err)()
+
 	query := `UPDATE sessions SET current_item_id = $1, updated_at = $2 WHERE id = $3`
-	_, err := DB.Exec(query, sql.NullString{String: itemID, Valid: itemID != ""}, time.Now(), sessionID)
+	_, err = p.q.Exec(ctx, query, sql.NullString{String: itemID, Valid: itemID != ""}, time.Now(), sessionID)
 	return err
 }
 
 // DeleteSession deletes a session and all related data (cascades)
-func DeleteSession(sessionID string) error {
+func (p *Postgres) DeleteSession(ctx context.Context, sessionID string) (err error) {
+	defer observe("delete_session", &err)()
+
 	query := `DELETE FROM sessions WHERE id = $1`
-	_, err := DB.Exec(query, sessionID)
+	_, err = p.q.Exec(ctx, query, sessionID)
 	return err
 }
 
+// DeleteSessionsCreatedBefore deletes old sessions and all related data
+// (cascades), returning their IDs
+func (p *Postgres) DeleteSessionsCreatedBefore(ctx context.Context, before time.Time) (_ []string, err error) {
+	defer observe("delete_sessions_created_before", &err)()
+
+	query := `DELETE FROM sessions WHERE created_at < $1 RETURNING id`
+	rows, err := p.q.Query(ctx, query, before)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessionIDs := []string{}
+	for rows.Next() {
+		var sessionID string
+		if err := rows.Scan(&sessionID); err != nil {
+			return nil, err
+		}
+		sessionIDs = append(sessionIDs, sessionID)
+	}
+	return sessionIDs, rows.Err()
+}
+
+// GetSessionIDByJoinCode resolves the join code of an active session
+func (p *Postgres) GetSessionIDByJoinCode(ctx context.Context, code string) (_ string, err error) {
+	defer observe("get_session_id_by_join_code", &err)()
+
+	query := `SELECT id FROM sessions WHERE join_code = $1 AND archived_at IS NULL`
+
+	var sessionID string
+	err = p.q.QueryRow(ctx, query, code).Scan(&sessionID)
+	return sessionID, err
+}
+
+// ArchiveSession marks a session archived so its join code can be reused.
+// Archiving an archived session keeps the original time.
+func (p *Postgres) ArchiveSession(ctx context.Context, sessionID string) (err error) {
+	defer observe("archive_session", &err)()
+
+	query := `UPDATE sessions SET archived_at = COALESCE(archived_at, $1) WHERE id = $2`
+	tag, err := p.q.Exec(ctx, query, time.Now(), sessionID)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
 // CreateUser creates a new user in the database
-func CreateUser(user *models.User, sessionID string) error {
+func (p *Postgres) CreateUser(ctx context.Context, user *models.User, sessionID string) (err error) {
+	defer observe("create_user", &err)()
+
 	query := `
-		INSERT INTO users (id, session_id, name, is_host, connected, created_at)
-		VALUES ($1, $2, $3, $4, $5, $6)
+		INSERT INTO users (id, session_id, name, is_host, connected, account_id, resume_token_hash, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
 	`
-	_, err := DB.Exec(query, user.ID, sessionID, user.Name, user.IsHost, user.Connected, time.Now())
+	_, err = p.q.Exec(ctx, query, user.ID, sessionID, user.Name, user.IsHost, user.Connected,
+		nullString(user.AccountID), user.ResumeTokenHash, time.Now())
 	return err
 }
 
+// userColumns selects a user with the avatar of its account
+const userColumns = `
+	SELECT u.id, u.session_id, u.name, u.is_host, u.connected, u.account_id, u.resume_token_hash, a.avatar_url
+	FROM users u
+	LEFT JOIN accounts a ON a.id = u.account_id
+`
+
 // GetSessionUsers retrieves all users for a session
-func GetSessionUsers(sessionID string) ([]*models.User, error) {
-	query := `SELECT id, name, is_host, connected FROM users WHERE session_id = $1`
+func (p *Postgres) GetSessionUsers(ctx context.Context, sessionID string) (_ []*models.User, err error) {
+	defer observe("get_session_users", &err)()
 
-	rows, err := DB.Query(query, sessionID)
+	rows, err := p.q.Query(ctx, userColumns+` WHERE u.session_id = $1`, sessionID)
 	if err != nil {
 		return nil, err
 	}
@@ -124,46 +334,79 @@ func GetSessionUsers(sessionID string) ([]*models.User, error) {
 
 	users := []*models.User{}
 	for rows.Next() {
-		user := &models.User{}
-		err := rows.Scan(&user.ID, &user.Name, &user.IsHost, &user.Connected)
+		user, err := scanUser(rows)
 		if err != nil {
 			return nil, err
 		}
 		users = append(users, user)
 	}
 
-	return users, nil
+	return users, rows.Err()
 }
 
 // GetUserByID retrieves a user by ID
-func GetUserByID(userID string) (*models.User, error) {
-	query := `SELECT id, name, is_host, connected FROM users WHERE id = $1`
+func (p *Postgres) GetUserByID(ctx context.Context, userID string) (_ *models.User, err error) {
+	defer observe("get_user_by_id", &err)()
+
+	return scanUser(p.q.QueryRow(ctx, userColumns+` WHERE u.id = $1`, userID))
+}
+
+// GetSessionUserByAccount retrieves the account's participant in a session
+func (p *Postgres) GetSessionUserByAccount(ctx context.Context, sessionID, accountID string) (_ *models.User, err error) {
+	defer observe("get_session_user_by_account", &err)()
+
+	query := userColumns + ` WHERE u.session_id = $1 AND u.account_id = $2`
+	return scanUser(p.q.QueryRow(ctx, query, sessionID, accountID))
+}
 
+// scanUser reads the columns selected with userColumns
+func scanUser(row pgx.Row) (*models.User, error) {
 	user := &models.User{}
-	err := DB.QueryRow(query, userID).Scan(&user.ID, &user.Name, &user.IsHost, &user.Connected)
+	var accountID, avatarURL sql.NullString
+	err := row.Scan(&user.ID, &user.SessionID, &user.Name, &user.IsHost, &user.Connected, &accountID,
+		&user.ResumeTokenHash, &avatarURL)
 	if err != nil {
 		return nil, err
 	}
-
+	user.AccountID = accountID.String
+	user.AvatarURL = avatarURL.String
 	return user, nil
 }
 
 // UpdateUserConnection updates a user's connection status
-func UpdateUserConnection(userID string, connected bool) error {
+func (p *Postgres) UpdateUserConnection(ctx context.Context, userID string, connected bool) (err error) {
+	defer observe("update_user_connection", &err)()
+
 	query := `UPDATE users SET connected = $1 WHERE id = $2`
-	_, err := DB.Exec(query, connected, userID)
+	_, err = p.q.Exec(ctx, query, connected, userID)
 	return err
 }
 
+// ClaimResumeToken stores the resume token hash of a user that has none
+func (p *Postgres) ClaimResumeToken(ctx context.Context, userID string, hash []byte) (claimed bool, err error) {
+	defer observe("claim_resume_token", &err)()
+
+	query := `UPDATE users SET resume_token_hash = $1 WHERE id = $2 AND resume_token_hash IS NULL`
+	tag, err := p.q.Exec(ctx, query, hash, userID)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() == 1, nil
+}
+
 // DeleteUser deletes a user from the database
-func DeleteUser(userID string) error {
+func (p *Postgres) DeleteUser(ctx context.Context, userID string) (err error) {
+	defer observe("delete_user", &err)()
+
 	query := `DELETE FROM users WHERE id = $1`
-	_, err := DB.Exec(query, userID)
+	_, err = p.q.Exec(ctx, query, userID)
 	return err
 }
 
 // IsUserNameTaken checks if a username is already taken in a session (case-insensitive)
-func IsUserNameTaken(sessionID, userName, excludeUserID string) (bool, error) {
+func (p *Postgres) IsUserNameTaken(ctx context.Context, sessionID, userName, excludeUserID string) (_ bool, err error) {
+	defer observe("is_user_name_taken", &err)()
+
 	var query string
 	var args []interface{}
 
@@ -184,129 +427,179 @@ func IsUserNameTaken(sessionID, userName, excludeUserID string) (bool, error) {
 	}
 
 	var count int
-	err := DB.QueryRow(query, args...).Scan(&count)
+	err = p.q.QueryRow(ctx, query, args...).Scan(&count)
 	if err != nil {
 		return false, err
 	}
 	return count > 0, nil
 }
 
-// CreatePlanningItem creates a new planning item
-func CreatePlanningItem(item *models.PlanningItem, sessionID string) error {
-	// Get the next order number
-	var maxOrder int
-	orderQuery := `SELECT COALESCE(MAX(item_order), 0) FROM planning_items WHERE session_id = $1`
-	DB.QueryRow(orderQuery, sessionID).Scan(&maxOrder)
+// CreatePlanningItem appends a new planning item to a session. The session
+// row stays locked from reading the last position to the insert, so
+// concurrent adds get distinct positions. It returns sql.ErrNoRows if the
+// session does not exist.
+func (p *Postgres) CreatePlanningItem(ctx context.Context, item *models.PlanningItem, sessionID string) (err error) {
+	defer observe("create_planning_item", &err)()
 
-	query := `
-		INSERT INTO planning_items (id, session_id, title, description, revealed, final_estimate, created_at, item_order)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
-	`
-	_, err := DB.Exec(query, item.ID, sessionID, item.Title, item.Description, item.Revealed,
-		sql.NullString{String: item.FinalEstimate, Valid: item.FinalEstimate != ""},
-		time.Now(), maxOrder+1)
-	return err
+	return p.inTx(ctx, func(tx *Postgres) error {
+		if err := tx.LockSession(ctx, sessionID); err != nil {
+			return err
+		}
+
+		var maxOrder int
+		orderQuery := `SELECT COALESCE(MAX(item_order), 0) FROM planning_items WHERE session_id = $1`
+		if err := tx.q.QueryRow(ctx, orderQuery, sessionID).Scan(&maxOrder); err != nil {
+			return err
+		}
+
+		query := `
+			INSERT INTO planning_items (id, session_id, title, description, revealed, final_estimate, created_at, item_order)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		`
+		_, err := tx.q.Exec(ctx, query, item.ID, sessionID, item.Title, item.Description, item.Revealed,
+			sql.NullString{String: item.FinalEstimate, Valid: item.FinalEstimate != ""},
+			time.Now(), maxOrder+1)
+		return err
+	})
 }
 
-// GetSessionItems retrieves all planning items for a session
-func GetSessionItems(sessionID string) ([]models.PlanningItem, error) {
-	query := `
-		SELECT id, title, description, revealed, final_estimate 
-		FROM planning_items 
-		WHERE session_id = $1 
-		ORDER BY item_order, created_at
+// itemColumns selects a planning item joined with its votes, one row per
+// vote and a single row with NULL vote columns for an item without any;
+// scanItems folds the rows back into items
+const itemColumns = `
+	SELECT i.id, i.title, i.description, i.revealed, i.final_estimate, v.user_id, v.vote
+	FROM planning_items i
+	LEFT JOIN votes v ON v.planning_item_id = i.id
+`
+
+// GetSessionItems retrieves all planning items for a session with their
+// votes in a single query
+func (p *Postgres) GetSessionItems(ctx context.Context, sessionID string) (_ []models.PlanningItem, err error) {
+	defer observe("get_session_items", &err)()
+
+	if err := p.flushVotes(ctx); err != nil {
+		return nil, err
+	}
+
+	query := itemColumns + `
+		WHERE i.session_id = $1
+		ORDER BY i.item_order, i.created_at, i.id
 	`
 
-	rows, err := DB.Query(query, sessionID)
+	rows, err := p.q.Query(ctx, query, sessionID)
 	if err != nil {
 		return nil, err
 	}
-	defer rows.Close()
-
-	items := []models.PlanningItem{}
-	for rows.Next() {
-		item := models.PlanningItem{
-			Votes: make(map[string]string),
-		}
-		var finalEstimate sql.NullString
-		err := rows.Scan(&item.ID, &item.Title, &item.Description, &item.Revealed, &finalEstimate)
-		if err != nil {
-			return nil, err
-		}
-		if finalEstimate.Valid {
-			item.FinalEstimate = finalEstimate.String
-		}
+	return scanItems(rows)
+}
 
-		// Load votes for this item
-		votes, err := GetItemVotes(item.ID)
-		if err != nil {
-			return nil, err
-		}
-		item.Votes = votes
+// GetPlanningItemByID retrieves a planning item by ID with its votes
+func (p *Postgres) GetPlanningItemByID(ctx context.Context, itemID string) (_ *models.PlanningItem, err error) {
+	defer observe("get_planning_item_by_id", &err)()
 
-		items = append(items, item)
+	if err := p.flushVotes(ctx); err != nil {
+		return nil, err
 	}
 
-	return items, nil
-}
-
-// GetPlanningItemByID retrieves a planning item by ID
-func GetPlanningItemByID(itemID string) (*models.PlanningItem, error) {
-	query := `SELECT id, title, description, revealed, final_estimate FROM planning_items WHERE id = $1`
-
-	item := &models.PlanningItem{
-		Votes: make(map[string]string),
+	rows, err := p.q.Query(ctx, itemColumns+` WHERE i.id = $1`, itemID)
+	if err != nil {
+		return nil, err
 	}
-	var finalEstimate sql.NullString
-	err := DB.QueryRow(query, itemID).Scan(&item.ID, &item.Title, &item.Description, &item.Revealed, &finalEstimate)
+	items, err := scanItems(rows)
 	if err != nil {
 		return nil, err
 	}
-	if finalEstimate.Valid {
-		item.FinalEstimate = finalEstimate.String
+	if len(items) == 0 {
+		return nil, sql.ErrNoRows
 	}
+	return &items[0], nil
+}
 
-	// Load votes
-	votes, err := GetItemVotes(item.ID)
-	if err != nil {
-		return nil, err
+// GetItemSessionID retrieves the session an item belongs to
+func (p *Postgres) GetItemSessionID(ctx context.Context, itemID string) (_ string, err error) {
+	defer observe("get_item_session_id", &err)()
+
+	var sessionID string
+	err = p.q.QueryRow(ctx, `SELECT session_id FROM planning_items WHERE id = $1`, itemID).Scan(&sessionID)
+	return sessionID, err
+}
+
+// scanItems reads rows selected with itemColumns, which must keep each
+// item's rows together, and closes them
+func scanItems(rows pgx.Rows) ([]models.PlanningItem, error) {
+	defer rows.Close()
+
+	items := []models.PlanningItem{}
+	for rows.Next() {
+		var item models.PlanningItem
+		var finalEstimate, voterID, vote sql.NullString
+		err := rows.Scan(&item.ID, &item.Title, &item.Description, &item.Revealed, &finalEstimate, &voterID, &vote)
+		if err != nil {
+			return nil, err
+		}
+
+		if n := len(items); n == 0 || items[n-1].ID != item.ID {
+			item.FinalEstimate = finalEstimate.String
+			item.Votes = make(map[string]string)
+			items = append(items, item)
+		}
+		if voterID.Valid {
+			items[len(items)-1].Votes[voterID.String] = vote.String
+		}
 	}
-	item.Votes = votes
 
-	return item, nil
+	return items, rows.Err()
 }
 
 // UpdateItemRevealed updates the revealed status of an item
-func UpdateItemRevealed(itemID string, revealed bool) error {
+func (p *Postgres) UpdateItemRevealed(ctx context.Context, itemID string, revealed bool) (err error) {
+	defer observe("update_item_revealed", &err)()
+
 	query := `UPDATE planning_items SET revealed = $1 WHERE id = $2`
-	_, err := DB.Exec(query, revealed, itemID)
+	_, err = p.q.Exec(ctx, query, revealed, itemID)
 	return err
 }
 
 // UpdateItemFinalEstimate updates the final estimate of an item
-func UpdateItemFinalEstimate(itemID, estimate string) error {
+func (p *Postgres) UpdateItemFinalEstimate(ctx context.Context, itemID, estimate string) (err error) {
+	defer observe("update_item_final_estimate", &err)()
+
 	query := `UPDATE planning_items SET final_estimate = $1 WHERE id = $2`
-	_, err := DB.Exec(query, sql.NullString{String: estimate, Valid: estimate != ""}, itemID)
+	_, err = p.q.Exec(ctx, query, sql.NullString{String: estimate, Valid: estimate != ""}, itemID)
 	return err
 }
 
-// SaveVote saves or updates a user's vote for an item
-func SaveVote(itemID, userID, vote string) error {
+// SaveVote saves or updates a user's vote for an item. With a vote flush
+// interval set, the vote is queued and written with the next batch; see
+// voteBatcher.
+func (p *Postgres) SaveVote(ctx context.Context, itemID, userID, vote string) (err error) {
+	defer observe("save_vote", &err)()
+
+	if p.votes != nil {
+		return p.votes.add(ctx, itemID, userID, vote)
+	}
+
 	query := `
 		INSERT INTO votes (planning_item_id, user_id, vote, created_at)
 		VALUES ($1, $2, $3, $4)
 		ON CONFLICT (planning_item_id, user_id) 
 		DO UPDATE SET vote = $3, created_at = $4
 	`
-	_, err := DB.Exec(query, itemID, userID, vote, time.Now())
+	_, err = p.q.Exec(ctx, query, itemID, userID, vote, time.Now())
 	return err
 }
 
 // GetItemVotes retrieves all votes for a planning item
-func GetItemVotes(itemID string) (map[string]string, error) {
+func (p *Postgres) GetItemVotes(ctx context.Context, itemID string) (_ map[string]string, err error) {
+	defer observe("get_item_votes", &err)()
+
+	if err := p.flushVotes(ctx); err != nil {
+		return nil, err
+	}
+
 	query := `SELECT user_id, vote FROM votes WHERE planning_item_id = $1`
 
-	rows, err := DB.Query(query, itemID)
+	rows, err := p.q.Query(ctx, query, itemID)
 	if err != nil {
 		return nil, err
 	}
@@ -322,12 +615,29 @@ func GetItemVotes(itemID string) (map[string]string, error) {
 		votes[userID] = vote
 	}
 
-	return votes, nil
+	return votes, rows.Err()
 }
 
 // DeleteItemVotes deletes all votes for a planning item
-func DeleteItemVotes(itemID string) error {
+func (p *Postgres) DeleteItemVotes(ctx context.Context, itemID string) (err error) {
+	defer observe("delete_item_votes", &err)()
+
+	// Votes still waiting for a batch would otherwise outlive the reset.
+	// Flushing them is no use inside a transaction, whose DELETE would
+	// not see them; they are dropped instead. A transaction drops them
+	// once it commits, so a reset that rolls back keeps them, and only
+	// those queued before it began: votes cast since were acknowledged
+	// during the reset and are kept.
+	if p.queue != nil {
+		if _, bound := p.q.(pgx.Tx); bound {
+			began := p.began
+			p.afterCommit = append(p.afterCommit, func() { p.queue.discard(itemID, began) })
+		} else {
+			p.queue.discard(itemID, time.Now())
+		}
+	}
+
 	query := `DELETE FROM votes WHERE planning_item_id = $1`
-	_, err := DB.Exec(query, itemID)
+	_, err = p.q.Exec(ctx, query, itemID)
 	return err
 }
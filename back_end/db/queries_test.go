@@ -0,0 +1,591 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"poker-planning-api/config"
+	"poker-planning-api/models"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// testDatabaseURL names the environment variable pointing the tests in this
+// package at a disposable PostgreSQL database; they are skipped without it
+const testDatabaseURL = "TEST_DATABASE_URL"
+
+// ctx is passed to every store call in these tests
+var ctx = context.Background()
+
+// queryCounter is a pgx tracer counting the queries sent to the database
+type queryCounter struct {
+	n atomic.Int64
+}
+
+func (c *queryCounter) TraceQueryStart(ctx context.Context, _ *pgx.Conn, _ pgx.TraceQueryStartData) context.Context {
+	c.n.Add(1)
+	return ctx
+}
+
+func (c *queryCounter) TraceQueryEnd(context.Context, *pgx.Conn, pgx.TraceQueryEndData) {}
+
+// openTestDB connects to the test database, applies the schema and counts
+// the queries sent through the returned store. A non-zero
+// voteFlushInterval batches votes.
+func openTestDB(tb testing.TB, voteFlushInterval time.Duration) (*Postgres, *queryCounter) {
+	tb.Helper()
+	url := os.Getenv(testDatabaseURL)
+	if url == "" {
+		tb.Skipf("%s is not set", testDatabaseURL)
+	}
+
+	poolCfg, err := pgxpool.ParseConfig(url)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	counter := &queryCounter{}
+	poolCfg.ConnConfig.Tracer = counter
+	p, err := open(poolCfg, config.DatabaseConfig{
+		MaxOpenConns:      20,
+		Timeouts:          config.QueryTimeouts{Write: 10 * time.Second},
+		VoteFlushInterval: voteFlushInterval,
+	})
+	if err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(func() { p.Close() })
+
+	schema, err := os.ReadFile("../database/schema.sql")
+	if err != nil {
+		tb.Fatal(err)
+	}
+	if _, err := p.pool.Exec(ctx, string(schema)); err != nil {
+		tb.Fatalf("applying schema: %v", err)
+	}
+	return p, counter
+}
+
+// seedSession stores a session with the given number of items, each voted
+// on by every one of its users, and returns it as loaded back
+func seedSession(tb testing.TB, p *Postgres, items, users int) *models.Session {
+	tb.Helper()
+	session := models.NewSession(uuid.NewString(), "Benchmark", uuid.NewString())
+	if err := p.CreateSession(ctx, session); err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(func() { p.DeleteSession(ctx, session.ID) })
+
+	userIDs := []string{session.HostID}
+	for i := 1; i < users; i++ {
+		userIDs = append(userIDs, uuid.NewString())
+	}
+	for i, userID := range userIDs {
+		user := &models.User{ID: userID, Name: fmt.Sprintf("User %d", i), IsHost: userID == session.HostID}
+		if err := p.CreateUser(ctx, user, session.ID); err != nil {
+			tb.Fatal(err)
+		}
+	}
+	for i := 0; i < items; i++ {
+		item := models.PlanningItem{ID: uuid.NewString(), Title: fmt.Sprintf("Story %d", i)}
+		if err := p.CreatePlanningItem(ctx, &item, session.ID); err != nil {
+			tb.Fatal(err)
+		}
+		for _, userID := range userIDs {
+			if err := p.SaveVote(ctx, item.ID, userID, "5"); err != nil {
+				tb.Fatal(err)
+			}
+		}
+	}
+
+	loaded, err := p.GetSession(ctx, session.ID)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return loaded
+}
+
+func TestGetSessionQueryCountIsConstant(t *testing.T) {
+	p, counter := openTestDB(t, 0)
+
+	for _, items := range []int{0, 1, 30} {
+		session := seedSession(t, p, items, 4)
+
+		counter.n.Store(0)
+		loaded, err := p.GetSession(ctx, session.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n := counter.n.Load(); n != 3 {
+			t.Errorf("loading a session with %d items took %d queries, want 3", items, n)
+		}
+		if len(loaded.Users) != 4 || len(loaded.Items) != items {
+			t.Fatalf("loaded %d users and %d items, want 4 and %d", len(loaded.Users), len(loaded.Items), items)
+		}
+		for i, item := range loaded.Items {
+			if item.Title != fmt.Sprintf("Story %d", i) || len(item.Votes) != 4 {
+				t.Errorf("item %d: %q with %d votes", i, item.Title, len(item.Votes))
+			}
+		}
+	}
+}
+
+func TestListSessionsQueryCountIsConstant(t *testing.T) {
+	p, counter := openTestDB(t, 0)
+
+	var hostIDs []string
+	for i := 0; i < 5; i++ {
+		hostIDs = append(hostIDs, seedSession(t, p, 3, 2).HostID)
+	}
+
+	counter.n.Store(0)
+	summaries, err := p.ListSessions(ctx, SessionFilter{UserIDs: hostIDs, Limit: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := counter.n.Load(); n != 1 {
+		t.Errorf("listing took %d queries, want 1", n)
+	}
+	if len(summaries) != 5 {
+		t.Fatalf("listed %d sessions, want 5", len(summaries))
+	}
+	for _, s := range summaries {
+		if s.UserCount != 2 || s.ItemCount != 3 || s.Role != models.RoleHost {
+			t.Errorf("summary %+v", s)
+		}
+	}
+}
+
+func TestAccountsLinkParticipants(t *testing.T) {
+	p, _ := openTestDB(t, 0)
+	account := &models.Account{ID: uuid.NewString(), DisplayName: "Hana", AvatarURL: "https://example.com/h.png", CreatedAt: time.Now()}
+	if err := p.CreateAccount(ctx, account); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { p.DeleteAccount(ctx, account.ID) })
+
+	session := seedSession(t, p, 0, 2)
+	user := &models.User{ID: uuid.NewString(), Name: "Hana", AccountID: account.ID}
+	if err := p.CreateUser(ctx, user, session.ID); err != nil {
+		t.Fatal(err)
+	}
+	again := &models.User{ID: uuid.NewString(), Name: "Hana again", AccountID: account.ID}
+	if err := p.CreateUser(ctx, again, session.ID); err == nil {
+		t.Error("an account joined the same session twice")
+	}
+
+	linked, err := p.GetSessionUserByAccount(ctx, session.ID, account.ID)
+	if err != nil || linked.ID != user.ID || linked.AvatarURL != account.AvatarURL {
+		t.Fatalf("participant %+v, %v", linked, err)
+	}
+	summaries, err := p.ListSessions(ctx, SessionFilter{AccountID: account.ID, Limit: 10})
+	if err != nil || len(summaries) != 1 || summaries[0].ID != session.ID || summaries[0].Role != models.RoleParticipant {
+		t.Fatalf("account listing %+v, %v", summaries, err)
+	}
+
+	if err := p.DeleteAccount(ctx, account.ID); err != nil {
+		t.Fatal(err)
+	}
+	guest, err := p.GetUserByID(ctx, user.ID)
+	if err != nil || guest.AccountID != "" || guest.AvatarURL != "" {
+		t.Errorf("participant after deleting the account %+v, %v", guest, err)
+	}
+}
+
+func TestTeamsGroupSessions(t *testing.T) {
+	p, _ := openTestDB(t, 0)
+	account := &models.Account{ID: uuid.NewString(), DisplayName: "Hana", Provider: "local", CreatedAt: time.Now()}
+	if err := p.CreateAccount(ctx, account); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { p.DeleteAccount(ctx, account.ID) })
+
+	team := &models.Team{
+		ID:        uuid.NewString(),
+		Name:      "Platform",
+		Settings:  models.SessionSettings{Deck: []string{"1", "2", "3"}, TimerSeconds: 60, AutoReveal: true},
+		CreatedAt: time.Now(),
+	}
+	if err := p.CreateTeam(ctx, team); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { p.DeleteTeam(ctx, team.ID) })
+	if err := p.SetTeamMember(ctx, team.ID, account.ID, models.TeamRoleOwner); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := p.GetTeam(ctx, team.ID)
+	if err != nil || len(loaded.Settings.Deck) != 3 || loaded.Settings.TimerSeconds != 60 || !loaded.Settings.AutoReveal {
+		t.Fatalf("team %+v, %v", loaded, err)
+	}
+	teams, err := p.ListAccountTeams(ctx, account.ID)
+	if err != nil || len(teams) != 1 || teams[0].Role != models.TeamRoleOwner {
+		t.Fatalf("account teams %+v, %v", teams, err)
+	}
+	members, err := p.GetTeamMembers(ctx, team.ID)
+	if err != nil || len(members) != 1 || members[0].DisplayName != "Hana" {
+		t.Fatalf("members %+v, %v", members, err)
+	}
+
+	// The team lists sessions its members have not joined
+	session := models.NewSession(uuid.NewString(), "Sprint 1", uuid.NewString())
+	session.TeamID = team.ID
+	session.Settings = team.Settings
+	if err := p.CreateSession(ctx, session); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { p.DeleteSession(ctx, session.ID) })
+	summaries, err := p.ListSessions(ctx, SessionFilter{TeamID: team.ID, AccountID: account.ID, Limit: 10})
+	if err != nil || len(summaries) != 1 || summaries[0].TeamID != team.ID || summaries[0].Role != "" {
+		t.Fatalf("team listing %+v, %v", summaries, err)
+	}
+
+	if err := p.DeleteTeam(ctx, team.ID); err != nil {
+		t.Fatal(err)
+	}
+	kept, err := p.GetSession(ctx, session.ID)
+	if err != nil || kept.TeamID != "" || len(kept.Settings.Deck) != 3 {
+		t.Errorf("session of a deleted team %+v, %v", kept, err)
+	}
+	if _, err := p.GetTeamRole(ctx, team.ID, account.ID); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("membership survived the team: %v", err)
+	}
+}
+
+func TestTemplatesKeepItems(t *testing.T) {
+	p, _ := openTestDB(t, 0)
+	account := &models.Account{ID: uuid.NewString(), DisplayName: "Ana", CreatedAt: time.Now()}
+	if err := p.CreateAccount(ctx, account); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { p.DeleteAccount(ctx, account.ID) })
+
+	template := &models.Template{
+		ID:        uuid.NewString(),
+		Name:      "Bugs triage",
+		AccountID: account.ID,
+		Settings:  models.SessionSettings{Deck: []string{"S", "M", "L"}, TimerSeconds: 30},
+		Items:     []models.TemplateItem{{Title: "New bugs"}, {Title: "Regressions", Description: "Since the last release"}},
+		CreatedAt: time.Now(),
+		UpdatedAt: time.Now(),
+	}
+	if err := p.CreateTemplate(ctx, template); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := p.GetTemplate(ctx, template.ID)
+	if err != nil || loaded.AccountID != account.ID || len(loaded.Settings.Deck) != 3 || len(loaded.Items) != 2 ||
+		loaded.Items[1].Description != "Since the last release" {
+		t.Fatalf("template %+v, %v", loaded, err)
+	}
+
+	loaded.Name = "Triage"
+	loaded.Items = nil
+	if err := p.UpdateTemplate(ctx, loaded); err != nil {
+		t.Fatal(err)
+	}
+	templates, err := p.ListTemplates(ctx, account.ID)
+	if err != nil || len(templates) != 1 || templates[0].Name != "Triage" || len(templates[0].Items) != 0 {
+		t.Fatalf("account templates %+v, %v", templates, err)
+	}
+
+	// Deleting the account deletes its templates
+	if err := p.DeleteAccount(ctx, account.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.GetTemplate(ctx, template.ID); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("template of a deleted account: %v", err)
+	}
+}
+
+func TestWithTxRollsBackOnError(t *testing.T) {
+	p, _ := openTestDB(t, 0)
+	session := models.NewSession(uuid.NewString(), "Sprint 1", uuid.NewString())
+	host := &models.User{ID: session.HostID, Name: "Hana", IsHost: true}
+	t.Cleanup(func() { p.DeleteSession(ctx, session.ID) })
+
+	err := p.WithTx(ctx, func(tx Store) error {
+		if err := tx.CreateSession(ctx, session); err != nil {
+			return err
+		}
+		if err := tx.CreateUser(ctx, host, session.ID); err != nil {
+			return err
+		}
+		return tx.CreateUser(ctx, host, session.ID)
+	})
+	if err == nil {
+		t.Fatal("expected the duplicate user to fail the transaction")
+	}
+	if _, err := p.GetSession(ctx, session.ID); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("session survived the rollback: %v", err)
+	}
+}
+
+func TestConcurrentItemsGetDistinctOrders(t *testing.T) {
+	p, _ := openTestDB(t, 0)
+	session := seedSession(t, p, 0, 1)
+
+	const adds = 20
+	var wg sync.WaitGroup
+	errs := make(chan error, adds)
+	for i := 0; i < adds; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			item := models.PlanningItem{ID: uuid.NewString(), Title: fmt.Sprintf("Story %d", i)}
+			errs <- p.CreatePlanningItem(ctx, &item, session.ID)
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	rows, err := p.pool.Query(ctx, `SELECT item_order FROM planning_items WHERE session_id = $1`, session.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	var orders []int
+	for rows.Next() {
+		var order int
+		if err := rows.Scan(&order); err != nil {
+			t.Fatal(err)
+		}
+		orders = append(orders, order)
+	}
+	sort.Ints(orders)
+	for i, order := range orders {
+		if order != i+1 {
+			t.Fatalf("item orders %v, want 1 to %d", orders, adds)
+		}
+	}
+
+	item := models.PlanningItem{ID: uuid.NewString(), Title: "Orphan"}
+	if err := p.CreatePlanningItem(ctx, &item, uuid.NewString()); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("adding to a missing session: %v, want sql.ErrNoRows", err)
+	}
+}
+
+func TestBatchedVotesAreReadBack(t *testing.T) {
+	p, _ := openTestDB(t, time.Hour)
+	session := seedSession(t, p, 1, 3)
+	itemID := session.Items[0].ID
+	var leaverID string
+	for id := range session.Users {
+		if id != session.HostID {
+			leaverID = id
+		}
+	}
+
+	if err := p.SaveVote(ctx, itemID, session.HostID, "3"); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.SaveVote(ctx, itemID, session.HostID, "8"); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.SaveVote(ctx, itemID, leaverID, "13"); err != nil {
+		t.Fatal(err)
+	}
+	// The hour-long interval keeps the votes queued, and a user who left
+	// while their vote waited must not fail the rest of the batch
+	if err := p.DeleteUser(ctx, leaverID); err != nil {
+		t.Fatal(err)
+	}
+
+	votes, err := p.GetItemVotes(ctx, itemID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if votes[session.HostID] != "8" {
+		t.Errorf("host vote = %q, want the last one, 8", votes[session.HostID])
+	}
+	if _, ok := votes[leaverID]; ok || len(votes) != 2 {
+		t.Errorf("votes %v, want the host's and the remaining user's", votes)
+	}
+
+	if err := p.DeleteItemVotes(ctx, itemID); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.SaveVote(ctx, itemID, session.HostID, "1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.WithTx(ctx, func(tx Store) error {
+		votes, err = tx.GetItemVotes(ctx, itemID)
+		return err
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if len(votes) != 1 || votes[session.HostID] != "1" {
+		t.Errorf("votes read in a transaction %v, want only the vote cast after the reset", votes)
+	}
+
+	// A reset inside a transaction keeps a vote queued in the middle of
+	// it, which was acknowledged, and deletes those cast before it
+	if err := p.WithTx(ctx, func(tx Store) error {
+		if err := p.SaveVote(ctx, itemID, session.HostID, "5"); err != nil {
+			return err
+		}
+		return tx.DeleteItemVotes(ctx, itemID)
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.flushVotes(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if votes, err := p.GetItemVotes(ctx, itemID); err != nil || len(votes) != 1 || votes[session.HostID] != "5" {
+		t.Errorf("votes after a reset in a transaction %v, %v; want only the host's 5 cast during it", votes, err)
+	}
+	// A reset that rolls back keeps the votes queued during it
+	rollback := errors.New("rollback")
+	if err := p.WithTx(ctx, func(tx Store) error {
+		if err := p.SaveVote(ctx, itemID, session.HostID, "2"); err != nil {
+			return err
+		}
+		if err := tx.DeleteItemVotes(ctx, itemID); err != nil {
+			return err
+		}
+		return rollback
+	}); !errors.Is(err, rollback) {
+		t.Fatalf("WithTx = %v, want %v", err, rollback)
+	}
+	if votes, err := p.GetItemVotes(ctx, itemID); err != nil || votes[session.HostID] != "2" {
+		t.Errorf("votes after a rolled-back reset %v, %v; want the host's 2", votes, err)
+	}
+}
+
+func TestDiscardKeepsVotesCastDuringTheReset(t *testing.T) {
+	b := newVoteBatcher(nil, time.Hour, 0)
+	began := time.Now()
+	b.pending = map[voteKey]queuedVote{
+		{itemID: "item", userID: "before"}:  {vote: "3", castAt: began.Add(-time.Millisecond)},
+		{itemID: "other", userID: "before"}: {vote: "3", castAt: began.Add(-time.Millisecond)},
+		// Queued in the middle of the reset, after it began
+		{itemID: "item", userID: "during"}: {vote: "5", castAt: began.Add(time.Millisecond)},
+	}
+	b.discard("item", began)
+
+	if _, ok := b.pending[voteKey{itemID: "item", userID: "before"}]; ok {
+		t.Error("a vote queued before the reset was kept")
+	}
+	if _, ok := b.pending[voteKey{itemID: "item", userID: "during"}]; !ok {
+		t.Error("a vote queued during the reset was dropped")
+	}
+	if _, ok := b.pending[voteKey{itemID: "other", userID: "before"}]; !ok {
+		t.Error("a vote on another item was dropped")
+	}
+}
+
+func BenchmarkGetSession(b *testing.B) {
+	p, counter := openTestDB(b, 0)
+
+	for _, items := range []int{1, 10, 30, 100} {
+		session := seedSession(b, p, items, 8)
+		b.Run(fmt.Sprintf("items=%d", items), func(b *testing.B) {
+			counter.n.Store(0)
+			for i := 0; i < b.N; i++ {
+				if _, err := p.GetSession(ctx, session.ID); err != nil {
+					b.Fatal(err)
+				}
+			}
+			b.ReportMetric(float64(counter.n.Load())/float64(b.N), "queries/op")
+		})
+	}
+}
+
+func BenchmarkListSessions(b *testing.B) {
+	p, counter := openTestDB(b, 0)
+
+	var hostIDs []string
+	for i := 0; i < 100; i++ {
+		hostIDs = append(hostIDs, seedSession(b, p, 30, 2).HostID)
+	}
+	filter := SessionFilter{UserIDs: hostIDs, Limit: 100}
+
+	b.ResetTimer()
+	counter.n.Store(0)
+	for i := 0; i < b.N; i++ {
+		if _, err := p.ListSessions(ctx, filter); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.ReportMetric(float64(counter.n.Load())/float64(b.N), "queries/op")
+}
+
+// BenchmarkSaveVote measures vote throughput with many voters at once,
+// writing each vote or batching them. The time includes writing the last
+// batch; ns/op is the cost per vote.
+func BenchmarkSaveVote(b *testing.B) {
+	for _, interval := range []time.Duration{0, 5 * time.Millisecond, 20 * time.Millisecond} {
+		name := "write-through"
+		if interval > 0 {
+			name = "batched=" + interval.String()
+		}
+		b.Run(name, func(b *testing.B) {
+			p, _ := openTestDB(b, interval)
+			session := seedSession(b, p, 20, 50)
+			var userIDs []string
+			for id := range session.Users {
+				userIDs = append(userIDs, id)
+			}
+
+			var next atomic.Int64
+			b.SetParallelism(8)
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					n := int(next.Add(1))
+					item := session.Items[n%len(session.Items)]
+					if err := p.SaveVote(ctx, item.ID, userIDs[n%len(userIDs)], "5"); err != nil {
+						b.Error(err)
+						return
+					}
+				}
+			})
+			if err := p.flushVotes(ctx); err != nil {
+				b.Fatal(err)
+			}
+			b.StopTimer()
+			b.ReportMetric(float64(b.N)/b.Elapsed().Seconds(), "votes/s")
+		})
+	}
+}
+
+func TestIsUnavailable(t *testing.T) {
+	pool, err := pgxpool.New(ctx, "postgres://user@127.0.0.1:1/db?connect_timeout=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	refused := pool.Ping(ctx)
+	pool.Close()
+	closed := pool.Ping(ctx)
+
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"connection refused", refused, true},
+		{"closed pool", closed, true},
+		{"wrapped", fmt.Errorf("flush: %w", closed), true},
+		{"too many connections", &pgconn.PgError{Code: "53300"}, true},
+		{"syntax error", &pgconn.PgError{Code: "42601"}, false},
+		{"no rows", sql.ErrNoRows, false},
+	}
+	for _, tt := range tests {
+		if got := IsUnavailable(tt.err); got != tt.want {
+			t.Errorf("%s: IsUnavailable(%v) = %v, want %v", tt.name, tt.err, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,172 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"poker-planning-api/models"
+	"time"
+)
+
+// SchemaVersion is the version of database/schema.sql this build expects.
+// Bump it together with the INSERT at the end of schema.sql.
+const SchemaVersion = 8
+
+// ErrUnavailable is returned when the store cannot be reached
+var ErrUnavailable = errors.New("database unavailable")
+
+// ErrJoinCodeTaken is returned by CreateSession when another active session
+// already uses the join code
+var ErrJoinCodeTaken = errors.New("join code already in use")
+
+// ErrLoginTaken is returned by CreateLogin when the provider already knows
+// the subject
+var ErrLoginTaken = errors.New("login already exists")
+
+// Store is the persistence layer used by the handlers. Postgres is the
+// production implementation; memstore provides a disposable in-memory one
+// for tests. Lookups of missing rows return sql.ErrNoRows.
+type Store interface {
+	// Ping checks that the store is reachable
+	Ping(ctx context.Context) error
+	// SchemaVersion returns the version recorded by schema.sql
+	SchemaVersion(ctx context.Context) (int, error)
+
+	// WithTx runs fn in a transaction: every call fn makes through tx
+	// takes effect if fn returns nil, and none does otherwise. Handlers
+	// use it for any operation that writes more than once or reads before
+	// writing.
+	WithTx(ctx context.Context, fn func(tx Store) error) error
+	// LockSession holds the session's row until the transaction ends,
+	// serializing transactions that read the session before writing to it.
+	// It returns sql.ErrNoRows if the session does not exist.
+	LockSession(ctx context.Context, sessionID string) error
+
+	CreateSession(ctx context.Context, session *models.Session) error
+	GetSession(ctx context.Context, sessionID string) (*models.Session, error)
+	ListSessions(ctx context.Context, filter SessionFilter) ([]models.SessionSummary, error)
+	UpdateSessionCurrentItem(ctx context.Context, sessionID, itemID string) error
+	DeleteSession(ctx context.Context, sessionID string) error
+	// GetSessionIDByJoinCode resolves the join code of a session that is
+	// not archived
+	GetSessionIDByJoinCode(ctx context.Context, code string) (string, error)
+	// ArchiveSession marks a session archived, releasing its join code
+	ArchiveSession(ctx context.Context, sessionID string) error
+	// DeleteSessionsCreatedBefore deletes old sessions with everything
+	// that belongs to them and returns their IDs
+	DeleteSessionsCreatedBefore(ctx context.Context, before time.Time) ([]string, error)
+
+	CreateAccount(ctx context.Context, account *models.Account) error
+	// GetAccount, UpdateAccount and DeleteAccount return sql.ErrNoRows if
+	// the account does not exist
+	GetAccount(ctx context.Context, accountID string) (*models.Account, error)
+	UpdateAccount(ctx context.Context, account *models.Account) error
+	// DeleteAccount unlinks the account's participants, who stay in their
+	// sessions as guests
+	DeleteAccount(ctx context.Context, accountID string) error
+
+	// CreateLogin links a provider's subject to an account. Deleting the
+	// account deletes its logins.
+	CreateLogin(ctx context.Context, login *models.Login) error
+	// GetLogin returns sql.ErrNoRows if the provider does not know the
+	// subject
+	GetLogin(ctx context.Context, provider, subject string) (*models.Login, error)
+
+	CreateTeam(ctx context.Context, team *models.Team) error
+	// GetTeam, UpdateTeam and DeleteTeam return sql.ErrNoRows if the team
+	// does not exist. GetTeam leaves Role and Members empty.
+	GetTeam(ctx context.Context, teamID string) (*models.Team, error)
+	UpdateTeam(ctx context.Context, team *models.Team) error
+	// DeleteTeam deletes the team and its memberships; its sessions stay,
+	// without a team
+	DeleteTeam(ctx context.Context, teamID string) error
+	// LockTeam holds the team's row until the transaction ends,
+	// serializing changes to its members. It returns sql.ErrNoRows if the
+	// team does not exist.
+	LockTeam(ctx context.Context, teamID string) error
+	// ListAccountTeams returns the account's teams by name, with Role set
+	ListAccountTeams(ctx context.Context, accountID string) ([]models.Team, error)
+	// SetTeamMember adds the account to the team or changes its role
+	SetTeamMember(ctx context.Context, teamID, accountID, role string) error
+	// GetTeamMembers returns the members with their profiles, owners first
+	GetTeamMembers(ctx context.Context, teamID string) ([]models.TeamMember, error)
+	// GetTeamRole and RemoveTeamMember return sql.ErrNoRows if the account
+	// is not a member
+	GetTeamRole(ctx context.Context, teamID, accountID string) (string, error)
+	RemoveTeamMember(ctx context.Context, teamID, accountID string) error
+
+	CreateTemplate(ctx context.Context, template *models.Template) error
+	// GetTemplate, UpdateTemplate and DeleteTemplate return sql.ErrNoRows
+	// if the template does not exist. UpdateTemplate keeps the owner.
+	GetTemplate(ctx context.Context, templateID string) (*models.Template, error)
+	UpdateTemplate(ctx context.Context, template *models.Template) error
+	DeleteTemplate(ctx context.Context, templateID string) error
+	// ListTemplates returns the templates of the account and of its teams,
+	// by name
+	ListTemplates(ctx context.Context, accountID string) ([]models.Template, error)
+
+	// CreateUser stores user.AccountID, linking the participant to an
+	// account; a session holds at most one participant per account
+	CreateUser(ctx context.Context, user *models.User, sessionID string) error
+	GetSessionUsers(ctx context.Context, sessionID string) ([]*models.User, error)
+	GetUserByID(ctx context.Context, userID string) (*models.User, error)
+	UpdateUserConnection(ctx context.Context, userID string, connected bool) error
+	// ClaimResumeToken stores the resume token hash of a user that has
+	// none, and reports whether it did; a user joined before resume
+	// tokens can be claimed only once
+	ClaimResumeToken(ctx context.Context, userID string, hash []byte) (bool, error)
+	DeleteUser(ctx context.Context, userID string) error
+	IsUserNameTaken(ctx context.Context, sessionID, userName, excludeUserID string) (bool, error)
+	// GetSessionUserByAccount returns the account's participant in a
+	// session, or sql.ErrNoRows if it has not joined
+	GetSessionUserByAccount(ctx context.Context, sessionID, accountID string) (*models.User, error)
+
+	// CreatePlanningItem appends an item after the session's last one,
+	// returning sql.ErrNoRows if the session does not exist
+	CreatePlanningItem(ctx context.Context, item *models.PlanningItem, sessionID string) error
+	GetSessionItems(ctx context.Context, sessionID string) ([]models.PlanningItem, error)
+	GetPlanningItemByID(ctx context.Context, itemID string) (*models.PlanningItem, error)
+	// GetItemSessionID returns the session an item belongs to, or
+	// sql.ErrNoRows if there is no such item. Unlike GetPlanningItemByID
+	// it reads no votes, so it does not flush queued ones.
+	GetItemSessionID(ctx context.Context, itemID string) (string, error)
+	UpdateItemRevealed(ctx context.Context, itemID string, revealed bool) error
+	UpdateItemFinalEstimate(ctx context.Context, itemID, estimate string) error
+
+	SaveVote(ctx context.Context, itemID, userID, vote string) error
+	GetItemVotes(ctx context.Context, itemID string) (map[string]string, error)
+	DeleteItemVotes(ctx context.Context, itemID string) error
+}
+
+// SessionFilter selects the sessions returned by ListSessions. Results are
+// ordered newest first, by created_at and then id, and leave
+// ConnectedCount to the caller.
+type SessionFilter struct {
+	// UserIDs are the caller's user IDs and AccountID the caller's
+	// account; only sessions in which one of the IDs, or a participant
+	// linked to the account, is the host or a participant match
+	UserIDs   []string
+	AccountID string
+	// TeamID lists every session of the team instead of the caller's;
+	// UserIDs and AccountID then only set the caller's role
+	TeamID string
+	// Query matches names containing it, ignoring case
+	Query string
+	// State is models.SessionStateActive, models.SessionStateArchived or
+	// empty for both
+	State string
+	// CreatedFrom (inclusive) and CreatedTo (exclusive) bound created_at
+	// when set
+	CreatedFrom time.Time
+	CreatedTo   time.Time
+	// After continues a listing past the session at this position
+	After *SessionCursor
+	Limit int
+}
+
+// SessionCursor is the position of a session in a listing
+type SessionCursor struct {
+	CreatedAt time.Time
+	ID        string
+}
+
+var _ Store = (*Postgres)(nil)
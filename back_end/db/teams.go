@@ -0,0 +1,188 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"poker-planning-api/models"
+	"time"
+)
+
+// CreateTeam creates a new team without members
+func (p *Postgres) CreateTeam(ctx context.Context, team *models.Team) (err error) {
+	defer observe("create_team", &err)()
+
+	query := `
+		INSERT INTO teams (id, name, deck, timer_seconds, auto_reveal, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $6)
+	`
+	_, err = p.q.Exec(ctx, query, team.ID, team.Name, deck(team.Settings),
+		team.Settings.TimerSeconds, team.Settings.AutoReveal, team.CreatedAt)
+	return err
+}
+
+// GetTeam retrieves a team by ID
+func (p *Postgres) GetTeam(ctx context.Context, teamID string) (_ *models.Team, err error) {
+	defer observe("get_team", &err)()
+
+	query := `SELECT id, name, deck, timer_seconds, auto_reveal, created_at FROM teams WHERE id = $1`
+	return scanTeam(p.q.QueryRow(ctx, query, teamID))
+}
+
+// UpdateTeam replaces a team's name and settings
+func (p *Postgres) UpdateTeam(ctx context.Context, team *models.Team) (err error) {
+	defer observe("update_team", &err)()
+
+	query := `
+		UPDATE teams SET name = $1, deck = $2, timer_seconds = $3, auto_reveal = $4, updated_at = $5
+		WHERE id = $6
+	`
+	tag, err := p.q.Exec(ctx, query, team.Name, deck(team.Settings), team.Settings.TimerSeconds,
+		team.Settings.AutoReveal, time.Now(), team.ID)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// DeleteTeam deletes a team and its memberships; its sessions stay
+// without a team (ON DELETE SET NULL)
+func (p *Postgres) DeleteTeam(ctx context.Context, teamID string) (err error) {
+	defer observe("delete_team", &err)()
+
+	tag, err := p.q.Exec(ctx, `DELETE FROM teams WHERE id = $1`, teamID)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// LockTeam locks the team's row until the transaction ends, so checks of
+// its members (that an owner remains) cannot interleave
+func (p *Postgres) LockTeam(ctx context.Context, teamID string) (err error) {
+	defer observe("lock_team", &err)()
+
+	var id string
+	return p.q.QueryRow(ctx, `SELECT id FROM teams WHERE id = $1 FOR UPDATE`, teamID).Scan(&id)
+}
+
+// ListAccountTeams retrieves the teams an account is a member of, by name
+func (p *Postgres) ListAccountTeams(ctx context.Context, accountID string) (_ []models.Team, err error) {
+	defer observe("list_account_teams", &err)()
+
+	query := `
+		SELECT t.id, t.name, t.deck, t.timer_seconds, t.auto_reveal, t.created_at, m.role
+		FROM teams t
+		JOIN team_members m ON m.team_id = t.id
+		WHERE m.account_id = $1
+		ORDER BY t.name, t.id
+	`
+	rows, err := p.q.Query(ctx, query, accountID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	teams := []models.Team{}
+	for rows.Next() {
+		var team models.Team
+		err := rows.Scan(&team.ID, &team.Name, &team.Settings.Deck, &team.Settings.TimerSeconds,
+			&team.Settings.AutoReveal, &team.CreatedAt, &team.Role)
+		if err != nil {
+			return nil, err
+		}
+		if len(team.Settings.Deck) == 0 {
+			team.Settings.Deck = nil
+		}
+		teams = append(teams, team)
+	}
+	return teams, rows.Err()
+}
+
+// SetTeamMember adds an account to a team or changes its role
+func (p *Postgres) SetTeamMember(ctx context.Context, teamID, accountID, role string) (err error) {
+	defer observe("set_team_member", &err)()
+
+	query := `
+		INSERT INTO team_members (team_id, account_id, role, created_at)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (team_id, account_id) DO UPDATE SET role = EXCLUDED.role
+	`
+	_, err = p.q.Exec(ctx, query, teamID, accountID, role, time.Now())
+	return err
+}
+
+// GetTeamMembers retrieves a team's members with their profiles, owners
+// first and then in the order they joined
+func (p *Postgres) GetTeamMembers(ctx context.Context, teamID string) (_ []models.TeamMember, err error) {
+	defer observe("get_team_members", &err)()
+
+	query := `
+		SELECT m.account_id, a.display_name, a.avatar_url, m.role, m.created_at
+		FROM team_members m
+		JOIN accounts a ON a.id = m.account_id
+		WHERE m.team_id = $1
+		ORDER BY m.role = 'owner' DESC, m.created_at, m.account_id
+	`
+	rows, err := p.q.Query(ctx, query, teamID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	members := []models.TeamMember{}
+	for rows.Next() {
+		var member models.TeamMember
+		var avatarURL sql.NullString
+		err := rows.Scan(&member.AccountID, &member.DisplayName, &avatarURL, &member.Role, &member.JoinedAt)
+		if err != nil {
+			return nil, err
+		}
+		member.AvatarURL = avatarURL.String
+		members = append(members, member)
+	}
+	return members, rows.Err()
+}
+
+// GetTeamRole retrieves an account's role in a team
+func (p *Postgres) GetTeamRole(ctx context.Context, teamID, accountID string) (_ string, err error) {
+	defer observe("get_team_role", &err)()
+
+	var role string
+	query := `SELECT role FROM team_members WHERE team_id = $1 AND account_id = $2`
+	err = p.q.QueryRow(ctx, query, teamID, accountID).Scan(&role)
+	return role, err
+}
+
+// RemoveTeamMember removes an account from a team
+func (p *Postgres) RemoveTeamMember(ctx context.Context, teamID, accountID string) (err error) {
+	defer observe("remove_team_member", &err)()
+
+	tag, err := p.q.Exec(ctx, `DELETE FROM team_members WHERE team_id = $1 AND account_id = $2`, teamID, accountID)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// scanTeam reads the team columns selected by GetTeam
+func scanTeam(row interface{ Scan(...interface{}) error }) (*models.Team, error) {
+	team := &models.Team{}
+	err := row.Scan(&team.ID, &team.Name, &team.Settings.Deck, &team.Settings.TimerSeconds,
+		&team.Settings.AutoReveal, &team.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	if len(team.Settings.Deck) == 0 {
+		team.Settings.Deck = nil
+	}
+	return team, nil
+}
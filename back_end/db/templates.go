@@ -0,0 +1,130 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"poker-planning-api/models"
+)
+
+// templateColumns are read by scanTemplate
+const templateColumns = `
+	SELECT id, name, account_id, team_id, deck, timer_seconds, auto_reveal, auth_required, items,
+		created_at, updated_at
+	FROM session_templates
+`
+
+// CreateTemplate creates a new template
+func (p *Postgres) CreateTemplate(ctx context.Context, template *models.Template) (err error) {
+	defer observe("create_template", &err)()
+
+	query := `
+		INSERT INTO session_templates (id, name, account_id, team_id, deck, timer_seconds, auto_reveal,
+			auth_required, items, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+	`
+	_, err = p.q.Exec(ctx, query, template.ID, template.Name, nullString(template.AccountID),
+		nullString(template.TeamID), deck(template.Settings), template.Settings.TimerSeconds,
+		template.Settings.AutoReveal, template.AuthRequired, templateItems(template),
+		template.CreatedAt, template.UpdatedAt)
+	return err
+}
+
+// GetTemplate retrieves a template by ID
+func (p *Postgres) GetTemplate(ctx context.Context, templateID string) (_ *models.Template, err error) {
+	defer observe("get_template", &err)()
+
+	return scanTemplate(p.q.QueryRow(ctx, templateColumns+` WHERE id = $1`, templateID))
+}
+
+// UpdateTemplate replaces a template's name, settings and items
+func (p *Postgres) UpdateTemplate(ctx context.Context, template *models.Template) (err error) {
+	defer observe("update_template", &err)()
+
+	query := `
+		UPDATE session_templates
+		SET name = $1, deck = $2, timer_seconds = $3, auto_reveal = $4, auth_required = $5, items = $6,
+			updated_at = $7
+		WHERE id = $8
+	`
+	tag, err := p.q.Exec(ctx, query, template.Name, deck(template.Settings), template.Settings.TimerSeconds,
+		template.Settings.AutoReveal, template.AuthRequired, templateItems(template), template.UpdatedAt,
+		template.ID)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// DeleteTemplate deletes a template; sessions created from it stay
+func (p *Postgres) DeleteTemplate(ctx context.Context, templateID string) (err error) {
+	defer observe("delete_template", &err)()
+
+	tag, err := p.q.Exec(ctx, `DELETE FROM session_templates WHERE id = $1`, templateID)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// ListTemplates retrieves the account's templates and its teams', by name
+func (p *Postgres) ListTemplates(ctx context.Context, accountID string) (_ []models.Template, err error) {
+	defer observe("list_templates", &err)()
+
+	query := templateColumns + `
+		WHERE account_id = $1
+			OR team_id IN (SELECT team_id FROM team_members WHERE account_id = $1)
+		ORDER BY name, id
+	`
+	rows, err := p.q.Query(ctx, query, accountID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	templates := []models.Template{}
+	for rows.Next() {
+		template, err := scanTemplate(rows)
+		if err != nil {
+			return nil, err
+		}
+		templates = append(templates, *template)
+	}
+	return templates, rows.Err()
+}
+
+// templateItems is the template's items as stored: an empty array rather
+// than null
+func templateItems(template *models.Template) []models.TemplateItem {
+	if template.Items == nil {
+		return []models.TemplateItem{}
+	}
+	return template.Items
+}
+
+// scanTemplate reads the columns selected by templateColumns
+func scanTemplate(row interface{ Scan(...interface{}) error }) (*models.Template, error) {
+	template := &models.Template{}
+	var accountID, teamID sql.NullString
+	err := row.Scan(&template.ID, &template.Name, &accountID, &teamID, &template.Settings.Deck,
+		&template.Settings.TimerSeconds, &template.Settings.AutoReveal, &template.AuthRequired,
+		&template.Items, &template.CreatedAt, &template.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	template.AccountID = accountID.String
+	template.TeamID = teamID.String
+	if len(template.Settings.Deck) == 0 {
+		template.Settings.Deck = nil
+	}
+	if template.Items == nil {
+		template.Items = []models.TemplateItem{}
+	}
+	return template, nil
+}
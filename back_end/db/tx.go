@@ -0,0 +1,72 @@
+package db
+
+import (
+	"context"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// querier is the part of *pgxpool.Pool and pgx.Tx the queries use
+type querier interface {
+	Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error)
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+}
+
+// WithTx runs fn in a transaction, committing it if fn returns nil and
+// rolling it back otherwise. fn must make its calls through tx. Calling
+// WithTx on a store that is already bound to a transaction runs fn in
+// that transaction.
+func (p *Postgres) WithTx(ctx context.Context, fn func(tx Store) error) error {
+	return p.inTx(ctx, func(tx *Postgres) error { return fn(tx) })
+}
+
+// inTx is WithTx for the queries in this package, which need the
+// transaction as a *Postgres
+func (p *Postgres) inTx(ctx context.Context, fn func(tx *Postgres) error) error {
+	if _, bound := p.q.(pgx.Tx); bound {
+		return fn(p)
+	}
+
+	// Votes saved before the transaction starts are written first, so it
+	// reads them; votes saved inside it are written through it. Votes the
+	// flush dropped are logged and gone either way, so only a database
+	// that cannot be reached fails the transaction.
+	began := time.Now()
+	if err := p.flushVotes(ctx); err != nil && IsUnavailable(err) {
+		return err
+	}
+
+	pgxTx, err := p.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	// Rolling back after a commit is a no-op; this also covers a panic in
+	// fn. A cancelled ctx closes the connection instead, which also rolls back
+	defer pgxTx.Rollback(ctx)
+
+	tx := &Postgres{pool: p.pool, q: pgxTx, queue: p.queue, began: began, connectTimeout: p.connectTimeout}
+	if err := fn(tx); err != nil {
+		return err
+	}
+	if err := pgxTx.Commit(ctx); err != nil {
+		return err
+	}
+	for _, hook := range tx.afterCommit {
+		hook()
+	}
+	return nil
+}
+
+// LockSession locks the session's row until the transaction ends, so
+// writes that read the session's rows before writing (the next item
+// order, whether a name is taken) cannot interleave. It returns
+// sql.ErrNoRows if the session does not exist.
+func (p *Postgres) LockSession(ctx context.Context, sessionID string) (err error) {
+	defer observe("lock_session", &err)()
+
+	var id string
+	return p.q.QueryRow(ctx, `SELECT id FROM sessions WHERE id = $1 FOR UPDATE`, sessionID).Scan(&id)
+}
@@ -0,0 +1,203 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"poker-planning-api/logging"
+	"poker-planning-api/metrics"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// maxVoteBatch queued votes are flushed without waiting for the interval,
+// by the voter whose vote fills the batch, so a burst is written in
+// bounded batches and slows down the voters rather than piling up
+const maxVoteBatch = 500
+
+// upsertQueuedVote writes a queued vote unless its item or user was
+// deleted while it waited, which would otherwise fail the whole batch
+const upsertQueuedVote = `
+	INSERT INTO votes (planning_item_id, user_id, vote, created_at)
+	SELECT $1::uuid, $2::uuid, $3::varchar, $4::timestamp
+	WHERE EXISTS (SELECT 1 FROM planning_items WHERE id = $1::uuid)
+		AND EXISTS (SELECT 1 FROM users WHERE id = $2::uuid)
+	ON CONFLICT (planning_item_id, user_id)
+	DO UPDATE SET vote = EXCLUDED.vote, created_at = EXCLUDED.created_at
+`
+
+// voteKey identifies a user's vote on an item
+type voteKey struct {
+	itemID, userID string
+}
+
+// queuedVote is a vote waiting to be written
+type queuedVote struct {
+	vote   string
+	castAt time.Time
+	// logger is the voter's, which carries the session, so a dropped vote
+	// is logged wherever the flush that dropped it ran
+	logger *slog.Logger
+}
+
+// voteBatcher coalesces vote upserts: SaveVote queues the vote and returns,
+// and the queue is written in one round trip once the flush interval has
+// passed since the first queued vote. A user changing their vote before
+// the flush replaces the queued one, so only the last is written.
+//
+// Durability: a saved vote is acknowledged before it is written. Queries
+// that read votes, and transactions, flush the queue first, so the server
+// always sees the votes it acknowledged. A crash loses at most the votes
+// of the last flush interval; Close flushes on a graceful shutdown. A vote
+// that cannot be written (its user left, or the database is down for
+// longer than the write timeout) is logged and counted in
+// poker_votes_dropped_total, and is not retried. A vote cast on an item
+// while a reset of its votes runs is kept and written after the reset,
+// though clients are sent the reset after the vote.
+type voteBatcher struct {
+	pool     *pgxpool.Pool
+	interval time.Duration
+	// timeout bounds each flush, whoever triggers it
+	timeout time.Duration
+
+	// flushMu serializes flushes, so batches are written in the order the
+	// votes were queued
+	flushMu sync.Mutex
+
+	mu      sync.Mutex
+	pending map[voteKey]queuedVote
+	// timer flushes the queue when the interval is up; nil while the
+	// queue is empty
+	timer *time.Timer
+}
+
+func newVoteBatcher(pool *pgxpool.Pool, interval, timeout time.Duration) *voteBatcher {
+	return &voteBatcher{
+		pool:     pool,
+		interval: interval,
+		timeout:  timeout,
+		pending:  make(map[voteKey]queuedVote),
+	}
+}
+
+// add queues a vote, flushing the queue when it is full
+func (b *voteBatcher) add(ctx context.Context, itemID, userID, vote string) error {
+	b.mu.Lock()
+	b.pending[voteKey{itemID: itemID, userID: userID}] = queuedVote{vote: vote, castAt: time.Now(), logger: logging.FromContext(ctx)}
+	full := len(b.pending) >= maxVoteBatch
+	if !full && b.timer == nil {
+		b.timer = time.AfterFunc(b.interval, func() { b.flush(context.Background()) })
+	}
+	b.mu.Unlock()
+
+	if full {
+		return b.flush(ctx)
+	}
+	return nil
+}
+
+// discard drops the votes on an item queued before the given time, the
+// start of the reset; later ones are kept. It waits for a flush in
+// progress, so that once it returns none of the dropped votes is written.
+// It must not be called inside a transaction, whose writes a flush may be
+// waiting for; DeleteItemVotes calls it after the commit.
+func (b *voteBatcher) discard(itemID string, before time.Time) {
+	b.flushMu.Lock()
+	defer b.flushMu.Unlock()
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for key, v := range b.pending {
+		if key.itemID == itemID && v.castAt.Before(before) {
+			delete(b.pending, key)
+		}
+	}
+}
+
+// flushVotes writes the queued votes, if votes are batched
+func (p *Postgres) flushVotes(ctx context.Context) error {
+	if p.votes == nil {
+		return nil
+	}
+	return p.votes.flush(ctx)
+}
+
+// flush writes the queued votes. The write is shared by every vote in the
+// batch, so it is bounded by the batcher's timeout rather than cancelled
+// with ctx. It returns an error if any vote was dropped.
+func (b *voteBatcher) flush(ctx context.Context) (err error) {
+	b.flushMu.Lock()
+	defer b.flushMu.Unlock()
+
+	b.mu.Lock()
+	votes := b.pending
+	b.pending = make(map[voteKey]queuedVote)
+	if b.timer != nil {
+		b.timer.Stop()
+		b.timer = nil
+	}
+	b.mu.Unlock()
+
+	if len(votes) == 0 {
+		return nil
+	}
+	defer observe("flush_votes", &err)()
+	metrics.VoteBatchSize.Observe(float64(len(votes)))
+
+	ctx = context.WithoutCancel(ctx)
+	if b.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, b.timeout)
+		defer cancel()
+	}
+
+	batch := &pgx.Batch{}
+	for key, v := range votes {
+		batch.Queue(upsertQueuedVote, key.itemID, key.userID, v.vote, v.castAt)
+	}
+	err = b.pool.SendBatch(ctx, batch).Close()
+	if err == nil {
+		return nil
+	}
+
+	// The batch runs as one implicit transaction, so a single rejected
+	// vote rolls back the others; write them one by one to lose only that
+	// one. Any other error means the database could not be reached.
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) {
+		for key, v := range votes {
+			drop(key, v, err)
+		}
+		return fmt.Errorf("dropped %d queued votes: %w", len(votes), err)
+	}
+	dropped := 0
+	for key, v := range votes {
+		if _, err := b.pool.Exec(ctx, upsertQueuedVote, key.itemID, key.userID, v.vote, v.castAt); err != nil {
+			dropped++
+			drop(key, v, err)
+		}
+	}
+	if dropped > 0 {
+		return fmt.Errorf("dropped %d of %d queued votes: %w", dropped, len(votes), err)
+	}
+	return nil
+}
+
+// drop logs and counts a vote that could not be written. Every flush
+// reports its losses here, including those run by the timer, which
+// nobody returns an error to.
+func drop(key voteKey, v queuedVote, err error) {
+	metrics.VotesDropped.Inc()
+	v.logger.Warn("queued vote dropped",
+		logging.KeyItemID, key.itemID, logging.KeyUserID, key.userID, logging.Err(err))
+}
+
+// close writes the queued votes before the pool is closed
+func (b *voteBatcher) close() error {
+	return b.flush(context.Background())
+}
@@ -0,0 +1,264 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"poker-planning-api/db"
+	"poker-planning-api/models"
+	"poker-planning-api/validate"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// AccountIDHeader carries the caller's account ID. Like a user ID for a
+// session, an account ID is the credential for the account: it is returned
+// only to whoever creates the account and never shown to other
+// participants.
+const AccountIDHeader = "X-Account-ID"
+
+// CreateAccount creates an account, whose ID identifies the caller from
+// then on
+func CreateAccount(w http.ResponseWriter, r *http.Request) {
+	var req models.AccountRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeInvalidBody(w, r, err)
+		return
+	}
+	if fieldErrors := validateAccount(&req); fieldErrors != nil {
+		writeValidationErrors(w, r, fieldErrors)
+		return
+	}
+
+	account := &models.Account{
+		ID:          uuid.New().String(),
+		DisplayName: req.DisplayName,
+		AvatarURL:   req.AvatarURL,
+		CreatedAt:   time.Now(),
+	}
+
+	ctx, cancel := withTimeout(r.Context(), timeouts.Write)
+	defer cancel()
+
+	if err := store.CreateAccount(ctx, account); err != nil {
+		writeDBError(w, r, err, "", "create account")
+		return
+	}
+	writeJSON(w, http.StatusOK, account)
+}
+
+// GetAccount returns the caller's account
+func GetAccount(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := withTimeout(r.Context(), timeouts.Read)
+	defer cancel()
+
+	account, ok := requireAccount(ctx, w, r)
+	if !ok {
+		return
+	}
+	writeJSON(w, http.StatusOK, account)
+}
+
+// UpdateAccount replaces the caller's display name and avatar. Names
+// already taken in sessions stay as they are; the avatar changes
+// everywhere, in sessions held in memory once they are reloaded.
+func UpdateAccount(w http.ResponseWriter, r *http.Request) {
+	var req models.AccountRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeInvalidBody(w, r, err)
+		return
+	}
+	if fieldErrors := validateAccount(&req); fieldErrors != nil {
+		writeValidationErrors(w, r, fieldErrors)
+		return
+	}
+
+	ctx, cancel := withTimeout(r.Context(), timeouts.Write)
+	defer cancel()
+
+	account, ok := requireAccount(ctx, w, r)
+	if !ok {
+		return
+	}
+	account.DisplayName = req.DisplayName
+	account.AvatarURL = req.AvatarURL
+	if err := store.UpdateAccount(ctx, account); err != nil {
+		writeDBError(w, r, err, models.CodeAccountNotFound, "update account")
+		return
+	}
+	writeJSON(w, http.StatusOK, account)
+}
+
+// DeleteAccount deletes the caller's account. Its participants stay in
+// their sessions as guests, and the sessions no longer appear in the
+// account's listing. Teams the account is the only member of are deleted
+// with it; the only owner of a team with other members must make one of
+// them an owner first.
+func DeleteAccount(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := withTimeout(r.Context(), timeouts.Write)
+	defer cancel()
+
+	account, ok := requireAccount(ctx, w, r)
+	if !ok {
+		return
+	}
+	err := store.WithTx(ctx, func(tx db.Store) error {
+		teams, err := tx.ListAccountTeams(ctx, account.ID)
+		if err != nil {
+			return err
+		}
+		for _, team := range teams {
+			if team.Role != models.TeamRoleOwner {
+				continue
+			}
+			if err := tx.LockTeam(ctx, team.ID); err != nil {
+				return err
+			}
+			err := keepTeamOwner(ctx, tx, team.ID, account.ID)
+			if errors.Is(err, errLastTeamOwner) {
+				err = deleteSoloTeam(ctx, tx, team.ID)
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return tx.DeleteAccount(ctx, account.ID)
+	})
+	if errors.Is(err, errLastTeamOwner) {
+		writeLastTeamOwner(w, r)
+		return
+	}
+	if err != nil {
+		writeDBError(w, r, err, models.CodeAccountNotFound, "delete account")
+		return
+	}
+	writeJSON(w, http.StatusOK, models.StatusResponse{Status: "deleted"})
+}
+
+// deleteSoloTeam deletes a team whose only owner is leaving, if nobody
+// else is in it, and fails with errLastTeamOwner otherwise. The team must
+// be locked.
+func deleteSoloTeam(ctx context.Context, tx db.Store, teamID string) error {
+	members, err := tx.GetTeamMembers(ctx, teamID)
+	if err != nil {
+		return err
+	}
+	if len(members) > 1 {
+		return errLastTeamOwner
+	}
+	return tx.DeleteTeam(ctx, teamID)
+}
+
+// validateAccount cleans and checks an account's profile
+func validateAccount(req *models.AccountRequest) []models.FieldError {
+	var v validate.Validator
+	v.Text("displayName", "Display name", &req.DisplayName, validate.Name)
+	v.URL("avatarUrl", "Avatar URL", &req.AvatarURL, validate.AvatarURL)
+	return v.Errors()
+}
+
+// callerAccountID returns the account ID sent in AccountIDHeader, or ""
+// without one. It answers the request and returns false when the ID is
+// malformed.
+func callerAccountID(w http.ResponseWriter, r *http.Request) (string, bool) {
+	accountID := r.Header.Get(AccountIDHeader)
+	if accountID == "" {
+		return "", true
+	}
+
+	var v validate.Validator
+	v.ID("accountId", "Account ID", accountID)
+	if fieldErrors := v.Errors(); fieldErrors != nil {
+		writeValidationErrors(w, r, fieldErrors)
+		return "", false
+	}
+	return accountID, true
+}
+
+// callerAccount loads the caller's account: the one signed in to with the
+// token from callerToken or else the one named in AccountIDHeader, which
+// must not belong to a sign-in provider. It returns nil without either,
+// and answers the request and returns false when the account cannot be
+// loaded.
+func callerAccount(ctx context.Context, w http.ResponseWriter, r *http.Request) (*models.Account, bool) {
+	if token, ok := callerToken(r); ok {
+		return tokenAccount(ctx, w, r, token)
+	}
+
+	accountID, ok := callerAccountID(w, r)
+	if !ok || accountID == "" {
+		return nil, ok
+	}
+
+	account, err := store.GetAccount(ctx, accountID)
+	if err != nil {
+		writeDBError(w, r, err, models.CodeAccountNotFound, "load account")
+		return nil, false
+	}
+	if account.Provider != "" {
+		writeSignInRequired(w, r)
+		return nil, false
+	}
+	return account, true
+}
+
+// tokenAccount loads the account a sign-in token identifies
+func tokenAccount(ctx context.Context, w http.ResponseWriter, r *http.Request, token string) (*models.Account, bool) {
+	if tokens == nil {
+		writeProblem(w, r, http.StatusUnauthorized, models.CodeUnauthorized, "Sign-in is not enabled")
+		return nil, false
+	}
+	claims, err := tokens.Verify(token)
+	if err != nil {
+		writeProblem(w, r, http.StatusUnauthorized, models.CodeUnauthorized, "The sign-in token is invalid or has expired; sign in again")
+		return nil, false
+	}
+
+	account, err := store.GetAccount(ctx, claims.AccountID)
+	if errors.Is(err, sql.ErrNoRows) {
+		writeProblem(w, r, http.StatusUnauthorized, models.CodeUnauthorized, "The signed-in account has been deleted")
+		return nil, false
+	}
+	if err != nil {
+		writeDBError(w, r, err, models.CodeAccountNotFound, "load account")
+		return nil, false
+	}
+	return account, true
+}
+
+// sendsAccount reports whether the request names an account, for checks
+// made before callerAccount loads it
+func sendsAccount(r *http.Request) bool {
+	_, ok := callerToken(r)
+	return ok || r.Header.Get(AccountIDHeader) != ""
+}
+
+// requireAccount is callerAccount for requests that need an account
+func requireAccount(ctx context.Context, w http.ResponseWriter, r *http.Request) (*models.Account, bool) {
+	account, ok := callerAccount(ctx, w, r)
+	if ok && account == nil {
+		writeAccountRequired(w, r)
+		return nil, false
+	}
+	return account, ok
+}
+
+func writeAccountRequired(w http.ResponseWriter, r *http.Request) {
+	writeProblem(w, r, http.StatusUnauthorized, models.CodeUnauthorized, "Sign in, or send an account ID in "+AccountIDHeader)
+}
+
+func writeSignInRequired(w http.ResponseWriter, r *http.Request) {
+	writeProblem(w, r, http.StatusUnauthorized, models.CodeSignInRequired, "This account is used by signing in, not by its ID")
+}
+
+// linkAccount makes a joining user the account's participant, when the
+// user joins with an account
+func linkAccount(user *models.User, account *models.Account) {
+	if account != nil {
+		user.AccountID = account.ID
+		user.AvatarURL = account.AvatarURL
+	}
+}
@@ -0,0 +1,238 @@
+package handlers
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"poker-planning-api/logging"
+	"poker-planning-api/metrics"
+	"poker-planning-api/models"
+	"poker-planning-api/protocol"
+	"poker-planning-api/validate"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/gorilla/websocket"
+)
+
+// adminToken is set by SetAdminToken; empty disables the admin API
+var adminToken string
+
+// SetAdminToken sets the bearer token required by the /admin API. An empty
+// token disables the API.
+func SetAdminToken(token string) {
+	adminToken = token
+}
+
+// minPurgeAge keeps a purge from deleting sessions that are still in use
+const minPurgeAge = time.Hour
+
+// closeGracePeriod is how long a client may take to answer a close frame
+// sent by an admin action before its connection is dropped
+const closeGracePeriod = 5 * time.Second
+
+// CloseReasonSessionClosed is the close frame reason sent with
+// protocol.CloseSessionClosed
+const CloseReasonSessionClosed = "session closed by an operator"
+
+// RequireAdmin guards the /admin API with the admin token, sent as
+// "Authorization: Bearer <token>". While no token is set the API answers
+// 404, as if it did not exist.
+func RequireAdmin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if adminToken == "" {
+			http.NotFound(w, r)
+			return
+		}
+
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(adminToken)) != 1 {
+			logging.FromContext(r.Context()).Warn("admin request rejected", "client_ip", clientIP(r))
+			w.Header().Set("WWW-Authenticate", `Bearer realm="admin"`)
+			writeProblem(w, r, http.StatusUnauthorized, models.CodeUnauthorized, "A valid admin token is required")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// AdminListSessions describes the sessions held in memory, most recently
+// active first
+func AdminListSessions(w http.ResponseWriter, r *http.Request) {
+	resp := models.AdminSessionsResponse{Sessions: []models.AdminSession{}}
+	for _, session := range cachedSessions() {
+		session.Mutex.RLock()
+		info := models.AdminSession{
+			ID:         session.ID,
+			Name:       session.Name,
+			JoinCode:   session.JoinCode,
+			Users:      len(session.Users),
+			Items:      len(session.Items),
+			CreatedAt:  session.CreatedAt,
+			ArchivedAt: session.ArchivedAt,
+		}
+		session.Mutex.RUnlock()
+		info.Connections = len(session.ConnectedUsers())
+		info.LastActivity = session.LastActivity()
+		info.MemoryBytes = session.ApproxSize()
+
+		resp.Sessions = append(resp.Sessions, info)
+		resp.Connections += info.Connections
+		resp.MemoryBytes += info.MemoryBytes
+	}
+	sort.Slice(resp.Sessions, func(i, j int) bool {
+		return resp.Sessions[i].LastActivity.After(resp.Sessions[j].LastActivity)
+	})
+
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// AdminCloseSession archives a session and disconnects everyone in it.
+// Clients are told not to reconnect, and new joins are refused.
+func AdminCloseSession(w http.ResponseWriter, r *http.Request) {
+	sessionID := mux.Vars(r)["sessionId"]
+	if !validate.IsID(sessionID) {
+		writeProblem(w, r, http.StatusNotFound, models.CodeSessionNotFound, "")
+		return
+	}
+	ctx, cancel := withTimeout(r.Context(), timeouts.Write)
+	defer cancel()
+
+	if err := store.ArchiveSession(ctx, sessionID); err != nil {
+		writeDBError(w, r, err, models.CodeSessionNotFound, "archive session")
+		return
+	}
+	connections := evictSession(sessionID, protocol.CloseSessionClosed, CloseReasonSessionClosed)
+
+	logging.FromContext(r.Context()).Info("admin closed session",
+		logging.KeySessionID, sessionID, "connections", connections)
+	writeJSON(w, http.StatusOK, models.AdminResult{Sessions: 1, Connections: connections})
+}
+
+// AdminEvictSession drops a session from memory. Its clients are asked to
+// reconnect, which reloads the session from the database.
+func AdminEvictSession(w http.ResponseWriter, r *http.Request) {
+	sessionID := mux.Vars(r)["sessionId"]
+
+	if _, cached := cachedSession(sessionID); !cached {
+		writeProblem(w, r, http.StatusNotFound, models.CodeSessionNotFound, "The session is not in memory")
+		return
+	}
+	connections := evictSession(sessionID, websocket.CloseServiceRestart, CloseReasonRestart)
+
+	logging.FromContext(r.Context()).Info("admin evicted session",
+		logging.KeySessionID, sessionID, "connections", connections)
+	writeJSON(w, http.StatusOK, models.AdminResult{Sessions: 1, Connections: connections})
+}
+
+// AdminBroadcastNotice sends a notice to every connected client, e.g.
+// before maintenance
+func AdminBroadcastNotice(w http.ResponseWriter, r *http.Request) {
+	var req models.NoticeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeInvalidBody(w, r, err)
+		return
+	}
+
+	var v validate.Validator
+	v.Text("message", "Message", &req.Message, validate.Notice)
+	if fieldErrors := v.Errors(); fieldErrors != nil {
+		writeValidationErrors(w, r, fieldErrors)
+		return
+	}
+
+	var result models.AdminResult
+	notice := models.WSMessage{Type: protocol.TypeNotice, Payload: protocol.NoticePayload{Message: req.Message}}
+	for _, session := range cachedSessions() {
+		users := session.ConnectedUsers()
+		if len(users) == 0 {
+			continue
+		}
+		result.Sessions++
+		result.Connections += len(users)
+		BroadcastToSession(session.ID, notice)
+	}
+
+	logging.FromContext(r.Context()).Info("admin broadcast notice",
+		"sessions", result.Sessions, "connections", result.Connections)
+	writeJSON(w, http.StatusOK, result)
+}
+
+// AdminPurgeSessions deletes sessions created longer ago than the given
+// age, disconnecting anyone still in them
+func AdminPurgeSessions(w http.ResponseWriter, r *http.Request) {
+	var req models.PurgeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeInvalidBody(w, r, err)
+		return
+	}
+
+	var v validate.Validator
+	age, err := time.ParseDuration(req.OlderThan)
+	switch {
+	case req.OlderThan == "":
+		v.Add("olderThan", models.FieldRequired, "Age is required")
+	case err != nil:
+		v.Add("olderThan", models.FieldInvalidFormat, `Age must be a duration such as "720h"`)
+	case age < minPurgeAge:
+		v.Add("olderThan", models.FieldOutOfRange, fmt.Sprintf("Age must be at least %s", minPurgeAge))
+	}
+	if fieldErrors := v.Errors(); fieldErrors != nil {
+		writeValidationErrors(w, r, fieldErrors)
+		return
+	}
+
+	ctx, cancel := withTimeout(r.Context(), timeouts.Maintenance)
+	defer cancel()
+	sessionIDs, err := store.DeleteSessionsCreatedBefore(ctx, time.Now().Add(-age))
+	if err != nil {
+		writeDBError(w, r, err, "", "purge sessions")
+		return
+	}
+
+	result := models.AdminResult{Sessions: len(sessionIDs)}
+	for _, sessionID := range sessionIDs {
+		result.Connections += evictSession(sessionID, protocol.CloseSessionClosed, CloseReasonSessionClosed)
+	}
+
+	logging.FromContext(r.Context()).Info("admin purged sessions",
+		"older_than", age.String(), "sessions", result.Sessions, "connections", result.Connections)
+	writeJSON(w, http.StatusOK, result)
+}
+
+// cachedSessions returns a snapshot of the sessions held in memory
+func cachedSessions() []*models.Session {
+	sessionsMutex.RLock()
+	defer sessionsMutex.RUnlock()
+
+	sessions := make([]*models.Session, 0, len(activeSessions))
+	for _, session := range activeSessions {
+		sessions = append(sessions, session)
+	}
+	return sessions
+}
+
+// evictSession drops a session from memory and closes its connections with
+// the given close frame, returning how many there were. Clients that do not
+// answer within closeGracePeriod are cut off.
+func evictSession(sessionID string, code int, reason string) int {
+	sessionsMutex.Lock()
+	session, cached := activeSessions[sessionID]
+	delete(activeSessions, sessionID)
+	metrics.ActiveSessions.Set(float64(len(activeSessions)))
+	sessionsMutex.Unlock()
+
+	if !cached {
+		return 0
+	}
+	users := session.ConnectedUsers()
+	for _, user := range users {
+		conn := user.Conn
+		sendClose(conn, code, reason)
+		time.AfterFunc(closeGracePeriod, func() { conn.Close() })
+	}
+	return len(users)
+}
@@ -0,0 +1,407 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"poker-planning-api/auth"
+	"poker-planning-api/db"
+	"poker-planning-api/logging"
+	"poker-planning-api/models"
+	"poker-planning-api/validate"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+// AuthCookie carries the sign-in token of a browser. Other clients send
+// the token as "Authorization: Bearer <token>".
+const AuthCookie = "poker_auth"
+
+// loginStateCookie carries a redirect sign-in from its start to the
+// callback
+const loginStateCookie = "poker_login"
+
+// loginStateTTL bounds how long a redirect sign-in may take
+const loginStateTTL = 10 * time.Minute
+
+// defaultDisplayName names an account whose provider knows no usable name
+const defaultDisplayName = "Participant"
+
+var (
+	// authProviders are the enabled sign-in providers by name, set by
+	// SetAuth
+	authProviders = map[string]auth.Provider{}
+	// tokens issues and verifies sign-in tokens; nil disables sign-in
+	tokens *auth.Tokens
+	// secureCookies restricts the cookies set on sign-in to HTTPS
+	secureCookies bool
+)
+
+// SetAuth enables sign-in with providers, issuing tokens with t. A nil t
+// disables sign-in, and every sign-in token is then refused.
+func SetAuth(providers []auth.Provider, t *auth.Tokens, secure bool) {
+	authProviders = make(map[string]auth.Provider, len(providers))
+	for _, provider := range providers {
+		authProviders[provider.Name()] = provider
+	}
+	tokens = t
+	secureCookies = secure
+}
+
+// Register creates an account that signs in with an email address and
+// password, and signs it in
+func Register(w http.ResponseWriter, r *http.Request) {
+	if _, enabled := authProviders[auth.LocalProvider]; !enabled || tokens == nil {
+		writeProviderNotFound(w, r, auth.LocalProvider)
+		return
+	}
+
+	var req models.RegisterRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeInvalidBody(w, r, err)
+		return
+	}
+	var v validate.Validator
+	v.Email("email", "Email", &req.Email)
+	v.Password("password", "Password", req.Password)
+	v.Text("displayName", "Display name", &req.DisplayName, validate.Name)
+	v.URL("avatarUrl", "Avatar URL", &req.AvatarURL, validate.AvatarURL)
+	if fieldErrors := v.Errors(); fieldErrors != nil {
+		writeValidationErrors(w, r, fieldErrors)
+		return
+	}
+
+	hash, err := auth.HashPassword(req.Password)
+	if err != nil {
+		logging.FromContext(r.Context()).Error("failed to hash password", logging.Err(err))
+		writeProblem(w, r, http.StatusInternalServerError, models.CodeInternal, "Failed to register")
+		return
+	}
+
+	ctx, cancel := withTimeout(r.Context(), timeouts.Write)
+	defer cancel()
+
+	account := newAccount(auth.LocalProvider, models.AccountRequest{DisplayName: req.DisplayName, AvatarURL: req.AvatarURL})
+	err = createLogin(ctx, account, &models.Login{
+		Provider:     auth.LocalProvider,
+		Subject:      req.Email,
+		PasswordHash: hash,
+	})
+	if errors.Is(err, db.ErrLoginTaken) {
+		writeProblem(w, r, http.StatusConflict, models.CodeEmailTaken, "An account already signs in with this email address")
+		return
+	}
+	if err != nil {
+		writeDBError(w, r, err, "", "register")
+		return
+	}
+	signIn(w, r, account, auth.LocalProvider)
+}
+
+// Login signs in with a password provider's credentials
+func Login(w http.ResponseWriter, r *http.Request) {
+	provider, ok := authProviders[mux.Vars(r)["provider"]].(auth.PasswordProvider)
+	if !ok || tokens == nil {
+		writeProviderNotFound(w, r, mux.Vars(r)["provider"])
+		return
+	}
+
+	var req models.LoginRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeInvalidBody(w, r, err)
+		return
+	}
+	var v validate.Validator
+	v.Email("email", "Email", &req.Email)
+	if req.Password == "" {
+		v.Add("password", models.FieldRequired, "Password is required")
+	}
+	if fieldErrors := v.Errors(); fieldErrors != nil {
+		writeValidationErrors(w, r, fieldErrors)
+		return
+	}
+
+	ctx, cancel := withTimeout(r.Context(), timeouts.Write)
+	defer cancel()
+
+	identity, err := provider.Authenticate(ctx, req.Email, req.Password)
+	if errors.Is(err, auth.ErrInvalidCredentials) {
+		logging.FromContext(r.Context()).Info("sign-in refused", "provider", provider.Name(), "client_ip", clientIP(r))
+		writeProblem(w, r, http.StatusUnauthorized, models.CodeInvalidCredentials, "Wrong email address or password")
+		return
+	}
+	if err != nil {
+		writeDBError(w, r, err, "", "sign in")
+		return
+	}
+	account, err := accountFor(ctx, identity)
+	if err != nil {
+		writeDBError(w, r, err, "", "sign in")
+		return
+	}
+	signIn(w, r, account, provider.Name())
+}
+
+// StartLogin sends the browser to a redirect provider to sign in. The
+// optional returnTo query parameter, a URL on an allowed origin, is where
+// the callback sends the browser afterwards.
+func StartLogin(w http.ResponseWriter, r *http.Request) {
+	provider, ok := authProviders[mux.Vars(r)["provider"]].(auth.RedirectProvider)
+	if !ok || tokens == nil {
+		writeProviderNotFound(w, r, mux.Vars(r)["provider"])
+		return
+	}
+
+	returnTo := r.URL.Query().Get("returnTo")
+	if returnTo != "" && !allowedReturnTo(returnTo) {
+		var v validate.Validator
+		v.Add("returnTo", models.FieldInvalidFormat, "returnTo must be a URL on an allowed origin")
+		writeValidationErrors(w, r, v.Errors())
+		return
+	}
+
+	state, err := auth.RandomString(32)
+	var nonce string
+	if err == nil {
+		nonce, err = auth.RandomString(32)
+	}
+	var sealed string
+	if err == nil {
+		sealed, err = tokens.SealLoginState(auth.LoginState{
+			Provider: provider.Name(),
+			State:    state,
+			Nonce:    nonce,
+			ReturnTo: returnTo,
+		}, loginStateTTL)
+	}
+	if err != nil {
+		logging.FromContext(r.Context()).Error("failed to start sign-in", logging.Err(err))
+		writeProblem(w, r, http.StatusInternalServerError, models.CodeInternal, "Failed to start sign-in")
+		return
+	}
+
+	authURL, err := provider.AuthURL(r.Context(), state, nonce)
+	if err != nil {
+		writeProviderUnavailable(w, r, provider.Name(), err)
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     loginStateCookie,
+		Value:    sealed,
+		Path:     "/api/auth/",
+		MaxAge:   int(loginStateTTL.Seconds()),
+		HttpOnly: true,
+		Secure:   secureCookies,
+		// Lax still sends the cookie on the provider's top-level redirect
+		// back to the callback
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+// LoginCallback completes a redirect sign-in. It redirects to the returnTo
+// given to StartLogin, or answers like Login without one.
+func LoginCallback(w http.ResponseWriter, r *http.Request) {
+	provider, ok := authProviders[mux.Vars(r)["provider"]].(auth.RedirectProvider)
+	if !ok || tokens == nil {
+		writeProviderNotFound(w, r, mux.Vars(r)["provider"])
+		return
+	}
+
+	// The state is single-use whatever the outcome
+	http.SetCookie(w, &http.Cookie{Name: loginStateCookie, Path: "/api/auth/", MaxAge: -1, HttpOnly: true, Secure: secureCookies})
+
+	query := r.URL.Query()
+	var state *auth.LoginState
+	if cookie, err := r.Cookie(loginStateCookie); err == nil {
+		state, _ = tokens.OpenLoginState(cookie.Value)
+	}
+	if state == nil || state.Provider != provider.Name() || query.Get("state") != state.State {
+		writeProblem(w, r, http.StatusUnauthorized, models.CodeSignInFailed, "The sign-in was not started from this browser, or took too long")
+		return
+	}
+	if reason := query.Get("error"); reason != "" {
+		writeProblem(w, r, http.StatusUnauthorized, models.CodeSignInFailed, "The identity provider refused the sign-in: "+reason)
+		return
+	}
+
+	ctx, cancel := withTimeout(r.Context(), timeouts.Write)
+	defer cancel()
+
+	identity, err := provider.Exchange(ctx, query.Get("code"), state.Nonce)
+	if errors.Is(err, auth.ErrInvalidCredentials) {
+		logging.FromContext(r.Context()).Warn("sign-in refused", "provider", provider.Name(), logging.Err(err))
+		writeProblem(w, r, http.StatusUnauthorized, models.CodeSignInFailed, "The identity provider did not confirm the sign-in")
+		return
+	}
+	if err != nil {
+		writeProviderUnavailable(w, r, provider.Name(), err)
+		return
+	}
+	account, err := accountFor(ctx, identity)
+	if err != nil {
+		writeDBError(w, r, err, "", "sign in")
+		return
+	}
+
+	if state.ReturnTo == "" {
+		signIn(w, r, account, provider.Name())
+		return
+	}
+	if _, ok := issueToken(w, r, account, provider.Name()); ok {
+		http.Redirect(w, r, state.ReturnTo, http.StatusFound)
+	}
+}
+
+// Logout clears the sign-in cookie. Tokens are not stored, so a copy of
+// the token stays valid until it expires.
+func Logout(w http.ResponseWriter, r *http.Request) {
+	http.SetCookie(w, &http.Cookie{Name: AuthCookie, Path: "/", MaxAge: -1, HttpOnly: true, Secure: secureCookies})
+	writeJSON(w, http.StatusOK, models.StatusResponse{Status: "signed_out"})
+}
+
+// callerToken returns the sign-in token sent as a bearer token or, by
+// browsers, in AuthCookie
+func callerToken(r *http.Request) (string, bool) {
+	if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		return token, true
+	}
+	if cookie, err := r.Cookie(AuthCookie); err == nil && cookie.Value != "" {
+		return cookie.Value, true
+	}
+	return "", false
+}
+
+// signedIn reports whether the caller acts as an account it signed in to
+// with a provider. Such accounts cannot be used through AccountIDHeader,
+// so holding one proves a sign-in.
+func signedIn(account *models.Account) bool {
+	return account != nil && account.Provider != ""
+}
+
+// signIn issues a token for the account and answers with it
+func signIn(w http.ResponseWriter, r *http.Request, account *models.Account, provider string) {
+	resp, ok := issueToken(w, r, account, provider)
+	if ok {
+		writeJSON(w, http.StatusOK, resp)
+	}
+}
+
+// issueToken issues a token for the account and sets it in AuthCookie. It
+// answers the request and returns false if the token cannot be issued.
+func issueToken(w http.ResponseWriter, r *http.Request, account *models.Account, provider string) (*models.AuthResponse, bool) {
+	token, expiresAt, err := tokens.Issue(account.ID, provider)
+	if err != nil {
+		logging.FromContext(r.Context()).Error("failed to issue token", logging.Err(err))
+		writeProblem(w, r, http.StatusInternalServerError, models.CodeInternal, "Failed to sign in")
+		return nil, false
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     AuthCookie,
+		Value:    token,
+		Path:     "/",
+		Expires:  expiresAt,
+		HttpOnly: true,
+		Secure:   secureCookies,
+		SameSite: http.SameSiteLaxMode,
+	})
+	logging.FromContext(r.Context()).Info("signed in", "provider", provider, "account_id", account.ID)
+	return &models.AuthResponse{Token: token, ExpiresAt: expiresAt, Account: *account}, true
+}
+
+// accountFor returns the account an identity signs in to, creating it
+// from the identity's profile on its first sign-in
+func accountFor(ctx context.Context, identity *auth.Identity) (*models.Account, error) {
+	login, err := store.GetLogin(ctx, identity.Provider, identity.Subject)
+	if err == nil {
+		return store.GetAccount(ctx, login.AccountID)
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+
+	account := newAccount(identity.Provider, identityProfile(identity))
+	err = createLogin(ctx, account, &models.Login{Provider: identity.Provider, Subject: identity.Subject})
+	if errors.Is(err, db.ErrLoginTaken) {
+		// A concurrent first sign-in created the account
+		if login, err = store.GetLogin(ctx, identity.Provider, identity.Subject); err != nil {
+			return nil, err
+		}
+		return store.GetAccount(ctx, login.AccountID)
+	}
+	return account, err
+}
+
+// newAccount builds an account belonging to provider
+func newAccount(provider string, profile models.AccountRequest) *models.Account {
+	return &models.Account{
+		ID:          uuid.New().String(),
+		DisplayName: profile.DisplayName,
+		AvatarURL:   profile.AvatarURL,
+		Provider:    provider,
+		CreatedAt:   time.Now(),
+	}
+}
+
+// createLogin saves a new account together with its login, returning
+// db.ErrLoginTaken if the provider already knows the subject
+func createLogin(ctx context.Context, account *models.Account, login *models.Login) error {
+	login.AccountID = account.ID
+	login.CreatedAt = account.CreatedAt
+	return store.WithTx(ctx, func(tx db.Store) error {
+		if err := tx.CreateAccount(ctx, account); err != nil {
+			return err
+		}
+		return tx.CreateLogin(ctx, login)
+	})
+}
+
+// identityProfile derives a new account's profile from what its provider
+// knows, leaving out what the API would reject
+func identityProfile(identity *auth.Identity) models.AccountRequest {
+	profile := models.AccountRequest{DisplayName: defaultDisplayName}
+	emailName, _, _ := strings.Cut(identity.Email, "@")
+	for _, name := range []string{identity.DisplayName, emailName} {
+		var v validate.Validator
+		v.Text("displayName", "Display name", &name, validate.Name)
+		if v.Errors() == nil {
+			profile.DisplayName = name
+			break
+		}
+	}
+
+	avatarURL := identity.AvatarURL
+	var v validate.Validator
+	v.URL("avatarUrl", "Avatar URL", &avatarURL, validate.AvatarURL)
+	if v.Errors() == nil {
+		profile.AvatarURL = avatarURL
+	}
+	return profile
+}
+
+// allowedReturnTo reports whether a sign-in may end on returnTo: an
+// absolute URL on an origin allowed to use the API
+func allowedReturnTo(returnTo string) bool {
+	u, err := url.Parse(returnTo)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return false
+	}
+	return origins.Allowed(u.Scheme + "://" + u.Host)
+}
+
+func writeProviderNotFound(w http.ResponseWriter, r *http.Request, name string) {
+	writeProblem(w, r, http.StatusNotFound, models.CodeProviderNotFound, "No sign-in provider "+name+" of this kind is enabled")
+}
+
+func writeProviderUnavailable(w http.ResponseWriter, r *http.Request, name string, err error) {
+	logging.FromContext(r.Context()).Warn("identity provider unavailable", "provider", name, logging.Err(err))
+	writeProblem(w, r, http.StatusBadGateway, models.CodeProviderUnavailable, "The identity provider could not be reached; try again later")
+}
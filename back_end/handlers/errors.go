@@ -0,0 +1,69 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"poker-planning-api/db"
+	"poker-planning-api/logging"
+	"poker-planning-api/middleware"
+	"poker-planning-api/models"
+)
+
+// problemTypeBase prefixes error codes to build the RFC 7807 "type" URI
+const problemTypeBase = "urn:poker-planning:error:"
+
+// writeProblem sends a problem+json response
+func writeProblem(w http.ResponseWriter, r *http.Request, status int, code, detail string, fieldErrors ...models.FieldError) {
+	problem := models.Problem{
+		Type:      problemTypeBase + code,
+		Title:     http.StatusText(status),
+		Status:    status,
+		Detail:    detail,
+		Instance:  r.URL.Path,
+		Code:      code,
+		RequestID: middleware.RequestIDFromContext(r.Context()),
+		Errors:    fieldErrors,
+	}
+
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(problem)
+}
+
+// writeInvalidBody reports a request body that could not be decoded
+func writeInvalidBody(w http.ResponseWriter, r *http.Request, err error) {
+	writeProblem(w, r, http.StatusBadRequest, models.CodeInvalidBody, "Request body must be valid JSON: "+err.Error())
+}
+
+// writeValidationErrors reports one or more rejected fields
+func writeValidationErrors(w http.ResponseWriter, r *http.Request, fieldErrors []models.FieldError) {
+	writeProblem(w, r, http.StatusBadRequest, models.CodeValidationFailed, "One or more fields are invalid", fieldErrors...)
+}
+
+// writeDBError maps a database error to a problem response. notFoundCode is
+// used when the query matched no rows.
+func writeDBError(w http.ResponseWriter, r *http.Request, err error, notFoundCode, action string) {
+	switch {
+	case errors.Is(err, sql.ErrNoRows) && notFoundCode != "":
+		writeProblem(w, r, http.StatusNotFound, notFoundCode, "")
+	case errors.Is(err, context.Canceled) && r.Context().Err() != nil:
+		// The client went away; nobody is left to answer
+		logging.FromContext(r.Context()).Debug("request cancelled", "action", action)
+	case db.IsUnavailable(err):
+		logging.FromContext(r.Context()).Warn("database unavailable", "action", action, logging.Err(err))
+		writeProblem(w, r, http.StatusServiceUnavailable, models.CodeDatabaseUnavailable, "The database is temporarily unavailable")
+	default:
+		logging.FromContext(r.Context()).Error("request failed", "action", action, logging.Err(err))
+		writeProblem(w, r, http.StatusInternalServerError, models.CodeInternal, "Failed to "+action)
+	}
+}
+
+// writeJSON sends a JSON response with the given status code
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
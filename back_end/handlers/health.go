@@ -0,0 +1,41 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"poker-planning-api/db"
+	"poker-planning-api/logging"
+	"poker-planning-api/models"
+	"time"
+)
+
+// readyTimeout bounds the database checks behind GET /ready, keeping probes
+// fast while the database is unreachable
+const readyTimeout = 2 * time.Second
+
+// Ready reports whether the database is reachable and its schema matches
+// the version this build expects
+func Ready(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), readyTimeout)
+	defer cancel()
+
+	if err := store.Ping(ctx); err != nil {
+		logging.FromContext(r.Context()).Debug("not ready: database unreachable", logging.Err(err))
+		writeProblem(w, r, http.StatusServiceUnavailable, models.CodeNotReady, "The database is unreachable")
+		return
+	}
+
+	version, err := store.SchemaVersion(ctx)
+	if err != nil {
+		writeProblem(w, r, http.StatusServiceUnavailable, models.CodeNotReady, "The schema version could not be read")
+		return
+	}
+	if version != db.SchemaVersion {
+		writeProblem(w, r, http.StatusServiceUnavailable, models.CodeNotReady,
+			fmt.Sprintf("Database schema version is %d, expected %d", version, db.SchemaVersion))
+		return
+	}
+
+	writeJSON(w, http.StatusOK, models.ReadinessResponse{Status: "ready", SchemaVersion: version})
+}
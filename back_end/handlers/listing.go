@@ -0,0 +1,193 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"poker-planning-api/db"
+	"poker-planning-api/models"
+	"poker-planning-api/validate"
+	"strings"
+	"time"
+)
+
+// UserIDHeader carries the user IDs a client holds, comma-separated or
+// repeated. A user ID only lists the session it belongs to; it is no
+// secret within that session, where every participant sees the others',
+// so acting as a participant or host takes a resume token, host key or
+// account.
+const UserIDHeader = "X-User-ID"
+
+const (
+	defaultPageSize = 20
+	maxPageSize     = 100
+	// maxCallerUserIDs bounds the user IDs sent with one listing
+	maxCallerUserIDs = 100
+)
+
+// ListSessions returns the sessions the caller created or joined, newest
+// first, identified by the user IDs in UserIDHeader and the caller's
+// account. Without either, the listing is empty: session names
+// are not public.
+func ListSessions(w http.ResponseWriter, r *http.Request) {
+	userIDs := callerUserIDs(r)
+
+	var v validate.Validator
+	if len(userIDs) > maxCallerUserIDs {
+		v.Add("userId", models.FieldTooLong, fmt.Sprintf("At most %d user IDs may be sent", maxCallerUserIDs))
+	}
+	for _, userID := range userIDs {
+		if !validate.IsID(userID) {
+			v.Add("userId", models.FieldInvalidFormat, UserIDHeader+" must hold valid user IDs")
+			break
+		}
+	}
+	filter, limit := listingFilter(&v, r.URL.Query())
+	if fieldErrors := v.Errors(); fieldErrors != nil {
+		writeValidationErrors(w, r, fieldErrors)
+		return
+	}
+
+	if len(userIDs) == 0 && !sendsAccount(r) {
+		writeJSON(w, http.StatusOK, models.SessionPage{Sessions: []models.SessionSummary{}})
+		return
+	}
+
+	ctx, cancel := withTimeout(r.Context(), timeouts.Read)
+	defer cancel()
+
+	accountID, ok := listingAccountID(ctx, w, r)
+	if !ok {
+		return
+	}
+	filter.UserIDs = userIDs
+	filter.AccountID = accountID
+	writeSessionPage(ctx, w, r, filter, limit)
+}
+
+// listingFilter reads the search, filter and paging parameters shared by
+// the session listings. It returns the page size; the filter's Limit asks
+// for one more row, which tells whether another page follows.
+func listingFilter(v *validate.Validator, query url.Values) (db.SessionFilter, int) {
+	search := query.Get("q")
+	v.Text("q", "Search", &search, validate.Search)
+	state := query.Get("state")
+	v.OneOf("state", "State", state, models.SessionStateActive, models.SessionStateArchived)
+	createdFrom := v.Time("createdFrom", "Start date", query.Get("createdFrom"))
+	createdTo := v.Time("createdTo", "End date", query.Get("createdTo"))
+	limit := v.Int("limit", "Limit", query.Get("limit"), defaultPageSize, 1, maxPageSize)
+	after := decodeCursor(v, query.Get("cursor"))
+
+	return db.SessionFilter{
+		Query:       search,
+		State:       state,
+		CreatedFrom: createdFrom,
+		CreatedTo:   createdTo,
+		After:       after,
+		Limit:       limit + 1,
+	}, limit
+}
+
+// writeSessionPage lists the sessions matching filter, a page of limit
+func writeSessionPage(ctx context.Context, w http.ResponseWriter, r *http.Request, filter db.SessionFilter, limit int) {
+	sessions, err := store.ListSessions(ctx, filter)
+	if err != nil {
+		writeDBError(w, r, err, "", "list sessions")
+		return
+	}
+
+	page := models.SessionPage{Sessions: sessions}
+	if len(sessions) > limit {
+		page.Sessions = sessions[:limit]
+		page.NextCursor = encodeCursor(page.Sessions[limit-1])
+	}
+	for i := range page.Sessions {
+		page.Sessions[i].ConnectedCount = connectedCount(page.Sessions[i].ID)
+	}
+
+	writeJSON(w, http.StatusOK, page)
+}
+
+// listingAccountID is the ID of callerAccount, except that an unknown
+// account in AccountIDHeader lists nothing rather than failing: deleting
+// an account removes its sessions from the listing
+func listingAccountID(ctx context.Context, w http.ResponseWriter, r *http.Request) (string, bool) {
+	if _, ok := callerToken(r); ok {
+		account, ok := callerAccount(ctx, w, r)
+		if !ok {
+			return "", false
+		}
+		return account.ID, true
+	}
+
+	accountID, ok := callerAccountID(w, r)
+	if !ok || accountID == "" {
+		return accountID, ok
+	}
+	account, err := store.GetAccount(ctx, accountID)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		return accountID, true
+	case err != nil:
+		writeDBError(w, r, err, models.CodeAccountNotFound, "load account")
+		return "", false
+	case account.Provider != "":
+		writeSignInRequired(w, r)
+		return "", false
+	}
+	return accountID, true
+}
+
+// callerUserIDs collects the user IDs sent in UserIDHeader
+func callerUserIDs(r *http.Request) []string {
+	var userIDs []string
+	for _, header := range r.Header.Values(UserIDHeader) {
+		for _, userID := range strings.Split(header, ",") {
+			if userID = strings.TrimSpace(userID); userID != "" {
+				userIDs = append(userIDs, userID)
+			}
+		}
+	}
+	return userIDs
+}
+
+// connectedCount counts the open connections to a session. Only cached
+// sessions can have any.
+func connectedCount(sessionID string) int {
+	sessionsMutex.RLock()
+	session, exists := activeSessions[sessionID]
+	sessionsMutex.RUnlock()
+
+	if !exists {
+		return 0
+	}
+	return len(session.ConnectedUsers())
+}
+
+// encodeCursor returns an opaque cursor for the page following session
+func encodeCursor(session models.SessionSummary) string {
+	position := session.CreatedAt.UTC().Format(time.RFC3339Nano) + "|" + session.ID
+	return base64.RawURLEncoding.EncodeToString([]byte(position))
+}
+
+// decodeCursor parses a cursor made by encodeCursor. An empty cursor
+// starts from the newest session.
+func decodeCursor(v *validate.Validator, cursor string) *db.SessionCursor {
+	if cursor == "" {
+		return nil
+	}
+
+	position, err := base64.RawURLEncoding.DecodeString(cursor)
+	createdAt, id, found := strings.Cut(string(position), "|")
+	if err == nil && found {
+		if t, err := time.Parse(time.RFC3339Nano, createdAt); err == nil && validate.IsID(id) {
+			return &db.SessionCursor{CreatedAt: t, ID: id}
+		}
+	}
+	v.Add("cursor", models.FieldInvalidFormat, "Cursor is not valid; use nextCursor from a previous page")
+	return nil
+}
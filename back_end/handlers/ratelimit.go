@@ -0,0 +1,114 @@
+package handlers
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"poker-planning-api/config"
+	"poker-planning-api/logging"
+	"poker-planning-api/metrics"
+	"poker-planning-api/models"
+	"poker-planning-api/ratelimit"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// rateLimits holds the limits set by SetRateLimits. The zero value, with
+// nil limiters, allows everything.
+type rateLimits struct {
+	requests       *ratelimit.Limiter
+	sessions       *ratelimit.Quota
+	messages       rate.Limit
+	messageBurst   int
+	trustedProxies int
+}
+
+var limits rateLimits
+
+// SetRateLimits configures the per-IP request and session limits and the
+// per-connection WebSocket message limit
+func SetRateLimits(cfg config.RateLimitConfig) {
+	if !cfg.Enabled {
+		limits = rateLimits{}
+		return
+	}
+	limits = rateLimits{
+		requests:       ratelimit.NewLimiter(cfg.RequestsPerSecond, cfg.RequestBurst),
+		sessions:       ratelimit.NewQuota(cfg.SessionsPerHour, time.Hour),
+		messages:       rate.Limit(cfg.MessagesPerSecond),
+		messageBurst:   cfg.MessageBurst,
+		trustedProxies: cfg.TrustedProxies,
+	}
+}
+
+// LimitRequests answers 429 to clients exceeding the per-IP request rate.
+// Only /api, /ws and /admin requests count; probes and scrapes are never
+// limited.
+func LimitRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/api/") || strings.HasPrefix(r.URL.Path, "/ws/") ||
+			strings.HasPrefix(r.URL.Path, "/admin/") {
+			if ok, retryAfter := limits.requests.Allow(clientIP(r)); !ok {
+				writeRateLimited(w, r, metrics.LimitRequests, models.CodeRateLimited, retryAfter,
+					"Too many requests; slow down")
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// allowSessionCreation applies the per-IP session quota, answering 429
+// when it is used up
+func allowSessionCreation(w http.ResponseWriter, r *http.Request) bool {
+	ok, retryAfter := limits.sessions.Allow(clientIP(r))
+	if !ok {
+		writeRateLimited(w, r, metrics.LimitSessions, models.CodeSessionQuota, retryAfter,
+			"Too many sessions created from this address; try again later")
+	}
+	return ok
+}
+
+func writeRateLimited(w http.ResponseWriter, r *http.Request, limit, code string, retryAfter time.Duration, detail string) {
+	metrics.RateLimited.WithLabelValues(limit).Inc()
+	logging.FromContext(r.Context()).Warn("rate limited", "limit", limit, "client_ip", clientIP(r))
+
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+	writeProblem(w, r, http.StatusTooManyRequests, code, detail)
+}
+
+// newMessageLimiter returns the token bucket for one WebSocket connection,
+// or nil when messages are not limited
+func newMessageLimiter() *rate.Limiter {
+	if limits.messages <= 0 {
+		return nil
+	}
+	return rate.NewLimiter(limits.messages, max(limits.messageBurst, 1))
+}
+
+// clientIP identifies the client for rate limiting. Behind proxies the
+// connection comes from the nearest proxy, so the address is taken from
+// X-Forwarded-For, skipping the entries appended by the trusted proxies;
+// entries further left can be forged by the client.
+func clientIP(r *http.Request) string {
+	if n := limits.trustedProxies; n > 0 {
+		var hops []string
+		for _, header := range r.Header.Values("X-Forwarded-For") {
+			hops = append(hops, strings.Split(header, ",")...)
+		}
+		if len(hops) >= n {
+			if ip := strings.TrimSpace(hops[len(hops)-n]); ip != "" {
+				return ip
+			}
+		}
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -1,107 +1,401 @@
 package handlers
 
 import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/base64"
 	"encoding/json"
-	"log"
+	"errors"
 	"net/http"
 	"poker-planning-api/db"
+	"poker-planning-api/joincode"
+	"poker-planning-api/logging"
+	"poker-planning-api/metrics"
 	"poker-planning-api/models"
+	"poker-planning-api/openapi"
+	"poker-planning-api/protocol"
+	"poker-planning-api/validate"
 	"sync"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/gorilla/mux"
 )
 
 var (
+	// store persists sessions; set by SetStore before serving requests
+	store db.Store
+
 	// In-memory cache for active WebSocket connections
 	activeSessions = make(map[string]*models.Session)
 	sessionsMutex  sync.RWMutex
 )
 
-// CreateSessionRequest represents the request to create a new session
-type CreateSessionRequest struct {
-	Name     string `json:"name"`
-	HostName string `json:"hostName"`
-}
-
-// CreateSessionResponse represents the response after creating a session
-type CreateSessionResponse struct {
-	SessionID string `json:"sessionId"`
-	HostID    string `json:"hostId"`
+// SetStore sets the persistence layer used by all handlers and drops any
+// cached sessions, so a fresh store starts from a clean slate
+func SetStore(s db.Store) {
+	sessionsMutex.Lock()
+	defer sessionsMutex.Unlock()
+	store = s
+	activeSessions = make(map[string]*models.Session)
+	metrics.ActiveSessions.Set(0)
+	connectionsMutex.Lock()
+	shuttingDown.Store(false)
+	connectionsMutex.Unlock()
 }
 
-// CreateSession handles creating a new poker planning session
+// CreateSession handles creating a new poker planning session. With an
+// account, signed in to or in AccountIDHeader, the host is the account's
+// participant, named after the account unless hostName is given. A
+// session created under a team takes the team's settings unless the
+// request gives its own. A session created from a template takes the
+// template's name, team, settings and authRequired unless the request
+// gives them, and starts with the template's items.
 func CreateSession(w http.ResponseWriter, r *http.Request) {
-	var req CreateSessionRequest
+	var req models.CreateSessionRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		writeInvalidBody(w, r, err)
 		return
 	}
 
-	if req.Name == "" || req.HostName == "" {
-		http.Error(w, "Session name and host name are required", http.StatusBadRequest)
+	hostNameRule := validate.Name
+	hostNameRule.Required = !sendsAccount(r)
+	nameRule := validate.Name
+	nameRule.Required = req.TemplateID == ""
+	var v validate.Validator
+	v.Text("name", "Session name", &req.Name, nameRule)
+	v.Text("hostName", "Host name", &req.HostName, hostNameRule)
+	if req.TemplateID != "" {
+		v.ID("templateId", "Template ID", req.TemplateID)
+	}
+	if req.TeamID != "" {
+		v.ID("teamId", "Team ID", req.TeamID)
+	}
+	if req.Settings != nil {
+		v.Settings("settings", req.Settings)
+	}
+	if fieldErrors := v.Errors(); fieldErrors != nil {
+		writeValidationErrors(w, r, fieldErrors)
 		return
 	}
 
-	sessionID := uuid.New().String()
-	hostID := uuid.New().String()
+	ctx, cancel := withTimeout(r.Context(), timeouts.Write)
+	defer cancel()
 
-	session := models.NewSession(sessionID, req.Name, hostID)
+	account, ok := callerAccount(ctx, w, r)
+	if !ok {
+		return
+	}
+	var template *models.Template
+	if req.TemplateID != "" {
+		if account == nil {
+			writeAccountRequired(w, r)
+			return
+		}
+		if template, _, ok = usableTemplate(ctx, w, r, req.TemplateID, account); !ok {
+			return
+		}
+		if req.Name == "" {
+			req.Name = template.Name
+		}
+		if req.TeamID == "" {
+			req.TeamID = template.TeamID
+		}
+		if req.Settings == nil {
+			req.Settings = &template.Settings
+		}
+		req.AuthRequired = req.AuthRequired || template.AuthRequired
+	}
+	if req.AuthRequired && !signedIn(account) {
+		writeProblem(w, r, http.StatusUnauthorized, models.CodeSignInRequired, "Sign in to create a session for signed-in participants")
+		return
+	}
+	var settings models.SessionSettings
+	if req.TeamID != "" {
+		if !signedIn(account) {
+			writeProblem(w, r, http.StatusUnauthorized, models.CodeSignInRequired, "Sign in to create a session for a team")
+			return
+		}
+		team, ok := memberTeam(ctx, w, r, req.TeamID, account)
+		if !ok {
+			return
+		}
+		settings = team.Settings
+	}
+	if req.Settings != nil {
+		settings = *req.Settings
+	}
 
-	// Save session to database
-	if err := db.CreateSession(session); err != nil {
-		log.Printf("Failed to create session: %v", err)
-		http.Error(w, "Failed to create session", http.StatusInternalServerError)
+	sessionID := uuid.New().String()
+	hostID := uuid.New().String()
+	hostKey, err := newKey()
+	if err != nil {
+		logging.FromContext(r.Context()).Error("failed to generate host key", logging.Err(err))
+		writeProblem(w, r, http.StatusInternalServerError, models.CodeInternal, "Failed to create session")
 		return
 	}
 
-	// Create host user
+	session := models.NewSession(sessionID, req.Name, hostID)
+	session.AuthRequired = req.AuthRequired
+	session.TeamID = req.TeamID
+	session.Settings = settings
+	session.HostKeyHash = hashKey(hostKey)
 	host := &models.User{
 		ID:        hostID,
 		Name:      req.HostName,
 		IsHost:    true,
 		Connected: false,
 	}
+	linkAccount(host, account)
+	if host.Name == "" {
+		host.Name = account.DisplayName
+	}
 
-	if err := db.CreateUser(host, sessionID); err != nil {
-		log.Printf("Failed to create host user: %v", err)
-		http.Error(w, "Failed to create host", http.StatusInternalServerError)
+	// Only a request that passed every check counts against the quota
+	if !allowSessionCreation(w, r) {
+		return
+	}
+
+	// Save the session, its host and its seed items together, so a
+	// failure cannot leave a session without a host
+	var items []models.PlanningItem
+	if template != nil {
+		for _, seed := range template.Items {
+			items = append(items, models.PlanningItem{
+				ID:          uuid.New().String(),
+				Title:       seed.Title,
+				Description: seed.Description,
+				Votes:       make(map[string]string),
+			})
+		}
+	}
+	if err := createWithJoinCode(ctx, session, host, items); err != nil {
+		writeDBError(w, r, err, "", "create session")
 		return
 	}
 
 	// Cache session for WebSocket connections
+	session.Items = append(session.Items, items...)
+	session.Touch()
 	sessionsMutex.Lock()
 	activeSessions[sessionID] = session
 	activeSessions[sessionID].Users[hostID] = host
+	metrics.ActiveSessions.Set(float64(len(activeSessions)))
 	sessionsMutex.Unlock()
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(CreateSessionResponse{
+	writeJSON(w, http.StatusOK, models.CreateSessionResponse{
 		SessionID: sessionID,
 		HostID:    hostID,
+		JoinCode:  session.JoinCode,
+		HostKey:   hostKey,
 	})
 }
 
+// newKey returns a random key proving who the caller is: a host key for
+// host-only requests, or a participant's resume token
+func newKey() (string, error) {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(key), nil
+}
+
+// hashKey returns the SHA-256 of a key, which is stored in its place
+func hashKey(key string) []byte {
+	hash := sha256.Sum256([]byte(key))
+	return hash[:]
+}
+
+// keyMatches reports whether key hashes to hash; no key matches an empty
+// hash
+func keyMatches(key string, hash []byte) bool {
+	return key != "" && len(hash) > 0 && subtle.ConstantTimeCompare(hashKey(key), hash) == 1
+}
+
+// isHost reports whether a REST request comes from the session's host: it
+// holds the host key, or its account is the host's. The host's user ID is
+// no proof, since every participant can read it.
+func isHost(session *models.Session, hostKey string, account *models.Account) bool {
+	session.Mutex.RLock()
+	defer session.Mutex.RUnlock()
+
+	if keyMatches(hostKey, session.HostKeyHash) {
+		return true
+	}
+	host, exists := session.Users[session.HostID]
+	return account != nil && exists && host.AccountID == account.ID
+}
+
+// admitCaller checks that the caller may use the session, over REST as
+// over WebSockets: sessions for signed-in participants turn guests and
+// ID-only accounts away, and a team's such sessions admit only its members.
+// It writes the problem when the caller is refused.
+func admitCaller(ctx context.Context, w http.ResponseWriter, r *http.Request, session *models.Session) (*models.Account, bool) {
+	session.Mutex.RLock()
+	authRequired := session.AuthRequired
+	teamID := session.TeamID
+	session.Mutex.RUnlock()
+
+	account, ok := callerAccount(ctx, w, r)
+	if !ok {
+		return nil, false
+	}
+	if authRequired && !signedIn(account) {
+		writeProblem(w, r, http.StatusUnauthorized, models.CodeSignInRequired, "Sign in to join this session")
+		return nil, false
+	}
+	// Membership of the team is what admits a signed-in caller to a team's
+	// session for signed-in participants
+	if authRequired && teamID != "" {
+		_, err := store.GetTeamRole(ctx, teamID, account.ID)
+		if errors.Is(err, sql.ErrNoRows) {
+			writeProblem(w, r, http.StatusForbidden, models.CodeNotTeamMember, "Only members of the session's team can join")
+			return nil, false
+		}
+		if err != nil {
+			writeDBError(w, r, err, "", "load team role")
+			return nil, false
+		}
+	}
+	return account, true
+}
+
+// joinCodeAttempts bounds the retries on join code collisions, which stay
+// rare while active sessions are few compared to the possible codes
+const joinCodeAttempts = 5
+
+// createWithJoinCode saves a new session, its host and its first items in
+// one transaction under a fresh join code, drawing another when the code
+// is held by an active session
+func createWithJoinCode(ctx context.Context, session *models.Session, host *models.User, items []models.PlanningItem) error {
+	var err error
+	for attempt := 0; attempt < joinCodeAttempts; attempt++ {
+		if session.JoinCode, err = joincode.New(); err != nil {
+			return err
+		}
+		err = store.WithTx(ctx, func(tx db.Store) error {
+			if err := tx.CreateSession(ctx, session); err != nil {
+				return err
+			}
+			if err := tx.CreateUser(ctx, host, session.ID); err != nil {
+				return err
+			}
+			for i := range items {
+				if err := tx.CreatePlanningItem(ctx, &items[i], session.ID); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if !errors.Is(err, db.ErrJoinCodeTaken) {
+			return err
+		}
+	}
+	return err
+}
+
+// ResolveJoinCode returns the active session a join code belongs to
+func ResolveJoinCode(w http.ResponseWriter, r *http.Request) {
+	code, ok := joincode.Normalize(mux.Vars(r)["code"])
+	if !ok {
+		writeProblem(w, r, http.StatusNotFound, models.CodeJoinCodeNotFound, "Join codes are 6 letters and digits")
+		return
+	}
+
+	ctx, cancel := withTimeout(r.Context(), timeouts.Read)
+	defer cancel()
+
+	sessionID, err := store.GetSessionIDByJoinCode(ctx, code)
+	if err != nil {
+		writeDBError(w, r, err, models.CodeJoinCodeNotFound, "resolve join code")
+		return
+	}
+	session, err := loadSession(ctx, sessionID)
+	if err != nil {
+		writeDBError(w, r, err, models.CodeJoinCodeNotFound, "load session")
+		return
+	}
+	if _, ok := admitCaller(ctx, w, r, session); !ok {
+		return
+	}
+
+	writeJSON(w, http.StatusOK, models.JoinCodeResponse{
+		SessionID: session.ID,
+		Name:      session.Name,
+		JoinCode:  code,
+	})
+}
+
+// ArchiveSession archives a session at its host's request. Participants
+// can no longer join and the join code becomes free for new sessions.
+func ArchiveSession(w http.ResponseWriter, r *http.Request) {
+	sessionID := mux.Vars(r)["sessionId"]
+
+	var req models.ArchiveSessionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeInvalidBody(w, r, err)
+		return
+	}
+
+	ctx, cancel := withTimeout(r.Context(), timeouts.Write)
+	defer cancel()
+
+	account, ok := callerAccount(ctx, w, r)
+	if !ok {
+		return
+	}
+	session, err := loadSession(ctx, sessionID)
+	if err != nil {
+		writeDBError(w, r, err, models.CodeSessionNotFound, "load session")
+		return
+	}
+	if !isHost(session, req.HostKey, account) {
+		writeProblem(w, r, http.StatusForbidden, models.CodeNotHost, "Only the host can archive the session")
+		return
+	}
+
+	if err := store.ArchiveSession(ctx, sessionID); err != nil {
+		writeDBError(w, r, err, models.CodeSessionNotFound, "archive session")
+		return
+	}
+
+	now := time.Now()
+	session.Mutex.Lock()
+	if session.ArchivedAt == nil {
+		session.ArchivedAt = &now
+	}
+	session.Mutex.Unlock()
+
+	writeJSON(w, http.StatusOK, models.StatusResponse{Status: "archived"})
+}
+
 // GetSession returns session information
 func GetSession(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	sessionID := vars["sessionId"]
 
+	ctx, cancel := withTimeout(r.Context(), timeouts.Read)
+	defer cancel()
+
 	// Try to get from database
-	session, err := db.GetSession(sessionID)
+	if !validate.IsID(sessionID) {
+		writeProblem(w, r, http.StatusNotFound, models.CodeSessionNotFound, "")
+		return
+	}
+	session, err := store.GetSession(ctx, sessionID)
 	if err != nil {
-		http.Error(w, "Session not found", http.StatusNotFound)
+		writeDBError(w, r, err, models.CodeSessionNotFound, "load session")
+		return
+	}
+	if _, ok := admitCaller(ctx, w, r, session); !ok {
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(session)
-}
-
-// AddItemRequest represents the request to add a planning item
-type AddItemRequest struct {
-	Title       string `json:"title"`
-	Description string `json:"description"`
+	writeJSON(w, http.StatusOK, session)
 }
 
 // AddItem adds a new planning item to a session
@@ -109,16 +403,17 @@ func AddItem(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	sessionID := vars["sessionId"]
 
-	var req AddItemRequest
+	var req models.AddItemRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		writeInvalidBody(w, r, err)
 		return
 	}
 
-	// Verify session exists
-	_, err := db.GetSession(sessionID)
-	if err != nil {
-		http.Error(w, "Session not found", http.StatusNotFound)
+	var v validate.Validator
+	v.Text("title", "Title", &req.Title, validate.Title)
+	v.Text("description", "Description", &req.Description, validate.Description)
+	if fieldErrors := v.Errors(); fieldErrors != nil {
+		writeValidationErrors(w, r, fieldErrors)
 		return
 	}
 
@@ -130,26 +425,31 @@ func AddItem(w http.ResponseWriter, r *http.Request) {
 		Revealed:    false,
 	}
 
+	ctx, cancel := withTimeout(r.Context(), timeouts.Write)
+	defer cancel()
+
+	session, err := loadSession(ctx, sessionID)
+	if err != nil {
+		writeDBError(w, r, err, models.CodeSessionNotFound, "load session")
+		return
+	}
+	if _, ok := admitCaller(ctx, w, r, session); !ok {
+		return
+	}
+
 	// Save item to database
-	if err := db.CreatePlanningItem(&item, sessionID); err != nil {
-		log.Printf("Failed to create item: %v", err)
-		http.Error(w, "Failed to create item", http.StatusInternalServerError)
+	if err := store.CreatePlanningItem(ctx, &item, sessionID); err != nil {
+		writeDBError(w, r, err, models.CodeSessionNotFound, "create item")
 		return
 	}
 
 	// Broadcast the update to all connected clients
 	BroadcastToSession(sessionID, models.WSMessage{
-		Type:    "item_added",
+		Type:    protocol.TypeItemAdded,
 		Payload: item,
 	})
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(item)
-}
-
-// SetCurrentItemRequest represents the request to set the current item
-type SetCurrentItemRequest struct {
-	ItemID string `json:"itemId"`
+	writeJSON(w, http.StatusOK, item)
 }
 
 // SetCurrentItem sets the current item being voted on
@@ -157,83 +457,123 @@ func SetCurrentItem(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	sessionID := vars["sessionId"]
 
-	var req SetCurrentItemRequest
+	var req models.SetCurrentItemRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		writeInvalidBody(w, r, err)
 		return
 	}
 
-	// Update in database
-	if err := db.UpdateSessionCurrentItem(sessionID, req.ItemID); err != nil {
-		log.Printf("Failed to update current item: %v", err)
-		http.Error(w, "Failed to update current item", http.StatusInternalServerError)
+	var v validate.Validator
+	v.ID("itemId", "Item ID", req.ItemID)
+	if fieldErrors := v.Errors(); fieldErrors != nil {
+		writeValidationErrors(w, r, fieldErrors)
 		return
 	}
 
-	// Update in cache if exists
-	sessionsMutex.Lock()
-	if session, exists := activeSessions[sessionID]; exists {
-		session.CurrentItemID = req.ItemID
+	ctx, cancel := withTimeout(r.Context(), timeouts.Write)
+	defer cancel()
+
+	session, err := loadSession(ctx, sessionID)
+	if err != nil {
+		writeDBError(w, r, err, models.CodeSessionNotFound, "load session")
+		return
 	}
-	sessionsMutex.Unlock()
+	if _, ok := admitCaller(ctx, w, r, session); !ok {
+		return
+	}
+	if err := checkSessionItem(ctx, session, req.ItemID); err != nil {
+		writeDBError(w, r, err, models.CodeItemNotFound, "load item")
+		return
+	}
+
+	// Update in database
+	if err := store.UpdateSessionCurrentItem(ctx, sessionID, req.ItemID); err != nil {
+		writeDBError(w, r, err, "", "update current item")
+		return
+	}
+
+	// Update the cached copy under its own lock, which guards its fields
+	session.Mutex.Lock()
+	session.CurrentItemID = req.ItemID
+	session.Mutex.Unlock()
 
 	// Broadcast the update to all connected clients
 	BroadcastToSession(sessionID, models.WSMessage{
-		Type:    "current_item_changed",
-		Payload: map[string]string{"itemId": req.ItemID},
+		Type:    protocol.TypeCurrentItemChanged,
+		Payload: protocol.ItemPayload{ItemID: req.ItemID},
 	})
 
-	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(map[string]string{"status": "success"})
+	writeJSON(w, http.StatusOK, models.StatusResponse{Status: "success"})
 }
 
-// GetSessions returns all active sessions (for debugging)
-func GetSessions(w http.ResponseWriter, r *http.Request) {
-	sessions, err := db.GetAllSessions()
-	if err != nil {
-		log.Printf("Failed to get sessions: %v", err)
-		http.Error(w, "Failed to retrieve sessions", http.StatusInternalServerError)
-		return
-	}
+// GetProtocolSpec serves the AsyncAPI description of the WebSocket protocol
+func GetProtocolSpec(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, protocol.AsyncAPI())
+}
 
-	sessionList := make([]map[string]interface{}, 0)
-	for _, session := range sessions {
-		users, _ := db.GetSessionUsers(session.ID)
-		items, _ := db.GetSessionItems(session.ID)
+// GetOpenAPISpec serves the OpenAPI description of the REST API
+func GetOpenAPISpec(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, openapi.Spec())
+}
 
-		sessionList = append(sessionList, map[string]interface{}{
-			"id":        session.ID,
-			"name":      session.Name,
-			"userCount": len(users),
-			"itemCount": len(items),
-		})
+// checkSessionItem returns sql.ErrNoRows unless the item belongs to the
+// session. Item IDs are checked only for their format by validation, and
+// the queries that take one do not know the session.
+func checkSessionItem(ctx context.Context, session *models.Session, itemID string) error {
+	if session.HasItem(itemID) {
+		return nil
 	}
-
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(sessionList)
+	sessionID, err := store.GetItemSessionID(ctx, itemID)
+	if err != nil {
+		return err
+	}
+	if sessionID != session.ID {
+		return sql.ErrNoRows
+	}
+	session.KnowItem(itemID)
+	return nil
 }
 
-// GetSessionByID returns a session by ID (used internally)
-func GetSessionByID(sessionID string) (*models.Session, bool) {
-	// Try cache first
+// cachedSession returns the session held in memory, if any. Only cached
+// sessions have connected clients.
+func cachedSession(sessionID string) (*models.Session, bool) {
 	sessionsMutex.RLock()
+	defer sessionsMutex.RUnlock()
 	session, exists := activeSessions[sessionID]
-	sessionsMutex.RUnlock()
+	return session, exists
+}
 
-	if exists {
-		return session, true
+// loadSession returns the cached session or loads it from the store,
+// passing store errors through so callers can tell a missing session from
+// an unreachable database. Other spellings of a session's ID are not
+// found, so a session is never cached twice.
+func loadSession(ctx context.Context, sessionID string) (*models.Session, error) {
+	if !validate.IsID(sessionID) {
+		return nil, sql.ErrNoRows
+	}
+	if session, exists := cachedSession(sessionID); exists {
+		return session, nil
 	}
 
 	// Try database
-	session, err := db.GetSession(sessionID)
+	session, err := store.GetSession(ctx, sessionID)
 	if err != nil {
-		return nil, false
+		return nil, err
 	}
 
 	// Add to cache
+	session.Touch()
 	sessionsMutex.Lock()
 	activeSessions[sessionID] = session
+	metrics.ActiveSessions.Set(float64(len(activeSessions)))
 	sessionsMutex.Unlock()
 
-	return session, true
+	return session, nil
+}
+
+// isCached reports whether session is the cached copy of its session
+func isCached(session *models.Session) bool {
+	sessionsMutex.RLock()
+	defer sessionsMutex.RUnlock()
+	return activeSessions[session.ID] == session
 }
@@ -0,0 +1,386 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"poker-planning-api/db"
+	"poker-planning-api/models"
+	"poker-planning-api/validate"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+// errLastTeamOwner stops a change to a team's members that would leave it
+// without an owner
+var errLastTeamOwner = errors.New("the team would have no owner left")
+
+// CreateTeam creates a team owned by the caller, who must be signed in
+func CreateTeam(w http.ResponseWriter, r *http.Request) {
+	var req models.TeamRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeInvalidBody(w, r, err)
+		return
+	}
+	if fieldErrors := validateTeam(&req); fieldErrors != nil {
+		writeValidationErrors(w, r, fieldErrors)
+		return
+	}
+
+	ctx, cancel := withTimeout(r.Context(), timeouts.Write)
+	defer cancel()
+
+	account, ok := teamAccount(ctx, w, r)
+	if !ok {
+		return
+	}
+
+	team := &models.Team{
+		ID:        uuid.New().String(),
+		Name:      req.Name,
+		Settings:  req.Settings,
+		CreatedAt: time.Now(),
+		Role:      models.TeamRoleOwner,
+	}
+	err := store.WithTx(ctx, func(tx db.Store) error {
+		if err := tx.CreateTeam(ctx, team); err != nil {
+			return err
+		}
+		return tx.SetTeamMember(ctx, team.ID, account.ID, models.TeamRoleOwner)
+	})
+	if err != nil {
+		writeDBError(w, r, err, "", "create team")
+		return
+	}
+	writeTeam(ctx, w, r, team)
+}
+
+// ListTeams returns the caller's teams, by name
+func ListTeams(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := withTimeout(r.Context(), timeouts.Read)
+	defer cancel()
+
+	account, ok := teamAccount(ctx, w, r)
+	if !ok {
+		return
+	}
+	teams, err := store.ListAccountTeams(ctx, account.ID)
+	if err != nil {
+		writeDBError(w, r, err, "", "list teams")
+		return
+	}
+	writeJSON(w, http.StatusOK, models.TeamList{Teams: teams})
+}
+
+// GetTeam returns a team with its members, to its members
+func GetTeam(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := withTimeout(r.Context(), timeouts.Read)
+	defer cancel()
+
+	team, _, ok := callerTeam(ctx, w, r)
+	if !ok {
+		return
+	}
+	writeTeam(ctx, w, r, team)
+}
+
+// UpdateTeam replaces a team's name and default settings at an owner's
+// request. Sessions created before keep their settings.
+func UpdateTeam(w http.ResponseWriter, r *http.Request) {
+	var req models.TeamRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeInvalidBody(w, r, err)
+		return
+	}
+	if fieldErrors := validateTeam(&req); fieldErrors != nil {
+		writeValidationErrors(w, r, fieldErrors)
+		return
+	}
+
+	ctx, cancel := withTimeout(r.Context(), timeouts.Write)
+	defer cancel()
+
+	team, _, ok := ownedTeam(ctx, w, r)
+	if !ok {
+		return
+	}
+	team.Name = req.Name
+	team.Settings = req.Settings
+	if err := store.UpdateTeam(ctx, team); err != nil {
+		writeDBError(w, r, err, models.CodeTeamNotFound, "update team")
+		return
+	}
+	writeTeam(ctx, w, r, team)
+}
+
+// DeleteTeam deletes a team at an owner's request. Its sessions stay, no
+// longer under a team.
+func DeleteTeam(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := withTimeout(r.Context(), timeouts.Write)
+	defer cancel()
+
+	team, _, ok := ownedTeam(ctx, w, r)
+	if !ok {
+		return
+	}
+	if err := store.DeleteTeam(ctx, team.ID); err != nil {
+		writeDBError(w, r, err, models.CodeTeamNotFound, "delete team")
+		return
+	}
+
+	sessionsMutex.RLock()
+	for _, session := range activeSessions {
+		session.Mutex.Lock()
+		if session.TeamID == team.ID {
+			session.TeamID = ""
+		}
+		session.Mutex.Unlock()
+	}
+	sessionsMutex.RUnlock()
+
+	writeJSON(w, http.StatusOK, models.StatusResponse{Status: "deleted"})
+}
+
+// SetTeamMember adds a signed-in account to a team or changes its role, at
+// an owner's request. The last owner cannot step down.
+func SetTeamMember(w http.ResponseWriter, r *http.Request) {
+	memberID := mux.Vars(r)["accountId"]
+
+	var req models.TeamMemberRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeInvalidBody(w, r, err)
+		return
+	}
+
+	var v validate.Validator
+	if req.Role == "" {
+		v.Add("role", models.FieldRequired, "Role is required")
+	}
+	v.OneOf("role", "Role", req.Role, models.TeamRoleOwner, models.TeamRoleMember)
+	if fieldErrors := v.Errors(); fieldErrors != nil {
+		writeValidationErrors(w, r, fieldErrors)
+		return
+	}
+
+	ctx, cancel := withTimeout(r.Context(), timeouts.Write)
+	defer cancel()
+
+	team, account, ok := ownedTeam(ctx, w, r)
+	if !ok {
+		return
+	}
+	if !validate.IsID(memberID) {
+		writeProblem(w, r, http.StatusNotFound, models.CodeAccountNotFound, "")
+		return
+	}
+	member, err := store.GetAccount(ctx, memberID)
+	if err != nil {
+		writeDBError(w, r, err, models.CodeAccountNotFound, "load account")
+		return
+	}
+	if !signedIn(member) {
+		v.Add("accountId", models.FieldInvalidFormat, "Only accounts signed in with a provider can join a team")
+		writeValidationErrors(w, r, v.Errors())
+		return
+	}
+
+	err = store.WithTx(ctx, func(tx db.Store) error {
+		if err := tx.LockTeam(ctx, team.ID); err != nil {
+			return err
+		}
+		if req.Role != models.TeamRoleOwner {
+			if err := keepTeamOwner(ctx, tx, team.ID, member.ID); err != nil {
+				return err
+			}
+		}
+		return tx.SetTeamMember(ctx, team.ID, member.ID, req.Role)
+	})
+	if errors.Is(err, errLastTeamOwner) {
+		writeLastTeamOwner(w, r)
+		return
+	}
+	if err != nil {
+		writeDBError(w, r, err, models.CodeTeamNotFound, "set team member")
+		return
+	}
+
+	if member.ID == account.ID {
+		team.Role = req.Role
+	}
+	writeTeam(ctx, w, r, team)
+}
+
+// RemoveTeamMember removes an account from a team, at an owner's request or
+// the member's own. The last owner cannot leave.
+func RemoveTeamMember(w http.ResponseWriter, r *http.Request) {
+	memberID := mux.Vars(r)["accountId"]
+
+	ctx, cancel := withTimeout(r.Context(), timeouts.Write)
+	defer cancel()
+
+	team, account, ok := callerTeam(ctx, w, r)
+	if !ok {
+		return
+	}
+	if memberID != account.ID && team.Role != models.TeamRoleOwner {
+		writeNotTeamOwner(w, r)
+		return
+	}
+	if !validate.IsID(memberID) {
+		writeProblem(w, r, http.StatusNotFound, models.CodeAccountNotFound, "The account is not a member of the team")
+		return
+	}
+
+	err := store.WithTx(ctx, func(tx db.Store) error {
+		if err := tx.LockTeam(ctx, team.ID); err != nil {
+			return err
+		}
+		if err := keepTeamOwner(ctx, tx, team.ID, memberID); err != nil {
+			return err
+		}
+		return tx.RemoveTeamMember(ctx, team.ID, memberID)
+	})
+	switch {
+	case errors.Is(err, errLastTeamOwner):
+		writeLastTeamOwner(w, r)
+	case errors.Is(err, sql.ErrNoRows):
+		writeProblem(w, r, http.StatusNotFound, models.CodeAccountNotFound, "The account is not a member of the team")
+	case err != nil:
+		writeDBError(w, r, err, "", "remove team member")
+	default:
+		writeJSON(w, http.StatusOK, models.StatusResponse{Status: "removed"})
+	}
+}
+
+// ListTeamSessions returns the sessions created under a team, newest
+// first, to its members. It takes the parameters of ListSessions; a
+// session's role is empty unless the caller's account joined it.
+func ListTeamSessions(w http.ResponseWriter, r *http.Request) {
+	var v validate.Validator
+	filter, limit := listingFilter(&v, r.URL.Query())
+	if fieldErrors := v.Errors(); fieldErrors != nil {
+		writeValidationErrors(w, r, fieldErrors)
+		return
+	}
+
+	ctx, cancel := withTimeout(r.Context(), timeouts.Read)
+	defer cancel()
+
+	team, account, ok := callerTeam(ctx, w, r)
+	if !ok {
+		return
+	}
+	filter.TeamID = team.ID
+	filter.AccountID = account.ID
+	writeSessionPage(ctx, w, r, filter, limit)
+}
+
+// validateTeam cleans and checks a team's name and settings
+func validateTeam(req *models.TeamRequest) []models.FieldError {
+	var v validate.Validator
+	v.Text("name", "Team name", &req.Name, validate.Name)
+	v.Settings("settings", &req.Settings)
+	return v.Errors()
+}
+
+// teamAccount is requireAccount for teams, whose members must be signed
+// in: an account ID alone does not prove who is asking
+func teamAccount(ctx context.Context, w http.ResponseWriter, r *http.Request) (*models.Account, bool) {
+	account, ok := requireAccount(ctx, w, r)
+	if !ok {
+		return nil, false
+	}
+	if !signedIn(account) {
+		writeProblem(w, r, http.StatusUnauthorized, models.CodeSignInRequired, "Sign in to use teams")
+		return nil, false
+	}
+	return account, true
+}
+
+// callerTeam loads the team named in the path, with Role set to the
+// caller's role, for its members only
+func callerTeam(ctx context.Context, w http.ResponseWriter, r *http.Request) (*models.Team, *models.Account, bool) {
+	account, ok := teamAccount(ctx, w, r)
+	if !ok {
+		return nil, nil, false
+	}
+	team, ok := memberTeam(ctx, w, r, mux.Vars(r)["teamId"], account)
+	return team, account, ok
+}
+
+// ownedTeam is callerTeam for requests only owners may make
+func ownedTeam(ctx context.Context, w http.ResponseWriter, r *http.Request) (*models.Team, *models.Account, bool) {
+	team, account, ok := callerTeam(ctx, w, r)
+	if ok && team.Role != models.TeamRoleOwner {
+		writeNotTeamOwner(w, r)
+		return nil, nil, false
+	}
+	return team, account, ok
+}
+
+// memberTeam loads a team for one of its members, with Role set
+func memberTeam(ctx context.Context, w http.ResponseWriter, r *http.Request, teamID string, account *models.Account) (*models.Team, bool) {
+	if !validate.IsID(teamID) {
+		writeProblem(w, r, http.StatusNotFound, models.CodeTeamNotFound, "")
+		return nil, false
+	}
+	team, err := store.GetTeam(ctx, teamID)
+	if err != nil {
+		writeDBError(w, r, err, models.CodeTeamNotFound, "load team")
+		return nil, false
+	}
+	team.Role, err = store.GetTeamRole(ctx, teamID, account.ID)
+	if errors.Is(err, sql.ErrNoRows) {
+		writeProblem(w, r, http.StatusForbidden, models.CodeNotTeamMember, "Only members of the team can do this")
+		return nil, false
+	}
+	if err != nil {
+		writeDBError(w, r, err, "", "load team role")
+		return nil, false
+	}
+	return team, true
+}
+
+// keepTeamOwner fails with errLastTeamOwner if accountID is the team's
+// only owner, which would leave the team without one once it steps down.
+// The team must be locked.
+func keepTeamOwner(ctx context.Context, tx db.Store, teamID, accountID string) error {
+	members, err := tx.GetTeamMembers(ctx, teamID)
+	if err != nil {
+		return err
+	}
+	owners, isOwner := 0, false
+	for _, member := range members {
+		if member.Role == models.TeamRoleOwner {
+			owners++
+			isOwner = isOwner || member.AccountID == accountID
+		}
+	}
+	if isOwner && owners == 1 {
+		return errLastTeamOwner
+	}
+	return nil
+}
+
+// writeTeam sends a team with its members
+func writeTeam(ctx context.Context, w http.ResponseWriter, r *http.Request, team *models.Team) {
+	members, err := store.GetTeamMembers(ctx, team.ID)
+	if err != nil {
+		writeDBError(w, r, err, "", "load team members")
+		return
+	}
+	team.Members = members
+	writeJSON(w, http.StatusOK, team)
+}
+
+func writeNotTeamOwner(w http.ResponseWriter, r *http.Request) {
+	writeProblem(w, r, http.StatusForbidden, models.CodeNotTeamOwner, "Only owners of the team can do this")
+}
+
+func writeLastTeamOwner(w http.ResponseWriter, r *http.Request) {
+	writeProblem(w, r, http.StatusConflict, models.CodeLastTeamOwner, "Make another member an owner first; a team needs an owner")
+}
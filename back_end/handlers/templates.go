@@ -0,0 +1,233 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"poker-planning-api/models"
+	"poker-planning-api/validate"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+// CreateTemplate creates a template owned by the caller's account or, with
+// teamId, shared with a team the caller is a signed-in member of
+func CreateTemplate(w http.ResponseWriter, r *http.Request) {
+	var req models.TemplateRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeInvalidBody(w, r, err)
+		return
+	}
+	var v validate.Validator
+	if req.TeamID != "" {
+		v.ID("teamId", "Team ID", req.TeamID)
+	}
+	if fieldErrors := validateTemplate(&v, &req); fieldErrors != nil {
+		writeValidationErrors(w, r, fieldErrors)
+		return
+	}
+
+	ctx, cancel := withTimeout(r.Context(), timeouts.Write)
+	defer cancel()
+
+	account, ok := requireAccount(ctx, w, r)
+	if !ok {
+		return
+	}
+	template := &models.Template{
+		ID:           uuid.New().String(),
+		Name:         req.Name,
+		Settings:     req.Settings,
+		AuthRequired: req.AuthRequired,
+		Items:        templateItems(req.Items),
+		CreatedAt:    time.Now(),
+	}
+	template.UpdatedAt = template.CreatedAt
+	if req.TeamID != "" {
+		if !signedIn(account) {
+			writeProblem(w, r, http.StatusUnauthorized, models.CodeSignInRequired, "Sign in to use teams")
+			return
+		}
+		if _, ok := memberTeam(ctx, w, r, req.TeamID, account); !ok {
+			return
+		}
+		template.TeamID = req.TeamID
+	} else {
+		template.AccountID = account.ID
+	}
+
+	if err := store.CreateTemplate(ctx, template); err != nil {
+		writeDBError(w, r, err, "", "create template")
+		return
+	}
+	writeJSON(w, http.StatusOK, template)
+}
+
+// ListTemplates returns the templates the caller may use, its own and its
+// teams', by name
+func ListTemplates(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := withTimeout(r.Context(), timeouts.Read)
+	defer cancel()
+
+	account, ok := requireAccount(ctx, w, r)
+	if !ok {
+		return
+	}
+	templates, err := store.ListTemplates(ctx, account.ID)
+	if err != nil {
+		writeDBError(w, r, err, "", "list templates")
+		return
+	}
+	writeJSON(w, http.StatusOK, models.TemplateList{Templates: templates})
+}
+
+// GetTemplate returns a template to those who may use it
+func GetTemplate(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := withTimeout(r.Context(), timeouts.Read)
+	defer cancel()
+
+	template, _, ok := callerTemplate(ctx, w, r)
+	if !ok {
+		return
+	}
+	writeJSON(w, http.StatusOK, template)
+}
+
+// UpdateTemplate replaces a template's name, settings and items at its
+// account's request or a team owner's. Sessions created before are not
+// changed.
+func UpdateTemplate(w http.ResponseWriter, r *http.Request) {
+	var req models.TemplateRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeInvalidBody(w, r, err)
+		return
+	}
+	var v validate.Validator
+	if fieldErrors := validateTemplate(&v, &req); fieldErrors != nil {
+		writeValidationErrors(w, r, fieldErrors)
+		return
+	}
+
+	ctx, cancel := withTimeout(r.Context(), timeouts.Write)
+	defer cancel()
+
+	template, ok := ownedTemplate(ctx, w, r)
+	if !ok {
+		return
+	}
+	if req.TeamID != "" && req.TeamID != template.TeamID {
+		v.Add("teamId", models.FieldInvalidFormat, "A template's team cannot change")
+		writeValidationErrors(w, r, v.Errors())
+		return
+	}
+	template.Name = req.Name
+	template.Settings = req.Settings
+	template.AuthRequired = req.AuthRequired
+	template.Items = templateItems(req.Items)
+	template.UpdatedAt = time.Now()
+	if err := store.UpdateTemplate(ctx, template); err != nil {
+		writeDBError(w, r, err, models.CodeTemplateNotFound, "update template")
+		return
+	}
+	writeJSON(w, http.StatusOK, template)
+}
+
+// DeleteTemplate deletes a template at its account's request or a team
+// owner's. Sessions created from it stay.
+func DeleteTemplate(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := withTimeout(r.Context(), timeouts.Write)
+	defer cancel()
+
+	template, ok := ownedTemplate(ctx, w, r)
+	if !ok {
+		return
+	}
+	if err := store.DeleteTemplate(ctx, template.ID); err != nil {
+		writeDBError(w, r, err, models.CodeTemplateNotFound, "delete template")
+		return
+	}
+	writeJSON(w, http.StatusOK, models.StatusResponse{Status: "deleted"})
+}
+
+// validateTemplate cleans and checks a template's name, settings and items
+func validateTemplate(v *validate.Validator, req *models.TemplateRequest) []models.FieldError {
+	v.Text("name", "Template name", &req.Name, validate.Name)
+	v.Settings("settings", &req.Settings)
+	if len(req.Items) > validate.MaxTemplateItems {
+		v.Add("items", models.FieldTooLong, fmt.Sprintf("A template may seed at most %d items", validate.MaxTemplateItems))
+		return v.Errors()
+	}
+	for i := range req.Items {
+		field := fmt.Sprintf("items[%d]", i)
+		v.Text(field+".title", "Title", &req.Items[i].Title, validate.Title)
+		v.Text(field+".description", "Description", &req.Items[i].Description, validate.Description)
+	}
+	return v.Errors()
+}
+
+// templateItems is never nil, so a template without items lists none
+func templateItems(items []models.TemplateItem) []models.TemplateItem {
+	if items == nil {
+		return []models.TemplateItem{}
+	}
+	return items
+}
+
+// callerTemplate loads the template named in the path for the caller's
+// account, with the caller's role in the template's team
+func callerTemplate(ctx context.Context, w http.ResponseWriter, r *http.Request) (*models.Template, string, bool) {
+	account, ok := requireAccount(ctx, w, r)
+	if !ok {
+		return nil, "", false
+	}
+	return usableTemplate(ctx, w, r, mux.Vars(r)["templateId"], account)
+}
+
+// ownedTemplate is callerTemplate for requests only the template's account
+// or its team's owners may make
+func ownedTemplate(ctx context.Context, w http.ResponseWriter, r *http.Request) (*models.Template, bool) {
+	template, role, ok := callerTemplate(ctx, w, r)
+	if ok && template.TeamID != "" && role != models.TeamRoleOwner {
+		writeNotTeamOwner(w, r)
+		return nil, false
+	}
+	return template, ok
+}
+
+// usableTemplate loads a template the account may use: its own or one of
+// its teams'. The role is the account's in the template's team. Other
+// templates are not found, so their IDs reveal nothing.
+func usableTemplate(ctx context.Context, w http.ResponseWriter, r *http.Request, templateID string, account *models.Account) (*models.Template, string, bool) {
+	if !validate.IsID(templateID) {
+		writeProblem(w, r, http.StatusNotFound, models.CodeTemplateNotFound, "")
+		return nil, "", false
+	}
+	template, err := store.GetTemplate(ctx, templateID)
+	if err != nil {
+		writeDBError(w, r, err, models.CodeTemplateNotFound, "load template")
+		return nil, "", false
+	}
+	if template.TeamID == "" {
+		if template.AccountID != account.ID {
+			writeProblem(w, r, http.StatusNotFound, models.CodeTemplateNotFound, "")
+			return nil, "", false
+		}
+		return template, "", true
+	}
+
+	role, err := store.GetTeamRole(ctx, template.TeamID, account.ID)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && !signedIn(account)) {
+		writeProblem(w, r, http.StatusNotFound, models.CodeTemplateNotFound, "")
+		return nil, "", false
+	}
+	if err != nil {
+		writeDBError(w, r, err, "", "load team role")
+		return nil, "", false
+	}
+	return template, role, true
+}
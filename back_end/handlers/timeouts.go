@@ -0,0 +1,27 @@
+package handlers
+
+import (
+	"context"
+	"poker-planning-api/config"
+	"time"
+)
+
+// timeouts bound the handlers' database calls by class; set by
+// SetQueryTimeouts. The zero value leaves every call unbounded.
+var timeouts config.QueryTimeouts
+
+// SetQueryTimeouts sets the per-class timeouts for database calls. REST
+// calls are also cancelled when the client goes away; WebSocket calls are
+// bounded per message.
+func SetQueryTimeouts(t config.QueryTimeouts) {
+	timeouts = t
+}
+
+// withTimeout derives the context for database calls from parent, bounded
+// by timeout when it is set
+func withTimeout(parent context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return context.WithCancel(parent)
+	}
+	return context.WithTimeout(parent, timeout)
+}
@@ -1,11 +1,23 @@
 package handlers
 
 import (
-	"log"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log/slog"
 	"net/http"
 	"poker-planning-api/db"
+	"poker-planning-api/joincode"
+	"poker-planning-api/logging"
+	"poker-planning-api/metrics"
+	"poker-planning-api/middleware"
 	"poker-planning-api/models"
-	"strings"
+	"poker-planning-api/protocol"
+	"poker-planning-api/validate"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/gorilla/mux"
@@ -13,357 +25,872 @@ import (
 )
 
 var upgrader = websocket.Upgrader{
+	// HandleWebSocket checks first so it can log and answer with a problem;
+	// this keeps the upgrade safe should that check ever be bypassed
 	CheckOrigin: func(r *http.Request) bool {
-		return true // Allow all origins for development
+		return origins.AllowRequest(r)
 	},
 }
 
-// JoinSessionMessage represents the initial message to join a session
-type JoinSessionMessage struct {
-	UserName string `json:"userName"`
-	UserID   string `json:"userId,omitempty"`
-}
+// maxMessageSize bounds the frames a client may send, well above the
+// largest valid message: a join with a name of validate.MaxNameLength
+// characters, each escaped in JSON
+const maxMessageSize = 16 << 10
+
+// joinTimeout bounds the wait for a client's join message after the
+// upgrade
+const joinTimeout = 10 * time.Second
 
-// VoteMessage represents a vote submission
-type VoteMessage struct {
-	ItemID string `json:"itemId"`
-	Vote   string `json:"vote"`
+// origins is the policy set by SetOriginPolicy, shared with CORS
+var origins = middleware.NewOriginPolicy(nil, false)
+
+// SetOriginPolicy sets the origins allowed to open WebSocket connections
+func SetOriginPolicy(policy *middleware.OriginPolicy) {
+	origins = policy
 }
 
-// ErrorMessage represents an error message to send to client
-type ErrorMessage struct {
-	Error string `json:"error"`
+// errUserNameTaken rejects a join under a name another user of the
+// session already has
+var errUserNameTaken = errors.New("user name taken")
+
+// createUser stores a user joining a session unless the name is taken
+// (case-insensitive). The session stays locked from the check to the
+// insert, so two joins cannot claim the same name.
+func createUser(ctx context.Context, user *models.User, sessionID string) error {
+	return store.WithTx(ctx, func(tx db.Store) error {
+		if err := tx.LockSession(ctx, sessionID); err != nil {
+			return err
+		}
+		taken, err := tx.IsUserNameTaken(ctx, sessionID, user.Name, user.ID)
+		if err != nil {
+			return err
+		}
+		if taken {
+			return errUserNameTaken
+		}
+		return tx.CreateUser(ctx, user, sessionID)
+	})
 }
 
-// isUserNameTaken checks if a username is already taken in the session (case-insensitive)
-func isUserNameTaken(sessionID, userName string, excludeUserID string) bool {
-	taken, err := db.IsUserNameTaken(sessionID, userName, excludeUserID)
-	if err != nil {
-		log.Printf("Error checking username: %v", err)
-		return false
+// rejectCreateUser answers a join whose createUser call failed
+func rejectCreateUser(conn *websocket.Conn, err error, logger *slog.Logger) {
+	if errors.Is(err, errUserNameTaken) {
+		rejectJoin(conn, "Username is already taken in this session")
+		return
 	}
-	return taken
+	logger.Error("failed to create user", logging.Err(err))
+	failJoin(conn, "Failed to create user")
 }
 
 // HandleWebSocket handles WebSocket connections for real-time updates
 func HandleWebSocket(w http.ResponseWriter, r *http.Request) {
+	if !addConnection() {
+		writeProblem(w, r, http.StatusServiceUnavailable, models.CodeShuttingDown, "The server is restarting; reconnect shortly")
+		return
+	}
+	defer connections.Done()
+
 	vars := mux.Vars(r)
 	sessionID := vars["sessionId"]
+	logger := logging.FromContext(r.Context()).With(logging.KeySessionID, sessionID)
+
+	// Browsers send cookies and credentials with cross-site WebSocket
+	// handshakes and do not apply CORS to them
+	if !origins.AllowRequest(r) {
+		origin := r.Header.Get("Origin")
+		logger.Warn("websocket origin rejected", "origin", origin)
+		writeProblem(w, r, http.StatusForbidden, models.CodeOriginNotAllowed, "Origin "+origin+" is not allowed")
+		return
+	}
 
-	session, exists := GetSessionByID(sessionID)
-	if !exists {
-		http.Error(w, "Session not found", http.StatusNotFound)
+	ctx, cancel := withTimeout(r.Context(), timeouts.Read)
+	defer cancel()
+
+	// /ws/{sessionId} also accepts a join code; session IDs are UUIDs and
+	// never look like one
+	if code, ok := joincode.Normalize(sessionID); ok {
+		id, err := store.GetSessionIDByJoinCode(ctx, code)
+		if err != nil {
+			writeDBError(w, r, err, models.CodeJoinCodeNotFound, "resolve join code")
+			return
+		}
+		sessionID = id
+		logger = logging.FromContext(r.Context()).With(logging.KeySessionID, sessionID)
+	}
+
+	session, err := loadSession(ctx, sessionID)
+	if err != nil {
+		writeDBError(w, r, err, models.CodeSessionNotFound, "load session")
+		return
+	}
+	session.Mutex.RLock()
+	archived := session.ArchivedAt != nil
+	authRequired := session.AuthRequired
+	session.Mutex.RUnlock()
+	if archived {
+		writeProblem(w, r, http.StatusGone, models.CodeSessionArchived, "The session has been archived")
+		return
+	}
+
+	// A signed-in caller is known from the handshake, where browsers send
+	// the sign-in cookie
+	account, ok := admitCaller(ctx, w, r, session)
+	if !ok {
 		return
 	}
 
 	conn, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
-		log.Printf("Failed to upgrade connection: %v", err)
+		logger.Warn("failed to upgrade connection", logging.Err(err))
 		return
 	}
+	// The message rate limit counts frames; this bounds their size
+	conn.SetReadLimit(maxMessageSize)
 
-	// Wait for the join message
-	var joinMsg JoinSessionMessage
+	// Wait for the join message. Until the user joins, Shutdown does not
+	// know the connection, so the wait is bounded to let it drain.
+	var joinMsg protocol.JoinMessage
+	conn.SetReadDeadline(time.Now().Add(joinTimeout))
 	if err := conn.ReadJSON(&joinMsg); err != nil {
-		log.Printf("Failed to read join message: %v", err)
+		logger.Warn("failed to read join message", logging.Err(err))
 		conn.Close()
 		return
 	}
+	conn.SetReadDeadline(time.Time{})
 
-	// Validate username is not empty
-	if strings.TrimSpace(joinMsg.UserName) == "" {
-		errorMsg := models.WSMessage{
-			Type:    "error",
-			Payload: map[string]string{"error": "Username cannot be empty"},
-		}
-		conn.WriteJSON(errorMsg)
+	version, err := protocol.Negotiate(joinMsg.ProtocolVersion)
+	if err != nil {
+		rejectJoin(conn, err.Error())
+		return
+	}
+
+	userNameRule := validate.Name
+	userNameRule.Required = joinMsg.AccountID == "" && account == nil
+	var v validate.Validator
+	v.Text("userName", "Username", &joinMsg.UserName, userNameRule)
+	if joinMsg.UserID != "" {
+		v.ID("userId", "User ID", joinMsg.UserID)
+	}
+	if joinMsg.AccountID != "" {
+		v.ID("accountId", "Account ID", joinMsg.AccountID)
+	}
+	if v.Errors() != nil {
+		sendError(conn, validationError(&v))
 		conn.Close()
 		return
 	}
 
-	// Create or retrieve user
+	// The join is bounded on its own, after the wait for the join message
+	joinCtx, cancelJoin := withTimeout(r.Context(), timeouts.Write)
+	defer cancelJoin()
+
+	if account != nil && joinMsg.AccountID != "" && joinMsg.AccountID != account.ID {
+		rejectJoin(conn, "The account ID is not the signed-in account")
+		return
+	}
+	if account == nil && joinMsg.AccountID != "" {
+		account, err = store.GetAccount(joinCtx, joinMsg.AccountID)
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			rejectJoin(conn, "Unknown account")
+			return
+		case err != nil:
+			logger.Error("failed to load account", logging.Err(err))
+			failJoin(conn, "Failed to load account")
+			return
+		}
+		if account.Provider != "" {
+			rejectJoin(conn, "Sign in to join as this account")
+			return
+		}
+	}
+	if account != nil {
+		if joinMsg.UserName == "" {
+			joinMsg.UserName = account.DisplayName
+		}
+
+		// An account that joined before rejoins as the same participant
+		if joinMsg.UserID == "" {
+			existing, err := store.GetSessionUserByAccount(joinCtx, sessionID, account.ID)
+			switch {
+			case err == nil:
+				joinMsg.UserID = existing.ID
+			case !errors.Is(err, sql.ErrNoRows):
+				logger.Error("failed to load user", logging.Err(err))
+				failJoin(conn, "Failed to load user")
+				return
+			}
+		}
+	}
+
+	// Create or retrieve user. New participants are given a resume token
+	// to rejoin with, sent only in their welcome message.
 	var user *models.User
+	var resumeToken string
 	if joinMsg.UserID != "" {
 		// Existing user reconnecting
-		existingUser, err := db.GetUserByID(joinMsg.UserID)
-		if err == nil {
+		existingUser, err := store.GetUserByID(joinCtx, joinMsg.UserID)
+		switch {
+		case err == nil && existingUser.SessionID != sessionID:
+			// A participant of another session, perhaps its host, cannot
+			// be taken into this one
+			rejectJoin(conn, "The user ID belongs to another session")
+			return
+		case err == nil:
+			// Participants of a session for signed-in users are bound to
+			// their account
+			if authRequired && existingUser.AccountID != account.ID {
+				rejectJoin(conn, "Sign in as this participant's account to rejoin")
+				return
+			}
+			if !mayResume(session, existingUser, joinMsg, account) {
+				// A participant joined before resume tokens proves nothing
+				// more than its ID; it may claim a token with it once
+				claimed := false
+				if isLegacyUser(session, existingUser) {
+					resumeToken, claimed, err = claimResumeToken(joinCtx, existingUser)
+					if err != nil {
+						logger.Error("failed to claim resume token", logging.Err(err))
+						failJoin(conn, "Failed to load user")
+						return
+					}
+				}
+				if !claimed {
+					rejectJoin(conn, "Rejoin with the participant's resume token or account")
+					return
+				}
+				logger.Info("legacy participant claimed a resume token", logging.KeyUserID, existingUser.ID)
+			}
 			user = existingUser
 			user.Conn = conn
 			user.Connected = true
-			db.UpdateUserConnection(user.ID, true)
-			session.Users[user.ID] = user
-		} else {
-			// User ID provided but not found, check for duplicate username
-			if isUserNameTaken(sessionID, joinMsg.UserName, joinMsg.UserID) {
-				errorMsg := models.WSMessage{
-					Type:    "error",
-					Payload: map[string]string{"error": "Username is already taken in this session"},
-				}
-				conn.WriteJSON(errorMsg)
-				conn.Close()
+			user.ProtocolVersion = version
+			if err := store.UpdateUserConnection(joinCtx, user.ID, true); err != nil {
+				logger.Error("failed to mark user connected", logging.Err(err))
+			}
+		case !errors.Is(err, sql.ErrNoRows):
+			logger.Error("failed to load user", logging.Err(err))
+			failJoin(conn, "Failed to load user")
+			return
+		default:
+			// User ID provided but not found, create new user. Only the
+			// host key makes it the host.
+			isHost := joinMsg.UserID == session.HostID
+			if isHost && !keyMatches(joinMsg.HostKey, session.HostKeyHash) {
+				rejectJoin(conn, "Join as the host with the host key")
 				return
 			}
-			// User ID provided but not found, create new user
 			user = &models.User{
 				ID:        joinMsg.UserID,
+				SessionID: sessionID,
 				Name:      joinMsg.UserName,
-				IsHost:    joinMsg.UserID == session.HostID,
+				IsHost:    isHost,
 				Connected: true,
 				Conn:      conn,
+
+				ProtocolVersion: version,
 			}
-			if err := db.CreateUser(user, sessionID); err != nil {
-				log.Printf("Failed to create user: %v", err)
-				errorMsg := models.WSMessage{
-					Type:    "error",
-					Payload: map[string]string{"error": "Failed to create user"},
-				}
-				conn.WriteJSON(errorMsg)
-				conn.Close()
+			linkAccount(user, account)
+			if resumeToken, err = issueResumeToken(user); err != nil {
+				logger.Error("failed to generate resume token", logging.Err(err))
+				failJoin(conn, "Failed to create user")
 				return
 			}
-			session.Users[user.ID] = user
-		}
-	} else {
-		// New user joining - check for duplicate username
-		if isUserNameTaken(sessionID, joinMsg.UserName, "") {
-			errorMsg := models.WSMessage{
-				Type:    "error",
-				Payload: map[string]string{"error": "Username is already taken in this session"},
+			if err := createUser(joinCtx, user, sessionID); err != nil {
+				rejectCreateUser(conn, err, logger)
+				return
 			}
-			conn.WriteJSON(errorMsg)
-			conn.Close()
-			return
 		}
-
+	} else {
 		// New user joining
 		user = &models.User{
 			ID:        uuid.New().String(),
+			SessionID: sessionID,
 			Name:      joinMsg.UserName,
 			IsHost:    false,
 			Connected: true,
 			Conn:      conn,
+
+			ProtocolVersion: version,
 		}
-		if err := db.CreateUser(user, sessionID); err != nil {
-			log.Printf("Failed to create user: %v", err)
-			errorMsg := models.WSMessage{
-				Type:    "error",
-				Payload: map[string]string{"error": "Failed to create user"},
-			}
-			conn.WriteJSON(errorMsg)
-			conn.Close()
+		linkAccount(user, account)
+		if resumeToken, err = issueResumeToken(user); err != nil {
+			logger.Error("failed to generate resume token", logging.Err(err))
+			failJoin(conn, "Failed to create user")
+			return
+		}
+		if err := createUser(joinCtx, user, sessionID); err != nil {
+			rejectCreateUser(conn, err, logger)
 			return
 		}
-		session.Users[user.ID] = user
 	}
 
-	// Send welcome message with user info and session state
+	logger = logger.With(logging.KeyUserID, user.ID)
+
+	// Register the user and send the welcome message while holding the
+	// connection's write lock, so no broadcast can reach the client first
+	lock := connWriteLock(conn)
+	lock.Lock()
+	session.AddUser(user)
+	session.Touch()
 	welcomeMsg := models.WSMessage{
-		Type: "welcome",
-		Payload: map[string]interface{}{
-			"userId":  user.ID,
-			"session": session,
+		Type: protocol.TypeWelcome,
+		Payload: protocol.WelcomePayload{
+			UserID:          user.ID,
+			Session:         session,
+			ProtocolVersion: version,
+			ResumeToken:     resumeToken,
 		},
 	}
-	if err := conn.WriteJSON(welcomeMsg); err != nil {
-		log.Printf("Failed to send welcome message: %v", err)
+	session.Mutex.RLock()
+	welcome, err := json.Marshal(welcomeMsg)
+	session.Mutex.RUnlock()
+	if err == nil {
+		err = conn.WriteMessage(websocket.TextMessage, welcome)
+	}
+	lock.Unlock()
+	if err != nil {
+		logger.Warn("failed to send welcome message", logging.Err(err))
 	}
+	logger.Info("websocket joined", "user_name", user.Name, "host", user.IsHost, "protocol_version", version)
 
 	// Broadcast user joined to all other users
 	BroadcastToSession(sessionID, models.WSMessage{
-		Type:    "user_joined",
+		Type:    protocol.TypeUserJoined,
 		Payload: user,
 	})
 
-	// Handle incoming messages
-	go handleMessages(conn, session, user)
+	// Handle incoming messages. This handler's own count keeps the
+	// WaitGroup above zero, so Shutdown cannot be waiting on an empty one.
+	connections.Add(1)
+	metrics.WebSocketConnections.Inc()
+	// The request's context ends when this handler returns, so messages
+	// keep only its values
+	go handleMessages(context.WithoutCancel(r.Context()), conn, session, user, logger)
+}
+
+// mayResume reports whether a join proves it may take back an existing
+// participant, whose ID everyone in the session sees: by the participant's
+// resume token or account, or for the host by the host key
+func mayResume(session *models.Session, user *models.User, joinMsg protocol.JoinMessage, account *models.Account) bool {
+	if account != nil && user.AccountID == account.ID {
+		return true
+	}
+	if keyMatches(joinMsg.ResumeToken, user.ResumeTokenHash) {
+		return true
+	}
+	session.Mutex.RLock()
+	defer session.Mutex.RUnlock()
+	return user.ID == session.HostID && keyMatches(joinMsg.HostKey, session.HostKeyHash)
+}
+
+// isLegacyUser reports whether the user joined before resume tokens and
+// has nothing else to rejoin with: no account and, for the host of a
+// session created before host keys, no host key
+func isLegacyUser(session *models.Session, user *models.User) bool {
+	if len(user.ResumeTokenHash) > 0 || user.AccountID != "" {
+		return false
+	}
+	session.Mutex.RLock()
+	defer session.Mutex.RUnlock()
+	return user.ID != session.HostID || len(session.HostKeyHash) == 0
+}
+
+// claimResumeToken issues a resume token to a legacy user, unless another
+// join claimed one first
+func claimResumeToken(ctx context.Context, user *models.User) (string, bool, error) {
+	token, err := newKey()
+	if err != nil {
+		return "", false, err
+	}
+	hash := hashKey(token)
+	claimed, err := store.ClaimResumeToken(ctx, user.ID, hash)
+	if err != nil || !claimed {
+		return "", false, err
+	}
+	user.ResumeTokenHash = hash
+	return token, true, nil
+}
+
+// issueResumeToken gives a new participant the token it rejoins with,
+// storing only its hash
+func issueResumeToken(user *models.User) (string, error) {
+	token, err := newKey()
+	if err != nil {
+		return "", err
+	}
+	user.ResumeTokenHash = hashKey(token)
+	return token, nil
+}
+
+// rejectJoin sends an error message to a client that could not join and
+// closes the connection
+func rejectJoin(conn *websocket.Conn, reason string) {
+	sendError(conn, protocol.ErrorPayload{Error: reason})
+	conn.Close()
+}
+
+// failJoin answers a join the server failed to serve, e.g. while the
+// database is down. Its code tells clients the join may succeed if they
+// retry it, unlike a rejection.
+func failJoin(conn *websocket.Conn, reason string) {
+	sendError(conn, protocol.ErrorPayload{Error: reason, Code: models.CodeInternal})
+	conn.Close()
 }
 
-func handleMessages(conn *websocket.Conn, session *models.Session, user *models.User) {
+// sendError tells one client that its request was rejected
+func sendError(conn *websocket.Conn, payload protocol.ErrorPayload) error {
+	return writeMessage(conn, models.WSMessage{Type: protocol.TypeError, Payload: payload})
+}
+
+// validationError describes the fields rejected by v
+func validationError(v *validate.Validator) protocol.ErrorPayload {
+	return protocol.ErrorPayload{
+		Error:  v.Message(),
+		Code:   models.CodeValidationFailed,
+		Errors: v.Errors(),
+	}
+}
+
+// decodeMessage decodes the payload of msg, telling the sender when it is
+// malformed
+func decodeMessage(user *models.User, msg protocol.Frame, v interface{}) bool {
+	if err := msg.DecodePayload(v); err != nil {
+		sendError(user.Conn, protocol.ErrorPayload{Error: err.Error(), Code: models.CodeInvalidBody})
+		return false
+	}
+	return true
+}
+
+// rejectInvalid tells the sender which fields v rejected. It reports
+// whether every field passed.
+func rejectInvalid(user *models.User, v *validate.Validator) bool {
+	if v.Errors() == nil {
+		return true
+	}
+	sendError(user.Conn, validationError(v))
+	return false
+}
+
+// requireItem reports whether the item belongs to the session, telling
+// the sender when it does not
+func requireItem(ctx context.Context, session *models.Session, user *models.User, itemID string, logger *slog.Logger) bool {
+	err := checkSessionItem(ctx, session, itemID)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		sendError(user.Conn, protocol.ErrorPayload{Error: "The item is not in this session", Code: models.CodeItemNotFound})
+		return false
+	case err != nil:
+		logger.Error("failed to load item", logging.KeyItemID, itemID, logging.Err(err))
+		return false
+	}
+	return true
+}
+
+func handleMessages(ctx context.Context, conn *websocket.Conn, session *models.Session, user *models.User, logger *slog.Logger) {
+	defer connections.Done()
+	defer metrics.WebSocketConnections.Dec()
 	defer func() {
-		// Mark user as disconnected in database
-		db.UpdateUserConnection(user.ID, false)
-		delete(session.Users, user.ID)
 		conn.Close()
+		connWriteLocks.Delete(conn)
+
+		// A reconnect may already have replaced this connection; only the
+		// current one marks the user as gone
+		if !session.DetachUser(user) {
+			logger.Debug("websocket replaced by a newer connection")
+			return
+		}
+		logger.Info("websocket left")
+
+		// Mark user as disconnected in database
+		leaveCtx, cancel := withTimeout(ctx, timeouts.Write)
+		defer cancel()
+		if err := store.UpdateUserConnection(leaveCtx, user.ID, false); err != nil {
+			logger.Error("failed to mark user disconnected", logging.Err(err))
+		}
+
+		session.Touch()
+
+		// Everyone is being disconnected; nobody is left to tell. The same
+		// goes for a session evicted by an operator.
+		if shuttingDown.Load() || !isCached(session) {
+			return
+		}
 
 		// Broadcast user left
 		BroadcastToSession(session.ID, models.WSMessage{
-			Type:    "user_left",
-			Payload: map[string]string{"userId": user.ID},
+			Type:    protocol.TypeUserLeft,
+			Payload: protocol.UserLeftPayload{UserID: user.ID},
 		})
 	}()
 
+	// Shutdown may have taken its snapshot of connections just before this
+	// user was added
+	if shuttingDown.Load() {
+		sendRestart(conn)
+	}
+
+	limiter := newMessageLimiter()
 	for {
-		var msg models.WSMessage
+		var msg protocol.Frame
 		if err := conn.ReadJSON(&msg); err != nil {
-			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
-				log.Printf("WebSocket error: %v", err)
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway,
+				websocket.CloseAbnormalClosure, websocket.CloseServiceRestart) {
+				logger.Warn("websocket read failed", logging.Err(err))
 			}
 			break
 		}
 
-		handleMessage(session, user, msg)
+		if limiter != nil && !limiter.Allow() {
+			disconnectFlooder(conn, logger)
+			break
+		}
+
+		session.Touch()
+		// A slow database delays this client's next message by at most
+		// the write timeout
+		msgCtx, cancel := withTimeout(logging.WithLogger(ctx, logger), timeouts.Write)
+		handleMessage(msgCtx, session, user, msg, logger)
+		cancel()
 	}
 }
 
-func handleMessage(session *models.Session, user *models.User, msg models.WSMessage) {
+// CloseReasonRateLimited is the close frame reason sent to clients that
+// exceed the message rate
+const CloseReasonRateLimited = "too many messages"
+
+// disconnectFlooder tells a client that exceeded the message rate why it
+// is being dropped. The caller closes the connection.
+func disconnectFlooder(conn *websocket.Conn, logger *slog.Logger) {
+	metrics.RateLimited.WithLabelValues(metrics.LimitMessages).Inc()
+	logger.Warn("websocket rate limited, disconnecting")
+
+	sendError(conn, protocol.ErrorPayload{
+		Error: "Too many messages; you have been disconnected",
+		Code:  models.CodeRateLimited,
+	})
+	closeFrame := websocket.FormatCloseMessage(websocket.ClosePolicyViolation, CloseReasonRateLimited)
+	conn.WriteControl(websocket.CloseMessage, closeFrame, time.Now().Add(time.Second))
+}
+
+func handleMessage(ctx context.Context, session *models.Session, user *models.User, msg protocol.Frame, logger *slog.Logger) {
+	label := msg.Type
 	switch msg.Type {
-	case "vote":
-		handleVote(session, user, msg)
-	case "reveal_votes":
-		handleRevealVotes(session, user, msg)
-	case "reset_votes":
-		handleResetVotes(session, user, msg)
-	case "set_final_estimate":
-		handleSetFinalEstimate(session, user, msg)
+	case protocol.TypeVote:
+		handleVote(ctx, session, user, msg, logger)
+	case protocol.TypeRevealVotes:
+		handleRevealVotes(ctx, session, user, msg, logger)
+	case protocol.TypeResetVotes:
+		handleResetVotes(ctx, session, user, msg, logger)
+	case protocol.TypeSetFinalEstimate:
+		handleSetFinalEstimate(ctx, session, user, msg, logger)
 	default:
-		log.Printf("Unknown message type: %s", msg.Type)
+		label = metrics.UnknownType
+		logger.Warn("unknown message type", "type", msg.Type)
 	}
+	metrics.WebSocketMessages.WithLabelValues(label).Inc()
 }
 
-func handleVote(session *models.Session, user *models.User, msg models.WSMessage) {
-	payload, ok := msg.Payload.(map[string]interface{})
-	if !ok {
+func handleVote(ctx context.Context, session *models.Session, user *models.User, msg protocol.Frame, logger *slog.Logger) {
+	var payload protocol.VotePayload
+	if !decodeMessage(user, msg, &payload) {
 		return
 	}
 
-	itemID, _ := payload["itemId"].(string)
-	vote, _ := payload["vote"].(string)
-
-	if itemID == "" || vote == "" {
+	var v validate.Validator
+	v.ID("itemId", "Item ID", payload.ItemID)
+	v.Text("vote", "Vote", &payload.Vote, validate.Estimate)
+	session.Mutex.RLock()
+	settings := session.Settings
+	session.Mutex.RUnlock()
+	if v.Errors() == nil && !settings.AllowsVote(payload.Vote) {
+		v.Add("vote", models.FieldInvalidFormat, "Vote must be a card of the session's deck")
+	}
+	if !rejectInvalid(user, &v) || !requireItem(ctx, session, user, payload.ItemID, logger) {
 		return
 	}
 
 	// Save vote to database
-	if err := db.SaveVote(itemID, user.ID, vote); err != nil {
-		log.Printf("Failed to save vote: %v", err)
+	if err := store.SaveVote(ctx, payload.ItemID, user.ID, payload.Vote); err != nil {
+		logger.Error("failed to save vote", logging.KeyItemID, payload.ItemID, logging.Err(err))
 		return
 	}
+	metrics.VotesCast.Inc()
 
 	// Broadcast vote update (without revealing the vote value)
 	BroadcastToSession(session.ID, models.WSMessage{
-		Type: "vote_submitted",
-		Payload: map[string]interface{}{
-			"itemID":   itemID,
-			"userId":   user.ID,
-			"hasVoted": true,
+		Type: protocol.TypeVoteSubmitted,
+		Payload: protocol.VoteSubmittedPayload{
+			ItemID:   payload.ItemID,
+			UserID:   user.ID,
+			HasVoted: true,
 		},
 	})
+
+	if settings.AutoReveal {
+		autoReveal(ctx, session, payload.ItemID, logger)
+	}
+}
+
+// autoReveal reveals an item once every connected participant has voted on
+// it, for sessions with AutoReveal set
+func autoReveal(ctx context.Context, session *models.Session, itemID string, logger *slog.Logger) {
+	revealVotes(ctx, session, itemID, func(item *models.PlanningItem) bool {
+		if item.Revealed {
+			return false
+		}
+		for _, user := range session.ConnectedUsers() {
+			if _, voted := item.Votes[user.ID]; !voted {
+				return false
+			}
+		}
+		return true
+	}, logger)
 }
 
-func handleRevealVotes(session *models.Session, user *models.User, msg models.WSMessage) {
+func handleRevealVotes(ctx context.Context, session *models.Session, user *models.User, msg protocol.Frame, logger *slog.Logger) {
 	if !user.IsHost {
 		return
 	}
 
-	payload, ok := msg.Payload.(map[string]interface{})
-	if !ok {
+	var payload protocol.ItemPayload
+	if !decodeMessage(user, msg, &payload) {
 		return
 	}
 
-	itemID, _ := payload["itemId"].(string)
-	if itemID == "" {
+	var v validate.Validator
+	v.ID("itemId", "Item ID", payload.ItemID)
+	if !rejectInvalid(user, &v) || !requireItem(ctx, session, user, payload.ItemID, logger) {
 		return
 	}
 
-	// Update in database
-	if err := db.UpdateItemRevealed(itemID, true); err != nil {
-		log.Printf("Failed to reveal votes: %v", err)
-		return
-	}
+	revealVotes(ctx, session, payload.ItemID, nil, logger)
+}
 
-	// Get the updated item with votes from database
-	item, err := db.GetPlanningItemByID(itemID)
+// revealVotes reveals an item's votes and broadcasts them. With when set,
+// the item is revealed only if when accepts it as read in the transaction,
+// which sees every vote cast and serializes with other reveals.
+func revealVotes(ctx context.Context, session *models.Session, itemID string, when func(*models.PlanningItem) bool, logger *slog.Logger) {
+	// Reveal and read back the votes in one transaction, so the broadcast
+	// shows exactly the votes that were revealed
+	var item *models.PlanningItem
+	revealed := false
+	err := store.WithTx(ctx, func(tx db.Store) error {
+		if when != nil {
+			if err := tx.LockSession(ctx, session.ID); err != nil {
+				return err
+			}
+			current, err := tx.GetPlanningItemByID(ctx, itemID)
+			if err != nil || !when(current) {
+				return err
+			}
+		}
+		if err := tx.UpdateItemRevealed(ctx, itemID, true); err != nil {
+			return err
+		}
+		var err error
+		item, err = tx.GetPlanningItemByID(ctx, itemID)
+		revealed = err == nil
+		return err
+	})
 	if err != nil {
-		log.Printf("Failed to get item: %v", err)
+		logger.Error("failed to reveal votes", logging.KeyItemID, itemID, logging.Err(err))
+		return
+	}
+	if !revealed {
 		return
 	}
+	metrics.Reveals.Inc()
 
 	BroadcastToSession(session.ID, models.WSMessage{
-		Type:    "votes_revealed",
+		Type:    protocol.TypeVotesRevealed,
 		Payload: item,
 	})
 }
 
-func handleResetVotes(session *models.Session, user *models.User, msg models.WSMessage) {
+func handleResetVotes(ctx context.Context, session *models.Session, user *models.User, msg protocol.Frame, logger *slog.Logger) {
 	if !user.IsHost {
 		return
 	}
 
-	payload, ok := msg.Payload.(map[string]interface{})
-	if !ok {
-		return
-	}
-
-	itemID, _ := payload["itemId"].(string)
-	if itemID == "" {
+	var payload protocol.ItemPayload
+	if !decodeMessage(user, msg, &payload) {
 		return
 	}
 
-	// Delete all votes from database
-	if err := db.DeleteItemVotes(itemID); err != nil {
-		log.Printf("Failed to delete votes: %v", err)
+	var v validate.Validator
+	v.ID("itemId", "Item ID", payload.ItemID)
+	if !rejectInvalid(user, &v) || !requireItem(ctx, session, user, payload.ItemID, logger) {
 		return
 	}
 
-	// Update revealed status
-	if err := db.UpdateItemRevealed(itemID, false); err != nil {
-		log.Printf("Failed to update revealed status: %v", err)
+	// Delete the votes and hide the item together, so a failure cannot
+	// leave a revealed item without votes
+	err := store.WithTx(ctx, func(tx db.Store) error {
+		if err := tx.DeleteItemVotes(ctx, payload.ItemID); err != nil {
+			return err
+		}
+		return tx.UpdateItemRevealed(ctx, payload.ItemID, false)
+	})
+	if err != nil {
+		logger.Error("failed to reset votes", logging.KeyItemID, payload.ItemID, logging.Err(err))
 		return
 	}
 
 	BroadcastToSession(session.ID, models.WSMessage{
-		Type:    "votes_reset",
-		Payload: map[string]string{"itemId": itemID},
+		Type:    protocol.TypeVotesReset,
+		Payload: protocol.ItemPayload{ItemID: payload.ItemID},
 	})
 }
 
-func handleSetFinalEstimate(session *models.Session, user *models.User, msg models.WSMessage) {
+func handleSetFinalEstimate(ctx context.Context, session *models.Session, user *models.User, msg protocol.Frame, logger *slog.Logger) {
 	if !user.IsHost {
 		return
 	}
 
-	payload, ok := msg.Payload.(map[string]interface{})
-	if !ok {
+	var payload protocol.FinalEstimatePayload
+	if !decodeMessage(user, msg, &payload) {
 		return
 	}
 
-	itemID, _ := payload["itemId"].(string)
-	estimate, _ := payload["estimate"].(string)
-
-	if itemID == "" {
+	var v validate.Validator
+	v.ID("itemId", "Item ID", payload.ItemID)
+	v.Text("estimate", "Estimate", &payload.Estimate, validate.FinalEstimate)
+	if !rejectInvalid(user, &v) || !requireItem(ctx, session, user, payload.ItemID, logger) {
 		return
 	}
 
 	// Update in database
-	if err := db.UpdateItemFinalEstimate(itemID, estimate); err != nil {
-		log.Printf("Failed to set final estimate: %v", err)
+	if err := store.UpdateItemFinalEstimate(ctx, payload.ItemID, payload.Estimate); err != nil {
+		logger.Error("failed to set final estimate", logging.KeyItemID, payload.ItemID, logging.Err(err))
 		return
 	}
 
 	BroadcastToSession(session.ID, models.WSMessage{
-		Type: "final_estimate_set",
-		Payload: map[string]string{
-			"itemId":   itemID,
-			"estimate": estimate,
-		},
+		Type:    protocol.TypeFinalEstimateSet,
+		Payload: payload,
 	})
 }
 
-// BroadcastToSession sends a message to all connected users in a session
+// BroadcastToSession sends a message to all connected users in a session.
+// Sessions not held in memory have nobody connected and are skipped.
 func BroadcastToSession(sessionID string, msg models.WSMessage) {
-	session, exists := GetSessionByID(sessionID)
+	session, exists := cachedSession(sessionID)
 	if !exists {
 		return
 	}
 
-	session.Mutex.RLock()
-	defer session.Mutex.RUnlock()
+	start := time.Now()
+	for _, user := range session.ConnectedUsers() {
+		if err := writeMessage(user.Conn, protocol.ForVersion(user.ProtocolVersion, msg)); err != nil {
+			metrics.BroadcastMessages.WithLabelValues(msg.Type, metrics.ResultFailed).Inc()
+			slog.Warn("failed to deliver broadcast",
+				logging.KeySessionID, sessionID, logging.KeyUserID, user.ID, "type", msg.Type, logging.Err(err))
+			continue
+		}
+		metrics.BroadcastMessages.WithLabelValues(msg.Type, metrics.ResultSent).Inc()
+	}
+	metrics.BroadcastDuration.WithLabelValues(msg.Type).Observe(time.Since(start).Seconds())
+}
 
-	for _, user := range session.Users {
-		if user.Connected && user.Conn != nil {
-			if err := user.Conn.WriteJSON(msg); err != nil {
-				log.Printf("Failed to send message to user %s: %v", user.ID, err)
-			}
+// connections tracks WebSocket handlers and their handleMessages goroutines
+var connections sync.WaitGroup
+
+// connectionsMutex orders addConnection against Shutdown: a WaitGroup must
+// not go up from zero while Wait may be running
+var connectionsMutex sync.Mutex
+
+// addConnection counts a new WebSocket handler in connections, unless the
+// server is shutting down
+func addConnection() bool {
+	connectionsMutex.Lock()
+	defer connectionsMutex.Unlock()
+	if shuttingDown.Load() {
+		return false
+	}
+	connections.Add(1)
+	return true
+}
+
+// WaitForConnections blocks until every accepted WebSocket connection has
+// been torn down, including marking its user as disconnected
+func WaitForConnections() {
+	connections.Wait()
+}
+
+// CloseReasonRestart is the close frame reason sent on shutdown
+const CloseReasonRestart = "server restarting, reconnect"
+
+// shuttingDown is set by Shutdown; new joins are refused from then on
+var shuttingDown atomic.Bool
+
+// Shutdown refuses new WebSocket joins and sends every connected client a
+// close frame (1012, service restart) asking it to reconnect. It then waits
+// until each connection has been torn down and its user marked as
+// disconnected. When ctx expires first, the remaining connections are closed
+// without waiting for the client's reply and ctx.Err() is returned.
+func Shutdown(ctx context.Context) error {
+	connectionsMutex.Lock()
+	shuttingDown.Store(true)
+	connectionsMutex.Unlock()
+
+	var users []*models.User
+	sessionsMutex.RLock()
+	for _, session := range activeSessions {
+		users = append(users, session.ConnectedUsers()...)
+	}
+	sessionsMutex.RUnlock()
+
+	for _, user := range users {
+		sendRestart(user.Conn)
+	}
+	slog.Info("sent restart close frames", "connections", len(users))
+
+	done := make(chan struct{})
+	go func() {
+		connections.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		for _, user := range users {
+			user.Conn.Close()
 		}
+		return ctx.Err()
+	}
+}
+
+// sendRestart asks the client to close and reconnect later
+func sendRestart(conn *websocket.Conn) {
+	sendClose(conn, websocket.CloseServiceRestart, CloseReasonRestart)
+}
+
+// sendClose starts the closing handshake. The read loop ends when the
+// client answers with its own close frame.
+func sendClose(conn *websocket.Conn, code int, reason string) {
+	closeFrame := websocket.FormatCloseMessage(code, reason)
+	// WriteControl may be called concurrently with other writes
+	if err := conn.WriteControl(websocket.CloseMessage, closeFrame, time.Now().Add(time.Second)); err != nil {
+		conn.Close()
 	}
 }
+
+// connWriteLocks serializes writes per connection, since gorilla/websocket
+// supports only one concurrent writer
+var connWriteLocks sync.Map // *websocket.Conn -> *sync.Mutex
+
+func connWriteLock(conn *websocket.Conn) *sync.Mutex {
+	lock, _ := connWriteLocks.LoadOrStore(conn, &sync.Mutex{})
+	return lock.(*sync.Mutex)
+}
+
+// writeMessage sends a JSON message on a connection shared with other writers
+func writeMessage(conn *websocket.Conn, v interface{}) error {
+	lock := connWriteLock(conn)
+	lock.Lock()
+	defer lock.Unlock()
+	return conn.WriteJSON(v)
+}
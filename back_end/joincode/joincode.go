@@ -0,0 +1,53 @@
+// Package joincode generates the short codes participants type to join a
+// session, e.g. "K7M-QX4" read aloud in a meeting.
+//
+// Codes use an alphabet without look-alikes: no 0/O, 1/I/L or U/V. Input
+// is case-insensitive and may contain spaces or dashes.
+package joincode
+
+import (
+	"crypto/rand"
+	"math/big"
+	"strings"
+)
+
+// Length is the number of characters in a code
+const Length = 6
+
+// Alphabet lists the characters codes are drawn from
+const Alphabet = "23456789ABCDEFGHJKMNPQRSTWXYZ"
+
+// New returns a random code
+func New() (string, error) {
+	max := big.NewInt(int64(len(Alphabet)))
+	code := make([]byte, Length)
+	for i := range code {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+		code[i] = Alphabet[n.Int64()]
+	}
+	return string(code), nil
+}
+
+// Normalize returns the canonical form of input, as stored in the
+// database, and whether input is a well-formed code
+func Normalize(input string) (string, bool) {
+	code := strings.Map(func(r rune) rune {
+		if r == ' ' || r == '-' {
+			return -1
+		}
+		return r
+	}, strings.ToUpper(input))
+
+	if len(code) != Length {
+		return "", false
+	}
+	for i := 0; i < len(code); i++ {
+		if strings.IndexByte(Alphabet, code[i]) < 0 {
+			return "", false
+		}
+	}
+	return code, true
+}
@@ -0,0 +1,35 @@
+package joincode
+
+import "testing"
+
+func TestNewCodesAreNormalized(t *testing.T) {
+	for i := 0; i < 100; i++ {
+		code, err := New()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if normalized, ok := Normalize(code); !ok || normalized != code {
+			t.Fatalf("New returned %q, which normalizes to %q, %v", code, normalized, ok)
+		}
+	}
+}
+
+func TestNormalize(t *testing.T) {
+	for input, want := range map[string]string{
+		"K7MQX4":                               "K7MQX4",
+		"k7m-qx4":                              "K7MQX4",
+		" K7M QX4":                             "K7MQX4",
+		"K7MQX":                                "",
+		"K7MQX45":                              "",
+		"K7MQX0":                               "",
+		"K7MQXO":                               "",
+		"K7MQXI":                               "",
+		"K7MQXÉ":                               "",
+		"7c9e6679-7425-40de-944b-e07fc1f90ae7": "",
+	} {
+		got, ok := Normalize(input)
+		if got != want || ok != (want != "") {
+			t.Errorf("Normalize(%q) = %q, %v; want %q", input, got, ok, want)
+		}
+	}
+}
@@ -0,0 +1,76 @@
+// Package logging configures the structured logger and carries
+// request-scoped loggers through contexts.
+//
+// Attribute keys are shared across layers so that every event for one
+// session can be found with a single filter, e.g. session_id=<id>.
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+)
+
+// Attribute keys used across the server
+const (
+	KeyRequestID = "request_id"
+	KeySessionID = "session_id"
+	KeyUserID    = "user_id"
+	KeyItemID    = "item_id"
+	KeyQuery     = "query"
+	KeyError     = "error"
+)
+
+// Output formats
+const (
+	FormatJSON = "json"
+	FormatText = "text"
+)
+
+// Options selects the output format and minimum level
+type Options struct {
+	// Format is "json" or "text"
+	Format string
+	// Level is "debug", "info", "warn" or "error"
+	Level string
+}
+
+// New builds a logger writing to w
+func New(w io.Writer, opts Options) (*slog.Logger, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(opts.Level)); err != nil {
+		return nil, fmt.Errorf("invalid log level %q", opts.Level)
+	}
+
+	handlerOpts := &slog.HandlerOptions{Level: level}
+	switch strings.ToLower(opts.Format) {
+	case FormatJSON:
+		return slog.New(slog.NewJSONHandler(w, handlerOpts)), nil
+	case FormatText:
+		return slog.New(slog.NewTextHandler(w, handlerOpts)), nil
+	default:
+		return nil, fmt.Errorf("invalid log format %q (want %q or %q)", opts.Format, FormatJSON, FormatText)
+	}
+}
+
+type contextKey struct{}
+
+// WithLogger returns a copy of ctx carrying logger
+func WithLogger(ctx context.Context, logger *slog.Logger) context.Context {
+	return context.WithValue(ctx, contextKey{}, logger)
+}
+
+// FromContext returns the logger stored in ctx, or the default logger
+func FromContext(ctx context.Context) *slog.Logger {
+	if logger, ok := ctx.Value(contextKey{}).(*slog.Logger); ok {
+		return logger
+	}
+	return slog.Default()
+}
+
+// Err formats an error as a log attribute
+func Err(err error) slog.Attr {
+	return slog.Any(KeyError, err)
+}
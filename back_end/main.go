@@ -1,85 +1,117 @@
 package main
 
 import (
-	"log"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
 	"net/http"
 	"os"
+	"os/signal"
+	"poker-planning-api/auth"
+	"poker-planning-api/config"
 	"poker-planning-api/db"
-	"poker-planning-api/handlers"
+	"poker-planning-api/logging"
+	"poker-planning-api/server"
 	"strconv"
-	"strings"
-
-	"github.com/gorilla/mux"
-	"github.com/rs/cors"
+	"syscall"
 )
 
-func getEnv(key, defaultValue string) string {
-	value := os.Getenv(key)
-	if value == "" {
-		return defaultValue
-	}
-	return value
-}
-
 func main() {
-	// Initialize database connection from environment variables
-	dbPort, _ := strconv.Atoi(getEnv("DB_PORT", "5432"))
+	flags := config.BindFlags(flag.CommandLine)
+	printConfig := flag.Bool("print-config", false, "print the effective configuration, with secrets redacted, and exit")
+	flag.Parse()
 
-	dbConfig := db.Config{
-		Host:     getEnv("DB_HOST", "localhost"),
-		Port:     dbPort,
-		User:     getEnv("DB_USER", "postgres"),
-		Password: getEnv("DB_PASSWORD", "1234"),
-		DBName:   getEnv("DB_NAME", "poker_planning"),
-		SSLMode:  getEnv("DB_SSLMODE", "disable"), // Use 'require' for Neon, 'disable' for local
+	cfg, err := config.Load(flags)
+	if err != nil {
+		slog.Error("invalid configuration", logging.Err(err))
+		os.Exit(1)
+	}
+	if *printConfig {
+		fmt.Print(cfg)
+		return
 	}
 
-	// Try to connect to database, but don't fail if it doesn't work immediately
-	if err := db.InitDB(dbConfig); err != nil {
-		log.Printf("Warning: Failed to initialize database: %v", err)
-		log.Println("Server will start anyway. Database connection will be retried on first request.")
-	} else {
-		log.Println("Successfully connected to database")
-		defer db.CloseDB()
+	logger, err := logging.New(os.Stderr, logging.Options{
+		Format: cfg.Log.Format,
+		Level:  cfg.Log.Level,
+	})
+	if err != nil {
+		slog.Error("invalid logging configuration", logging.Err(err))
+		os.Exit(1)
 	}
+	slog.SetDefault(logger)
+	slog.Debug("configuration loaded", "env", cfg.Env, "config", cfg.String())
 
-	router := mux.NewRouter()
+	if cfg.Server.AllowAllOrigins {
+		slog.Warn("accepting requests and WebSocket connections from any origin; development only")
+	}
 
-	// Health check endpoint for Cloud Run
-	router.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-		w.WriteHeader(http.StatusOK)
-		w.Write([]byte("OK"))
-	}).Methods("GET")
+	// Production refuses an empty secret; in development sign-ins then
+	// last until the server restarts
+	if cfg.Auth.Enabled() && cfg.Auth.Secret == "" {
+		secret, err := auth.RandomString(32)
+		if err != nil {
+			slog.Error("failed to generate an auth secret", logging.Err(err))
+			os.Exit(1)
+		}
+		cfg.Auth.Secret = secret
+		slog.Warn("no auth secret configured; using a random one, so sign-ins end when the server restarts")
+	}
 
-	router.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
-		w.WriteHeader(http.StatusOK)
-		w.Write([]byte("Healthy"))
-	}).Methods("GET")
+	store, err := db.Open(cfg.Database)
+	if err != nil {
+		slog.Error("invalid database configuration", logging.Err(err))
+		os.Exit(1)
+	}
 
-	// API routes
-	router.HandleFunc("/api/sessions", handlers.CreateSession).Methods("POST")
-	router.HandleFunc("/api/sessions", handlers.GetSessions).Methods("GET")
-	router.HandleFunc("/api/sessions/{sessionId}", handlers.GetSession).Methods("GET")
-	router.HandleFunc("/api/sessions/{sessionId}/items", handlers.AddItem).Methods("POST")
-	router.HandleFunc("/api/sessions/{sessionId}/current-item", handlers.SetCurrentItem).Methods("POST")
+	// Cloud Run and Fly send SIGTERM on every deploy
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
-	// WebSocket route
-	router.HandleFunc("/ws/{sessionId}", handlers.HandleWebSocket)
+	// Serve right away and connect in the background; /ready reports 503
+	// and API calls fail with 503 until the database answers
+	go store.Connect(ctx, db.DefaultBackoff)
 
-	// CORS configuration - allow multiple origins
-	allowedOrigins := strings.Split(getEnv("ALLOWED_ORIGINS", "http://localhost:3000"), ",")
-	c := cors.New(cors.Options{
-		AllowedOrigins:   allowedOrigins,
-		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-		AllowedHeaders:   []string{"*"},
-		AllowCredentials: true,
+	// Build routes, CORS and middleware
+	handler := server.New(server.Options{
+		Store:           store,
+		AllowedOrigins:  cfg.Server.AllowedOrigins,
+		AllowAllOrigins: cfg.Server.AllowAllOrigins,
+		RateLimit:       cfg.RateLimit,
+		AdminToken:      cfg.Server.AdminToken,
+		QueryTimeouts:   cfg.Database.Timeouts,
+		Auth:            cfg.Auth,
+		Logger:          logger,
 	})
 
-	handler := c.Handler(router)
+	shutdownTimeout := cfg.Server.ShutdownTimeout
+	srv := &http.Server{Addr: ":" + strconv.Itoa(cfg.Server.Port), Handler: handler}
+
+	serveErr := make(chan error, 1)
+	go func() {
+		slog.Info("server starting", "addr", srv.Addr)
+		serveErr <- srv.ListenAndServe()
+	}()
+
+	select {
+	case err := <-serveErr:
+		slog.Error("server failed", logging.Err(err))
+		store.Close()
+		os.Exit(1)
+	case <-ctx.Done():
+	}
+	stop()
 
-	port := getEnv("PORT", "8080")
-	log.Printf("Server starting on :%s", port)
-	if err := http.ListenAndServe(":"+port, handler); err != nil {
-		log.Fatal("Server failed to start:", err)
+	// Stop accepting connections, ask WebSocket clients to reconnect, mark
+	// them disconnected and drain in-flight requests before closing the pool
+	slog.Info("shutting down", "timeout", shutdownTimeout.String())
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx, srv); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		slog.Warn("shutdown did not complete before the deadline", logging.Err(err))
 	}
+	store.Close()
+	slog.Info("shutdown complete")
 }
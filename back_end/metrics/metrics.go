@@ -0,0 +1,157 @@
+// Package metrics defines the Prometheus metrics exported on /metrics.
+//
+// Metric names and label values are part of the operational contract:
+// dashboards and alerts depend on them, so rename nothing without a
+// deprecation period. The full list is documented in README.md.
+package metrics
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "poker"
+
+// Broadcast results used as the "result" label of BroadcastMessages
+const (
+	ResultSent   = "sent"
+	ResultFailed = "failed"
+)
+
+// UnknownType labels WebSocket messages whose type the server does not
+// handle, so arbitrary client input cannot grow label cardinality
+const UnknownType = "unknown"
+
+// Limits used as the "limit" label of RateLimited
+const (
+	LimitRequests = "requests"
+	LimitSessions = "sessions"
+	LimitMessages = "messages"
+)
+
+var (
+	// ActiveSessions is the number of sessions held in memory
+	ActiveSessions = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "active_sessions",
+		Help:      "Sessions currently held in memory.",
+	})
+
+	// WebSocketConnections is the number of joined WebSocket connections
+	WebSocketConnections = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "websocket_connections",
+		Help:      "Open WebSocket connections that completed the join handshake.",
+	})
+
+	// WebSocketMessages counts client messages by type
+	WebSocketMessages = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "websocket_messages_total",
+		Help:      "WebSocket messages received from clients, by message type.",
+	}, []string{"type"})
+
+	// BroadcastMessages counts per-recipient deliveries by type and result
+	BroadcastMessages = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "broadcast_messages_total",
+		Help:      "Messages broadcast to session participants, by message type and result (sent or failed).",
+	}, []string{"type", "result"})
+
+	// BroadcastDuration observes the time to deliver a broadcast to every
+	// participant of a session
+	BroadcastDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "broadcast_duration_seconds",
+		Help:      "Time to deliver one broadcast to every participant of a session, by message type.",
+		Buckets:   []float64{.0001, .0005, .001, .005, .01, .05, .1, .5, 1},
+	}, []string{"type"})
+
+	// VotesCast counts accepted votes
+	VotesCast = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "votes_total",
+		Help:      "Votes cast and stored.",
+	})
+
+	// VoteBatchSize observes how many votes each batched write carries
+	VoteBatchSize = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "vote_batch_size",
+		Help:      "Votes written per batch when vote writes are batched.",
+		Buckets:   []float64{1, 2, 5, 10, 25, 50, 100, 250, 500},
+	})
+
+	// VotesDropped counts acknowledged votes that could not be written
+	VotesDropped = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "votes_dropped_total",
+		Help:      "Batched votes acknowledged to the voter but never written to the database.",
+	})
+
+	// Reveals counts reveal operations
+	Reveals = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "reveals_total",
+		Help:      "Votes revealed by a host.",
+	})
+
+	// DBQueryDuration observes database calls by query name
+	DBQueryDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "db_query_duration_seconds",
+		Help:      "Database query latency, by query name.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"query"})
+
+	// RateLimited counts requests, session creations and WebSocket
+	// messages rejected by a rate limit
+	RateLimited = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "rate_limited_total",
+		Help:      "Requests, session creations and WebSocket messages rejected by rate limits, by limit.",
+	}, []string{"limit"})
+)
+
+// Registry holds the application metrics plus the Go runtime and process
+// collectors. A dedicated registry keeps /metrics free of anything
+// libraries register on the global default.
+var Registry = prometheus.NewRegistry()
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		ActiveSessions,
+		WebSocketConnections,
+		WebSocketMessages,
+		BroadcastMessages,
+		BroadcastDuration,
+		VotesCast,
+		VoteBatchSize,
+		VotesDropped,
+		Reveals,
+		DBQueryDuration,
+		RateLimited,
+	)
+}
+
+// Handler serves the registry in the Prometheus exposition format
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{})
+}
+
+// ObserveQuery starts timing a database query. Call the returned function
+// when the query completes:
+//
+//	defer metrics.ObserveQuery("get_session")()
+func ObserveQuery(name string) func() {
+	start := time.Now()
+	return func() {
+		DBQueryDuration.WithLabelValues(name).Observe(time.Since(start).Seconds())
+	}
+}
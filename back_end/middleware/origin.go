@@ -0,0 +1,49 @@
+package middleware
+
+import (
+	"net/http"
+	"strings"
+)
+
+// OriginPolicy decides which browser origins may use the API. CORS and the
+// WebSocket upgrader share one policy so that the two cannot drift apart:
+// browsers do not apply CORS to WebSocket handshakes, so without the same
+// check there any site could open a connection with a visitor's session.
+type OriginPolicy struct {
+	allowed  map[string]bool
+	allowAll bool
+}
+
+// NewOriginPolicy allows the listed origins, e.g. "https://poker.example.com".
+// allowAll accepts every origin and is meant for local development only.
+func NewOriginPolicy(origins []string, allowAll bool) *OriginPolicy {
+	p := &OriginPolicy{allowed: map[string]bool{}, allowAll: allowAll}
+	for _, origin := range origins {
+		if origin == "*" {
+			p.allowAll = true
+			continue
+		}
+		p.allowed[normalizeOrigin(origin)] = true
+	}
+	return p
+}
+
+// Allowed reports whether a request from origin may proceed
+func (p *OriginPolicy) Allowed(origin string) bool {
+	return p.allowAll || p.allowed[normalizeOrigin(origin)]
+}
+
+// AllowRequest applies the policy to the request's Origin header. Requests
+// without one come from non-browser clients, which cannot be used for
+// cross-site attacks, and are allowed.
+func (p *OriginPolicy) AllowRequest(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	return origin == "" || p.Allowed(origin)
+}
+
+// normalizeOrigin makes configured and received origins comparable:
+// schemes and hosts are case-insensitive and a trailing slash is common in
+// configuration
+func normalizeOrigin(origin string) string {
+	return strings.TrimSuffix(strings.ToLower(strings.TrimSpace(origin)), "/")
+}
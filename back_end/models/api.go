@@ -0,0 +1,269 @@
+package models
+
+import "time"
+
+// CreateSessionRequest represents the request to create a new session
+type CreateSessionRequest struct {
+	Name     string `json:"name"`
+	HostName string `json:"hostName"`
+	// AuthRequired admits only participants signed in with a provider;
+	// the host must be signed in too
+	AuthRequired bool `json:"authRequired,omitempty"`
+	// TeamID creates the session under a team the caller is a member of
+	TeamID string `json:"teamId,omitempty"`
+	// Settings replace the template's or team's defaults, or the built-in
+	// ones
+	Settings *SessionSettings `json:"settings,omitempty"`
+	// TemplateID sets up the session from a template: its settings, team,
+	// authRequired and seed items. Name may then be left out to use the
+	// template's.
+	TemplateID string `json:"templateId,omitempty"`
+}
+
+// CreateSessionResponse represents the response after creating a session
+type CreateSessionResponse struct {
+	SessionID string `json:"sessionId"`
+	HostID    string `json:"hostId"`
+	JoinCode  string `json:"joinCode"`
+	// HostKey authorizes host-only REST requests such as archiving. Unlike
+	// hostId it is never shown to participants, and it is not stored.
+	HostKey string `json:"hostKey"`
+}
+
+// JoinCodeResponse identifies the session a join code belongs to
+type JoinCodeResponse struct {
+	SessionID string `json:"sessionId"`
+	Name      string `json:"name"`
+	JoinCode  string `json:"joinCode"`
+}
+
+// ArchiveSessionRequest represents the request to archive a session. Only
+// the host may archive: by the hostKey from CreateSessionResponse or by
+// the host's account.
+type ArchiveSessionRequest struct {
+	HostKey string `json:"hostKey,omitempty"`
+}
+
+// SessionSummary is a short description of a session used in listings
+type SessionSummary struct {
+	ID       string `json:"id"`
+	Name     string `json:"name"`
+	JoinCode string `json:"joinCode,omitempty"`
+	State    string `json:"state"`
+	// Role is the caller's role in the session: RoleHost or RoleParticipant,
+	// or empty in a team listing for a session the caller has not joined
+	Role      string `json:"role,omitempty"`
+	TeamID    string `json:"teamId,omitempty"`
+	UserCount int    `json:"userCount"`
+	// ConnectedCount is the number of participants connected right now
+	ConnectedCount int        `json:"connectedCount"`
+	ItemCount      int        `json:"itemCount"`
+	CreatedAt      time.Time  `json:"createdAt"`
+	ArchivedAt     *time.Time `json:"archivedAt,omitempty"`
+}
+
+// SessionPage is one page of a session listing
+type SessionPage struct {
+	Sessions []SessionSummary `json:"sessions"`
+	// NextCursor requests the following page; empty on the last one
+	NextCursor string `json:"nextCursor,omitempty"`
+}
+
+// Session states reported in SessionSummary.State
+const (
+	SessionStateActive   = "active"
+	SessionStateArchived = "archived"
+)
+
+// Roles reported in SessionSummary.Role
+const (
+	RoleHost        = "host"
+	RoleParticipant = "participant"
+)
+
+// TeamRequest creates a team or replaces its name and settings
+type TeamRequest struct {
+	Name     string          `json:"name"`
+	Settings SessionSettings `json:"settings"`
+}
+
+// TeamList is the caller's teams, each with the caller's role
+type TeamList struct {
+	Teams []Team `json:"teams"`
+}
+
+// TeamMemberRequest adds a member to a team or changes their role
+type TeamMemberRequest struct {
+	// Role is TeamRoleOwner or TeamRoleMember
+	Role string `json:"role"`
+}
+
+// TemplateRequest creates a template or replaces it. TeamID is set when
+// the template is created and cannot change.
+type TemplateRequest struct {
+	Name         string          `json:"name"`
+	TeamID       string          `json:"teamId,omitempty"`
+	Settings     SessionSettings `json:"settings"`
+	AuthRequired bool            `json:"authRequired,omitempty"`
+	Items        []TemplateItem  `json:"items,omitempty"`
+}
+
+// TemplateList is the templates the caller may use, by name
+type TemplateList struct {
+	Templates []Template `json:"templates"`
+}
+
+// AccountRequest creates an account or replaces its profile
+type AccountRequest struct {
+	DisplayName string `json:"displayName"`
+	AvatarURL   string `json:"avatarUrl,omitempty"`
+}
+
+// RegisterRequest creates an account that signs in with an email address
+// and password
+type RegisterRequest struct {
+	Email       string `json:"email"`
+	Password    string `json:"password"`
+	DisplayName string `json:"displayName"`
+	AvatarURL   string `json:"avatarUrl,omitempty"`
+}
+
+// LoginRequest signs in with an email address and password
+type LoginRequest struct {
+	Email    string `json:"email"`
+	Password string `json:"password"`
+}
+
+// AuthResponse is the result of signing in. The token is also set in a
+// cookie for browsers; other clients send it as a bearer token.
+type AuthResponse struct {
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expiresAt"`
+	Account   Account   `json:"account"`
+}
+
+// AddItemRequest represents the request to add a planning item
+type AddItemRequest struct {
+	Title       string `json:"title"`
+	Description string `json:"description"`
+}
+
+// SetCurrentItemRequest represents the request to set the current item
+type SetCurrentItemRequest struct {
+	ItemID string `json:"itemId"`
+}
+
+// AdminSession describes a session held in the server's memory
+type AdminSession struct {
+	ID       string `json:"id"`
+	Name     string `json:"name"`
+	JoinCode string `json:"joinCode,omitempty"`
+	// Users counts the users held in memory, Connections those connected
+	Users        int        `json:"users"`
+	Connections  int        `json:"connections"`
+	Items        int        `json:"items"`
+	LastActivity time.Time  `json:"lastActivity"`
+	MemoryBytes  int        `json:"memoryBytes"`
+	CreatedAt    time.Time  `json:"createdAt"`
+	ArchivedAt   *time.Time `json:"archivedAt,omitempty"`
+}
+
+// AdminSessionsResponse lists the sessions held in memory, most recently
+// active first
+type AdminSessionsResponse struct {
+	Sessions    []AdminSession `json:"sessions"`
+	Connections int            `json:"connections"`
+	MemoryBytes int            `json:"memoryBytes"`
+}
+
+// NoticeRequest is a message to broadcast to every connected client
+type NoticeRequest struct {
+	Message string `json:"message"`
+}
+
+// PurgeRequest selects the sessions to delete by age
+type PurgeRequest struct {
+	// OlderThan is a duration such as "720h"; sessions created longer ago
+	// are deleted
+	OlderThan string `json:"olderThan"`
+}
+
+// AdminResult reports how many sessions and connections an admin action
+// affected
+type AdminResult struct {
+	Sessions    int `json:"sessions"`
+	Connections int `json:"connections"`
+}
+
+// StatusResponse is returned by endpoints that have nothing else to report
+type StatusResponse struct {
+	Status string `json:"status"`
+}
+
+// ReadinessResponse is returned by GET /ready when the server can serve
+// traffic
+type ReadinessResponse struct {
+	Status        string `json:"status"`
+	SchemaVersion int    `json:"schemaVersion"`
+}
+
+// Problem is an RFC 7807 problem details response
+type Problem struct {
+	Type      string       `json:"type"`
+	Title     string       `json:"title"`
+	Status    int          `json:"status"`
+	Detail    string       `json:"detail,omitempty"`
+	Instance  string       `json:"instance,omitempty"`
+	Code      string       `json:"code"`
+	RequestID string       `json:"requestId,omitempty"`
+	Errors    []FieldError `json:"errors,omitempty"`
+}
+
+// FieldError describes why a single request field was rejected
+type FieldError struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// Stable error codes returned in Problem.Code. Clients may rely on these;
+// titles and details are for humans only.
+const (
+	CodeInvalidBody         = "invalid_request_body"
+	CodeValidationFailed    = "validation_failed"
+	CodeSessionNotFound     = "session_not_found"
+	CodeDatabaseUnavailable = "database_unavailable"
+	CodeInternal            = "internal_error"
+	CodeNotReady            = "not_ready"
+	CodeShuttingDown        = "shutting_down"
+	CodeRateLimited         = "rate_limited"
+	CodeSessionQuota        = "session_quota_exceeded"
+	CodeOriginNotAllowed    = "origin_not_allowed"
+	CodeJoinCodeNotFound    = "join_code_not_found"
+	CodeNotHost             = "not_host"
+	CodeSessionArchived     = "session_archived"
+	CodeUnauthorized        = "unauthorized"
+	CodeAccountNotFound     = "account_not_found"
+	CodeSignInRequired      = "sign_in_required"
+	CodeInvalidCredentials  = "invalid_credentials"
+	CodeEmailTaken          = "email_taken"
+	CodeProviderNotFound    = "provider_not_found"
+	CodeSignInFailed        = "sign_in_failed"
+	CodeProviderUnavailable = "identity_provider_unavailable"
+	CodeTeamNotFound        = "team_not_found"
+	CodeNotTeamMember       = "not_team_member"
+	CodeNotTeamOwner        = "not_team_owner"
+	CodeLastTeamOwner       = "last_team_owner"
+	CodeTemplateNotFound    = "template_not_found"
+	CodeItemNotFound        = "item_not_found"
+)
+
+// Field-level validation codes used in FieldError.Code
+const (
+	FieldRequired          = "required"
+	FieldTooLong           = "too_long"
+	FieldInvalidCharacters = "invalid_characters"
+	FieldInvalidFormat     = "invalid_format"
+	FieldOutOfRange        = "out_of_range"
+	FieldTooShort          = "too_short"
+)
@@ -2,19 +2,140 @@ package models
 
 import (
 	"sync"
+	"sync/atomic"
 	"time"
+	"unsafe"
 
 	"github.com/gorilla/websocket"
 )
 
+// Account is a persistent identity that participants in any number of
+// sessions may belong to
+type Account struct {
+	ID          string `json:"id"`
+	DisplayName string `json:"displayName"`
+	AvatarURL   string `json:"avatarUrl,omitempty"`
+	// Provider is the sign-in provider the account belongs to, which
+	// callers must sign in with to act as it; empty for an account used
+	// through its ID alone
+	Provider  string    `json:"provider,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// Login ties an account to the identity a sign-in provider knows it by
+type Login struct {
+	Provider string
+	// Subject identifies the person at the provider: the email address
+	// of a local login, the OpenID Connect subject otherwise
+	Subject   string
+	AccountID string
+	// PasswordHash is the bcrypt hash of a local login's password
+	PasswordHash string
+	CreatedAt    time.Time
+}
+
+// Team groups the sessions of the people who estimate together. Its
+// members are accounts signed in with a provider.
+type Team struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	// Settings are the defaults of sessions created under the team
+	Settings  SessionSettings `json:"settings"`
+	CreatedAt time.Time       `json:"createdAt"`
+	// Role is the caller's role in the team
+	Role string `json:"role,omitempty"`
+	// Members is filled in when a single team is read
+	Members []TeamMember `json:"members,omitempty"`
+}
+
+// TeamMember is an account's membership in a team
+type TeamMember struct {
+	AccountID   string    `json:"accountId"`
+	DisplayName string    `json:"displayName"`
+	AvatarURL   string    `json:"avatarUrl,omitempty"`
+	Role        string    `json:"role"`
+	JoinedAt    time.Time `json:"joinedAt"`
+}
+
+// Team roles. Owners manage the team and its members; members create and
+// join its sessions.
+const (
+	TeamRoleOwner  = "owner"
+	TeamRoleMember = "member"
+)
+
+// SessionSettings shape how a session is played
+type SessionSettings struct {
+	// Deck lists the cards participants may vote; empty accepts any vote
+	// of up to 10 characters, such as the cards in CardValues
+	Deck []string `json:"deck,omitempty"`
+	// TimerSeconds is how long clients count down for each item; 0 shows
+	// no timer
+	TimerSeconds int `json:"timerSeconds,omitempty"`
+	// AutoReveal reveals an item once every connected participant voted
+	AutoReveal bool `json:"autoReveal,omitempty"`
+}
+
+// AllowsVote reports whether vote is a card of the deck
+func (s SessionSettings) AllowsVote(vote string) bool {
+	if len(s.Deck) == 0 {
+		return true
+	}
+	for _, card := range s.Deck {
+		if card == vote {
+			return true
+		}
+	}
+	return false
+}
+
+// Template is a reusable setup for new sessions. It belongs to the account
+// that created it or, with TeamID, to a team. It assigns no roles: the
+// creator of a session always hosts it.
+type Template struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	// TeamID shares the template with the team's members
+	TeamID string `json:"teamId,omitempty"`
+	// AccountID owns a template without a team; like any account ID it is
+	// not sent to clients
+	AccountID string          `json:"-"`
+	Settings  SessionSettings `json:"settings"`
+	// AuthRequired creates sessions that admit only signed-in participants
+	AuthRequired bool `json:"authRequired,omitempty"`
+	// Items are added to every session created from the template, in order
+	Items     []TemplateItem `json:"items"`
+	CreatedAt time.Time      `json:"createdAt"`
+	UpdatedAt time.Time      `json:"updatedAt"`
+}
+
+// TemplateItem is a planning item a template seeds its sessions with
+type TemplateItem struct {
+	Title       string `json:"title"`
+	Description string `json:"description,omitempty"`
+}
+
 // User represents a participant in a planning session
 type User struct {
-	ID        string          `json:"id"`
-	Name      string          `json:"name"`
-	IsHost    bool            `json:"isHost"`
-	Vote      string          `json:"vote,omitempty"`
-	Connected bool            `json:"connected"`
+	ID string `json:"id"`
+	// SessionID is the session the participant belongs to
+	SessionID string `json:"-"`
+	Name      string `json:"name"`
+	IsHost    bool   `json:"isHost"`
+	Vote      string `json:"vote,omitempty"`
+	Connected bool   `json:"connected"`
+	// AccountID links the participant to an account; empty for a guest.
+	// It is a credential, so it is never sent to other participants.
+	AccountID string `json:"-"`
+	// AvatarURL is the linked account's avatar
+	AvatarURL string          `json:"avatarUrl,omitempty"`
 	Conn      *websocket.Conn `json:"-"`
+	// ProtocolVersion is the WebSocket protocol version negotiated at join
+	ProtocolVersion int `json:"-"`
+	// ResumeTokenHash is the SHA-256 of the token the participant rejoins
+	// with; empty for the host, who rejoins with the host key, and for
+	// participants joined before tokens until they claim one
+	ResumeTokenHash []byte `json:"-"`
 }
 
 // PlanningItem represents a single item to be estimated
@@ -35,8 +156,24 @@ type Session struct {
 	Users         map[string]*User `json:"users"`
 	Items         []PlanningItem   `json:"items"`
 	CurrentItemID string           `json:"currentItemId,omitempty"`
+	JoinCode      string           `json:"joinCode,omitempty"`
 	CreatedAt     time.Time        `json:"createdAt"`
-	Mutex         sync.RWMutex     `json:"-"`
+	ArchivedAt    *time.Time       `json:"archivedAt,omitempty"`
+	// AuthRequired admits only participants signed in with a provider
+	AuthRequired bool `json:"authRequired,omitempty"`
+	// TeamID is the team the session was created under, if any
+	TeamID   string          `json:"teamId,omitempty"`
+	Settings SessionSettings `json:"settings"`
+	// HostKeyHash is the SHA-256 of the host key; empty for sessions
+	// created before host keys
+	HostKeyHash []byte       `json:"-"`
+	Mutex       sync.RWMutex `json:"-"`
+
+	// lastActivity holds the UnixNano time recorded by Touch
+	lastActivity atomic.Int64
+	// knownItems holds the IDs of items added after the session was
+	// loaded that were found to belong to it; items never move
+	knownItems sync.Map
 }
 
 // Message types for WebSocket communication
@@ -60,6 +197,43 @@ func NewSession(id, name, hostID string) *Session {
 	}
 }
 
+// Touch records activity in the session: a join, a leave or a message
+func (s *Session) Touch() {
+	s.lastActivity.Store(time.Now().UnixNano())
+}
+
+// LastActivity returns the time of the last Touch, or the zero time
+func (s *Session) LastActivity() time.Time {
+	if t := s.lastActivity.Load(); t != 0 {
+		return time.Unix(0, t)
+	}
+	return time.Time{}
+}
+
+// ApproxSize estimates the memory held by the session in bytes: its
+// structs, strings and votes, not the buffers of open connections
+func (s *Session) ApproxSize() int {
+	s.Mutex.RLock()
+	defer s.Mutex.RUnlock()
+
+	size := int(unsafe.Sizeof(*s)) + len(s.ID) + len(s.Name) + len(s.HostID) + len(s.CurrentItemID) + len(s.JoinCode) +
+		len(s.TeamID) + len(s.HostKeyHash)
+	for _, card := range s.Settings.Deck {
+		size += len(card)
+	}
+	for userID, user := range s.Users {
+		size += len(userID) + int(unsafe.Sizeof(*user)) + len(user.ID) + len(user.Name) + len(user.Vote) +
+			len(user.AccountID) + len(user.AvatarURL) + len(user.SessionID) + len(user.ResumeTokenHash)
+	}
+	for _, item := range s.Items {
+		size += int(unsafe.Sizeof(item)) + len(item.ID) + len(item.Title) + len(item.Description) + len(item.FinalEstimate)
+		for userID, vote := range item.Votes {
+			size += len(userID) + len(vote)
+		}
+	}
+	return size
+}
+
 // AddUser adds a user to the session
 func (s *Session) AddUser(user *User) {
 	s.Mutex.Lock()
@@ -77,6 +251,32 @@ func (s *Session) RemoveUser(userID string) {
 	}
 }
 
+// DetachUser removes the user unless another connection has since replaced
+// it under the same ID, and reports whether it was removed
+func (s *Session) DetachUser(user *User) bool {
+	s.Mutex.Lock()
+	defer s.Mutex.Unlock()
+	if current, exists := s.Users[user.ID]; exists && current == user {
+		user.Connected = false
+		delete(s.Users, user.ID)
+		return true
+	}
+	return false
+}
+
+// ConnectedUsers returns a snapshot of the users with an open connection
+func (s *Session) ConnectedUsers() []*User {
+	s.Mutex.RLock()
+	defer s.Mutex.RUnlock()
+	users := make([]*User, 0, len(s.Users))
+	for _, user := range s.Users {
+		if user.Connected && user.Conn != nil {
+			users = append(users, user)
+		}
+	}
+	return users
+}
+
 // GetUser gets a user by ID
 func (s *Session) GetUser(userID string) (*User, bool) {
 	s.Mutex.RLock()
@@ -93,6 +293,28 @@ func (s *Session) AddItem(item PlanningItem) {
 	s.Items = append(s.Items, item)
 }
 
+// HasItem reports whether the item is known to belong to the session:
+// it was loaded with the session or passed to KnowItem since
+func (s *Session) HasItem(itemID string) bool {
+	if _, known := s.knownItems.Load(itemID); known {
+		return true
+	}
+	s.Mutex.RLock()
+	defer s.Mutex.RUnlock()
+	for i := range s.Items {
+		if s.Items[i].ID == itemID {
+			return true
+		}
+	}
+	return false
+}
+
+// KnowItem records that the item belongs to the session, so HasItem
+// answers for it without asking the store again
+func (s *Session) KnowItem(itemID string) {
+	s.knownItems.Store(itemID, struct{}{})
+}
+
 // GetCurrentItem returns the current item being voted on
 func (s *Session) GetCurrentItem() *PlanningItem {
 	s.Mutex.RLock()
@@ -0,0 +1,495 @@
+package openapi
+
+import (
+	"net/http"
+	"poker-planning-api/models"
+	"poker-planning-api/schema"
+	"strconv"
+	"strings"
+)
+
+// Operation describes a single REST route
+type Operation struct {
+	Method      string
+	Path        string
+	OperationID string
+	Summary     string
+	// Parameters lists the query and header parameters; path parameters
+	// are taken from Path
+	Parameters []Parameter
+	// Request is a zero value of the JSON request body type, or nil
+	Request interface{}
+	// Response is a zero value of the JSON success body type. A string
+	// means a text/plain body; nil, a WebSocket upgrade unless Redirect.
+	Response interface{}
+	// Redirect reports whether the operation may answer 302 Found
+	Redirect bool
+	// Errors lists the problem+json statuses the operation may return
+	Errors []int
+	// Client reports whether the Go client package wraps this operation
+	Client bool
+	// Admin marks operations that require the admin token
+	Admin bool
+	// SignIn marks operations that accept a sign-in token, as a bearer
+	// token or cookie, in place of the account header
+	SignIn bool
+}
+
+// Parameter describes an optional query or header parameter
+type Parameter struct {
+	Name string
+	// In is "query" or "header"
+	In          string
+	Description string
+	// Type is the JSON Schema type, "string" when empty
+	Type string
+}
+
+// accountHeader identifies the caller's account
+var accountHeader = Parameter{Name: "X-Account-ID", In: "header", Description: "The caller's account ID"}
+
+// Operations lists every route served by the API. The router test checks
+// this table against the routes registered in main.go, and the client test
+// checks it against the methods of client.Client.
+var Operations = []Operation{
+	{
+		Method: http.MethodGet, Path: "/", OperationID: "root",
+		Summary: "Liveness probe for Cloud Run", Response: "",
+	},
+	{
+		Method: http.MethodGet, Path: "/health", OperationID: "health",
+		Summary: "Liveness check; does not touch the database", Response: "", Client: true,
+	},
+	{
+		Method: http.MethodGet, Path: "/ready", OperationID: "ready",
+		Summary: "Readiness: database reachable and schema up to date", Response: models.ReadinessResponse{},
+		Errors: []int{http.StatusServiceUnavailable},
+	},
+	{
+		Method: http.MethodGet, Path: "/metrics", OperationID: "getMetrics",
+		Summary: "Prometheus metrics in the text exposition format", Response: "",
+	},
+	{
+		Method: http.MethodPost, Path: "/api/sessions", OperationID: "createSession",
+		Summary:    "Create a planning session and its host; with an account, hostName defaults to its display name, under a team, settings default to the team's, and from a template, the session takes its setup and seed items",
+		Parameters: []Parameter{accountHeader},
+		Request:    models.CreateSessionRequest{}, Response: models.CreateSessionResponse{},
+		Errors: []int{http.StatusBadRequest, http.StatusUnauthorized, http.StatusForbidden, http.StatusNotFound, http.StatusTooManyRequests, http.StatusServiceUnavailable, http.StatusInternalServerError},
+		Client: true, SignIn: true,
+	},
+	{
+		Method: http.MethodGet, Path: "/api/sessions", OperationID: "listSessions",
+		Summary: "List the sessions the caller created or joined, newest first",
+		Parameters: []Parameter{
+			{Name: "X-User-ID", In: "header", Description: "The caller's user IDs, comma-separated; without any, or an account, the list is empty"},
+			{Name: "X-Account-ID", In: "header", Description: "The caller's account; adds the sessions its participants joined"},
+			{Name: "q", In: "query", Description: "Only names containing this text, ignoring case"},
+			{Name: "state", In: "query", Description: "active or archived; both when omitted"},
+			{Name: "createdFrom", In: "query", Description: "Created at or after this date or RFC 3339 time"},
+			{Name: "createdTo", In: "query", Description: "Created before this date or RFC 3339 time"},
+			{Name: "limit", In: "query", Description: "Page size, 1 to 100; default 20", Type: "integer"},
+			{Name: "cursor", In: "query", Description: "nextCursor from the previous page"},
+		},
+		Response: models.SessionPage{},
+		Errors:   []int{http.StatusBadRequest, http.StatusUnauthorized, http.StatusNotFound, http.StatusTooManyRequests, http.StatusServiceUnavailable, http.StatusInternalServerError},
+		Client:   true,
+		SignIn:   true,
+	},
+	{
+		Method: http.MethodGet, Path: "/api/sessions/{sessionId}", OperationID: "getSession",
+		Summary:    "Get a session with its users, items and votes",
+		Parameters: []Parameter{accountHeader},
+		Response:   models.Session{},
+		Errors:     []int{http.StatusUnauthorized, http.StatusForbidden, http.StatusNotFound, http.StatusTooManyRequests, http.StatusServiceUnavailable, http.StatusInternalServerError},
+		Client:     true,
+		SignIn:     true,
+	},
+	{
+		Method: http.MethodPost, Path: "/api/sessions/{sessionId}/items", OperationID: "addItem",
+		Summary:    "Add a planning item to a session",
+		Parameters: []Parameter{accountHeader},
+		Request:    models.AddItemRequest{}, Response: models.PlanningItem{},
+		Errors: []int{http.StatusBadRequest, http.StatusUnauthorized, http.StatusForbidden, http.StatusNotFound, http.StatusTooManyRequests, http.StatusServiceUnavailable, http.StatusInternalServerError},
+		Client: true, SignIn: true,
+	},
+	{
+		Method: http.MethodPost, Path: "/api/sessions/{sessionId}/current-item", OperationID: "setCurrentItem",
+		Summary:    "Set the item being estimated",
+		Parameters: []Parameter{accountHeader},
+		Request:    models.SetCurrentItemRequest{}, Response: models.StatusResponse{},
+		Errors: []int{http.StatusBadRequest, http.StatusUnauthorized, http.StatusForbidden, http.StatusNotFound, http.StatusTooManyRequests, http.StatusServiceUnavailable, http.StatusInternalServerError},
+		Client: true, SignIn: true,
+	},
+	{
+		Method: http.MethodPost, Path: "/api/sessions/{sessionId}/archive", OperationID: "archiveSession",
+		Summary:    "Archive a session, freeing its join code; host only, by hostKey or the host's account",
+		Parameters: []Parameter{accountHeader},
+		Request:    models.ArchiveSessionRequest{}, Response: models.StatusResponse{},
+		Errors: []int{http.StatusBadRequest, http.StatusUnauthorized, http.StatusForbidden, http.StatusNotFound, http.StatusTooManyRequests, http.StatusServiceUnavailable, http.StatusInternalServerError},
+		Client: true, SignIn: true,
+	},
+	{
+		Method: http.MethodGet, Path: "/api/join/{code}", OperationID: "resolveJoinCode",
+		Summary:    "Find the active session a join code belongs to",
+		Parameters: []Parameter{accountHeader},
+		Response:   models.JoinCodeResponse{},
+		Errors:     []int{http.StatusUnauthorized, http.StatusForbidden, http.StatusNotFound, http.StatusTooManyRequests, http.StatusServiceUnavailable, http.StatusInternalServerError},
+		Client:     true,
+		SignIn:     true,
+	},
+	{
+		Method: http.MethodPost, Path: "/api/accounts", OperationID: "createAccount",
+		Summary: "Create an account; its id is the credential sent in X-Account-ID",
+		Request: models.AccountRequest{}, Response: models.Account{},
+		Errors: []int{http.StatusBadRequest, http.StatusTooManyRequests, http.StatusServiceUnavailable, http.StatusInternalServerError},
+		Client: true,
+	},
+	{
+		Method: http.MethodGet, Path: "/api/accounts/me", OperationID: "getAccount",
+		Summary:    "Get the caller's account",
+		Parameters: []Parameter{accountHeader},
+		Response:   models.Account{},
+		Errors:     []int{http.StatusBadRequest, http.StatusUnauthorized, http.StatusNotFound, http.StatusTooManyRequests, http.StatusServiceUnavailable, http.StatusInternalServerError},
+		Client:     true,
+		SignIn:     true,
+	},
+	{
+		Method: http.MethodPut, Path: "/api/accounts/me", OperationID: "updateAccount",
+		Summary:    "Replace the caller's display name and avatar",
+		Parameters: []Parameter{accountHeader},
+		Request:    models.AccountRequest{}, Response: models.Account{},
+		Errors: []int{http.StatusBadRequest, http.StatusUnauthorized, http.StatusNotFound, http.StatusTooManyRequests, http.StatusServiceUnavailable, http.StatusInternalServerError},
+		Client: true, SignIn: true,
+	},
+	{
+		Method: http.MethodDelete, Path: "/api/accounts/me", OperationID: "deleteAccount",
+		Summary:    "Delete the caller's account; its participants stay in their sessions as guests",
+		Parameters: []Parameter{accountHeader},
+		Response:   models.StatusResponse{},
+		Errors:     []int{http.StatusBadRequest, http.StatusUnauthorized, http.StatusNotFound, http.StatusConflict, http.StatusTooManyRequests, http.StatusServiceUnavailable, http.StatusInternalServerError},
+		Client:     true,
+		SignIn:     true,
+	},
+	{
+		Method: http.MethodPost, Path: "/api/teams", OperationID: "createTeam",
+		Summary: "Create a team owned by the signed-in caller",
+		Request: models.TeamRequest{}, Response: models.Team{},
+		Errors: []int{http.StatusBadRequest, http.StatusUnauthorized, http.StatusTooManyRequests, http.StatusServiceUnavailable, http.StatusInternalServerError},
+		Client: true, SignIn: true,
+	},
+	{
+		Method: http.MethodGet, Path: "/api/teams", OperationID: "listTeams",
+		Summary: "List the signed-in caller's teams by name", Response: models.TeamList{},
+		Errors: []int{http.StatusUnauthorized, http.StatusTooManyRequests, http.StatusServiceUnavailable, http.StatusInternalServerError},
+		Client: true, SignIn: true,
+	},
+	{
+		Method: http.MethodGet, Path: "/api/teams/{teamId}", OperationID: "getTeam",
+		Summary: "Get a team with its members; members only", Response: models.Team{},
+		Errors: []int{http.StatusUnauthorized, http.StatusForbidden, http.StatusNotFound, http.StatusTooManyRequests, http.StatusServiceUnavailable, http.StatusInternalServerError},
+		Client: true, SignIn: true,
+	},
+	{
+		Method: http.MethodPut, Path: "/api/teams/{teamId}", OperationID: "updateTeam",
+		Summary: "Replace a team's name and default session settings; owners only",
+		Request: models.TeamRequest{}, Response: models.Team{},
+		Errors: []int{http.StatusBadRequest, http.StatusUnauthorized, http.StatusForbidden, http.StatusNotFound, http.StatusTooManyRequests, http.StatusServiceUnavailable, http.StatusInternalServerError},
+		Client: true, SignIn: true,
+	},
+	{
+		Method: http.MethodDelete, Path: "/api/teams/{teamId}", OperationID: "deleteTeam",
+		Summary: "Delete a team; its sessions stay without a team. Owners only", Response: models.StatusResponse{},
+		Errors: []int{http.StatusUnauthorized, http.StatusForbidden, http.StatusNotFound, http.StatusTooManyRequests, http.StatusServiceUnavailable, http.StatusInternalServerError},
+		Client: true, SignIn: true,
+	},
+	{
+		Method: http.MethodPut, Path: "/api/teams/{teamId}/members/{accountId}", OperationID: "setTeamMember",
+		Summary: "Add a signed-in account to a team or change its role; owners only",
+		Request: models.TeamMemberRequest{}, Response: models.Team{},
+		Errors: []int{http.StatusBadRequest, http.StatusUnauthorized, http.StatusForbidden, http.StatusNotFound, http.StatusConflict, http.StatusTooManyRequests, http.StatusServiceUnavailable, http.StatusInternalServerError},
+		Client: true, SignIn: true,
+	},
+	{
+		Method: http.MethodDelete, Path: "/api/teams/{teamId}/members/{accountId}", OperationID: "removeTeamMember",
+		Summary: "Remove a member from a team; owners, or the member leaving", Response: models.StatusResponse{},
+		Errors: []int{http.StatusUnauthorized, http.StatusForbidden, http.StatusNotFound, http.StatusConflict, http.StatusTooManyRequests, http.StatusServiceUnavailable, http.StatusInternalServerError},
+		Client: true, SignIn: true,
+	},
+	{
+		Method: http.MethodGet, Path: "/api/teams/{teamId}/sessions", OperationID: "listTeamSessions",
+		Summary: "List the sessions created under a team, newest first; members only",
+		Parameters: []Parameter{
+			{Name: "q", In: "query", Description: "Only names containing this text, ignoring case"},
+			{Name: "state", In: "query", Description: "active or archived; both when omitted"},
+			{Name: "createdFrom", In: "query", Description: "Created at or after this date or RFC 3339 time"},
+			{Name: "createdTo", In: "query", Description: "Created before this date or RFC 3339 time"},
+			{Name: "limit", In: "query", Description: "Page size, 1 to 100; default 20", Type: "integer"},
+			{Name: "cursor", In: "query", Description: "nextCursor from the previous page"},
+		},
+		Response: models.SessionPage{},
+		Errors:   []int{http.StatusBadRequest, http.StatusUnauthorized, http.StatusForbidden, http.StatusNotFound, http.StatusTooManyRequests, http.StatusServiceUnavailable, http.StatusInternalServerError},
+		Client:   true,
+		SignIn:   true,
+	},
+	{
+		Method: http.MethodPost, Path: "/api/templates", OperationID: "createTemplate",
+		Summary:    "Create a session template owned by the caller's account or, with teamId, shared with a team; team templates need a signed-in member",
+		Parameters: []Parameter{accountHeader},
+		Request:    models.TemplateRequest{}, Response: models.Template{},
+		Errors: []int{http.StatusBadRequest, http.StatusUnauthorized, http.StatusForbidden, http.StatusNotFound, http.StatusTooManyRequests, http.StatusServiceUnavailable, http.StatusInternalServerError},
+		Client: true, SignIn: true,
+	},
+	{
+		Method: http.MethodGet, Path: "/api/templates", OperationID: "listTemplates",
+		Summary:    "List the templates the caller may use, its own and its teams', by name",
+		Parameters: []Parameter{accountHeader},
+		Response:   models.TemplateList{},
+		Errors:     []int{http.StatusBadRequest, http.StatusUnauthorized, http.StatusNotFound, http.StatusTooManyRequests, http.StatusServiceUnavailable, http.StatusInternalServerError},
+		Client:     true,
+		SignIn:     true,
+	},
+	{
+		Method: http.MethodGet, Path: "/api/templates/{templateId}", OperationID: "getTemplate",
+		Summary:    "Get a template; its account or its team's members only",
+		Parameters: []Parameter{accountHeader},
+		Response:   models.Template{},
+		Errors:     []int{http.StatusBadRequest, http.StatusUnauthorized, http.StatusNotFound, http.StatusTooManyRequests, http.StatusServiceUnavailable, http.StatusInternalServerError},
+		Client:     true,
+		SignIn:     true,
+	},
+	{
+		Method: http.MethodPut, Path: "/api/templates/{templateId}", OperationID: "updateTemplate",
+		Summary:    "Replace a template's name, settings and items; its account or its team's owners only",
+		Parameters: []Parameter{accountHeader},
+		Request:    models.TemplateRequest{}, Response: models.Template{},
+		Errors: []int{http.StatusBadRequest, http.StatusUnauthorized, http.StatusForbidden, http.StatusNotFound, http.StatusTooManyRequests, http.StatusServiceUnavailable, http.StatusInternalServerError},
+		Client: true, SignIn: true,
+	},
+	{
+		Method: http.MethodDelete, Path: "/api/templates/{templateId}", OperationID: "deleteTemplate",
+		Summary:    "Delete a template; sessions created from it stay. Its account or its team's owners only",
+		Parameters: []Parameter{accountHeader},
+		Response:   models.StatusResponse{},
+		Errors:     []int{http.StatusBadRequest, http.StatusUnauthorized, http.StatusForbidden, http.StatusNotFound, http.StatusTooManyRequests, http.StatusServiceUnavailable, http.StatusInternalServerError},
+		Client:     true,
+		SignIn:     true,
+	},
+	{
+		Method: http.MethodPost, Path: "/api/auth/register", OperationID: "register",
+		Summary: "Create an account signing in with an email address and password, and sign it in",
+		Request: models.RegisterRequest{}, Response: models.AuthResponse{},
+		Errors: []int{http.StatusBadRequest, http.StatusNotFound, http.StatusConflict, http.StatusTooManyRequests, http.StatusServiceUnavailable, http.StatusInternalServerError},
+		Client: true,
+	},
+	{
+		Method: http.MethodPost, Path: "/api/auth/{provider}/login", OperationID: "login",
+		Summary: "Sign in with the email address and password of a provider such as local",
+		Request: models.LoginRequest{}, Response: models.AuthResponse{},
+		Errors: []int{http.StatusBadRequest, http.StatusUnauthorized, http.StatusNotFound, http.StatusTooManyRequests, http.StatusServiceUnavailable, http.StatusInternalServerError},
+		Client: true,
+	},
+	{
+		Method: http.MethodGet, Path: "/api/auth/{provider}/login", OperationID: "startLogin",
+		Summary: "Redirect the browser to an OpenID Connect provider to sign in",
+		Parameters: []Parameter{
+			{Name: "returnTo", In: "query", Description: "URL on an allowed origin to return to once signed in; without it the callback answers with JSON"},
+		},
+		Redirect: true,
+		Errors:   []int{http.StatusBadRequest, http.StatusNotFound, http.StatusTooManyRequests, http.StatusInternalServerError, http.StatusBadGateway},
+	},
+	{
+		Method: http.MethodGet, Path: "/api/auth/{provider}/callback", OperationID: "loginCallback",
+		Summary: "Complete an OpenID Connect sign-in, redirecting to returnTo when one was given",
+		Parameters: []Parameter{
+			{Name: "code", In: "query", Description: "Authorization code from the provider"},
+			{Name: "state", In: "query", Description: "State given to the provider when the sign-in started"},
+			{Name: "error", In: "query", Description: "Why the provider refused the sign-in"},
+		},
+		Response: models.AuthResponse{}, Redirect: true,
+		Errors: []int{http.StatusUnauthorized, http.StatusNotFound, http.StatusTooManyRequests, http.StatusInternalServerError, http.StatusBadGateway, http.StatusServiceUnavailable},
+	},
+	{
+		Method: http.MethodPost, Path: "/api/auth/logout", OperationID: "logout",
+		Summary: "Clear the sign-in cookie; issued tokens stay valid until they expire", Response: models.StatusResponse{},
+		Errors: []int{http.StatusTooManyRequests},
+		Client: true,
+	},
+	{
+		Method: http.MethodGet, Path: "/api/openapi.json", OperationID: "getOpenAPISpec",
+		Summary: "This document", Response: map[string]interface{}{},
+	},
+	{
+		Method: http.MethodGet, Path: "/api/asyncapi.json", OperationID: "getProtocolSpec",
+		Summary: "AsyncAPI description of the WebSocket protocol", Response: map[string]interface{}{},
+	},
+	{
+		Method: http.MethodGet, Path: "/admin/sessions", OperationID: "adminListSessions",
+		Summary:  "List the sessions held in memory with connections, last activity and memory use",
+		Response: models.AdminSessionsResponse{},
+		Errors:   []int{http.StatusUnauthorized, http.StatusTooManyRequests},
+		Admin:    true,
+	},
+	{
+		Method: http.MethodPost, Path: "/admin/sessions/{sessionId}/close", OperationID: "adminCloseSession",
+		Summary:  "Archive a session and disconnect its clients, who must not reconnect",
+		Response: models.AdminResult{},
+		Errors:   []int{http.StatusUnauthorized, http.StatusNotFound, http.StatusTooManyRequests, http.StatusServiceUnavailable, http.StatusInternalServerError},
+		Admin:    true,
+	},
+	{
+		Method: http.MethodPost, Path: "/admin/sessions/{sessionId}/evict", OperationID: "adminEvictSession",
+		Summary:  "Drop a session from memory; its clients reconnect and reload it",
+		Response: models.AdminResult{},
+		Errors:   []int{http.StatusUnauthorized, http.StatusNotFound, http.StatusTooManyRequests},
+		Admin:    true,
+	},
+	{
+		Method: http.MethodPost, Path: "/admin/broadcast", OperationID: "adminBroadcastNotice",
+		Summary: "Send a notice to every connected client",
+		Request: models.NoticeRequest{}, Response: models.AdminResult{},
+		Errors: []int{http.StatusBadRequest, http.StatusUnauthorized, http.StatusTooManyRequests},
+		Admin:  true,
+	},
+	{
+		Method: http.MethodPost, Path: "/admin/purge", OperationID: "adminPurgeSessions",
+		Summary: "Delete sessions created longer ago than olderThan",
+		Request: models.PurgeRequest{}, Response: models.AdminResult{},
+		Errors: []int{http.StatusBadRequest, http.StatusUnauthorized, http.StatusTooManyRequests, http.StatusServiceUnavailable, http.StatusInternalServerError},
+		Admin:  true,
+	},
+	{
+		Method: http.MethodGet, Path: "/ws/{sessionId}", OperationID: "joinSession",
+		Summary: "Upgrade to a WebSocket connection; sessionId may also be a join code. See /api/asyncapi.json for the protocol",
+		Errors:  []int{http.StatusBadRequest, http.StatusUnauthorized, http.StatusNotFound, http.StatusGone, http.StatusTooManyRequests},
+		Client:  true,
+		SignIn:  true,
+	},
+}
+
+// Spec builds the OpenAPI 3 document for Operations. Schemas are derived
+// from the Go types so the document cannot drift from the handlers.
+func Spec() map[string]interface{} {
+	g := schema.NewGenerator("#/components/schemas/")
+	problemSchema := g.For(models.Problem{})
+
+	paths := map[string]interface{}{}
+	for _, op := range Operations {
+		item, ok := paths[op.Path].(map[string]interface{})
+		if !ok {
+			item = map[string]interface{}{}
+			paths[op.Path] = item
+		}
+
+		operation := map[string]interface{}{
+			"operationId": op.OperationID,
+			"summary":     op.Summary,
+			"responses":   responses(g, op, problemSchema),
+		}
+		if params := parameters(op); len(params) > 0 {
+			operation["parameters"] = params
+		}
+		if op.Admin {
+			operation["security"] = []interface{}{map[string]interface{}{"adminToken": []interface{}{}}}
+		}
+		if op.SignIn {
+			// The empty requirement keeps signing in optional
+			operation["security"] = []interface{}{
+				map[string]interface{}{},
+				map[string]interface{}{"signInToken": []interface{}{}},
+				map[string]interface{}{"signInCookie": []interface{}{}},
+			}
+		}
+		if op.Request != nil {
+			operation["requestBody"] = map[string]interface{}{
+				"required": true,
+				"content": map[string]interface{}{
+					"application/json": map[string]interface{}{"schema": g.For(op.Request)},
+				},
+			}
+		}
+		item[strings.ToLower(op.Method)] = operation
+	}
+
+	return map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]interface{}{
+			"title":   "Poker Planning API",
+			"version": "1.0.0",
+		},
+		"paths": paths,
+		"components": map[string]interface{}{
+			"schemas": g.Schemas(),
+			"securitySchemes": map[string]interface{}{
+				"adminToken":   map[string]interface{}{"type": "http", "scheme": "bearer"},
+				"signInToken":  map[string]interface{}{"type": "http", "scheme": "bearer", "bearerFormat": "JWT"},
+				"signInCookie": map[string]interface{}{"type": "apiKey", "in": "cookie", "name": "poker_auth"},
+			},
+		},
+	}
+}
+
+func responses(g *schema.Generator, op Operation, problemSchema map[string]interface{}) map[string]interface{} {
+	result := map[string]interface{}{}
+
+	if op.Redirect {
+		result["302"] = map[string]interface{}{"description": "Found"}
+	}
+
+	switch response := op.Response.(type) {
+	case nil:
+		if !op.Redirect {
+			result["101"] = map[string]interface{}{"description": "Switching Protocols"}
+		}
+	case string:
+		result["200"] = map[string]interface{}{
+			"description": "OK",
+			"content": map[string]interface{}{
+				"text/plain": map[string]interface{}{"schema": map[string]interface{}{"type": "string"}},
+			},
+		}
+	default:
+		result["200"] = map[string]interface{}{
+			"description": "OK",
+			"content": map[string]interface{}{
+				"application/json": map[string]interface{}{"schema": g.For(response)},
+			},
+		}
+	}
+
+	for _, status := range op.Errors {
+		result[strconv.Itoa(status)] = map[string]interface{}{
+			"description": http.StatusText(status),
+			"content": map[string]interface{}{
+				"application/problem+json": map[string]interface{}{"schema": problemSchema},
+			},
+		}
+	}
+	return result
+}
+
+func parameters(op Operation) []interface{} {
+	params := []interface{}{}
+	for _, segment := range strings.Split(op.Path, "/") {
+		if strings.HasPrefix(segment, "{") && strings.HasSuffix(segment, "}") {
+			params = append(params, map[string]interface{}{
+				"name":     strings.Trim(segment, "{}"),
+				"in":       "path",
+				"required": true,
+				"schema":   map[string]interface{}{"type": "string"},
+			})
+		}
+	}
+	for _, param := range op.Parameters {
+		schemaType := param.Type
+		if schemaType == "" {
+			schemaType = "string"
+		}
+		params = append(params, map[string]interface{}{
+			"name":        param.Name,
+			"in":          param.In,
+			"description": param.Description,
+			"schema":      map[string]interface{}{"type": schemaType},
+		})
+	}
+	return params
+}
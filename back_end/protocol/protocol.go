@@ -0,0 +1,176 @@
+package protocol
+
+import (
+	"encoding/json"
+	"fmt"
+	"poker-planning-api/models"
+)
+
+// Protocol versions understood by the server
+const (
+	// Version1 is the original protocol. Clients that do not send a
+	// protocolVersion in their join message are assumed to speak it.
+	Version1 = 1
+	// Version2 uses "itemId" consistently in every payload
+	Version2 = 2
+
+	MinVersion     = Version1
+	CurrentVersion = Version2
+)
+
+// Message types sent from client to server
+const (
+	TypeVote             = "vote"
+	TypeRevealVotes      = "reveal_votes"
+	TypeResetVotes       = "reset_votes"
+	TypeSetFinalEstimate = "set_final_estimate"
+)
+
+// Message types sent from server to client
+const (
+	TypeWelcome            = "welcome"
+	TypeError              = "error"
+	TypeUserJoined         = "user_joined"
+	TypeUserLeft           = "user_left"
+	TypeItemAdded          = "item_added"
+	TypeCurrentItemChanged = "current_item_changed"
+	TypeVoteSubmitted      = "vote_submitted"
+	TypeVotesRevealed      = "votes_revealed"
+	TypeVotesReset         = "votes_reset"
+	TypeFinalEstimateSet   = "final_estimate_set"
+	TypeNotice             = "notice"
+)
+
+// CloseSessionClosed is the close code sent when an operator closes a
+// session. Clients must not reconnect.
+const CloseSessionClosed = 4000
+
+// JoinMessage is the first frame a client sends after connecting. Unlike
+// every other message it is not wrapped in a type/payload envelope.
+type JoinMessage struct {
+	// UserName may be left out when joining with an account, whose display
+	// name is used instead
+	UserName string `json:"userName,omitempty"`
+	// UserID rejoins as an existing participant. Participant IDs are seen
+	// by everyone in the session, so the rejoin must be proven with the
+	// participant's ResumeToken, its account, or for the host the HostKey.
+	UserID string `json:"userId,omitempty"`
+	// ResumeToken is the one sent in the participant's welcome message
+	ResumeToken string `json:"resumeToken,omitempty"`
+	// HostKey is the one returned when the session was created
+	HostKey string `json:"hostKey,omitempty"`
+	// AccountID joins as the account's participant, resuming it if the
+	// account has joined the session before
+	AccountID       string `json:"accountId,omitempty"`
+	ProtocolVersion int    `json:"protocolVersion,omitempty"`
+}
+
+// Frame is an incoming message whose payload has not been decoded yet
+type Frame struct {
+	Type    string          `json:"type"`
+	Payload json.RawMessage `json:"payload"`
+}
+
+// DecodePayload unmarshals the frame payload into v
+func (f Frame) DecodePayload(v interface{}) error {
+	if len(f.Payload) == 0 {
+		return fmt.Errorf("%s: missing payload", f.Type)
+	}
+	if err := json.Unmarshal(f.Payload, v); err != nil {
+		return fmt.Errorf("%s: invalid payload: %w", f.Type, err)
+	}
+	return nil
+}
+
+// VotePayload is sent by a participant to cast or change a vote
+type VotePayload struct {
+	ItemID string `json:"itemId"`
+	Vote   string `json:"vote"`
+}
+
+// ItemPayload identifies an item; used by reveal_votes, reset_votes,
+// votes_reset and current_item_changed
+type ItemPayload struct {
+	ItemID string `json:"itemId"`
+}
+
+// FinalEstimatePayload is used by set_final_estimate and final_estimate_set
+type FinalEstimatePayload struct {
+	ItemID   string `json:"itemId"`
+	Estimate string `json:"estimate"`
+}
+
+// WelcomePayload is sent to a client once it has joined a session
+type WelcomePayload struct {
+	UserID          string          `json:"userId"`
+	Session         *models.Session `json:"session"`
+	ProtocolVersion int             `json:"protocolVersion"`
+	// ResumeToken is sent once, when the participant is created or, if it
+	// joined before resume tokens, when it first rejoins. Send it
+	// back with UserID to rejoin as the participant; it is not stored.
+	ResumeToken string `json:"resumeToken,omitempty"`
+}
+
+// ErrorPayload describes why a request was rejected
+type ErrorPayload struct {
+	Error string `json:"error"`
+	// Code is a stable error code from models, when the error has one
+	Code string `json:"code,omitempty"`
+	// Errors lists the rejected fields when Code is validation_failed
+	Errors []models.FieldError `json:"errors,omitempty"`
+}
+
+// NoticePayload is a message from the operators shown to every connected
+// participant, e.g. announcing maintenance
+type NoticePayload struct {
+	Message string `json:"message"`
+}
+
+// UserLeftPayload is broadcast when a participant disconnects
+type UserLeftPayload struct {
+	UserID string `json:"userId"`
+}
+
+// VoteSubmittedPayload is broadcast when a participant votes, without
+// revealing the vote value
+type VoteSubmittedPayload struct {
+	ItemID   string `json:"itemId"`
+	UserID   string `json:"userId"`
+	HasVoted bool   `json:"hasVoted"`
+}
+
+// voteSubmittedV1 is the Version1 shape of vote_submitted
+type voteSubmittedV1 struct {
+	ItemID   string `json:"itemID"`
+	UserID   string `json:"userId"`
+	HasVoted bool   `json:"hasVoted"`
+}
+
+// Negotiate picks the protocol version to use with a client that asked
+// for the given version. Zero means the client predates negotiation.
+func Negotiate(requested int) (int, error) {
+	switch {
+	case requested == 0:
+		return Version1, nil
+	case requested < MinVersion:
+		return 0, fmt.Errorf("protocol version %d is no longer supported (minimum %d)", requested, MinVersion)
+	case requested > CurrentVersion:
+		return CurrentVersion, nil
+	default:
+		return requested, nil
+	}
+}
+
+// ForVersion converts a message built with the current protocol types into
+// the shape expected by a client speaking the given version
+func ForVersion(version int, msg models.WSMessage) models.WSMessage {
+	if version >= Version2 {
+		return msg
+	}
+
+	switch payload := msg.Payload.(type) {
+	case VoteSubmittedPayload:
+		msg.Payload = voteSubmittedV1(payload)
+	}
+	return msg
+}
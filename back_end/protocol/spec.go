@@ -0,0 +1,120 @@
+package protocol
+
+import (
+	"fmt"
+	"poker-planning-api/models"
+	"poker-planning-api/schema"
+)
+
+// Direction tells which side of the connection sends a message
+type Direction string
+
+const (
+	ClientToServer Direction = "client"
+	ServerToClient Direction = "server"
+)
+
+// MessageSpec documents a single message of the protocol
+type MessageSpec struct {
+	Type      string
+	Direction Direction
+	Summary   string
+	// Payload is a zero value of the payload type
+	Payload interface{}
+}
+
+// Messages lists every enveloped message of the protocol. The join message
+// is described separately because it is sent without an envelope.
+var Messages = []MessageSpec{
+	{TypeVote, ClientToServer, "Cast or change a vote for an item", VotePayload{}},
+	{TypeRevealVotes, ClientToServer, "Reveal all votes for an item (host only)", ItemPayload{}},
+	{TypeResetVotes, ClientToServer, "Clear all votes for an item (host only)", ItemPayload{}},
+	{TypeSetFinalEstimate, ClientToServer, "Record the agreed estimate for an item (host only)", FinalEstimatePayload{}},
+
+	{TypeWelcome, ServerToClient, "Join accepted; carries the full session state", WelcomePayload{}},
+	{TypeError, ServerToClient, "A request was rejected", ErrorPayload{}},
+	{TypeUserJoined, ServerToClient, "A participant joined the session", models.User{}},
+	{TypeUserLeft, ServerToClient, "A participant disconnected", UserLeftPayload{}},
+	{TypeItemAdded, ServerToClient, "A planning item was added", models.PlanningItem{}},
+	{TypeCurrentItemChanged, ServerToClient, "The item being estimated changed", ItemPayload{}},
+	{TypeVoteSubmitted, ServerToClient, "A participant voted; the value stays hidden", VoteSubmittedPayload{}},
+	{TypeVotesRevealed, ServerToClient, "Votes for an item were revealed", models.PlanningItem{}},
+	{TypeVotesReset, ServerToClient, "Votes for an item were cleared", ItemPayload{}},
+	{TypeFinalEstimateSet, ServerToClient, "The final estimate for an item was recorded", FinalEstimatePayload{}},
+	{TypeNotice, ServerToClient, "A notice from the operators, e.g. planned maintenance", NoticePayload{}},
+}
+
+const joinMessageName = "join"
+
+// AsyncAPI builds an AsyncAPI 2.6 document describing the WebSocket
+// protocol from the Go types above
+func AsyncAPI() map[string]interface{} {
+	g := schema.NewGenerator("#/components/schemas/")
+
+	messages := map[string]interface{}{
+		joinMessageName: map[string]interface{}{
+			"name":    joinMessageName,
+			"summary": "First frame sent by the client; not wrapped in an envelope",
+			"payload": g.For(JoinMessage{}),
+		},
+	}
+	publish := []interface{}{messageRef(joinMessageName)}
+	subscribe := []interface{}{}
+
+	for _, m := range Messages {
+		messages[m.Type] = map[string]interface{}{
+			"name":    m.Type,
+			"summary": m.Summary,
+			"payload": map[string]interface{}{
+				"type":     "object",
+				"required": []string{"type", "payload"},
+				"properties": map[string]interface{}{
+					"type":    map[string]interface{}{"const": m.Type},
+					"payload": g.For(m.Payload),
+				},
+			},
+		}
+		if m.Direction == ClientToServer {
+			publish = append(publish, messageRef(m.Type))
+		} else {
+			subscribe = append(subscribe, messageRef(m.Type))
+		}
+	}
+
+	return map[string]interface{}{
+		"asyncapi": "2.6.0",
+		"info": map[string]interface{}{
+			"title":   "Poker Planning WebSocket API",
+			"version": fmt.Sprintf("%d", CurrentVersion),
+			"description": fmt.Sprintf("Clients negotiate a protocol version by sending protocolVersion in the join message. "+
+				"Supported versions: %d to %d. Clients that omit it are served version %d.", MinVersion, CurrentVersion, Version1),
+		},
+		"defaultContentType": "application/json",
+		"channels": map[string]interface{}{
+			"/ws/{sessionId}": map[string]interface{}{
+				"parameters": map[string]interface{}{
+					"sessionId": map[string]interface{}{
+						"description": "The session's UUID or its join code",
+						"schema":      map[string]interface{}{"type": "string"},
+					},
+				},
+				"publish": map[string]interface{}{
+					"operationId": "sendToServer",
+					"message":     map[string]interface{}{"oneOf": publish},
+				},
+				"subscribe": map[string]interface{}{
+					"operationId": "receiveFromServer",
+					"message":     map[string]interface{}{"oneOf": subscribe},
+				},
+			},
+		},
+		"components": map[string]interface{}{
+			"messages": messages,
+			"schemas":  g.Schemas(),
+		},
+	}
+}
+
+func messageRef(name string) map[string]interface{} {
+	return map[string]interface{}{"$ref": "#/components/messages/" + name}
+}
@@ -0,0 +1,162 @@
+// Package ratelimit tracks per-client token buckets and quotas.
+//
+// Both types are keyed, typically by client IP, and forget keys that have
+// been idle long enough to be back at their full allowance, so memory stays
+// bounded by the number of recently active clients. A nil *Limiter or
+// *Quota allows everything, which is how a disabled limit is represented.
+package ratelimit
+
+import (
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// sweepInterval is how often idle keys are looked for
+const sweepInterval = time.Minute
+
+// Limiter is a token bucket per key
+type Limiter struct {
+	limit rate.Limit
+	burst int
+	// idle is how long a bucket takes to refill completely; idle buckets
+	// are indistinguishable from new ones and are dropped
+	idle time.Duration
+	now  func() time.Time
+
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+type bucket struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+// NewLimiter allows perSecond events per key on average with bursts of up
+// to burst. It returns nil, allowing everything, when perSecond is not
+// positive.
+func NewLimiter(perSecond float64, burst int) *Limiter {
+	if perSecond <= 0 {
+		return nil
+	}
+	if burst < 1 {
+		burst = 1
+	}
+	return &Limiter{
+		limit:   rate.Limit(perSecond),
+		burst:   burst,
+		idle:    time.Duration(float64(burst) / perSecond * float64(time.Second)),
+		now:     time.Now,
+		buckets: map[string]*bucket{},
+	}
+}
+
+// Allow takes a token for key. When none is left it returns false and how
+// long until the next one is available.
+func (l *Limiter) Allow(key string) (bool, time.Duration) {
+	if l == nil {
+		return true, 0
+	}
+	now := l.now()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.sweep(now)
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{limiter: rate.NewLimiter(l.limit, l.burst)}
+		l.buckets[key] = b
+	}
+	b.lastSeen = now
+
+	reservation := b.limiter.ReserveN(now, 1)
+	if delay := reservation.DelayFrom(now); delay > 0 {
+		reservation.CancelAt(now)
+		return false, delay
+	}
+	return true, 0
+}
+
+func (l *Limiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < sweepInterval {
+		return
+	}
+	l.lastSweep = now
+	for key, b := range l.buckets {
+		if now.Sub(b.lastSeen) > l.idle {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// Quota allows at most limit events per key within any sliding window
+type Quota struct {
+	limit  int
+	window time.Duration
+	now    func() time.Time
+
+	mu        sync.Mutex
+	events    map[string][]time.Time
+	lastSweep time.Time
+}
+
+// NewQuota allows limit events per key per window. It returns nil,
+// allowing everything, when limit is not positive.
+func NewQuota(limit int, window time.Duration) *Quota {
+	if limit <= 0 {
+		return nil
+	}
+	return &Quota{
+		limit:  limit,
+		window: window,
+		now:    time.Now,
+		events: map[string][]time.Time{},
+	}
+}
+
+// Allow records an event for key if the quota has room. Otherwise it
+// returns false and how long until the oldest event leaves the window.
+func (q *Quota) Allow(key string) (bool, time.Duration) {
+	if q == nil {
+		return true, 0
+	}
+	now := q.now()
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.sweep(now)
+
+	events := q.recent(key, now)
+	if len(events) >= q.limit {
+		q.events[key] = events
+		return false, events[0].Add(q.window).Sub(now)
+	}
+	q.events[key] = append(events, now)
+	return true, 0
+}
+
+// recent returns the events for key that are still within the window
+func (q *Quota) recent(key string, now time.Time) []time.Time {
+	events := q.events[key]
+	i := 0
+	for i < len(events) && now.Sub(events[i]) >= q.window {
+		i++
+	}
+	return events[i:]
+}
+
+func (q *Quota) sweep(now time.Time) {
+	if now.Sub(q.lastSweep) < sweepInterval {
+		return
+	}
+	q.lastSweep = now
+	for key, events := range q.events {
+		if now.Sub(events[len(events)-1]) >= q.window {
+			delete(q.events, key)
+		}
+	}
+}
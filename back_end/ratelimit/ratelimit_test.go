@@ -0,0 +1,117 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+type fakeClock struct{ t time.Time }
+
+func newClock() *fakeClock {
+	return &fakeClock{t: time.Unix(1_700_000_000, 0)}
+}
+
+func (c *fakeClock) now() time.Time {
+	return c.t
+}
+
+func (c *fakeClock) advance(d time.Duration) {
+	c.t = c.t.Add(d)
+}
+
+func allow(t *testing.T, ok bool, key string) {
+	t.Helper()
+	if !ok {
+		t.Fatalf("Allow(%q) denied, want allowed", key)
+	}
+}
+
+func deny(t *testing.T, ok bool, key string) {
+	t.Helper()
+	if ok {
+		t.Fatalf("Allow(%q) allowed, want denied", key)
+	}
+}
+
+func TestLimiterBurstAndRefill(t *testing.T) {
+	clock := newClock()
+	l := NewLimiter(2, 3)
+	l.now = clock.now
+
+	for i := 0; i < 3; i++ {
+		ok, _ := l.Allow("a")
+		allow(t, ok, "a")
+	}
+	ok, retry := l.Allow("a")
+	deny(t, ok, "a")
+	if retry != 500*time.Millisecond {
+		t.Errorf("retry = %v, want 500ms", retry)
+	}
+
+	// Keys are independent
+	ok, _ = l.Allow("b")
+	allow(t, ok, "b")
+
+	clock.advance(500 * time.Millisecond)
+	ok, _ = l.Allow("a")
+	allow(t, ok, "a")
+	ok, _ = l.Allow("a")
+	deny(t, ok, "a")
+}
+
+func TestLimiterForgetsIdleKeys(t *testing.T) {
+	clock := newClock()
+	l := NewLimiter(1, 5)
+	l.now = clock.now
+
+	l.Allow("a")
+	clock.advance(2 * sweepInterval)
+	l.Allow("b")
+	if _, ok := l.buckets["a"]; ok {
+		t.Error("idle bucket was not dropped")
+	}
+}
+
+func TestQuotaSlidingWindow(t *testing.T) {
+	clock := newClock()
+	q := NewQuota(2, time.Hour)
+	q.now = clock.now
+
+	ok, _ := q.Allow("a")
+	allow(t, ok, "a")
+	clock.advance(20 * time.Minute)
+	ok, _ = q.Allow("a")
+	allow(t, ok, "a")
+
+	ok, retry := q.Allow("a")
+	deny(t, ok, "a")
+	if retry != 40*time.Minute {
+		t.Errorf("retry = %v, want 40m", retry)
+	}
+
+	clock.advance(40 * time.Minute)
+	ok, _ = q.Allow("a")
+	allow(t, ok, "a")
+	ok, _ = q.Allow("a")
+	deny(t, ok, "a")
+}
+
+func TestDisabledLimitsAllowEverything(t *testing.T) {
+	if l := NewLimiter(0, 10); l != nil {
+		t.Fatal("NewLimiter(0) should return nil")
+	}
+	if q := NewQuota(0, time.Hour); q != nil {
+		t.Fatal("NewQuota(0) should return nil")
+	}
+
+	var l *Limiter
+	var q *Quota
+	for i := 0; i < 100; i++ {
+		if ok, _ := l.Allow("a"); !ok {
+			t.Fatal("nil Limiter denied")
+		}
+		if ok, _ := q.Allow("a"); !ok {
+			t.Fatal("nil Quota denied")
+		}
+	}
+}
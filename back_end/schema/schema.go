@@ -0,0 +1,109 @@
+package schema
+
+import (
+	"reflect"
+	"strings"
+	"time"
+)
+
+// Generator derives JSON Schemas from Go types using their json tags.
+// Named struct types are collected once and referenced with $ref, so the
+// resulting definitions can be embedded in AsyncAPI or OpenAPI documents.
+type Generator struct {
+	refPrefix string
+	schemas   map[string]interface{}
+}
+
+// NewGenerator creates a generator whose $ref values start with refPrefix,
+// e.g. "#/components/schemas/"
+func NewGenerator(refPrefix string) *Generator {
+	return &Generator{
+		refPrefix: refPrefix,
+		schemas:   map[string]interface{}{},
+	}
+}
+
+// For returns the schema for the type of v
+func (g *Generator) For(v interface{}) map[string]interface{} {
+	return g.schemaFor(reflect.TypeOf(v))
+}
+
+// Schemas returns the named struct schemas collected so far
+func (g *Generator) Schemas() map[string]interface{} {
+	return g.schemas
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+func (g *Generator) schemaFor(t reflect.Type) map[string]interface{} {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	if t == timeType {
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		if _, exists := g.schemas[t.Name()]; !exists {
+			// Reserve the name first so recursive types terminate
+			g.schemas[t.Name()] = nil
+			g.schemas[t.Name()] = g.structSchema(t)
+		}
+		return map[string]interface{}{"$ref": g.refPrefix + t.Name()}
+	case reflect.Map:
+		return map[string]interface{}{
+			"type":                 "object",
+			"additionalProperties": g.schemaFor(t.Elem()),
+		}
+	case reflect.Slice, reflect.Array:
+		return map[string]interface{}{
+			"type":  "array",
+			"items": g.schemaFor(t.Elem()),
+		}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	default:
+		return map[string]interface{}{}
+	}
+}
+
+func (g *Generator) structSchema(t reflect.Type) map[string]interface{} {
+	properties := map[string]interface{}{}
+	required := []string{}
+
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+		if name == "" {
+			name = field.Name
+		}
+
+		properties[name] = g.schemaFor(field.Type)
+		if !strings.Contains(opts, "omitempty") {
+			required = append(required, name)
+		}
+	}
+
+	return map[string]interface{}{
+		"type":       "object",
+		"properties": properties,
+		"required":   required,
+	}
+}
@@ -0,0 +1,1887 @@
+package server_test
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"poker-planning-api/auth/oidctest"
+	"poker-planning-api/client"
+	"poker-planning-api/config"
+	"poker-planning-api/db"
+	"poker-planning-api/handlers"
+	"poker-planning-api/logging"
+	"poker-planning-api/metrics"
+	"poker-planning-api/models"
+	"poker-planning-api/protocol"
+	"poker-planning-api/server"
+	"poker-planning-api/servertest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+const quiet = 100 * time.Millisecond
+
+func TestJoinBroadcastsToEveryParticipant(t *testing.T) {
+	h := servertest.New(t)
+	created := h.CreateSession("Sprint 1", "Hana")
+
+	host := h.Join(created.SessionID, "Hana", created.HostID)
+	if host.UserID() != created.HostID {
+		t.Fatalf("host joined as %s, want %s", host.UserID(), created.HostID)
+	}
+	if host.Conn.ProtocolVersion() != protocol.CurrentVersion {
+		t.Errorf("negotiated version %d, want %d", host.Conn.ProtocolVersion(), protocol.CurrentVersion)
+	}
+	joined := host.ExpectOne(protocol.TypeUserJoined).(models.User)
+	if joined.ID != created.HostID || !joined.IsHost {
+		t.Errorf("host saw %+v joining, want the host", joined)
+	}
+
+	alice := h.Join(created.SessionID, "Alice", "")
+	session := alice.Conn.Session()
+	if session.ID != created.SessionID || len(session.Users) != 2 {
+		t.Errorf("welcome carried session %s with %d users, want %s with 2", session.ID, len(session.Users), created.SessionID)
+	}
+
+	for _, p := range []*servertest.Participant{host, alice} {
+		joined := p.ExpectOne(protocol.TypeUserJoined).(models.User)
+		if joined.ID != alice.UserID() || joined.Name != "Alice" || joined.IsHost {
+			t.Errorf("saw %+v joining, want Alice", joined)
+		}
+		p.ExpectNothing(quiet)
+	}
+}
+
+func TestDuplicateUsernameIsRejected(t *testing.T) {
+	h := servertest.New(t)
+	created := h.CreateSession("Sprint 1", "Hana")
+	host := h.Join(created.SessionID, "Hana", created.HostID)
+	host.Expect(protocol.TypeUserJoined)
+
+	alice := h.Join(created.SessionID, "Alice", "")
+	host.Expect(protocol.TypeUserJoined)
+	alice.Expect(protocol.TypeUserJoined)
+
+	reason := h.JoinRejected(created.SessionID, "  aLiCe ", "")
+	if reason != "Username is already taken in this session" {
+		t.Errorf("rejection reason %q", reason)
+	}
+
+	reason = h.JoinRejected(created.SessionID, "   ", "")
+	if reason != "Username is required" {
+		t.Errorf("rejection reason %q", reason)
+	}
+
+	host.ExpectNothing(quiet)
+	alice.ExpectNothing(quiet)
+}
+
+func TestUserIDsAreBoundToTheirSession(t *testing.T) {
+	h := servertest.New(t)
+	sprint := h.CreateSession("Sprint 1", "Hana")
+	host := h.Join(sprint.SessionID, "Hana", sprint.HostID)
+	host.Expect(protocol.TypeUserJoined)
+
+	// Another session's host ID does not carry over, host rights included
+	other := h.CreateSession("Sprint 2", "Omar")
+	reason := h.JoinRejected(other.SessionID, "Hana", sprint.HostID)
+	if reason != "The user ID belongs to another session" {
+		t.Errorf("rejection reason %q", reason)
+	}
+	host.ExpectNothing(quiet)
+}
+
+func TestRejoiningNeedsMoreThanTheUserID(t *testing.T) {
+	h := servertest.New(t)
+	created := h.CreateSession("Sprint 1", "Hana")
+	host := h.Join(created.SessionID, "Hana", created.HostID)
+	host.Expect(protocol.TypeUserJoined)
+	alice := h.Join(created.SessionID, "Alice", "")
+	alice.Expect(protocol.TypeUserJoined)
+	host.Expect(protocol.TypeUserJoined)
+	if alice.Conn.ResumeToken() == "" || host.Conn.ResumeToken() != "" {
+		t.Fatalf("resume tokens %q for Alice and %q for the host, want one for Alice only",
+			alice.Conn.ResumeToken(), host.Conn.ResumeToken())
+	}
+
+	// Every participant sees the others' IDs, the host's included
+	reason := h.JoinRejected(created.SessionID, "Mallory", created.HostID)
+	if reason != "Rejoin with the participant's resume token or account" {
+		t.Errorf("taking over the host: %q", reason)
+	}
+	reason = h.JoinRejected(created.SessionID, "Mallory", alice.UserID())
+	if reason != "Rejoin with the participant's resume token or account" {
+		t.Errorf("taking over Alice: %q", reason)
+	}
+	_, err := h.Client.JoinSession(context.Background(), created.SessionID, client.JoinOptions{
+		UserID:      created.HostID,
+		ResumeToken: alice.Conn.ResumeToken(),
+	})
+	var joinErr *client.JoinError
+	if !errors.As(err, &joinErr) {
+		t.Errorf("taking over the host with Alice's resume token: %v", err)
+	}
+	host.ExpectNothing(quiet)
+
+	// The resume token brings Alice back as herself
+	alice.Close()
+	host.Expect(protocol.TypeUserLeft)
+	again := h.Join(created.SessionID, "Alice", alice.UserID())
+	if again.UserID() != alice.UserID() {
+		t.Errorf("rejoined as %s, want %s", again.UserID(), alice.UserID())
+	}
+	host.Expect(protocol.TypeUserJoined)
+}
+
+func TestLegacyParticipantsClaimAResumeTokenOnce(t *testing.T) {
+	h := servertest.New(t)
+	ctx := context.Background()
+
+	// A session, host and participant stored before host keys and resume
+	// tokens, so their hashes are NULL
+	const sessionID, hostID, aliceID = "00000000-0000-0000-0000-0000000000a1",
+		"00000000-0000-0000-0000-0000000000a2", "00000000-0000-0000-0000-0000000000a3"
+	if err := h.Store.CreateSession(ctx, models.NewSession(sessionID, "Sprint 1", hostID)); err != nil {
+		t.Fatal(err)
+	}
+	for _, user := range []*models.User{
+		{ID: hostID, SessionID: sessionID, Name: "Hana", IsHost: true},
+		{ID: aliceID, SessionID: sessionID, Name: "Alice"},
+	} {
+		if err := h.Store.CreateUser(ctx, user, sessionID); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, legacy := range []struct{ name, id string }{{"Hana", hostID}, {"Alice", aliceID}} {
+		// The first ID-only rejoin claims a resume token...
+		conn, err := h.Client.JoinSession(ctx, sessionID, client.JoinOptions{UserName: legacy.name, UserID: legacy.id})
+		if err != nil {
+			t.Fatalf("first rejoin as %s: %v", legacy.name, err)
+		}
+		token := conn.ResumeToken()
+		if conn.UserID() != legacy.id || token == "" {
+			t.Errorf("rejoined as %s with resume token %q, want %s with a token", conn.UserID(), token, legacy.id)
+		}
+		conn.Close()
+
+		// ...after which the ID alone is no longer enough
+		reason := h.JoinRejected(sessionID, legacy.name, legacy.id)
+		if reason != "Rejoin with the participant's resume token or account" {
+			t.Errorf("second ID-only rejoin as %s: %q", legacy.name, reason)
+		}
+		conn, err = h.Client.JoinSession(ctx, sessionID, client.JoinOptions{UserName: legacy.name, UserID: legacy.id, ResumeToken: token})
+		if err != nil {
+			t.Fatalf("rejoin as %s with the claimed token: %v", legacy.name, err)
+		}
+		conn.Close()
+	}
+}
+
+func TestItemsAreBoundToTheirSession(t *testing.T) {
+	h := servertest.New(t)
+	ctx := context.Background()
+	sprint := h.CreateSession("Sprint 1", "Hana")
+	host := h.Join(sprint.SessionID, "Hana", sprint.HostID)
+	host.Expect(protocol.TypeUserJoined)
+	other := h.CreateSession("Sprint 2", "Omar")
+	theirs := h.AddItem(other.SessionID, "Login page")
+	h.Store.SaveVote(ctx, theirs.ID, other.HostID, "5")
+
+	// Another session's items are not found, whoever asks
+	host.Conn.Vote(theirs.ID, "8")
+	host.Conn.RevealVotes(theirs.ID)
+	host.Conn.ResetVotes(theirs.ID)
+	host.Conn.SetFinalEstimate(theirs.ID, "8")
+	for _, message := range []string{"vote", "reveal_votes", "reset_votes", "set_final_estimate"} {
+		if rejected := host.ExpectOne(protocol.TypeError).(protocol.ErrorPayload); rejected.Code != models.CodeItemNotFound {
+			t.Errorf("%s on another session's item: %+v", message, rejected)
+		}
+	}
+	host.ExpectNothing(quiet)
+	err := h.Client.SetCurrentItem(ctx, sprint.SessionID, theirs.ID)
+	expectProblem(t, "current item of another session", err, http.StatusNotFound, models.CodeItemNotFound)
+
+	saved, err := h.Store.GetPlanningItemByID(ctx, theirs.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saved.Revealed || saved.FinalEstimate != "" || len(saved.Votes) != 1 || saved.Votes[other.HostID] != "5" {
+		t.Errorf("another session's item changed: %+v", saved)
+	}
+
+	// The session's own items, added after it was loaded, are found
+	ours := h.AddItem(sprint.SessionID, "Signup page")
+	host.Expect(protocol.TypeItemAdded)
+	host.Conn.Vote(ours.ID, "8")
+	host.Expect(protocol.TypeVoteSubmitted)
+}
+
+func TestVotingRound(t *testing.T) {
+	h := servertest.New(t)
+	created := h.CreateSession("Sprint 1", "Hana")
+	host := h.Join(created.SessionID, "Hana", created.HostID)
+	host.Expect(protocol.TypeUserJoined)
+	alice := h.Join(created.SessionID, "Alice", "")
+	host.Expect(protocol.TypeUserJoined)
+	alice.Expect(protocol.TypeUserJoined)
+	everyone := []*servertest.Participant{host, alice}
+
+	item := h.AddItem(created.SessionID, "Login page")
+	for _, p := range everyone {
+		added := p.ExpectOne(protocol.TypeItemAdded).(models.PlanningItem)
+		if added.ID != item.ID || added.Title != "Login page" {
+			t.Errorf("item_added %+v, want %+v", added, item)
+		}
+	}
+
+	h.SetCurrentItem(created.SessionID, item.ID)
+	for _, p := range everyone {
+		if changed := p.ExpectOne(protocol.TypeCurrentItemChanged).(protocol.ItemPayload); changed.ItemID != item.ID {
+			t.Errorf("current_item_changed to %s, want %s", changed.ItemID, item.ID)
+		}
+	}
+
+	// Votes are announced without their value
+	if err := alice.Conn.Vote(item.ID, "5"); err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range everyone {
+		submitted := p.ExpectOne(protocol.TypeVoteSubmitted).(protocol.VoteSubmittedPayload)
+		want := protocol.VoteSubmittedPayload{ItemID: item.ID, UserID: alice.UserID(), HasVoted: true}
+		if submitted != want {
+			t.Errorf("vote_submitted %+v, want %+v", submitted, want)
+		}
+	}
+	if err := host.Conn.Vote(item.ID, "8"); err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range everyone {
+		p.Expect(protocol.TypeVoteSubmitted)
+	}
+
+	// Only the host may reveal
+	alice.Conn.RevealVotes(item.ID)
+	host.ExpectNothing(quiet)
+	alice.ExpectNothing(quiet)
+
+	host.Conn.RevealVotes(item.ID)
+	for _, p := range everyone {
+		revealed := p.ExpectOne(protocol.TypeVotesRevealed).(models.PlanningItem)
+		if !revealed.Revealed || revealed.Votes[alice.UserID()] != "5" || revealed.Votes[host.UserID()] != "8" {
+			t.Errorf("votes_revealed %+v", revealed)
+		}
+	}
+
+	host.Conn.ResetVotes(item.ID)
+	for _, p := range everyone {
+		if reset := p.ExpectOne(protocol.TypeVotesReset).(protocol.ItemPayload); reset.ItemID != item.ID {
+			t.Errorf("votes_reset for %s, want %s", reset.ItemID, item.ID)
+		}
+	}
+	stored, err := h.Store.GetPlanningItemByID(context.Background(), item.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Revealed || len(stored.Votes) != 0 {
+		t.Errorf("after reset the store has %+v", stored)
+	}
+
+	host.Conn.SetFinalEstimate(item.ID, "5")
+	for _, p := range everyone {
+		final := p.ExpectOne(protocol.TypeFinalEstimateSet).(protocol.FinalEstimatePayload)
+		if final != (protocol.FinalEstimatePayload{ItemID: item.ID, Estimate: "5"}) {
+			t.Errorf("final_estimate_set %+v", final)
+		}
+		p.ExpectNothing(quiet)
+	}
+
+	// An empty estimate clears it
+	host.Conn.SetFinalEstimate(item.ID, "")
+	for _, p := range everyone {
+		final := p.ExpectOne(protocol.TypeFinalEstimateSet).(protocol.FinalEstimatePayload)
+		if final != (protocol.FinalEstimatePayload{ItemID: item.ID}) {
+			t.Errorf("final_estimate_set %+v", final)
+		}
+	}
+	if stored, err := h.Store.GetPlanningItemByID(context.Background(), item.ID); err != nil || stored.FinalEstimate != "" {
+		t.Errorf("cleared final estimate: %+v, %v", stored, err)
+	}
+}
+
+func TestDisconnectBroadcastsUserLeft(t *testing.T) {
+	h := servertest.New(t)
+	created := h.CreateSession("Sprint 1", "Hana")
+	host := h.Join(created.SessionID, "Hana", created.HostID)
+	host.Expect(protocol.TypeUserJoined)
+	alice := h.Join(created.SessionID, "Alice", "")
+	host.Expect(protocol.TypeUserJoined)
+	alice.Expect(protocol.TypeUserJoined)
+
+	alice.Close()
+	left := host.ExpectOne(protocol.TypeUserLeft).(protocol.UserLeftPayload)
+	if left.UserID != alice.UserID() {
+		t.Errorf("user_left for %s, want %s", left.UserID, alice.UserID())
+	}
+	host.ExpectNothing(quiet)
+
+	user, err := h.Store.GetUserByID(context.Background(), alice.UserID())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.Connected {
+		t.Error("disconnected user is still marked connected in the store")
+	}
+
+	// The same user can come back under its ID
+	again := h.Join(created.SessionID, "Alice", alice.UserID())
+	if again.UserID() != alice.UserID() {
+		t.Errorf("rejoined as %s, want %s", again.UserID(), alice.UserID())
+	}
+	if joined := host.ExpectOne(protocol.TypeUserJoined).(models.User); joined.ID != alice.UserID() {
+		t.Errorf("user_joined for %s, want %s", joined.ID, alice.UserID())
+	}
+}
+
+func TestLegacyClientReceivesVersion1Payloads(t *testing.T) {
+	h := servertest.New(t)
+	created := h.CreateSession("Sprint 1", "Hana")
+	item := h.AddItem(created.SessionID, "Login page")
+
+	// A client that predates version negotiation sends no protocolVersion
+	ws, _, err := websocket.DefaultDialer.Dial(h.WebSocketURL(created.SessionID), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+	ws.SetReadDeadline(time.Now().Add(servertest.Timeout))
+	if err := ws.WriteJSON(map[string]string{"userName": "Old"}); err != nil {
+		t.Fatal(err)
+	}
+
+	var welcome struct {
+		Type    string
+		Payload protocol.WelcomePayload
+	}
+	if err := ws.ReadJSON(&welcome); err != nil || welcome.Type != protocol.TypeWelcome {
+		t.Fatalf("welcome: %+v, %v", welcome, err)
+	}
+	if welcome.Payload.ProtocolVersion != protocol.Version1 {
+		t.Errorf("negotiated version %d, want %d", welcome.Payload.ProtocolVersion, protocol.Version1)
+	}
+
+	var joined map[string]interface{}
+	ws.ReadJSON(&joined)
+
+	ws.WriteJSON(models.WSMessage{Type: protocol.TypeVote, Payload: protocol.VotePayload{ItemID: item.ID, Vote: "3"}})
+	var submitted struct {
+		Type    string
+		Payload map[string]interface{}
+	}
+	if err := ws.ReadJSON(&submitted); err != nil {
+		t.Fatal(err)
+	}
+	if submitted.Type != protocol.TypeVoteSubmitted || submitted.Payload["itemID"] != item.ID {
+		t.Errorf("version 1 client got %+v, want vote_submitted with itemID", submitted)
+	}
+}
+
+func TestRESTErrorsAreProblems(t *testing.T) {
+	h := servertest.New(t)
+
+	_, err := h.Client.GetSession(context.Background(), "00000000-0000-0000-0000-000000000000")
+	var apiErr *client.Error
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusNotFound || apiErr.Problem.Code != models.CodeSessionNotFound {
+		t.Errorf("get missing session: %v", err)
+	}
+	if apiErr != nil && apiErr.Problem.RequestID == "" {
+		t.Error("problem has no request ID")
+	}
+
+	_, err = h.Client.CreateSession(context.Background(), models.CreateSessionRequest{Name: "Sprint 1"})
+	if !errors.As(err, &apiErr) || apiErr.Problem.Code != models.CodeValidationFailed ||
+		len(apiErr.Problem.Errors) != 1 || apiErr.Problem.Errors[0].Field != "hostName" {
+		t.Errorf("create session without host: %v", err)
+	}
+}
+
+func TestMetricsFollowTheSession(t *testing.T) {
+	h := servertest.New(t)
+	// Metrics are process-wide, so compare against the values before the test
+	votes := testutil.ToFloat64(metrics.VotesCast)
+	reveals := testutil.ToFloat64(metrics.Reveals)
+	voteMessages := testutil.ToFloat64(metrics.WebSocketMessages.WithLabelValues(protocol.TypeVote))
+	sent := testutil.ToFloat64(metrics.BroadcastMessages.WithLabelValues(protocol.TypeVoteSubmitted, metrics.ResultSent))
+
+	created := h.CreateSession("Sprint 1", "Hana")
+	item := h.AddItem(created.SessionID, "Login page")
+	host := h.Join(created.SessionID, "Hana", created.HostID)
+	host.Expect(protocol.TypeUserJoined)
+	alice := h.Join(created.SessionID, "Alice", "")
+	host.Expect(protocol.TypeUserJoined)
+	alice.Expect(protocol.TypeUserJoined)
+
+	if got := testutil.ToFloat64(metrics.WebSocketConnections); got != 2 {
+		t.Errorf("%v open connections, want 2", got)
+	}
+	if got := testutil.ToFloat64(metrics.ActiveSessions); got != 1 {
+		t.Errorf("%v active sessions, want 1", got)
+	}
+
+	alice.Conn.Vote(item.ID, "5")
+	host.Expect(protocol.TypeVoteSubmitted)
+	alice.Expect(protocol.TypeVoteSubmitted)
+	host.Conn.RevealVotes(item.ID)
+	host.Expect(protocol.TypeVotesRevealed)
+
+	if got := testutil.ToFloat64(metrics.VotesCast) - votes; got != 1 {
+		t.Errorf("votes increased by %v, want 1", got)
+	}
+	if got := testutil.ToFloat64(metrics.Reveals) - reveals; got != 1 {
+		t.Errorf("reveals increased by %v, want 1", got)
+	}
+	if got := testutil.ToFloat64(metrics.WebSocketMessages.WithLabelValues(protocol.TypeVote)) - voteMessages; got != 1 {
+		t.Errorf("vote messages increased by %v, want 1", got)
+	}
+	if got := testutil.ToFloat64(metrics.BroadcastMessages.WithLabelValues(protocol.TypeVoteSubmitted, metrics.ResultSent)) - sent; got != 2 {
+		t.Errorf("vote_submitted deliveries increased by %v, want 2", got)
+	}
+
+	resp, err := http.Get(h.URL() + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	for _, name := range []string{
+		"poker_active_sessions", "poker_websocket_connections", "poker_websocket_messages_total",
+		"poker_broadcast_messages_total", "poker_broadcast_duration_seconds", "poker_votes_total",
+		"poker_reveals_total",
+	} {
+		if !strings.Contains(string(body), "# TYPE "+name+" ") {
+			t.Errorf("/metrics does not expose %s", name)
+		}
+	}
+}
+
+func TestConnectionLogsCarrySessionAndUser(t *testing.T) {
+	h := servertest.New(t)
+	created := h.CreateSession("Sprint 1", "Hana")
+	host := h.Join(created.SessionID, "Hana", created.HostID)
+	host.Expect(protocol.TypeUserJoined)
+	host.Close()
+	h.Server.Close()
+	handlers.WaitForConnections()
+
+	events := map[string]map[string]interface{}{}
+	lines := bufio.NewScanner(strings.NewReader(h.Logs()))
+	for lines.Scan() {
+		var entry map[string]interface{}
+		if err := json.Unmarshal(lines.Bytes(), &entry); err != nil {
+			t.Fatalf("log line is not JSON: %s", lines.Text())
+		}
+		events[entry["msg"].(string)] = entry
+	}
+
+	for _, msg := range []string{"websocket joined", "websocket left"} {
+		entry, ok := events[msg]
+		if !ok {
+			t.Errorf("no %q log entry", msg)
+			continue
+		}
+		if entry[logging.KeySessionID] != created.SessionID || entry[logging.KeyUserID] != created.HostID {
+			t.Errorf("%q logged with session %v and user %v", msg, entry[logging.KeySessionID], entry[logging.KeyUserID])
+		}
+		if entry[logging.KeyRequestID] == "" || entry[logging.KeyRequestID] == nil {
+			t.Errorf("%q has no request ID", msg)
+		}
+	}
+	if events["http request"] == nil {
+		t.Error("no access log entry")
+	}
+}
+
+func TestReadinessFollowsTheDatabase(t *testing.T) {
+	h := servertest.New(t)
+	created := h.CreateSession("Sprint 1", "Hana")
+
+	var ready models.ReadinessResponse
+	if status := getJSON(t, h.URL()+"/ready", &ready); status != http.StatusOK || ready.SchemaVersion != db.SchemaVersion {
+		t.Errorf("/ready: %d %+v", status, ready)
+	}
+
+	h.Store.SetUnavailable(true)
+
+	var problem models.Problem
+	if status := getJSON(t, h.URL()+"/ready", &problem); status != http.StatusServiceUnavailable || problem.Code != models.CodeNotReady {
+		t.Errorf("/ready during outage: %d %+v", status, problem)
+	}
+	if _, err := h.Client.Health(context.Background()); err != nil {
+		t.Errorf("/health during outage: %v", err)
+	}
+	_, err := h.Client.GetSession(context.Background(), created.SessionID)
+	var apiErr *client.Error
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusServiceUnavailable || apiErr.Problem.Code != models.CodeDatabaseUnavailable {
+		t.Errorf("get session during outage: %v", err)
+	}
+
+	h.Store.SetUnavailable(false)
+
+	if status := getJSON(t, h.URL()+"/ready", &ready); status != http.StatusOK {
+		t.Errorf("/ready after recovery: %d", status)
+	}
+	if _, err := h.Client.GetSession(context.Background(), created.SessionID); err != nil {
+		t.Errorf("get session after recovery: %v", err)
+	}
+}
+
+func getJSON(t *testing.T, url string, v interface{}) int {
+	t.Helper()
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		t.Fatalf("GET %s: %v", url, err)
+	}
+	return resp.StatusCode
+}
+
+func TestShutdownAsksClientsToReconnect(t *testing.T) {
+	h := servertest.New(t)
+	created := h.CreateSession("Sprint 1", "Hana")
+	host := h.Join(created.SessionID, "Hana", created.HostID)
+	host.Expect(protocol.TypeUserJoined)
+	alice := h.Join(created.SessionID, "Alice", "")
+	host.Expect(protocol.TypeUserJoined)
+	alice.Expect(protocol.TypeUserJoined)
+
+	ctx, cancel := context.WithTimeout(context.Background(), servertest.Timeout)
+	defer cancel()
+	if err := server.Shutdown(ctx, h.Server.Config); err != nil {
+		t.Fatalf("shutdown: %v", err)
+	}
+
+	for _, p := range []*servertest.Participant{host, alice} {
+		select {
+		case <-p.Conn.Done():
+		case <-time.After(servertest.Timeout):
+			t.Fatalf("%s is still connected", p.UserID())
+		}
+		var closeErr *websocket.CloseError
+		if !errors.As(p.Conn.Err(), &closeErr) || closeErr.Code != websocket.CloseServiceRestart || closeErr.Text != handlers.CloseReasonRestart {
+			t.Errorf("%s disconnected with %v, want a service restart close frame", p.UserID(), p.Conn.Err())
+		}
+		// Participants are not told about each other leaving
+		p.ExpectNothing(quiet)
+
+		user, err := h.Store.GetUserByID(context.Background(), p.UserID())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if user.Connected {
+			t.Errorf("%s is still marked connected in the store", p.UserID())
+		}
+	}
+}
+
+func TestClientReconnectsUntilClosed(t *testing.T) {
+	h := servertest.New(t)
+	created := h.CreateSession("Sprint 1", "Hana")
+	welcomes := make(chan protocol.WelcomePayload, 8)
+	drops := make(chan error, 8)
+	conn, err := h.Client.JoinSession(context.Background(), created.SessionID, client.JoinOptions{
+		UserName:  "Alice",
+		Reconnect: true,
+		Handlers: client.Handlers{
+			OnWelcome:    func(welcome protocol.WelcomePayload) { welcomes <- welcome },
+			OnDisconnect: func(err error) { drops <- err },
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	<-welcomes
+
+	// drop has the server close the connection, for a frame over its limit
+	drop := func() {
+		t.Helper()
+		conn.Vote(strings.Repeat("x", 1<<20), "5")
+		select {
+		case <-drops:
+		case <-time.After(servertest.Timeout):
+			t.Fatal("connection not dropped")
+		}
+	}
+	// failedRejoins counts the rejoins the server failed to serve
+	failedRejoins := func() int {
+		return strings.Count(h.Logs(), `"msg":"failed to load user"`)
+	}
+	waitForFailedRejoins := func(n int) {
+		t.Helper()
+		deadline := time.Now().Add(servertest.Timeout)
+		for failedRejoins() < n {
+			if time.Now().After(deadline) {
+				t.Fatalf("%d failed rejoins, want %d", failedRejoins(), n)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	// A rejoin the server fails to serve is retried
+	h.Store.SetUnavailable(true)
+	drop()
+	waitForFailedRejoins(1)
+	h.Store.SetUnavailable(false)
+	select {
+	case welcome := <-welcomes:
+		if welcome.UserID != conn.UserID() {
+			t.Errorf("rejoined as %s, want %s", welcome.UserID, conn.UserID())
+		}
+	case <-time.After(servertest.Timeout):
+		t.Fatal("not reconnected once the database was back")
+	}
+
+	// Close ends the backoff between attempts at once
+	h.Store.SetUnavailable(true)
+	defer h.Store.SetUnavailable(false)
+	failed := failedRejoins()
+	drop()
+	waitForFailedRejoins(failed + 1)
+	conn.Close()
+	select {
+	case <-conn.Done():
+	// Well below the second attempt's backoff of a second
+	case <-time.After(200 * time.Millisecond):
+		t.Fatal("Close waited for the reconnect backoff")
+	}
+	if err := conn.Err(); err != nil {
+		t.Errorf("Err() = %v after Close, want nil", err)
+	}
+}
+
+func TestRequestsAreRateLimitedPerClient(t *testing.T) {
+	h := servertest.New(t, servertest.WithRateLimit(config.RateLimitConfig{
+		Enabled:           true,
+		TrustedProxies:    1,
+		RequestsPerSecond: 0.1,
+		RequestBurst:      2,
+	}))
+	get := func(path, clientIP string) *http.Response {
+		t.Helper()
+		req, _ := http.NewRequest(http.MethodGet, h.URL()+path, nil)
+		req.Header.Set("X-Forwarded-For", "203.0.113.99, "+clientIP)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp
+	}
+
+	missing := "/api/sessions/00000000-0000-0000-0000-000000000000"
+	for i := 0; i < 2; i++ {
+		if resp := get(missing, "198.51.100.1"); resp.StatusCode != http.StatusNotFound {
+			t.Fatalf("request %d: status %d, want 404", i, resp.StatusCode)
+		}
+	}
+	resp := get(missing, "198.51.100.1")
+	if resp.StatusCode != http.StatusTooManyRequests || resp.Header.Get("Retry-After") == "" {
+		t.Errorf("over the limit: status %d, Retry-After %q; want 429 with Retry-After",
+			resp.StatusCode, resp.Header.Get("Retry-After"))
+	}
+
+	// Other clients and probes are unaffected
+	if resp := get(missing, "198.51.100.2"); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("another client: status %d, want 404", resp.StatusCode)
+	}
+	if resp := get("/health", "198.51.100.1"); resp.StatusCode != http.StatusOK {
+		t.Errorf("/health: status %d, want 200", resp.StatusCode)
+	}
+}
+
+func TestSessionCreationIsCapped(t *testing.T) {
+	h := servertest.New(t, servertest.WithRateLimit(config.RateLimitConfig{
+		Enabled:         true,
+		SessionsPerHour: 2,
+	}))
+	before := testutil.ToFloat64(metrics.RateLimited.WithLabelValues(metrics.LimitSessions))
+
+	// Requests rejected by other checks do not count
+	var apiErr *client.Error
+	for _, req := range []models.CreateSessionRequest{
+		{Name: "Sprint 0", HostName: "Hana", TemplateID: "00000000-0000-0000-0000-000000000001"},
+		{Name: "Sprint 0", HostName: "Hana", TeamID: "00000000-0000-0000-0000-000000000001"},
+		{Name: "Sprint 0", HostName: "Hana", AuthRequired: true},
+	} {
+		_, err := h.Client.CreateSession(context.Background(), req)
+		if !errors.As(err, &apiErr) || apiErr.StatusCode == http.StatusTooManyRequests {
+			t.Errorf("rejected request %+v: %v, want a 4xx other than 429", req, err)
+		}
+	}
+
+	h.CreateSession("Sprint 1", "Hana")
+	h.CreateSession("Sprint 2", "Hana")
+	_, err := h.Client.CreateSession(context.Background(), models.CreateSessionRequest{Name: "Sprint 3", HostName: "Hana"})
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusTooManyRequests || apiErr.Problem.Code != models.CodeSessionQuota {
+		t.Errorf("third session: %v, want 429 %s", err, models.CodeSessionQuota)
+	}
+	if got := testutil.ToFloat64(metrics.RateLimited.WithLabelValues(metrics.LimitSessions)) - before; got != 1 {
+		t.Errorf("rate_limited_total{limit=sessions} grew by %v, want 1", got)
+	}
+}
+
+func TestFloodingClientIsDisconnected(t *testing.T) {
+	h := servertest.New(t, servertest.WithRateLimit(config.RateLimitConfig{
+		Enabled:           true,
+		MessagesPerSecond: 0.1,
+		MessageBurst:      2,
+	}))
+	created := h.CreateSession("Sprint 1", "Hana")
+	host := h.Join(created.SessionID, "Hana", created.HostID)
+	host.Expect(protocol.TypeUserJoined)
+	alice := h.Join(created.SessionID, "Alice", "")
+	host.Expect(protocol.TypeUserJoined)
+	alice.Expect(protocol.TypeUserJoined)
+	item := h.AddItem(created.SessionID, "Login page")
+	host.Expect(protocol.TypeItemAdded)
+	alice.Expect(protocol.TypeItemAdded)
+
+	for i := 0; i < 5; i++ {
+		alice.Conn.Vote(item.ID, "5")
+	}
+
+	// The burst is handled, then the client is told why it is dropped
+	events := alice.Expect(protocol.TypeVoteSubmitted, protocol.TypeVoteSubmitted, protocol.TypeError)
+	if payload := events[2].Payload.(protocol.ErrorPayload); payload.Code != models.CodeRateLimited {
+		t.Errorf("error payload %+v, want code %s", payload, models.CodeRateLimited)
+	}
+	select {
+	case <-alice.Conn.Done():
+	case <-time.After(servertest.Timeout):
+		t.Fatal("flooding client is still connected")
+	}
+	var closeErr *websocket.CloseError
+	if !errors.As(alice.Conn.Err(), &closeErr) || closeErr.Code != websocket.ClosePolicyViolation {
+		t.Errorf("disconnected with %v, want a policy violation close frame", alice.Conn.Err())
+	}
+
+	host.Expect(protocol.TypeVoteSubmitted, protocol.TypeVoteSubmitted, protocol.TypeUserLeft)
+}
+
+func TestOversizedFramesAreRefused(t *testing.T) {
+	h := servertest.New(t)
+	created := h.CreateSession("Sprint 1", "Hana")
+	conn, _, err := websocket.DefaultDialer.Dial(h.WebSocketURL(created.SessionID), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	join := `{"userName":"` + strings.Repeat("a", 1<<20) + `"}`
+	conn.WriteMessage(websocket.TextMessage, []byte(join))
+	conn.SetReadDeadline(time.Now().Add(servertest.Timeout))
+	// The server stops reading at the limit and drops the connection
+	// while the frame is still being written
+	if _, _, err := conn.ReadMessage(); err == nil {
+		t.Fatal("connection still open after an oversized join")
+	}
+	if !strings.Contains(h.Logs(), websocket.ErrReadLimit.Error()) {
+		t.Error("oversized join was not refused at the read limit")
+	}
+}
+
+func TestWebSocketOriginIsChecked(t *testing.T) {
+	h := servertest.New(t)
+	created := h.CreateSession("Sprint 1", "Hana")
+	dial := func(origin string) (*websocket.Conn, *http.Response, error) {
+		header := http.Header{}
+		if origin != "" {
+			header.Set("Origin", origin)
+		}
+		return websocket.DefaultDialer.Dial(h.WebSocketURL(created.SessionID), header)
+	}
+
+	conn, resp, err := dial("https://evil.example")
+	if err == nil {
+		conn.Close()
+		t.Fatal("upgrade from a foreign origin succeeded")
+	}
+	var problem models.Problem
+	if resp == nil || resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("foreign origin: %v, want 403", err)
+	}
+	json.NewDecoder(resp.Body).Decode(&problem)
+	if problem.Code != models.CodeOriginNotAllowed {
+		t.Errorf("problem code %q, want %s", problem.Code, models.CodeOriginNotAllowed)
+	}
+	if !strings.Contains(h.Logs(), `"origin":"https://evil.example"`) {
+		t.Error("rejected origin was not logged")
+	}
+
+	// The configured origin and clients that send none are accepted
+	for _, origin := range []string{"http://localhost:3000", ""} {
+		conn, _, err := dial(origin)
+		if err != nil {
+			t.Fatalf("origin %q: %v", origin, err)
+		}
+		conn.Close()
+	}
+}
+
+func TestAllowAllOriginsOverride(t *testing.T) {
+	h := servertest.New(t, func(opts *server.Options) {
+		opts.AllowAllOrigins = true
+	})
+	created := h.CreateSession("Sprint 1", "Hana")
+
+	header := http.Header{"Origin": {"http://192.168.1.20:3000"}}
+	conn, _, err := websocket.DefaultDialer.Dial(h.WebSocketURL(created.SessionID), header)
+	if err != nil {
+		t.Fatalf("development override: %v", err)
+	}
+	conn.Close()
+}
+
+func TestCORSSharesTheOriginPolicy(t *testing.T) {
+	h := servertest.New(t)
+
+	for origin, want := range map[string]string{
+		"http://localhost:3000": "http://localhost:3000",
+		"https://evil.example":  "",
+	} {
+		req, _ := http.NewRequest(http.MethodGet, h.URL()+"/api/sessions", nil)
+		req.Header.Set("Origin", origin)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if got := resp.Header.Get("Access-Control-Allow-Origin"); got != want {
+			t.Errorf("origin %s: Access-Control-Allow-Origin %q, want %q", origin, got, want)
+		}
+	}
+}
+
+func TestRESTInputIsValidated(t *testing.T) {
+	h := servertest.New(t)
+	ctx := context.Background()
+	fieldErrors := func(err error) map[string]string {
+		t.Helper()
+		var apiErr *client.Error
+		if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusBadRequest || apiErr.Problem.Code != models.CodeValidationFailed {
+			t.Fatalf("got %v, want 400 %s", err, models.CodeValidationFailed)
+		}
+		codes := map[string]string{}
+		for _, e := range apiErr.Problem.Errors {
+			codes[e.Field] = e.Code
+		}
+		return codes
+	}
+
+	_, err := h.Client.CreateSession(ctx, models.CreateSessionRequest{
+		Name:     strings.Repeat("n", 256),
+		HostName: "Ha\x00na",
+	})
+	if got := fieldErrors(err); got["name"] != models.FieldTooLong || got["hostName"] != models.FieldInvalidCharacters {
+		t.Errorf("create session field errors %v", got)
+	}
+
+	// Names are trimmed and normalized; 255 characters is the limit, not
+	// 255 bytes
+	created, err := h.Client.CreateSession(ctx, models.CreateSessionRequest{
+		Name:     "  " + strings.Repeat("é", 255) + "  ",
+		HostName: "Hana",
+	})
+	if err != nil {
+		t.Fatalf("create session with a 255 character name: %v", err)
+	}
+	session, err := h.Client.GetSession(ctx, created.SessionID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if session.Name != strings.Repeat("é", 255) {
+		t.Errorf("session name was not trimmed and normalized: %q", session.Name)
+	}
+
+	_, err = h.Client.AddItem(ctx, created.SessionID, models.AddItemRequest{Title: " \t", Description: "line 1\nline 2"})
+	if got := fieldErrors(err); got["title"] != models.FieldRequired || len(got) != 1 {
+		t.Errorf("add item field errors %v", got)
+	}
+	_, err = h.Client.AddItem(ctx, created.SessionID, models.AddItemRequest{Title: strings.Repeat("t", 501)})
+	if got := fieldErrors(err); got["title"] != models.FieldTooLong {
+		t.Errorf("add item field errors %v", got)
+	}
+
+	err = h.Client.SetCurrentItem(ctx, created.SessionID, "not-an-id")
+	if got := fieldErrors(err); got["itemId"] != models.FieldInvalidFormat {
+		t.Errorf("set current item field errors %v", got)
+	}
+
+	// Only the canonical spelling of an ID finds what it names, as the
+	// in-memory store and PostgreSQL would otherwise disagree
+	item := h.AddItem(created.SessionID, "Story")
+	err = h.Client.SetCurrentItem(ctx, created.SessionID, strings.ToUpper(item.ID))
+	if got := fieldErrors(err); got["itemId"] != models.FieldInvalidFormat {
+		t.Errorf("set current item with an upper case ID: field errors %v", got)
+	}
+	for _, id := range []string{"{" + created.SessionID + "}", strings.ReplaceAll(created.SessionID, "-", "")} {
+		_, err = h.Client.GetSession(ctx, id)
+		var apiErr *client.Error
+		if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusNotFound || apiErr.Problem.Code != models.CodeSessionNotFound {
+			t.Errorf("get session %s: %v, want 404 %s", id, err, models.CodeSessionNotFound)
+		}
+		_, err = h.Client.AddItem(ctx, id, models.AddItemRequest{Title: "Story"})
+		if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusNotFound {
+			t.Errorf("add item to session %s: %v, want 404", id, err)
+		}
+	}
+}
+
+func TestWebSocketInputIsValidated(t *testing.T) {
+	h := servertest.New(t)
+	created := h.CreateSession("Sprint 1", "Hana")
+	host := h.Join(created.SessionID, "Hana", created.HostID)
+	host.Expect(protocol.TypeUserJoined)
+	item := h.AddItem(created.SessionID, "Login page")
+	host.Expect(protocol.TypeItemAdded)
+
+	if reason := h.JoinRejected(created.SessionID, "Al\u202eice", ""); !strings.Contains(reason, "control characters") {
+		t.Errorf("join with a bidi override: %q", reason)
+	}
+
+	// Invalid messages are answered with field errors and change nothing
+	host.Conn.Vote(item.ID, "12345678901")
+	rejected := host.ExpectOne(protocol.TypeError).(protocol.ErrorPayload)
+	if rejected.Code != models.CodeValidationFailed || len(rejected.Errors) != 1 ||
+		rejected.Errors[0].Field != "vote" || rejected.Errors[0].Code != models.FieldTooLong {
+		t.Errorf("vote error %+v", rejected)
+	}
+	host.Conn.SetFinalEstimate("not-an-id", "5")
+	rejected = host.ExpectOne(protocol.TypeError).(protocol.ErrorPayload)
+	if len(rejected.Errors) != 1 || rejected.Errors[0].Field != "itemId" {
+		t.Errorf("set_final_estimate error %+v", rejected)
+	}
+	host.ExpectNothing(quiet)
+
+	// The connection stays usable
+	host.Conn.Vote(item.ID, " 8 ")
+	host.Expect(protocol.TypeVoteSubmitted)
+	saved, err := h.Store.GetPlanningItemByID(context.Background(), item.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saved.Votes[created.HostID] != "8" {
+		t.Errorf("stored vote %q, want trimmed \"8\"", saved.Votes[created.HostID])
+	}
+}
+
+func TestJoinCodes(t *testing.T) {
+	h := servertest.New(t)
+	ctx := context.Background()
+	created := h.CreateSession("Sprint 1", "Hana")
+	if len(created.JoinCode) != 6 {
+		t.Fatalf("join code %q, want 6 characters", created.JoinCode)
+	}
+
+	typed := strings.ToLower(created.JoinCode[:3] + "-" + created.JoinCode[3:])
+	resolved, err := h.Client.ResolveJoinCode(ctx, typed)
+	if err != nil {
+		t.Fatalf("resolve %q: %v", typed, err)
+	}
+	if resolved.SessionID != created.SessionID || resolved.Name != "Sprint 1" || resolved.JoinCode != created.JoinCode {
+		t.Errorf("resolved %+v", resolved)
+	}
+
+	alice := h.Join(created.JoinCode, "Alice", "")
+	if session := alice.Conn.Session(); session.ID != created.SessionID || session.JoinCode != created.JoinCode {
+		t.Errorf("joined by code into %s (%s), want %s", session.ID, session.JoinCode, created.SessionID)
+	}
+
+	var apiErr *client.Error
+	_, err = h.Client.ResolveJoinCode(ctx, "I0O1LU")
+	if !errors.As(err, &apiErr) || apiErr.Problem.Code != models.CodeJoinCodeNotFound {
+		t.Errorf("resolve malformed code: %v", err)
+	}
+
+	err = h.Client.ArchiveSession(ctx, created.SessionID, created.HostID)
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusForbidden || apiErr.Problem.Code != models.CodeNotHost {
+		t.Errorf("archive by a participant: %v", err)
+	}
+	if err := h.Client.ArchiveSession(ctx, created.SessionID, created.HostKey); err != nil {
+		t.Fatalf("archive: %v", err)
+	}
+
+	_, err = h.Client.ResolveJoinCode(ctx, created.JoinCode)
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusNotFound || apiErr.Problem.Code != models.CodeJoinCodeNotFound {
+		t.Errorf("resolve archived code: %v", err)
+	}
+	_, resp, err := websocket.DefaultDialer.Dial(h.WebSocketURL(created.SessionID), nil)
+	if err == nil || resp == nil || resp.StatusCode != http.StatusGone {
+		t.Errorf("join archived session: %v", err)
+	}
+
+	// The archived session's code is free again, but only once
+	reuse := func(id string) error {
+		session := models.NewSession(id, "Sprint 2", "00000000-0000-0000-0000-000000000001")
+		session.JoinCode = created.JoinCode
+		return h.Store.CreateSession(context.Background(), session)
+	}
+	if err := reuse("00000000-0000-0000-0000-000000000002"); err != nil {
+		t.Fatalf("reusing an archived code: %v", err)
+	}
+	if err := reuse("00000000-0000-0000-0000-000000000003"); !errors.Is(err, db.ErrJoinCodeTaken) {
+		t.Errorf("reusing an active code: %v", err)
+	}
+}
+
+func TestSessionListingIsPrivate(t *testing.T) {
+	h := servertest.New(t)
+	ctx := context.Background()
+	planning := h.CreateSession("Sprint 1 planning", "Hana")
+	retro := h.CreateSession("Sprint 1 retro", "Hana")
+	other := h.CreateSession("Sprint 2 planning", "Hana")
+	h.CreateSession("Someone else's session", "Omar")
+	hana := []string{planning.HostID, retro.HostID, other.HostID}
+
+	host := h.Join(planning.SessionID, "Hana", planning.HostID)
+	bob := h.Join(planning.SessionID, "Bob", "")
+	host.Expect(protocol.TypeUserJoined, protocol.TypeUserJoined)
+
+	names := func(page *models.SessionPage) []string {
+		var names []string
+		for _, s := range page.Sessions {
+			names = append(names, s.Name)
+		}
+		return names
+	}
+	list := func(opts client.ListOptions) *models.SessionPage {
+		t.Helper()
+		page, err := h.Client.ListSessions(ctx, opts)
+		if err != nil {
+			t.Fatalf("list %+v: %v", opts, err)
+		}
+		return page
+	}
+
+	if page := list(client.ListOptions{}); len(page.Sessions) != 0 {
+		t.Errorf("anonymous listing returned %v", names(page))
+	}
+
+	first := list(client.ListOptions{UserIDs: hana, Limit: 2})
+	if got := names(first); len(got) != 2 || got[0] != "Sprint 2 planning" || got[1] != "Sprint 1 retro" || first.NextCursor == "" {
+		t.Fatalf("first page %v, cursor %q", got, first.NextCursor)
+	}
+	second := list(client.ListOptions{UserIDs: hana, Limit: 2, Cursor: first.NextCursor})
+	if got := names(second); len(got) != 1 || got[0] != "Sprint 1 planning" || second.NextCursor != "" {
+		t.Fatalf("second page %v, cursor %q", got, second.NextCursor)
+	}
+	if s := second.Sessions[0]; s.Role != models.RoleHost || s.UserCount != 2 || s.ConnectedCount != 2 || s.State != models.SessionStateActive {
+		t.Errorf("summary %+v", s)
+	}
+
+	joined := list(client.ListOptions{UserIDs: []string{bob.UserID()}})
+	if len(joined.Sessions) != 1 || joined.Sessions[0].ID != planning.SessionID || joined.Sessions[0].Role != models.RoleParticipant {
+		t.Errorf("participant listing %+v", joined.Sessions)
+	}
+
+	if got := names(list(client.ListOptions{UserIDs: hana, Query: "RETRO"})); len(got) != 1 || got[0] != "Sprint 1 retro" {
+		t.Errorf("search returned %v", got)
+	}
+	if err := h.Client.ArchiveSession(ctx, retro.SessionID, retro.HostKey); err != nil {
+		t.Fatal(err)
+	}
+	if got := names(list(client.ListOptions{UserIDs: hana, State: models.SessionStateArchived})); len(got) != 1 || got[0] != "Sprint 1 retro" {
+		t.Errorf("archived sessions %v", got)
+	}
+	if got := names(list(client.ListOptions{UserIDs: hana, State: models.SessionStateActive})); len(got) != 2 {
+		t.Errorf("active sessions %v", got)
+	}
+	if got := names(list(client.ListOptions{UserIDs: hana, CreatedTo: time.Now().Add(-time.Hour)})); len(got) != 0 {
+		t.Errorf("sessions created over an hour ago %v", got)
+	}
+
+	var apiErr *client.Error
+	_, err := h.Client.ListSessions(ctx, client.ListOptions{UserIDs: hana, State: "open", Cursor: "nonsense"})
+	if !errors.As(err, &apiErr) || apiErr.Problem.Code != models.CodeValidationFailed || len(apiErr.Problem.Errors) != 2 {
+		t.Errorf("invalid filters: %v", err)
+	}
+}
+
+const adminToken = "0123456789abcdef0123456789abcdef"
+
+// adminCall sends an admin request with the given bearer token, decoding
+// the response into out, and returns the status code
+func adminCall(t *testing.T, h *servertest.Harness, method, path, token string, body, out interface{}) int {
+	t.Helper()
+
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		reader = strings.NewReader(string(data))
+	}
+	req, err := http.NewRequest(method, h.URL()+path, reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if out != nil && resp.StatusCode == http.StatusOK {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			t.Fatalf("%s %s: %v", method, path, err)
+		}
+	}
+	return resp.StatusCode
+}
+
+// expectClosed waits for the server to close the participant's connection
+// with the given code
+func expectClosed(t *testing.T, p *servertest.Participant, code int) {
+	t.Helper()
+	select {
+	case <-p.Conn.Done():
+	case <-time.After(servertest.Timeout):
+		t.Fatalf("%s is still connected", p.UserID())
+	}
+	var closeErr *websocket.CloseError
+	if !errors.As(p.Conn.Err(), &closeErr) || closeErr.Code != code {
+		t.Errorf("%s disconnected with %v, want close code %d", p.UserID(), p.Conn.Err(), code)
+	}
+}
+
+func TestAdminAPIRequiresToken(t *testing.T) {
+	h := servertest.New(t)
+	if status := adminCall(t, h, http.MethodGet, "/admin/sessions", adminToken, nil, nil); status != http.StatusNotFound {
+		t.Errorf("admin API without a configured token answered %d, want 404", status)
+	}
+
+	h = servertest.New(t, servertest.WithAdminToken(adminToken))
+	for _, token := range []string{"", "wrong"} {
+		if status := adminCall(t, h, http.MethodGet, "/admin/sessions", token, nil, nil); status != http.StatusUnauthorized {
+			t.Errorf("token %q answered %d, want 401", token, status)
+		}
+	}
+	if status := adminCall(t, h, http.MethodGet, "/admin/sessions", adminToken, nil, nil); status != http.StatusOK {
+		t.Errorf("valid token answered %d", status)
+	}
+}
+
+func TestAdminInspectsAndControlsSessions(t *testing.T) {
+	h := servertest.New(t, servertest.WithAdminToken(adminToken))
+	planning := h.CreateSession("Sprint 1", "Hana")
+	retro := h.CreateSession("Retro", "Omar")
+	host := h.Join(planning.SessionID, "Hana", planning.HostID)
+	alice := h.Join(planning.SessionID, "Alice", "")
+	host.Expect(protocol.TypeUserJoined, protocol.TypeUserJoined)
+	alice.Expect(protocol.TypeUserJoined)
+	omar := h.Join(retro.SessionID, "Omar", retro.HostID)
+	omar.Expect(protocol.TypeUserJoined)
+
+	var listing models.AdminSessionsResponse
+	adminCall(t, h, http.MethodGet, "/admin/sessions", adminToken, nil, &listing)
+	if len(listing.Sessions) != 2 || listing.Connections != 3 || listing.MemoryBytes <= 0 {
+		t.Fatalf("listing %+v", listing)
+	}
+	if s := listing.Sessions[1]; s.ID != planning.SessionID || s.Connections != 2 || s.LastActivity.IsZero() || s.MemoryBytes <= 0 {
+		t.Errorf("least recently active session %+v, want Sprint 1", s)
+	}
+
+	var result models.AdminResult
+	adminCall(t, h, http.MethodPost, "/admin/broadcast", adminToken, models.NoticeRequest{Message: "Maintenance at 18:00"}, &result)
+	if result.Sessions != 2 || result.Connections != 3 {
+		t.Errorf("broadcast reached %+v", result)
+	}
+	for _, p := range []*servertest.Participant{host, alice, omar} {
+		if notice := p.ExpectOne(protocol.TypeNotice).(protocol.NoticePayload); notice.Message != "Maintenance at 18:00" {
+			t.Errorf("notice %+v", notice)
+		}
+	}
+
+	adminCall(t, h, http.MethodPost, "/admin/sessions/"+retro.SessionID+"/evict", adminToken, nil, &result)
+	if result.Connections != 1 {
+		t.Errorf("evict disconnected %d clients, want 1", result.Connections)
+	}
+	expectClosed(t, omar, websocket.CloseServiceRestart)
+	h.Join(retro.SessionID, "Omar", retro.HostID).Expect(protocol.TypeUserJoined)
+
+	adminCall(t, h, http.MethodPost, "/admin/sessions/"+planning.SessionID+"/close", adminToken, nil, &result)
+	if result.Connections != 2 {
+		t.Errorf("close disconnected %d clients, want 2", result.Connections)
+	}
+	expectClosed(t, host, protocol.CloseSessionClosed)
+	expectClosed(t, alice, protocol.CloseSessionClosed)
+	if _, resp, err := websocket.DefaultDialer.Dial(h.WebSocketURL(planning.SessionID), nil); err == nil || resp.StatusCode != http.StatusGone {
+		t.Errorf("joining a closed session: %v", err)
+	}
+
+	if status := adminCall(t, h, http.MethodPost, "/admin/purge", adminToken, models.PurgeRequest{OlderThan: "1m"}, nil); status != http.StatusBadRequest {
+		t.Errorf("purging sessions younger than an hour answered %d, want 400", status)
+	}
+	old := models.NewSession("00000000-0000-0000-0000-000000000001", "Last year", "00000000-0000-0000-0000-000000000002")
+	old.CreatedAt = time.Now().AddDate(-1, 0, 0)
+	if err := h.Store.CreateSession(context.Background(), old); err != nil {
+		t.Fatal(err)
+	}
+	adminCall(t, h, http.MethodPost, "/admin/purge", adminToken, models.PurgeRequest{OlderThan: "720h"}, &result)
+	if result.Sessions != 1 {
+		t.Errorf("purged %+v, want the one old session", result)
+	}
+	if _, err := h.Store.GetSession(context.Background(), old.ID); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("purged session still stored: %v", err)
+	}
+}
+
+func TestSlowDatabaseCallsTimeOut(t *testing.T) {
+	timeout := 50 * time.Millisecond
+	h := servertest.New(t, servertest.WithQueryTimeouts(config.QueryTimeouts{Read: timeout, Write: timeout}))
+	created := h.CreateSession("Sprint 1", "Hana")
+	item := h.AddItem(created.SessionID, "Login page")
+	host := h.Join(created.SessionID, "Hana", created.HostID)
+	host.Expect(protocol.TypeUserJoined)
+
+	h.Store.SetLatency(time.Minute)
+
+	start := time.Now()
+	_, err := h.Client.GetSession(context.Background(), created.SessionID)
+	var apiErr *client.Error
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusServiceUnavailable || apiErr.Problem.Code != models.CodeDatabaseUnavailable {
+		t.Errorf("get session from a slow database: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 10*timeout {
+		t.Errorf("request took %v with a %v read timeout", elapsed, timeout)
+	}
+
+	// The vote times out instead of blocking the connection, so the next
+	// one goes through once the database has recovered
+	if err := host.Conn.Vote(item.ID, "5"); err != nil {
+		t.Fatal(err)
+	}
+	host.ExpectNothing(2 * timeout)
+	h.Store.SetLatency(0)
+	if err := host.Conn.Vote(item.ID, "8"); err != nil {
+		t.Fatal(err)
+	}
+	host.Expect(protocol.TypeVoteSubmitted)
+}
+
+// expectProblem fails the test unless err is a problem response with the
+// given status and code
+func expectProblem(t *testing.T, what string, err error, status int, code string) {
+	t.Helper()
+	var apiErr *client.Error
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != status || apiErr.Problem.Code != code {
+		t.Errorf("%s: %v, want %d %s", what, err, status, code)
+	}
+}
+
+func TestAccountsSpanSessions(t *testing.T) {
+	h := servertest.New(t)
+	ctx := context.Background()
+	const unknownAccount = "00000000-0000-4000-8000-000000000000"
+
+	account, err := h.Client.CreateAccount(ctx, models.AccountRequest{
+		DisplayName: " Hana ",
+		AvatarURL:   "https://example.com/hana.png",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if account.DisplayName != "Hana" || account.ID == "" {
+		t.Fatalf("created %+v", account)
+	}
+	hana := h.NewClient(account.ID)
+
+	// The host takes the account's display name
+	own, err := hana.CreateSession(ctx, models.CreateSessionRequest{Name: "Sprint 1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	other := h.CreateSession("Sprint 2", "Omar")
+	omar := h.Join(other.SessionID, "Omar", other.HostID)
+	omar.Expect(protocol.TypeUserJoined)
+
+	first := h.JoinWith(hana, other.SessionID, "", "")
+	joined := omar.ExpectOne(protocol.TypeUserJoined).(models.User)
+	if joined.Name != "Hana" || joined.AvatarURL != account.AvatarURL {
+		t.Errorf("saw %+v joining, want Hana with her avatar", joined)
+	}
+	first.Close()
+	omar.Expect(protocol.TypeUserLeft)
+
+	// Rejoining with the account resumes the same participant, and guests
+	// can still join
+	again := h.JoinWith(hana, other.SessionID, "", "")
+	if again.UserID() != first.UserID() {
+		t.Errorf("rejoined as %s, want %s", again.UserID(), first.UserID())
+	}
+	h.Join(other.SessionID, "Bob", "")
+
+	page, err := hana.ListSessions(ctx, client.ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	roles := map[string]string{}
+	for _, s := range page.Sessions {
+		roles[s.ID] = s.Role
+	}
+	if len(roles) != 2 || roles[own.SessionID] != models.RoleHost || roles[other.SessionID] != models.RoleParticipant {
+		t.Errorf("account listing %+v", page.Sessions)
+	}
+
+	// The account ID is a credential and never shown to other participants
+	resp, err := http.Get(h.URL() + "/api/sessions/" + other.SessionID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if strings.Contains(string(body), account.ID) {
+		t.Errorf("session leaks the account ID: %s", body)
+	}
+
+	updated, err := hana.UpdateAccount(ctx, models.AccountRequest{DisplayName: "Hana K."})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := hana.GetAccount(ctx); err != nil || got.DisplayName != "Hana K." || got.AvatarURL != "" || !got.CreatedAt.Equal(updated.CreatedAt) {
+		t.Errorf("after update: %+v, %v", got, err)
+	}
+
+	_, err = h.Client.GetAccount(ctx)
+	expectProblem(t, "account without an ID", err, http.StatusUnauthorized, models.CodeUnauthorized)
+	stranger := h.NewClient(unknownAccount)
+	_, err = stranger.GetAccount(ctx)
+	expectProblem(t, "unknown account", err, http.StatusNotFound, models.CodeAccountNotFound)
+	_, err = stranger.CreateSession(ctx, models.CreateSessionRequest{Name: "Sprint 3"})
+	expectProblem(t, "session for an unknown account", err, http.StatusNotFound, models.CodeAccountNotFound)
+	_, err = h.Client.CreateAccount(ctx, models.AccountRequest{DisplayName: "Eve", AvatarURL: "javascript:alert(1)"})
+	expectProblem(t, "script avatar", err, http.StatusBadRequest, models.CodeValidationFailed)
+
+	// The host's account archives without the host key; other accounts
+	// cannot
+	err = hana.ArchiveSession(ctx, other.SessionID, "")
+	expectProblem(t, "archive by a participant's account", err, http.StatusForbidden, models.CodeNotHost)
+	if err := hana.ArchiveSession(ctx, own.SessionID, ""); err != nil {
+		t.Errorf("archive by the host's account: %v", err)
+	}
+	_, err = stranger.JoinSession(ctx, other.SessionID, client.JoinOptions{})
+	var joinErr *client.JoinError
+	if !errors.As(err, &joinErr) || joinErr.Reason != "Unknown account" {
+		t.Errorf("join with an unknown account: %v", err)
+	}
+
+	// Deleting the account keeps its participants as guests
+	if err := hana.DeleteAccount(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if page, err := hana.ListSessions(ctx, client.ListOptions{}); err != nil || len(page.Sessions) != 0 {
+		t.Errorf("listing after deletion: %+v, %v", page, err)
+	}
+	session, err := h.Client.GetSession(ctx, other.SessionID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user := session.Users[first.UserID()]; user == nil || user.Name != "Hana" || user.AvatarURL != "" {
+		t.Errorf("participant after deletion: %+v", user)
+	}
+}
+
+func TestLocalSignIn(t *testing.T) {
+	h := servertest.New(t)
+	ctx := context.Background()
+
+	registered, err := h.Client.Register(ctx, models.RegisterRequest{
+		Email:       " Hana@Example.com",
+		Password:    "correct horse",
+		DisplayName: "Hana",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if registered.Token == "" || registered.Account.Provider != "local" || registered.Account.DisplayName != "Hana" {
+		t.Fatalf("registered %+v", registered)
+	}
+	_, err = h.Client.Register(ctx, models.RegisterRequest{Email: "hana@example.com", Password: "another one", DisplayName: "Hana"})
+	expectProblem(t, "taken email", err, http.StatusConflict, models.CodeEmailTaken)
+	_, err = h.Client.Register(ctx, models.RegisterRequest{Email: "omar@example.com", Password: "short", DisplayName: "Omar"})
+	expectProblem(t, "short password", err, http.StatusBadRequest, models.CodeValidationFailed)
+
+	_, err = h.Client.Login(ctx, "local", models.LoginRequest{Email: "hana@example.com", Password: "wrong horse"})
+	expectProblem(t, "wrong password", err, http.StatusUnauthorized, models.CodeInvalidCredentials)
+	_, err = h.Client.Login(ctx, "local", models.LoginRequest{Email: "nobody@example.com", Password: "correct horse"})
+	expectProblem(t, "unknown email", err, http.StatusUnauthorized, models.CodeInvalidCredentials)
+	_, err = h.Client.Login(ctx, "oidc", models.LoginRequest{Email: "hana@example.com", Password: "correct horse"})
+	expectProblem(t, "disabled provider", err, http.StatusNotFound, models.CodeProviderNotFound)
+
+	signedIn, err := h.Client.Login(ctx, "local", models.LoginRequest{Email: "HANA@example.com", Password: "correct horse"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if signedIn.Account.ID != registered.Account.ID {
+		t.Errorf("signed in to %s, registered %s", signedIn.Account.ID, registered.Account.ID)
+	}
+	hana := h.NewClient("")
+	hana.Token = signedIn.Token
+	if account, err := hana.GetAccount(ctx); err != nil || account.ID != registered.Account.ID {
+		t.Errorf("signed-in account: %+v, %v", account, err)
+	}
+
+	// The ID of an account with a login is no credential
+	_, err = h.NewClient(registered.Account.ID).GetAccount(ctx)
+	expectProblem(t, "account ID of a signed-in account", err, http.StatusUnauthorized, models.CodeSignInRequired)
+	forged := h.NewClient("")
+	forged.Token = signedIn.Token + "x"
+	_, err = forged.GetAccount(ctx)
+	expectProblem(t, "forged token", err, http.StatusUnauthorized, models.CodeUnauthorized)
+
+	// Sessions for signed-in users turn guests and ID-only accounts away
+	_, err = h.Client.CreateSession(ctx, models.CreateSessionRequest{Name: "Sprint 1", HostName: "Bob", AuthRequired: true})
+	expectProblem(t, "guest creating a signed-in session", err, http.StatusUnauthorized, models.CodeSignInRequired)
+	created, err := hana.CreateSession(ctx, models.CreateSessionRequest{Name: "Sprint 1", AuthRequired: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	host := h.JoinWith(hana, created.SessionID, "", created.HostID)
+	if host.UserID() != created.HostID {
+		t.Fatalf("host joined as %s", host.UserID())
+	}
+	host.Expect(protocol.TypeUserJoined)
+
+	if _, err := h.Client.JoinSession(ctx, created.SessionID, client.JoinOptions{UserName: "Bob"}); err == nil || !strings.Contains(err.Error(), "401") {
+		t.Errorf("guest join: %v", err)
+	}
+	_, err = h.Client.GetSession(ctx, created.SessionID)
+	expectProblem(t, "guest reading the session", err, http.StatusUnauthorized, models.CodeSignInRequired)
+	_, err = h.Client.ResolveJoinCode(ctx, created.JoinCode)
+	expectProblem(t, "guest resolving the join code", err, http.StatusUnauthorized, models.CodeSignInRequired)
+	_, err = h.Client.AddItem(ctx, created.SessionID, models.AddItemRequest{Title: "Login page"})
+	expectProblem(t, "guest adding an item", err, http.StatusUnauthorized, models.CodeSignInRequired)
+	item, err := hana.AddItem(ctx, created.SessionID, models.AddItemRequest{Title: "Login page"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	host.Expect(protocol.TypeItemAdded)
+	err = h.Client.SetCurrentItem(ctx, created.SessionID, item.ID)
+	expectProblem(t, "guest setting the current item", err, http.StatusUnauthorized, models.CodeSignInRequired)
+	if session, err := hana.GetSession(ctx, created.SessionID); err != nil || len(session.Items) != 1 {
+		t.Errorf("signed-in host reading the session: %+v, %v", session, err)
+	}
+	account, err := h.Client.CreateAccount(ctx, models.AccountRequest{DisplayName: "Eve"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := h.NewClient(account.ID).JoinSession(ctx, created.SessionID, client.JoinOptions{}); err == nil || !strings.Contains(err.Error(), "401") {
+		t.Errorf("join with an account ID: %v", err)
+	}
+
+	// A signed-in participant joins under the account's name, and cannot
+	// take over another participant
+	omarAuth, err := h.Client.Register(ctx, models.RegisterRequest{Email: "omar@example.com", Password: "correct horse", DisplayName: "Omar"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	omar := h.NewClient("")
+	omar.Token = omarAuth.Token
+	h.JoinWith(omar, created.SessionID, "", "")
+	if joined := host.ExpectOne(protocol.TypeUserJoined).(models.User); joined.Name != "Omar" {
+		t.Errorf("saw %+v joining, want Omar", joined)
+	}
+	conn, err := omar.JoinSession(ctx, created.SessionID, client.JoinOptions{UserID: created.HostID})
+	var joinErr *client.JoinError
+	if !errors.As(err, &joinErr) {
+		if conn != nil {
+			conn.Close()
+		}
+		t.Errorf("taking over the host: %v", err)
+	}
+
+	if err := hana.Logout(ctx); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestOIDCSignIn(t *testing.T) {
+	idp := oidctest.New(t)
+	h := servertest.New(t, servertest.WithOIDC(idp))
+
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	browser := &http.Client{Jar: jar}
+
+	// Without returnTo the callback answers with the sign-in
+	var first models.AuthResponse
+	if status := browse(t, browser, h.URL()+"/api/auth/oidc/login", &first); status != http.StatusOK {
+		t.Fatalf("sign-in answered %d", status)
+	}
+	if first.Account.Provider != "oidc" || first.Account.DisplayName != "Alice" || first.Token == "" {
+		t.Fatalf("signed in as %+v", first)
+	}
+
+	// The browser holds the sign-in cookie, and the provider's subject
+	// keeps mapping to the same account
+	var account models.Account
+	if status := browse(t, browser, h.URL()+"/api/accounts/me", &account); status != http.StatusOK || account.ID != first.Account.ID {
+		t.Errorf("account from the cookie: %d %+v", status, account)
+	}
+	var again models.AuthResponse
+	browse(t, browser, h.URL()+"/api/auth/oidc/login", &again)
+	if again.Account.ID != first.Account.ID {
+		t.Errorf("second sign-in to %s, first to %s", again.Account.ID, first.Account.ID)
+	}
+	idp.SetUser(oidctest.User{Subject: "user-2", Email: "bob@example.com"})
+	browse(t, browser, h.URL()+"/api/auth/oidc/login", &again)
+	if again.Account.ID == first.Account.ID || again.Account.DisplayName != "bob" {
+		t.Errorf("another user signed in as %+v", again.Account)
+	}
+
+	// returnTo sends the browser back to the frontend
+	stopAtFrontend := &http.Client{Jar: jar, CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		if req.URL.Host == "localhost:3000" {
+			return http.ErrUseLastResponse
+		}
+		return nil
+	}}
+	resp, err := stopAtFrontend.Get(h.URL() + "/api/auth/oidc/login?returnTo=" + url.QueryEscape("http://localhost:3000/sessions"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusFound || resp.Header.Get("Location") != "http://localhost:3000/sessions" {
+		t.Errorf("sign-in with returnTo answered %d to %q", resp.StatusCode, resp.Header.Get("Location"))
+	}
+
+	var problem models.Problem
+	if status := browse(t, browser, h.URL()+"/api/auth/oidc/login?returnTo="+url.QueryEscape("https://evil.example/"), &problem); status != http.StatusBadRequest {
+		t.Errorf("foreign returnTo answered %d", status)
+	}
+	if status := browse(t, http.DefaultClient, h.URL()+"/api/auth/oidc/callback?state=forged&code=forged", &problem); status != http.StatusUnauthorized || problem.Code != models.CodeSignInFailed {
+		t.Errorf("callback without a started sign-in: %d %s", status, problem.Code)
+	}
+	idp.SetDeny(true)
+	if status := browse(t, browser, h.URL()+"/api/auth/oidc/login", &problem); status != http.StatusUnauthorized || problem.Code != models.CodeSignInFailed {
+		t.Errorf("refused sign-in: %d %s", status, problem.Code)
+	}
+	if status := browse(t, browser, h.URL()+"/api/auth/local/login", &problem); status != http.StatusNotFound || problem.Code != models.CodeProviderNotFound {
+		t.Errorf("redirect sign-in with a password provider: %d %s", status, problem.Code)
+	}
+}
+
+// browse GETs url with c, following redirects, decodes the JSON response
+// into out and returns its status
+func browse(t *testing.T, c *http.Client, url string, out interface{}) int {
+	t.Helper()
+	resp, err := c.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		t.Fatalf("GET %s: %d with an invalid body: %v", url, resp.StatusCode, err)
+	}
+	return resp.StatusCode
+}
+
+func TestTeamsGroupSessions(t *testing.T) {
+	h := servertest.New(t)
+	ctx := context.Background()
+	signIn := func(email, name string) (*client.Client, *models.Account) {
+		t.Helper()
+		registered, err := h.Client.Register(ctx, models.RegisterRequest{Email: email, Password: "correct horse", DisplayName: name})
+		if err != nil {
+			t.Fatal(err)
+		}
+		c := h.NewClient("")
+		c.Token = registered.Token
+		return c, &registered.Account
+	}
+	hana, _ := signIn("hana@example.com", "Hana")
+	omar, omarAccount := signIn("omar@example.com", "Omar")
+	eve, _ := signIn("eve@example.com", "Eve")
+
+	guest, err := h.Client.CreateAccount(ctx, models.AccountRequest{DisplayName: "Bob"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = h.NewClient(guest.ID).CreateTeam(ctx, models.TeamRequest{Name: "Platform"})
+	expectProblem(t, "team of an account ID", err, http.StatusUnauthorized, models.CodeSignInRequired)
+	_, err = hana.CreateTeam(ctx, models.TeamRequest{Name: "Platform", Settings: models.SessionSettings{Deck: []string{"1", "1"}}})
+	expectProblem(t, "repeated card", err, http.StatusBadRequest, models.CodeValidationFailed)
+
+	settings := models.SessionSettings{Deck: []string{"1", "2", "3", "5", "8"}, TimerSeconds: 60, AutoReveal: true}
+	team, err := hana.CreateTeam(ctx, models.TeamRequest{Name: "Platform", Settings: settings})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if team.Role != models.TeamRoleOwner || len(team.Members) != 1 || team.Members[0].DisplayName != "Hana" {
+		t.Fatalf("created %+v", team)
+	}
+
+	// Owners add members; others cannot even see the team
+	_, err = omar.GetTeam(ctx, team.ID)
+	expectProblem(t, "outsider reading the team", err, http.StatusForbidden, models.CodeNotTeamMember)
+	_, err = hana.SetTeamMember(ctx, team.ID, guest.ID, models.TeamRoleMember)
+	expectProblem(t, "adding an account ID", err, http.StatusBadRequest, models.CodeValidationFailed)
+	if team, err = hana.SetTeamMember(ctx, team.ID, omarAccount.ID, models.TeamRoleMember); err != nil || len(team.Members) != 2 {
+		t.Fatalf("added Omar: %+v, %v", team, err)
+	}
+	_, err = omar.UpdateTeam(ctx, team.ID, models.TeamRequest{Name: "Mine"})
+	expectProblem(t, "member renaming the team", err, http.StatusForbidden, models.CodeNotTeamOwner)
+	if teams, err := omar.ListTeams(ctx); err != nil || len(teams.Teams) != 1 || teams.Teams[0].Role != models.TeamRoleMember {
+		t.Errorf("Omar's teams: %+v, %v", teams, err)
+	}
+
+	// Sessions under the team take its settings, and admit its members
+	_, err = eve.CreateSession(ctx, models.CreateSessionRequest{Name: "Sprint 1", TeamID: team.ID})
+	expectProblem(t, "outsider creating a team session", err, http.StatusForbidden, models.CodeNotTeamMember)
+	created, err := omar.CreateSession(ctx, models.CreateSessionRequest{Name: "Sprint 1", TeamID: team.ID, AuthRequired: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	session, err := omar.GetSession(ctx, created.SessionID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if session.TeamID != team.ID || session.Settings.TimerSeconds != 60 || len(session.Settings.Deck) != 5 {
+		t.Errorf("team session %+v", session)
+	}
+	if _, err := eve.JoinSession(ctx, created.SessionID, client.JoinOptions{}); err == nil || !strings.Contains(err.Error(), "403") {
+		t.Errorf("outsider joining a team session: %v", err)
+	}
+	_, err = eve.GetSession(ctx, created.SessionID)
+	expectProblem(t, "outsider reading a team session", err, http.StatusForbidden, models.CodeNotTeamMember)
+	_, err = eve.ResolveJoinCode(ctx, created.JoinCode)
+	expectProblem(t, "outsider resolving a team session's code", err, http.StatusForbidden, models.CodeNotTeamMember)
+	_, err = eve.AddItem(ctx, created.SessionID, models.AddItemRequest{Title: "Login page"})
+	expectProblem(t, "outsider adding an item to a team session", err, http.StatusForbidden, models.CodeNotTeamMember)
+
+	host := h.JoinWith(omar, created.SessionID, "", created.HostID)
+	host.Expect(protocol.TypeUserJoined)
+	member := h.JoinWith(hana, created.SessionID, "", "")
+	member.Expect(protocol.TypeUserJoined)
+	host.Expect(protocol.TypeUserJoined)
+	everyone := []*servertest.Participant{host, member}
+	item, err := omar.AddItem(ctx, created.SessionID, models.AddItemRequest{Title: "Login page"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range everyone {
+		p.Expect(protocol.TypeItemAdded)
+	}
+
+	// Votes come from the deck, and the last one reveals the item
+	member.Conn.Vote(item.ID, "13")
+	if rejected := member.ExpectOne(protocol.TypeError).(protocol.ErrorPayload); len(rejected.Errors) != 1 || rejected.Errors[0].Field != "vote" {
+		t.Errorf("vote off the deck: %+v", rejected)
+	}
+	member.Conn.Vote(item.ID, "3")
+	for _, p := range everyone {
+		p.Expect(protocol.TypeVoteSubmitted)
+		p.ExpectNothing(quiet)
+	}
+	host.Conn.Vote(item.ID, "5")
+	for _, p := range everyone {
+		p.Expect(protocol.TypeVoteSubmitted)
+		revealed := p.ExpectOne(protocol.TypeVotesRevealed).(models.PlanningItem)
+		if !revealed.Revealed || len(revealed.Votes) != 2 {
+			t.Errorf("votes_revealed %+v", revealed)
+		}
+		p.ExpectNothing(quiet)
+	}
+
+	// Members see the team's history, joined or not
+	page, err := hana.ListTeamSessions(ctx, team.ID, client.ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Sessions) != 1 || page.Sessions[0].ID != created.SessionID || page.Sessions[0].TeamID != team.ID ||
+		page.Sessions[0].Role != models.RoleParticipant {
+		t.Errorf("team sessions %+v", page.Sessions)
+	}
+	_, err = eve.ListTeamSessions(ctx, team.ID, client.ListOptions{})
+	expectProblem(t, "outsider listing team sessions", err, http.StatusForbidden, models.CodeNotTeamMember)
+
+	// A team keeps an owner; members may leave
+	err = hana.RemoveTeamMember(ctx, team.ID, team.Members[0].AccountID)
+	expectProblem(t, "last owner leaving", err, http.StatusConflict, models.CodeLastTeamOwner)
+	_, err = hana.SetTeamMember(ctx, team.ID, team.Members[0].AccountID, models.TeamRoleMember)
+	expectProblem(t, "last owner stepping down", err, http.StatusConflict, models.CodeLastTeamOwner)
+	err = hana.DeleteAccount(ctx)
+	expectProblem(t, "last owner deleting their account", err, http.StatusConflict, models.CodeLastTeamOwner)
+	if err := omar.RemoveTeamMember(ctx, team.ID, omarAccount.ID); err != nil {
+		t.Fatal(err)
+	}
+	_, err = omar.ListTeamSessions(ctx, team.ID, client.ListOptions{})
+	expectProblem(t, "former member listing", err, http.StatusForbidden, models.CodeNotTeamMember)
+
+	// Deleting the team keeps its sessions
+	if err := hana.DeleteTeam(ctx, team.ID); err != nil {
+		t.Fatal(err)
+	}
+	_, err = hana.GetTeam(ctx, team.ID)
+	expectProblem(t, "deleted team", err, http.StatusNotFound, models.CodeTeamNotFound)
+	if session, err := hana.GetSession(ctx, created.SessionID); err != nil || session.TeamID != "" {
+		t.Errorf("session of a deleted team: %+v, %v", session, err)
+	}
+	// A team its owner is alone in goes with the owner's account
+	solo, err := hana.CreateTeam(ctx, models.TeamRequest{Name: "Solo"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := hana.DeleteAccount(ctx); err != nil {
+		t.Fatal(err)
+	}
+	_, err = eve.GetTeam(ctx, solo.ID)
+	expectProblem(t, "team of a deleted account", err, http.StatusNotFound, models.CodeTeamNotFound)
+}
+
+func TestTemplatesSeedSessions(t *testing.T) {
+	h := servertest.New(t)
+	ctx := context.Background()
+
+	owner, err := h.Client.CreateAccount(ctx, models.AccountRequest{DisplayName: "Ana"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := h.Client.CreateAccount(ctx, models.AccountRequest{DisplayName: "Ben"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ana, ben := h.NewClient(owner.ID), h.NewClient(other.ID)
+
+	_, err = h.Client.CreateTemplate(ctx, models.TemplateRequest{Name: "Bugs triage"})
+	expectProblem(t, "template without an account", err, http.StatusUnauthorized, models.CodeUnauthorized)
+	_, err = ana.CreateTemplate(ctx, models.TemplateRequest{Name: "Bugs triage", Items: []models.TemplateItem{{Title: " "}}})
+	expectProblem(t, "blank seed item", err, http.StatusBadRequest, models.CodeValidationFailed)
+
+	triage, err := ana.CreateTemplate(ctx, models.TemplateRequest{
+		Name:     "Bugs triage",
+		Settings: models.SessionSettings{Deck: []string{"S", "M", "L"}, TimerSeconds: 30},
+		Items:    []models.TemplateItem{{Title: "New bugs"}, {Title: "Regressions", Description: "Since the last release"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Only the template's account sees it
+	_, err = ben.GetTemplate(ctx, triage.ID)
+	expectProblem(t, "someone else's template", err, http.StatusNotFound, models.CodeTemplateNotFound)
+	_, err = ben.CreateSession(ctx, models.CreateSessionRequest{TemplateID: triage.ID})
+	expectProblem(t, "session from someone else's template", err, http.StatusNotFound, models.CodeTemplateNotFound)
+	if templates, err := ben.ListTemplates(ctx); err != nil || len(templates.Templates) != 0 {
+		t.Errorf("Ben's templates: %+v, %v", templates, err)
+	}
+
+	// A session from the template takes its name, settings and items
+	created, err := ana.CreateSession(ctx, models.CreateSessionRequest{TemplateID: triage.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	session, err := h.Client.GetSession(ctx, created.SessionID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if session.Name != "Bugs triage" || len(session.Settings.Deck) != 3 || session.Settings.TimerSeconds != 30 {
+		t.Errorf("session from a template %+v", session)
+	}
+	if len(session.Items) != 2 || session.Items[0].Title != "New bugs" || session.Items[1].Description != "Since the last release" {
+		t.Errorf("seed items %+v", session.Items)
+	}
+
+	// The request's own name and settings win; editing the template leaves
+	// earlier sessions alone
+	created, err = ana.CreateSession(ctx, models.CreateSessionRequest{
+		Name: "Hotfixes", TemplateID: triage.ID, Settings: &models.SessionSettings{TimerSeconds: 90},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if session, err := h.Client.GetSession(ctx, created.SessionID); err != nil || session.Name != "Hotfixes" ||
+		session.Settings.TimerSeconds != 90 || session.Settings.Deck != nil || len(session.Items) != 2 {
+		t.Errorf("session overriding its template: %+v, %v", session, err)
+	}
+	if _, err := ana.UpdateTemplate(ctx, triage.ID, models.TemplateRequest{Name: "Triage"}); err != nil {
+		t.Fatal(err)
+	}
+	if session, err := h.Client.GetSession(ctx, created.SessionID); err != nil || len(session.Items) != 2 {
+		t.Errorf("session after its template changed: %+v, %v", session, err)
+	}
+
+	// Team templates are shared with members; only owners change them
+	signIn := func(email, name string) (*client.Client, *models.Account) {
+		t.Helper()
+		registered, err := h.Client.Register(ctx, models.RegisterRequest{Email: email, Password: "correct horse", DisplayName: name})
+		if err != nil {
+			t.Fatal(err)
+		}
+		c := h.NewClient("")
+		c.Token = registered.Token
+		return c, &registered.Account
+	}
+	hana, _ := signIn("hana@example.com", "Hana")
+	omar, omarAccount := signIn("omar@example.com", "Omar")
+	team, err := hana.CreateTeam(ctx, models.TeamRequest{Name: "Platform"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = ana.CreateTemplate(ctx, models.TemplateRequest{Name: "Planning", TeamID: team.ID})
+	expectProblem(t, "team template of an account ID", err, http.StatusUnauthorized, models.CodeSignInRequired)
+	planning, err := hana.CreateTemplate(ctx, models.TemplateRequest{Name: "Planning", TeamID: team.ID, AuthRequired: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = omar.GetTemplate(ctx, planning.ID)
+	expectProblem(t, "outsider reading a team template", err, http.StatusNotFound, models.CodeTemplateNotFound)
+	if _, err := hana.SetTeamMember(ctx, team.ID, omarAccount.ID, models.TeamRoleMember); err != nil {
+		t.Fatal(err)
+	}
+	if templates, err := omar.ListTemplates(ctx); err != nil || len(templates.Templates) != 1 || templates.Templates[0].ID != planning.ID {
+		t.Errorf("Omar's templates: %+v, %v", templates, err)
+	}
+	_, err = omar.UpdateTemplate(ctx, planning.ID, models.TemplateRequest{Name: "Mine"})
+	expectProblem(t, "member editing a team template", err, http.StatusForbidden, models.CodeNotTeamOwner)
+	_, err = hana.UpdateTemplate(ctx, planning.ID, models.TemplateRequest{Name: "Planning", TeamID: owner.ID})
+	expectProblem(t, "moving a template to another team", err, http.StatusBadRequest, models.CodeValidationFailed)
+
+	created, err = omar.CreateSession(ctx, models.CreateSessionRequest{TemplateID: planning.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if session, err := omar.GetSession(ctx, created.SessionID); err != nil || session.TeamID != team.ID || !session.AuthRequired {
+		t.Errorf("session from a team template: %+v, %v", session, err)
+	}
+
+	// Deleting the team deletes its templates
+	if err := hana.DeleteTeam(ctx, team.ID); err != nil {
+		t.Fatal(err)
+	}
+	_, err = hana.GetTemplate(ctx, planning.ID)
+	expectProblem(t, "template of a deleted team", err, http.StatusNotFound, models.CodeTemplateNotFound)
+	if err := ana.DeleteTemplate(ctx, triage.ID); err != nil {
+		t.Fatal(err)
+	}
+	_, err = ana.GetTemplate(ctx, triage.ID)
+	expectProblem(t, "deleted template", err, http.StatusNotFound, models.CodeTemplateNotFound)
+}
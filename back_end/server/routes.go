@@ -0,0 +1,80 @@
+package server
+
+import (
+	"net/http"
+	"poker-planning-api/handlers"
+	"poker-planning-api/metrics"
+
+	"github.com/gorilla/mux"
+)
+
+// newRouter registers every route served by the API. Keep openapi.Operations
+// in sync; routes_test.go fails if the two disagree.
+func newRouter() *mux.Router {
+	router := mux.NewRouter()
+
+	// Health check endpoint for Cloud Run
+	router.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("OK"))
+	}).Methods("GET")
+
+	// Liveness only: must not depend on the database, or an outage would
+	// get healthy instances restarted
+	router.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("Healthy"))
+	}).Methods("GET")
+
+	router.HandleFunc("/ready", handlers.Ready).Methods("GET")
+
+	router.Handle("/metrics", metrics.Handler()).Methods("GET")
+
+	// API routes
+	router.HandleFunc("/api/sessions", handlers.CreateSession).Methods("POST")
+	router.HandleFunc("/api/sessions", handlers.ListSessions).Methods("GET")
+	router.HandleFunc("/api/sessions/{sessionId}", handlers.GetSession).Methods("GET")
+	router.HandleFunc("/api/sessions/{sessionId}/items", handlers.AddItem).Methods("POST")
+	router.HandleFunc("/api/sessions/{sessionId}/current-item", handlers.SetCurrentItem).Methods("POST")
+	router.HandleFunc("/api/sessions/{sessionId}/archive", handlers.ArchiveSession).Methods("POST")
+	router.HandleFunc("/api/join/{code}", handlers.ResolveJoinCode).Methods("GET")
+	router.HandleFunc("/api/accounts", handlers.CreateAccount).Methods("POST")
+	router.HandleFunc("/api/accounts/me", handlers.GetAccount).Methods("GET")
+	router.HandleFunc("/api/accounts/me", handlers.UpdateAccount).Methods("PUT")
+	router.HandleFunc("/api/accounts/me", handlers.DeleteAccount).Methods("DELETE")
+	router.HandleFunc("/api/teams", handlers.CreateTeam).Methods("POST")
+	router.HandleFunc("/api/teams", handlers.ListTeams).Methods("GET")
+	router.HandleFunc("/api/teams/{teamId}", handlers.GetTeam).Methods("GET")
+	router.HandleFunc("/api/teams/{teamId}", handlers.UpdateTeam).Methods("PUT")
+	router.HandleFunc("/api/teams/{teamId}", handlers.DeleteTeam).Methods("DELETE")
+	router.HandleFunc("/api/teams/{teamId}/members/{accountId}", handlers.SetTeamMember).Methods("PUT")
+	router.HandleFunc("/api/teams/{teamId}/members/{accountId}", handlers.RemoveTeamMember).Methods("DELETE")
+	router.HandleFunc("/api/teams/{teamId}/sessions", handlers.ListTeamSessions).Methods("GET")
+	router.HandleFunc("/api/templates", handlers.CreateTemplate).Methods("POST")
+	router.HandleFunc("/api/templates", handlers.ListTemplates).Methods("GET")
+	router.HandleFunc("/api/templates/{templateId}", handlers.GetTemplate).Methods("GET")
+	router.HandleFunc("/api/templates/{templateId}", handlers.UpdateTemplate).Methods("PUT")
+	router.HandleFunc("/api/templates/{templateId}", handlers.DeleteTemplate).Methods("DELETE")
+	router.HandleFunc("/api/auth/register", handlers.Register).Methods("POST")
+	router.HandleFunc("/api/auth/{provider}/login", handlers.Login).Methods("POST")
+	router.HandleFunc("/api/auth/{provider}/login", handlers.StartLogin).Methods("GET")
+	router.HandleFunc("/api/auth/{provider}/callback", handlers.LoginCallback).Methods("GET")
+	router.HandleFunc("/api/auth/logout", handlers.Logout).Methods("POST")
+	router.HandleFunc("/api/openapi.json", handlers.GetOpenAPISpec).Methods("GET")
+	router.HandleFunc("/api/asyncapi.json", handlers.GetProtocolSpec).Methods("GET")
+
+	// Admin routes, answering 404 unless an admin token is configured
+	admin := func(handler http.HandlerFunc) http.Handler {
+		return handlers.RequireAdmin(handler)
+	}
+	router.Handle("/admin/sessions", admin(handlers.AdminListSessions)).Methods("GET")
+	router.Handle("/admin/sessions/{sessionId}/close", admin(handlers.AdminCloseSession)).Methods("POST")
+	router.Handle("/admin/sessions/{sessionId}/evict", admin(handlers.AdminEvictSession)).Methods("POST")
+	router.Handle("/admin/broadcast", admin(handlers.AdminBroadcastNotice)).Methods("POST")
+	router.Handle("/admin/purge", admin(handlers.AdminPurgeSessions)).Methods("POST")
+
+	// WebSocket route
+	router.HandleFunc("/ws/{sessionId}", handlers.HandleWebSocket)
+
+	return router
+}
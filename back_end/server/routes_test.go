@@ -0,0 +1,64 @@
+package server
+
+import (
+	"net/http"
+	"poker-planning-api/openapi"
+	"sort"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+// TestRoutesMatchOpenAPISpec fails when a route is registered in the router
+// but missing from the OpenAPI operations table, or the reverse
+func TestRoutesMatchOpenAPISpec(t *testing.T) {
+	routed := map[string]bool{}
+	err := newRouter().Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
+		path, err := route.GetPathTemplate()
+		if err != nil {
+			return err
+		}
+		methods, err := route.GetMethods()
+		if err != nil {
+			// Routes without a method matcher (the WebSocket upgrade) are
+			// reached with GET
+			methods = []string{http.MethodGet}
+		}
+		for _, method := range methods {
+			routed[method+" "+path] = true
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("walking router: %v", err)
+	}
+
+	specified := map[string]bool{}
+	for _, op := range openapi.Operations {
+		key := op.Method + " " + op.Path
+		if specified[key] {
+			t.Errorf("operation %s is listed twice in the spec", key)
+		}
+		specified[key] = true
+	}
+
+	for _, key := range sortedKeys(routed) {
+		if !specified[key] {
+			t.Errorf("route %s is registered but missing from the OpenAPI spec", key)
+		}
+	}
+	for _, key := range sortedKeys(specified) {
+		if !routed[key] {
+			t.Errorf("operation %s is in the OpenAPI spec but not registered in the router", key)
+		}
+	}
+}
+
+func sortedKeys(m map[string]bool) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,104 @@
+package server
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"poker-planning-api/auth"
+	"poker-planning-api/config"
+	"poker-planning-api/db"
+	"poker-planning-api/handlers"
+	"poker-planning-api/middleware"
+
+	"github.com/rs/cors"
+)
+
+// Options configures the HTTP server
+type Options struct {
+	// Store persists sessions, users, items and votes
+	Store db.Store
+	// AllowedOrigins lists the browser origins allowed by CORS and on
+	// WebSocket upgrades
+	AllowedOrigins []string
+	// AllowAllOrigins accepts every origin; for local development only
+	AllowAllOrigins bool
+	// RateLimit bounds requests and sessions per client IP and messages per
+	// WebSocket connection; the zero value disables rate limiting
+	RateLimit config.RateLimitConfig
+	// AdminToken enables the /admin API for requests bearing it
+	AdminToken string
+	// QueryTimeouts bound database calls by class; the zero value leaves
+	// them unbounded
+	QueryTimeouts config.QueryTimeouts
+	// Auth configures sign-in, which is off while its Secret is empty
+	Auth config.AuthConfig
+	// Logger is the base logger for request and connection logs; nil uses
+	// slog.Default()
+	Logger *slog.Logger
+}
+
+// New builds the complete HTTP handler: routes, CORS, request IDs, logging
+// and rate limits. It is used by main and by the end-to-end tests in
+// servertest.
+func New(opts Options) http.Handler {
+	handlers.SetStore(opts.Store)
+	handlers.SetRateLimits(opts.RateLimit)
+	handlers.SetAdminToken(opts.AdminToken)
+	handlers.SetQueryTimeouts(opts.QueryTimeouts)
+	origins := middleware.NewOriginPolicy(opts.AllowedOrigins, opts.AllowAllOrigins)
+	handlers.SetOriginPolicy(origins)
+	setAuth(opts.Store, opts.Auth)
+
+	router := newRouter()
+
+	// CORS configuration - same origin policy as WebSocket upgrades
+	c := cors.New(cors.Options{
+		AllowOriginFunc:  origins.Allowed,
+		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
+		AllowedHeaders:   []string{"*"},
+		ExposedHeaders:   []string{middleware.RequestIDHeader},
+		AllowCredentials: true,
+	})
+
+	logger := opts.Logger
+	if logger == nil {
+		logger = slog.Default()
+	}
+
+	// Rate limiting runs inside the logger so rejections carry a request ID
+	return c.Handler(middleware.RequestID(middleware.Logger(logger)(handlers.LimitRequests(router))))
+}
+
+// setAuth enables the configured sign-in providers
+func setAuth(store db.Store, cfg config.AuthConfig) {
+	if cfg.Secret == "" {
+		handlers.SetAuth(nil, nil, false)
+		return
+	}
+
+	var providers []auth.Provider
+	if cfg.Local.Enabled {
+		providers = append(providers, auth.NewLocal(store))
+	}
+	if cfg.OIDC.Issuer != "" {
+		providers = append(providers, auth.NewOIDC(cfg.OIDC))
+	}
+	handlers.SetAuth(providers, auth.NewTokens([]byte(cfg.Secret), cfg.TokenTTL), cfg.SecureCookie)
+}
+
+// Shutdown stops srv from accepting connections, sends WebSocket clients a
+// close frame asking them to reconnect, and waits for in-flight requests and
+// connection teardown (which marks users disconnected in the store), all
+// bounded by ctx. The caller closes the store afterwards.
+func Shutdown(ctx context.Context, srv *http.Server) error {
+	// http.Server.Shutdown does not track hijacked WebSocket connections,
+	// so they are drained separately
+	wsDone := make(chan error, 1)
+	go func() { wsDone <- handlers.Shutdown(ctx) }()
+
+	err := srv.Shutdown(ctx)
+	if wsErr := <-wsDone; err == nil {
+		err = wsErr
+	}
+	return err
+}
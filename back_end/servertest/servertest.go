@@ -0,0 +1,321 @@
+// Package servertest boots the complete API on an httptest.Server backed by
+// a disposable in-memory store, for end-to-end tests of the REST and
+// WebSocket flows.
+package servertest
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log/slog"
+	"net/http/httptest"
+	"poker-planning-api/auth/oidctest"
+	"poker-planning-api/client"
+	"poker-planning-api/config"
+	"poker-planning-api/db/memstore"
+	"poker-planning-api/handlers"
+	"poker-planning-api/models"
+	"poker-planning-api/server"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Timeout bounds every wait for an expected event
+const Timeout = 5 * time.Second
+
+// authSecret signs the tokens of every test server
+const authSecret = "servertest-secret-0123456789abcdef"
+
+// Harness is a running server with a fresh store. Handlers keep package-level
+// state, so tests using a Harness must not run in parallel.
+type Harness struct {
+	t testing.TB
+
+	Server *httptest.Server
+	Store  *memstore.Store
+	Client *client.Client
+
+	logs *syncBuffer
+
+	// hostKeys and resumeTokens prove joins as the hosts and participants
+	// the harness created, by user ID
+	secretsMu    sync.Mutex
+	hostKeys     map[string]string
+	resumeTokens map[string]string
+}
+
+// Option adjusts the server options used by New
+type Option func(*server.Options)
+
+// WithRateLimit enables rate limiting, which is off by default
+func WithRateLimit(cfg config.RateLimitConfig) Option {
+	return func(opts *server.Options) {
+		opts.RateLimit = cfg
+	}
+}
+
+// WithQueryTimeouts bounds database calls, which are unbounded by default
+func WithQueryTimeouts(timeouts config.QueryTimeouts) Option {
+	return func(opts *server.Options) {
+		opts.QueryTimeouts = timeouts
+	}
+}
+
+// WithAdminToken enables the /admin API, which is off by default
+func WithAdminToken(token string) Option {
+	return func(opts *server.Options) {
+		opts.AdminToken = token
+	}
+}
+
+// WithOIDC enables sign-in with the mock identity provider, named "oidc".
+// Local sign-in is on by default.
+func WithOIDC(idp *oidctest.Server) Option {
+	return func(opts *server.Options) {
+		opts.Auth.OIDC = idp.Config("oidc")
+	}
+}
+
+// New starts a server for the duration of the test
+func New(t testing.TB, options ...Option) *Harness {
+	t.Helper()
+
+	store := memstore.New()
+	logs := &syncBuffer{}
+	opts := server.Options{
+		Store:          store,
+		AllowedOrigins: []string{"http://localhost:3000"},
+		Logger:         slog.New(slog.NewJSONHandler(logs, &slog.HandlerOptions{Level: slog.LevelDebug})),
+		Auth: config.AuthConfig{
+			Secret:   authSecret,
+			TokenTTL: time.Hour,
+			Local:    config.LocalAuthConfig{Enabled: true},
+		},
+	}
+	for _, option := range options {
+		option(&opts)
+	}
+
+	// The provider redirects back to this server, whose address is known
+	// once it listens
+	ts := httptest.NewUnstartedServer(nil)
+	baseURL := "http://" + ts.Listener.Addr().String()
+	if opts.Auth.OIDC.Issuer != "" && opts.Auth.OIDC.RedirectURL == "" {
+		opts.Auth.OIDC.RedirectURL = baseURL + "/api/auth/" + opts.Auth.OIDC.Name + "/callback"
+	}
+	ts.Config.Handler = server.New(opts)
+	ts.Start()
+	// Connections are closed by cleanups registered later, which run first;
+	// wait for the server side to finish before the next test swaps the store
+	t.Cleanup(func() {
+		ts.Close()
+		handlers.WaitForConnections()
+	})
+
+	return &Harness{
+		t:            t,
+		Server:       ts,
+		Store:        store,
+		Client:       client.New(ts.URL),
+		logs:         logs,
+		hostKeys:     make(map[string]string),
+		resumeTokens: make(map[string]string),
+	}
+}
+
+// Logs returns the JSON log lines written so far by request and connection
+// loggers
+func (h *Harness) Logs() string {
+	return h.logs.String()
+}
+
+// syncBuffer is a bytes.Buffer safe for concurrent writers
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+// URL returns the base URL of the server, e.g. "http://127.0.0.1:54321"
+func (h *Harness) URL() string {
+	return h.Server.URL
+}
+
+// WebSocketURL returns the ws:// URL for a session
+func (h *Harness) WebSocketURL(sessionID string) string {
+	return "ws" + strings.TrimPrefix(h.Server.URL, "http") + "/ws/" + sessionID
+}
+
+// CreateSession creates a session over REST and fails the test on error
+func (h *Harness) CreateSession(name, hostName string) *models.CreateSessionResponse {
+	h.t.Helper()
+	created, err := h.Client.CreateSession(context.Background(), models.CreateSessionRequest{Name: name, HostName: hostName})
+	if err != nil {
+		h.t.Fatalf("create session: %v", err)
+	}
+	h.secretsMu.Lock()
+	h.hostKeys[created.HostID] = created.HostKey
+	h.secretsMu.Unlock()
+	return created
+}
+
+// joinOptions fills in what proves a join as userID, if the harness has
+// it: the host key of a session created with CreateSession, or the resume
+// token of a participant joined with Join
+func (h *Harness) joinOptions(userName, userID string) client.JoinOptions {
+	h.secretsMu.Lock()
+	defer h.secretsMu.Unlock()
+	return client.JoinOptions{
+		UserName:    userName,
+		UserID:      userID,
+		HostKey:     h.hostKeys[userID],
+		ResumeToken: h.resumeTokens[userID],
+	}
+}
+
+// AddItem adds an item over REST and fails the test on error
+func (h *Harness) AddItem(sessionID, title string) *models.PlanningItem {
+	h.t.Helper()
+	item, err := h.Client.AddItem(context.Background(), sessionID, models.AddItemRequest{Title: title})
+	if err != nil {
+		h.t.Fatalf("add item: %v", err)
+	}
+	return item
+}
+
+// SetCurrentItem sets the current item over REST and fails the test on error
+func (h *Harness) SetCurrentItem(sessionID, itemID string) {
+	h.t.Helper()
+	if err := h.Client.SetCurrentItem(context.Background(), sessionID, itemID); err != nil {
+		h.t.Fatalf("set current item: %v", err)
+	}
+}
+
+// Participant is a WebSocket connection that records every event it receives
+type Participant struct {
+	t      testing.TB
+	Conn   *client.Conn
+	events chan client.Event
+}
+
+// Join connects a participant. Pass the hostId from CreateSession as userID
+// to join as the host, the UserID of an earlier Participant to rejoin as
+// it, or "" to join as a new participant.
+func (h *Harness) Join(sessionID, userName, userID string) *Participant {
+	h.t.Helper()
+	return h.JoinWith(h.Client, sessionID, userName, userID)
+}
+
+// NewClient returns a client for the server acting as the given account,
+// or as a guest if accountID is empty
+func (h *Harness) NewClient(accountID string) *client.Client {
+	c := client.New(h.Server.URL)
+	c.AccountID = accountID
+	return c
+}
+
+// JoinWith is Join through c, e.g. a client acting as an account
+func (h *Harness) JoinWith(c *client.Client, sessionID, userName, userID string) *Participant {
+	h.t.Helper()
+
+	p := &Participant{t: h.t, events: make(chan client.Event, 1024)}
+	opts := h.joinOptions(userName, userID)
+	opts.Handlers = client.Handlers{
+		OnEvent: func(event client.Event) { p.events <- event },
+	}
+	conn, err := c.JoinSession(context.Background(), sessionID, opts)
+	if err != nil {
+		h.t.Fatalf("join %s as %q: %v", sessionID, userName, err)
+	}
+	if token := conn.ResumeToken(); token != "" {
+		h.secretsMu.Lock()
+		h.resumeTokens[conn.UserID()] = token
+		h.secretsMu.Unlock()
+	}
+	p.Conn = conn
+	h.t.Cleanup(func() { conn.Close() })
+	return p
+}
+
+// JoinRejected attempts to join and returns the server's rejection reason,
+// failing the test if the join succeeds. Unlike Join it sends no host key
+// or resume token.
+func (h *Harness) JoinRejected(sessionID, userName, userID string) string {
+	h.t.Helper()
+
+	conn, err := h.Client.JoinSession(context.Background(), sessionID, client.JoinOptions{
+		UserName: userName,
+		UserID:   userID,
+	})
+	if err == nil {
+		conn.Close()
+		h.t.Fatalf("join %s as %q succeeded, expected rejection", sessionID, userName)
+	}
+
+	var joinErr *client.JoinError
+	if !errors.As(err, &joinErr) {
+		h.t.Fatalf("join %s as %q: expected a join rejection, got %v", sessionID, userName, err)
+	}
+	return joinErr.Reason
+}
+
+// UserID returns the ID the server assigned to the participant
+func (p *Participant) UserID() string {
+	return p.Conn.UserID()
+}
+
+// Expect asserts that the next events are exactly the given types, in order,
+// and returns them
+func (p *Participant) Expect(types ...string) []client.Event {
+	p.t.Helper()
+
+	events := make([]client.Event, 0, len(types))
+	for i, want := range types {
+		select {
+		case event := <-p.events:
+			if event.Type != want {
+				p.t.Fatalf("event %d: got %q, want %q (expected sequence %v)", i, event.Type, want, types)
+			}
+			events = append(events, event)
+		case <-time.After(Timeout):
+			p.t.Fatalf("event %d: timed out waiting for %q (expected sequence %v)", i, want, types)
+		}
+	}
+	return events
+}
+
+// ExpectOne asserts that the next event has the given type and returns its
+// payload
+func (p *Participant) ExpectOne(eventType string) interface{} {
+	p.t.Helper()
+	return p.Expect(eventType)[0].Payload
+}
+
+// ExpectNothing asserts that no event arrives within wait
+func (p *Participant) ExpectNothing(wait time.Duration) {
+	p.t.Helper()
+
+	select {
+	case event := <-p.events:
+		p.t.Fatalf("unexpected %q event: %+v", event.Type, event.Payload)
+	case <-time.After(wait):
+	}
+}
+
+// Close disconnects the participant
+func (p *Participant) Close() {
+	p.Conn.Close()
+}
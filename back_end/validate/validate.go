@@ -0,0 +1,274 @@
+// Package validate cleans and checks user input for the REST and WebSocket
+// handlers, so that both reject the same values with the same field errors
+// before anything reaches the database.
+//
+// Text is normalized to Unicode NFC and trimmed before it is checked, and
+// lengths are counted in characters like PostgreSQL's VARCHAR(n).
+package validate
+
+import (
+	"fmt"
+	"net/mail"
+	"net/url"
+	"poker-planning-api/models"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// Length limits, matching the columns in database/schema.sql
+const (
+	MaxNameLength        = 255 // sessions.name, users.name
+	MaxTitleLength       = 500 // planning_items.title
+	MaxDescriptionLength = 10000
+	MaxEstimateLength    = 10 // votes.vote, planning_items.final_estimate
+	MaxNoticeLength      = 1000
+	MaxURLLength         = 2048 // accounts.avatar_url
+	MaxEmailLength       = 254  // logins.subject; the longest address SMTP allows
+	MinPasswordLength    = 8
+	// MaxPasswordLength is in bytes: bcrypt ignores anything longer
+	MaxPasswordLength = 72
+	MaxDeckSize       = 50
+	MaxTimerSeconds   = 3600 // an hour per item
+	// MaxTemplateItems bounds the items a template seeds a session with
+	MaxTemplateItems = 50
+)
+
+// Rule describes how a text field is cleaned and checked
+type Rule struct {
+	Required  bool
+	MaxLength int
+	// Multiline allows line breaks and tabs
+	Multiline bool
+}
+
+// Rules for the fields accepted by the API
+var (
+	Name        = Rule{Required: true, MaxLength: MaxNameLength}
+	Title       = Rule{Required: true, MaxLength: MaxTitleLength}
+	Description = Rule{MaxLength: MaxDescriptionLength, Multiline: true}
+	Estimate    = Rule{Required: true, MaxLength: MaxEstimateLength}
+	// FinalEstimate may be empty, which clears an item's final estimate
+	FinalEstimate = Rule{MaxLength: MaxEstimateLength}
+	Search        = Rule{MaxLength: MaxNameLength}
+	Notice        = Rule{Required: true, MaxLength: MaxNoticeLength}
+	AvatarURL     = Rule{MaxLength: MaxURLLength}
+	Email         = Rule{Required: true, MaxLength: MaxEmailLength}
+)
+
+// Validator collects one error per rejected field
+type Validator struct {
+	errors []models.FieldError
+}
+
+// Text cleans *value in place and checks it against rule. label names the
+// field in messages, e.g. "Session name".
+func (v *Validator) Text(field, label string, value *string, rule Rule) {
+	if !utf8.ValidString(*value) {
+		v.Add(field, models.FieldInvalidCharacters, label+" is not valid UTF-8")
+		return
+	}
+	*value = strings.TrimSpace(norm.NFC.String(*value))
+
+	switch {
+	case *value == "":
+		if rule.Required {
+			v.Add(field, models.FieldRequired, label+" is required")
+		}
+	case utf8.RuneCountInString(*value) > rule.MaxLength:
+		v.Add(field, models.FieldTooLong, fmt.Sprintf("%s must be at most %d characters", label, rule.MaxLength))
+	case strings.IndexFunc(*value, rejectFunc(rule.Multiline)) >= 0:
+		v.Add(field, models.FieldInvalidCharacters, label+" must not contain control characters")
+	}
+}
+
+// ID checks that value is a UUID, as generated for sessions, users and
+// items
+func (v *Validator) ID(field, label, value string) {
+	if value == "" {
+		v.Add(field, models.FieldRequired, label+" is required")
+		return
+	}
+	if !IsID(value) {
+		v.Add(field, models.FieldInvalidFormat, label+" is not a valid ID")
+	}
+}
+
+// IsID reports whether value is an ID in the form the server issues: a
+// UUID of 36 lowercase hexadecimal digits and hyphens. PostgreSQL also
+// accepts braces, a urn:uuid: prefix, upper case and missing hyphens, but
+// the in-memory store and the session cache compare IDs as strings, so
+// those spellings are refused everywhere.
+func IsID(value string) bool {
+	if len(value) != 36 {
+		return false
+	}
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		switch i {
+		case 8, 13, 18, 23:
+			if c != '-' {
+				return false
+			}
+		default:
+			if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// URL cleans *value in place like Text and checks that it is empty or an
+// absolute http or https URL, so it is safe to use as an image source
+func (v *Validator) URL(field, label string, value *string, rule Rule) {
+	before := len(v.errors)
+	v.Text(field, label, value, rule)
+	if len(v.errors) > before || *value == "" {
+		return
+	}
+	u, err := url.Parse(*value)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		v.Add(field, models.FieldInvalidFormat, label+" must be an http or https URL")
+	}
+}
+
+// Email cleans *value in place like Text, lower-cased so an address has
+// one spelling, and checks that it is a plain address (no display name)
+func (v *Validator) Email(field, label string, value *string) {
+	before := len(v.errors)
+	v.Text(field, label, value, Email)
+	if len(v.errors) > before {
+		return
+	}
+	*value = strings.ToLower(*value)
+	addr, err := mail.ParseAddress(*value)
+	if err != nil || addr.Address != *value {
+		v.Add(field, models.FieldInvalidFormat, label+" must be an email address")
+	}
+}
+
+// Password checks a new password's length. Passwords are taken as they
+// are, without trimming or normalizing.
+func (v *Validator) Password(field, label, value string) {
+	switch {
+	case value == "":
+		v.Add(field, models.FieldRequired, label+" is required")
+	case utf8.RuneCountInString(value) < MinPasswordLength:
+		v.Add(field, models.FieldTooShort, fmt.Sprintf("%s must be at least %d characters", label, MinPasswordLength))
+	case len(value) > MaxPasswordLength:
+		v.Add(field, models.FieldTooLong, fmt.Sprintf("%s must be at most %d bytes", label, MaxPasswordLength))
+	}
+}
+
+// Settings cleans each card of the deck in place like Text and checks
+// that the cards are distinct and the timer is within bounds. field
+// prefixes the errors' fields, e.g. "settings".
+func (v *Validator) Settings(field string, settings *models.SessionSettings) {
+	if len(settings.Deck) > MaxDeckSize {
+		v.Add(field+".deck", models.FieldTooLong, fmt.Sprintf("Deck must have at most %d cards", MaxDeckSize))
+	} else {
+		seen := map[string]bool{}
+		for i := range settings.Deck {
+			before := len(v.errors)
+			v.Text(field+".deck", "Card", &settings.Deck[i], Estimate)
+			if len(v.errors) > before {
+				break
+			}
+			if seen[settings.Deck[i]] {
+				v.Add(field+".deck", models.FieldInvalidFormat, "Deck must not repeat the card "+settings.Deck[i])
+				break
+			}
+			seen[settings.Deck[i]] = true
+		}
+	}
+	if settings.TimerSeconds < 0 || settings.TimerSeconds > MaxTimerSeconds {
+		v.Add(field+".timerSeconds", models.FieldOutOfRange, fmt.Sprintf("Timer must be between 0 and %d seconds", MaxTimerSeconds))
+	}
+}
+
+// Int parses value as an integer between min and max, returning def when
+// value is empty
+func (v *Validator) Int(field, label, value string, def, min, max int) int {
+	if value == "" {
+		return def
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		v.Add(field, models.FieldInvalidFormat, label+" must be a whole number")
+		return def
+	}
+	if n < min || n > max {
+		v.Add(field, models.FieldOutOfRange, fmt.Sprintf("%s must be between %d and %d", label, min, max))
+		return def
+	}
+	return n
+}
+
+// Time parses value as an RFC 3339 timestamp or a date (YYYY-MM-DD, taken
+// as midnight UTC). An empty value gives the zero time.
+func (v *Validator) Time(field, label, value string) time.Time {
+	if value == "" {
+		return time.Time{}
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t
+	}
+	if t, err := time.Parse(time.DateOnly, value); err == nil {
+		return t
+	}
+	v.Add(field, models.FieldInvalidFormat, label+" must be a date (YYYY-MM-DD) or an RFC 3339 timestamp")
+	return time.Time{}
+}
+
+// OneOf checks that value is empty or one of options
+func (v *Validator) OneOf(field, label, value string, options ...string) {
+	if value == "" {
+		return
+	}
+	for _, option := range options {
+		if value == option {
+			return
+		}
+	}
+	v.Add(field, models.FieldInvalidFormat, label+" must be one of "+strings.Join(options, ", "))
+}
+
+// Add records an error for a check made outside the Validator
+func (v *Validator) Add(field, code, message string) {
+	v.errors = append(v.errors, models.FieldError{Field: field, Code: code, Message: message})
+}
+
+// Errors returns the collected field errors, or nil if every field passed
+func (v *Validator) Errors() []models.FieldError {
+	return v.errors
+}
+
+// Message joins the collected errors into one sentence for WebSocket
+// clients
+func (v *Validator) Message() string {
+	messages := make([]string, len(v.errors))
+	for i, e := range v.errors {
+		messages[i] = e.Message
+	}
+	return strings.Join(messages, "; ")
+}
+
+// rejectFunc matches control characters and the bidirectional overrides
+// that can make a name display as something else
+func rejectFunc(multiline bool) func(rune) bool {
+	return func(r rune) bool {
+		if multiline && (r == '\n' || r == '\r' || r == '\t') {
+			return false
+		}
+		return unicode.IsControl(r) || isBidiControl(r)
+	}
+}
+
+func isBidiControl(r rune) bool {
+	return (r >= '\u202a' && r <= '\u202e') || (r >= '\u2066' && r <= '\u2069') || r == '\u200e' || r == '\u200f'
+}
@@ -0,0 +1,209 @@
+package validate
+
+import (
+	"poker-planning-api/models"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestText(t *testing.T) {
+	tests := []struct {
+		name  string
+		in    string
+		rule  Rule
+		want  string
+		error string
+	}{
+		{"trims", "  Alice\t", Name, "Alice", ""},
+		{"normalizes to NFC", "Jose\u0301", Name, "Jos\u00e9", ""},
+		{"required", "   ", Name, "", models.FieldRequired},
+		{"optional", "", Description, "", ""},
+		{"counts characters", strings.Repeat("é", MaxNameLength), Name, strings.Repeat("é", MaxNameLength), ""},
+		{"too long", strings.Repeat("a", MaxNameLength+1), Name, "", models.FieldTooLong},
+		{"control character", "Ali\x07ce", Name, "", models.FieldInvalidCharacters},
+		{"newline in a name", "Ali\nce", Name, "", models.FieldInvalidCharacters},
+		{"newline in a description", "one\ntwo", Description, "one\ntwo", ""},
+		{"bidi override", "Ali\u202ece", Name, "", models.FieldInvalidCharacters},
+		{"invalid UTF-8", "Ali\xffce", Name, "", models.FieldInvalidCharacters},
+	}
+	for _, tt := range tests {
+		var v Validator
+		value := tt.in
+		v.Text("field", "Field", &value, tt.rule)
+
+		errs := v.Errors()
+		switch {
+		case tt.error == "" && errs != nil:
+			t.Errorf("%s: unexpected errors %v", tt.name, errs)
+		case tt.error != "" && (len(errs) != 1 || errs[0].Code != tt.error || errs[0].Field != "field"):
+			t.Errorf("%s: errors %v, want one %s", tt.name, errs, tt.error)
+		case tt.error == "" && value != tt.want:
+			t.Errorf("%s: cleaned to %q, want %q", tt.name, value, tt.want)
+		}
+	}
+}
+
+func TestID(t *testing.T) {
+	var v Validator
+	v.ID("a", "A", "3f1a3f4f-9c2e-4b5e-9d4a-0b6f6f0e8a0f")
+	v.ID("b", "B", "")
+	v.ID("c", "C", "42")
+
+	errs := v.Errors()
+	if len(errs) != 2 || errs[0].Code != models.FieldRequired || errs[1].Code != models.FieldInvalidFormat {
+		t.Errorf("errors %v", errs)
+	}
+	if msg := v.Message(); msg != "B is required; C is not a valid ID" {
+		t.Errorf("message %q", msg)
+	}
+
+	// Other spellings of a UUID name the same row in PostgreSQL only
+	for _, id := range []string{
+		"{3f1a3f4f-9c2e-4b5e-9d4a-0b6f6f0e8a0f}",
+		"urn:uuid:3f1a3f4f-9c2e-4b5e-9d4a-0b6f6f0e8a0f",
+		"3f1a3f4f9c2e4b5e9d4a0b6f6f0e8a0f",
+		"3F1A3F4F-9C2E-4B5E-9D4A-0B6F6F0E8A0F",
+		"3f1a3f4f-9c2e-4b5e-9d4a-0b6f6f0e8a0g",
+		"3f1a3f4f+9c2e-4b5e-9d4a-0b6f6f0e8a0f",
+	} {
+		if IsID(id) {
+			t.Errorf("IsID(%q) = true, want false", id)
+		}
+	}
+}
+
+func TestURL(t *testing.T) {
+	for in, want := range map[string]string{
+		"":                                 "",
+		" https://example.com/a.png ":      "",
+		"http://localhost:3000/avatar.png": "",
+		"javascript:alert(1)":              models.FieldInvalidFormat,
+		"/avatars/a.png":                   models.FieldInvalidFormat,
+		"https://" + strings.Repeat("a", MaxURLLength): models.FieldTooLong,
+	} {
+		var v Validator
+		value := in
+		v.URL("avatarUrl", "Avatar", &value, AvatarURL)
+		errs := v.Errors()
+		switch {
+		case want == "" && errs != nil:
+			t.Errorf("%q: unexpected errors %v", in, errs)
+		case want != "" && (len(errs) != 1 || errs[0].Code != want):
+			t.Errorf("%q: errors %v, want one %s", in, errs, want)
+		}
+	}
+}
+
+func TestEmail(t *testing.T) {
+	for in, want := range map[string]string{
+		" Alice@Example.com ":                "alice@example.com",
+		"bob@localhost":                      "bob@localhost",
+		"":                                   models.FieldRequired,
+		"alice":                              models.FieldInvalidFormat,
+		"Alice <alice@example.com>":          models.FieldInvalidFormat,
+		"alice@example.com, bob@example.com": models.FieldInvalidFormat,
+	} {
+		var v Validator
+		value := in
+		v.Email("email", "Email", &value)
+		errs := v.Errors()
+		switch {
+		case strings.Contains(want, "@"):
+			if errs != nil || value != want {
+				t.Errorf("%q: got %q and errors %v, want %q", in, value, errs, want)
+			}
+		case len(errs) != 1 || errs[0].Code != want:
+			t.Errorf("%q: errors %v, want one %s", in, errs, want)
+		}
+	}
+}
+
+func TestPassword(t *testing.T) {
+	for in, want := range map[string]string{
+		"correct horse":         "",
+		" spaces count ":        "",
+		"":                      models.FieldRequired,
+		"short":                 models.FieldTooShort,
+		strings.Repeat("é", 40): models.FieldTooLong,
+	} {
+		var v Validator
+		v.Password("password", "Password", in)
+		errs := v.Errors()
+		switch {
+		case want == "" && errs != nil:
+			t.Errorf("%q: unexpected errors %v", in, errs)
+		case want != "" && (len(errs) != 1 || errs[0].Code != want):
+			t.Errorf("%q: errors %v, want one %s", in, errs, want)
+		}
+	}
+}
+
+func TestQueryParameters(t *testing.T) {
+	var v Validator
+	if n := v.Int("limit", "Limit", "", 20, 1, 100); n != 20 {
+		t.Errorf("default limit %d", n)
+	}
+	if n := v.Int("limit", "Limit", "50", 20, 1, 100); n != 50 {
+		t.Errorf("limit %d", n)
+	}
+	if d := v.Time("from", "From", "2024-03-01"); !d.Equal(time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("date %v", d)
+	}
+	if d := v.Time("from", "From", "2024-03-01T12:00:00+02:00"); d.UTC().Hour() != 10 {
+		t.Errorf("timestamp %v", d)
+	}
+	v.OneOf("state", "State", "", "active", "archived")
+	v.OneOf("state", "State", "archived", "active", "archived")
+	if errs := v.Errors(); errs != nil {
+		t.Fatalf("unexpected errors %v", errs)
+	}
+
+	v.Int("a", "A", "ten", 20, 1, 100)
+	v.Int("b", "B", "0", 20, 1, 100)
+	v.Time("c", "C", "yesterday")
+	v.OneOf("d", "D", "open", "active", "archived")
+
+	errs := v.Errors()
+	want := []string{models.FieldInvalidFormat, models.FieldOutOfRange, models.FieldInvalidFormat, models.FieldInvalidFormat}
+	if len(errs) != len(want) {
+		t.Fatalf("errors %v", errs)
+	}
+	for i, code := range want {
+		if errs[i].Code != code {
+			t.Errorf("error %d: %v, want %s", i, errs[i], code)
+		}
+	}
+}
+
+func TestSettings(t *testing.T) {
+	settings := models.SessionSettings{Deck: []string{" 1 ", "2", "?"}, TimerSeconds: 90}
+	var v Validator
+	v.Settings("settings", &settings)
+	if errs := v.Errors(); errs != nil {
+		t.Fatalf("unexpected errors %v", errs)
+	}
+	if settings.Deck[0] != "1" {
+		t.Errorf("card not trimmed: %q", settings.Deck[0])
+	}
+
+	for name, tc := range map[string]struct {
+		settings models.SessionSettings
+		field    string
+		code     string
+	}{
+		"repeated card": {models.SessionSettings{Deck: []string{"1", "1"}}, "settings.deck", models.FieldInvalidFormat},
+		"empty card":    {models.SessionSettings{Deck: []string{""}}, "settings.deck", models.FieldRequired},
+		"long card":     {models.SessionSettings{Deck: []string{"eleven char"}}, "settings.deck", models.FieldTooLong},
+		"large deck":    {models.SessionSettings{Deck: make([]string, MaxDeckSize+1)}, "settings.deck", models.FieldTooLong},
+		"long timer":    {models.SessionSettings{TimerSeconds: MaxTimerSeconds + 1}, "settings.timerSeconds", models.FieldOutOfRange},
+		"negative":      {models.SessionSettings{TimerSeconds: -1}, "settings.timerSeconds", models.FieldOutOfRange},
+	} {
+		var v Validator
+		v.Settings("settings", &tc.settings)
+		errs := v.Errors()
+		if len(errs) != 1 || errs[0].Field != tc.field || errs[0].Code != tc.code {
+			t.Errorf("%s: errors %v, want one %s on %s", name, errs, tc.code, tc.field)
+		}
+	}
+}
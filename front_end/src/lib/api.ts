@@ -1,6 +1,6 @@
 const API_BASE_URL = process.env.NEXT_PUBLIC_API_URL || 'http://localhost:8080';
 
-export async function createSession(name: string, hostName: string): Promise<{ sessionId: string; hostId: string }> {
+export async function createSession(name: string, hostName: string): Promise<{ sessionId: string; hostId: string; joinCode: string; hostKey: string }> {
   const response = await fetch(`${API_BASE_URL}/api/sessions`, {
     method: 'POST',
     headers: {
@@ -16,6 +16,16 @@ export async function createSession(name: string, hostName: string): Promise<{ s
   return response.json();
 }
 
+// Secrets proving a rejoin are kept for the browser tab, never in the URL:
+// the host key for the session's creator, the resume token for the others
+export function saveJoinSecret(kind: 'hostKey' | 'resumeToken', sessionId: string, secret: string) {
+  sessionStorage.setItem(`${kind}:${sessionId}`, secret);
+}
+
+export function loadJoinSecret(kind: 'hostKey' | 'resumeToken', sessionId: string): string {
+  return sessionStorage.getItem(`${kind}:${sessionId}`) || '';
+}
+
 export async function getSession(sessionId: string) {
   const response = await fetch(`${API_BASE_URL}/api/sessions/${sessionId}`);
 
@@ -58,6 +68,16 @@ export async function setCurrentItem(sessionId: string, itemId: string) {
   return response.json();
 }
 
+export async function resolveJoinCode(code: string): Promise<{ sessionId: string; name: string; joinCode: string }> {
+  const response = await fetch(`${API_BASE_URL}/api/join/${encodeURIComponent(code)}`);
+
+  if (!response.ok) {
+    throw new Error('No active session with that join code');
+  }
+
+  return response.json();
+}
+
 export function connectWebSocket(sessionId: string): WebSocket {
   const wsUrl = API_BASE_URL.replace(/^http/, 'ws').replace(/^https/, 'wss');
   return new WebSocket(`${wsUrl}/ws/${sessionId}`);
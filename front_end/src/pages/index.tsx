@@ -1,6 +1,7 @@
 import { useState, useEffect } from 'react';
 import { useRouter } from 'next/router';
-import { createSession } from '@/lib/api';
+import { createSession, resolveJoinCode, saveJoinSecret } from '@/lib/api';
+import { MAX_NAME_LENGTH } from '@/types';
 
 export default function Home() {
   const [name, setName] = useState('');
@@ -36,7 +37,8 @@ export default function Home() {
     setIsCreating(true);
 
     try {
-      const { sessionId, hostId } = await createSession(sessionName, name);
+      const { sessionId, hostId, hostKey } = await createSession(sessionName, name);
+      saveJoinSecret('hostKey', sessionId, hostKey);
       router.push(`/session/${sessionId}?userId=${hostId}&userName=${encodeURIComponent(name)}`);
     } catch (err) {
       setError('Failed to create session. Please try again.');
@@ -44,7 +46,7 @@ export default function Home() {
     }
   };
 
-  const handleJoinSession = (e: React.FormEvent) => {
+  const handleJoinSession = async (e: React.FormEvent) => {
     e.preventDefault();
     setError('');
 
@@ -61,13 +63,25 @@ export default function Home() {
       return;
     }
 
-    // Otherwise, prompt for session ID
-    const sessionId = prompt('Enter Session ID:');
-    if (sessionId) {
-      router.push(`/session/${sessionId}?userName=${encodeURIComponent(name)}`);
-    } else {
+    // Otherwise, prompt for a join code or session ID
+    const input = prompt('Enter the join code or session ID:')?.trim();
+    if (!input) {
       setIsJoining(false);
+      return;
+    }
+
+    // Session IDs are UUIDs; anything else is treated as a join code
+    let sessionId = input;
+    if (!/^[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}$/i.test(input)) {
+      try {
+        ({ sessionId } = await resolveJoinCode(input));
+      } catch (err) {
+        setError('No active session with that join code.');
+        setIsJoining(false);
+        return;
+      }
     }
+    router.push(`/session/${sessionId}?userName=${encodeURIComponent(name)}`);
   };
 
   return (
@@ -95,6 +109,7 @@ export default function Home() {
               onChange={(e) => setName(e.target.value)}
               className="w-full px-4 py-3 border border-gray-300 rounded-lg focus:ring-2 focus:ring-blue-500 focus:border-transparent outline-none transition"
               placeholder="Enter your name"
+              maxLength={MAX_NAME_LENGTH}
               required
               autoFocus={!!joinSessionId}
             />
@@ -126,6 +141,7 @@ export default function Home() {
                     onChange={(e) => setSessionName(e.target.value)}
                     className="w-full px-4 py-3 border border-gray-300 rounded-lg focus:ring-2 focus:ring-blue-500 focus:border-transparent outline-none transition"
                     placeholder="Sprint Planning - Dec 2023"
+                    maxLength={MAX_NAME_LENGTH}
                   />
                 </div>
                 <button
@@ -1,7 +1,19 @@
-import { useEffect, useState, useCallback } from 'react';
+import { useEffect, useState, useCallback, useRef } from 'react';
 import { useRouter } from 'next/router';
-import { Session, PlanningItem, User, WSMessage, CARD_VALUES } from '@/types';
-import { connectWebSocket, addItem, setCurrentItem } from '@/lib/api';
+import {
+  Session,
+  PlanningItem,
+  User,
+  WSMessage,
+  CARD_VALUES,
+  PROTOCOL_VERSION,
+  CLOSE_SERVICE_RESTART,
+  CLOSE_SESSION_CLOSED,
+  ERROR_VALIDATION_FAILED,
+  MAX_TITLE_LENGTH,
+  MAX_DESCRIPTION_LENGTH,
+} from '@/types';
+import { connectWebSocket, addItem, setCurrentItem, saveJoinSecret, loadJoinSecret } from '@/lib/api';
 
 export default function SessionPage() {
   const router = useRouter();
@@ -13,6 +25,10 @@ export default function SessionPage() {
   const [connected, setConnected] = useState(false);
   const [selectedVote, setSelectedVote] = useState<string | null>(null);
   const [connectionError, setConnectionError] = useState<string | null>(null);
+  // Bumped to reconnect after the server restarts; the assigned user ID is
+  // reused so the rejoin is not rejected as a duplicate name
+  const [reconnectCount, setReconnectCount] = useState(0);
+  const joinedUserId = useRef<string | null>(null);
 
   // Form states
   const [newItemTitle, setNewItemTitle] = useState('');
@@ -28,7 +44,11 @@ export default function SessionPage() {
       console.log('WebSocket connected');
       websocket.send(JSON.stringify({
         userName: userName,
-        userId: userId || '',
+        userId: joinedUserId.current || userId || '',
+        // A user ID alone does not rejoin; the server wants proof
+        hostKey: loadJoinSecret('hostKey', sessionId as string),
+        resumeToken: loadJoinSecret('resumeToken', sessionId as string),
+        protocolVersion: PROTOCOL_VERSION,
       }));
     };
 
@@ -41,23 +61,36 @@ export default function SessionPage() {
       console.error('WebSocket error:', error);
     };
 
-    websocket.onclose = () => {
+    let reconnectTimer: ReturnType<typeof setTimeout> | undefined;
+    websocket.onclose = (event) => {
       console.log('WebSocket disconnected');
       setConnected(false);
+      if (event.code === CLOSE_SERVICE_RESTART) {
+        reconnectTimer = setTimeout(() => setReconnectCount((n) => n + 1), 1000 + Math.random() * 2000);
+      } else if (event.code === CLOSE_SESSION_CLOSED) {
+        setConnectionError('This session was closed by an operator');
+        reconnectTimer = setTimeout(() => router.push('/'), 2000);
+      }
     };
 
     setWs(websocket);
 
     return () => {
+      clearTimeout(reconnectTimer);
       websocket.close();
     };
-  }, [sessionId, userName, userId]);
+  }, [sessionId, userName, userId, reconnectCount]);
 
   const handleWebSocketMessage = useCallback((message: WSMessage) => {
     console.log('Received message:', message);
 
     switch (message.type) {
       case 'error':
+        // A rejected vote or estimate leaves the connection open
+        if (joinedUserId.current && message.payload.code === ERROR_VALIDATION_FAILED) {
+          alert(message.payload.error);
+          break;
+        }
         setConnectionError(message.payload.error);
         setConnected(false);
         // Redirect back to home page with error and keep the join link
@@ -66,10 +99,20 @@ export default function SessionPage() {
         }, 2000);
         break;
 
+      case 'notice':
+        // Operator announcement, e.g. upcoming maintenance
+        alert(message.payload.message);
+        break;
+
       case 'welcome':
         setConnected(true);
         setConnectionError(null);
         setSession(message.payload.session);
+        joinedUserId.current = message.payload.userId;
+        // Sent once, to a new participant, to rejoin with
+        if (message.payload.resumeToken) {
+          saveJoinSecret('resumeToken', sessionId as string, message.payload.resumeToken);
+        }
         const user = message.payload.session.users[message.payload.userId];
         setCurrentUser(user);
         break;
@@ -306,6 +349,11 @@ export default function SessionPage() {
               <p className="text-sm text-gray-600">
                 Logged in as: <span className="font-semibold">{currentUser.name}</span>
                 {isHost && <span className="ml-2 text-blue-600">(Host)</span>}
+                {session.joinCode && (
+                  <span className="ml-4">
+                    Join code: <span className="font-mono font-semibold tracking-widest">{session.joinCode}</span>
+                  </span>
+                )}
               </p>
             </div>
             <button
@@ -342,6 +390,7 @@ export default function SessionPage() {
                     value={newItemTitle}
                     onChange={(e) => setNewItemTitle(e.target.value)}
                     placeholder="Item title"
+                    maxLength={MAX_TITLE_LENGTH}
                     className="w-full px-3 py-2 border border-gray-300 rounded mb-2 focus:ring-2 focus:ring-blue-500 outline-none"
                     required
                   />
@@ -349,6 +398,7 @@ export default function SessionPage() {
                     value={newItemDescription}
                     onChange={(e) => setNewItemDescription(e.target.value)}
                     placeholder="Description (optional)"
+                    maxLength={MAX_DESCRIPTION_LENGTH}
                     className="w-full px-3 py-2 border border-gray-300 rounded mb-2 focus:ring-2 focus:ring-blue-500 outline-none"
                     rows={2}
                   />
@@ -22,7 +22,9 @@ export interface Session {
   users: { [userId: string]: User };
   items: PlanningItem[];
   currentItemId?: string;
+  joinCode?: string;
   createdAt: string;
+  archivedAt?: string;
 }
 
 export interface WSMessage {
@@ -30,4 +32,22 @@ export interface WSMessage {
   payload: any;
 }
 
+// WebSocket protocol version spoken by this client (see /api/asyncapi.json)
+export const PROTOCOL_VERSION = 2;
+
+// WebSocket close code sent when the server restarts; clients reconnect
+export const CLOSE_SERVICE_RESTART = 1012;
+
+// WebSocket close code sent when an operator closes the session; clients
+// must not reconnect
+export const CLOSE_SESSION_CLOSED = 4000;
+
+// Error code sent with field errors; see the back end's validate package
+export const ERROR_VALIDATION_FAILED = 'validation_failed';
+
+// Input limits enforced by the server
+export const MAX_NAME_LENGTH = 255;
+export const MAX_TITLE_LENGTH = 500;
+export const MAX_DESCRIPTION_LENGTH = 10000;
+
 export const CARD_VALUES = ['0', '1', '2', '3', '5', '8', '13', '21', '34', '55', '89', '?'];